@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	providerACR = "acr"
+
+	// acrHTTPTimeout bounds a single request to Azure AD or a registry
+	acrHTTPTimeout = 10 * time.Second
+
+	// acrRefreshTokenUser is the user name ACR expects with refresh tokens
+	acrRefreshTokenUser = "00000000-0000-0000-0000-000000000000"
+
+	acrResource    = "https://management.azure.com/"
+	acrIMDSURL     = "http://169.254.169.254/metadata/identity/oauth2/token"
+	acrAuthorityV2 = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+)
+
+// imdsTokenSource gets the Azure AD token of the managed identity from the
+// instance metadata service, of the user-assigned identity clientID if set
+type imdsTokenSource struct {
+	client   *http.Client
+	url      string
+	clientID string
+}
+
+func (s imdsTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {acrResource}}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata service answered %s", resp.Status)
+	}
+	token := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid instance metadata service response: %v", err)
+	}
+	expiresIn, _ := token.ExpiresIn.Int64()
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		Expiry:      time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// acrCredentialSource exchanges an Azure AD token for a refresh token of
+// every registry
+type acrCredentialSource struct {
+	registries  []string
+	tenantID    string
+	tokenSource oauth2.TokenSource
+	client      *http.Client
+	// scheme of the registry endpoints, only plain HTTP in tests
+	scheme     string
+	now        func() time.Time
+	credential refreshingCredential
+}
+
+// newACRCredentialSource gets the Azure AD tokens as the service principal
+// when a client secret is configured, as the managed identity otherwise
+func newACRCredentialSource(httpClient *http.Client) (*acrCredentialSource, error) {
+	source := &acrCredentialSource{
+		registries:  splitCommaList(configACRRegistries),
+		tenantID:    configACRTenantID,
+		tokenSource: imdsTokenSource{client: httpClient, url: acrIMDSURL, clientID: configACRClientID},
+		client:      httpClient,
+		scheme:      "https",
+		now:         time.Now,
+	}
+	if len(source.registries) == 0 {
+		return nil, fmt.Errorf("`acr-registries` is required with `provider=%s`", providerACR)
+	}
+	if configACRClientSecretFile != "" {
+		if configACRTenantID == "" || configACRClientID == "" {
+			return nil, fmt.Errorf("`acr-tenant-id` and `acr-client-id` are required with `acr-client-secret-file`")
+		}
+		b, err := os.ReadFile(configACRClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Azure client secret: %v", err)
+		}
+		config := &clientcredentials.Config{
+			ClientID:     configACRClientID,
+			ClientSecret: strings.TrimSpace(string(b)),
+			TokenURL:     fmt.Sprintf(acrAuthorityV2, configACRTenantID),
+			Scopes:       []string{acrResource + ".default"},
+		}
+		source.tokenSource = config.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, httpClient))
+	}
+	return source, nil
+}
+
+func (s *acrCredentialSource) String() string {
+	return providerACR + ":" + strings.Join(s.registries, ",")
+}
+
+// load returns the dockerconfigjson of the current refresh tokens, refreshing
+// them `acr-refresh-before`, or `refresh-before`, before the first of them
+// expires
+func (s *acrCredentialSource) load(context.Context) (string, error) {
+	return s.credential.get(s.now(), providerRefreshBefore(configACRRefreshBefore), s.generate)
+}
+
+func (s *acrCredentialSource) generate() (string, time.Time, error) {
+	token, err := s.tokenSource.Token()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get Azure AD token: %v", err)
+	}
+	auths := map[string]string{}
+	expiresAt := token.Expiry
+	for _, registry := range s.registries {
+		refreshToken, err := s.exchange(registry, token.AccessToken)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to get ACR refresh token of %s: %v", registry, err)
+		}
+		auths[registry] = base64.StdEncoding.EncodeToString([]byte(acrRefreshTokenUser + ":" + refreshToken))
+		if exp, ok := jwtExpiry(refreshToken); ok && (expiresAt.IsZero() || exp.Before(expiresAt)) {
+			expiresAt = exp
+		}
+	}
+	content, err := renderDockerConfigJSON(auths)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Infof("Refreshed ACR refresh tokens, expiring at %s", expiresAt.Format(time.RFC3339))
+	return content, expiresAt, nil
+}
+
+// exchange trades the Azure AD access token for a refresh token of registry
+func (s *acrCredentialSource) exchange(registry, accessToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {accessToken},
+	}
+	if s.tenantID != "" {
+		form.Set("tenant", s.tenantID)
+	}
+	resp, err := s.client.PostForm(s.scheme+"://"+registry+"/oauth2/exchange", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %s", resp.Status)
+	}
+	exchanged := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&exchanged); err != nil {
+		return "", fmt.Errorf("invalid registry response: %v", err)
+	}
+	if exchanged.RefreshToken == "" {
+		return "", fmt.Errorf("registry returned an empty refresh token")
+	}
+	return exchanged.RefreshToken, nil
+}
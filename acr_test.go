@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestACRCredentialSource(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	refreshToken := "header." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, now.Add(3*time.Hour).Unix()))) + ".signature"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("client_id") != "identity" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"aad-token","expires_in":"86399"}`))
+		case "/oauth2/exchange":
+			if r.PostFormValue("access_token") != "aad-token" || r.PostFormValue("service") != r.Host {
+				http.Error(w, "bad request", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"refresh_token":"` + refreshToken + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	source := &acrCredentialSource{
+		registries:  []string{registry},
+		tokenSource: imdsTokenSource{client: server.Client(), url: server.URL + "/metadata", clientID: "identity"},
+		client:      server.Client(),
+		scheme:      "http",
+		now:         func() time.Time { return now },
+	}
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(acrRefreshTokenUser + ":" + refreshToken))
+	expected := `{"auths":{"` + registry + `":{"auth":"` + auth + `"}}}`
+	if content != expected {
+		t.Errorf("content %s, want %s", content, expected)
+	}
+	if !source.credential.expiresAt.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("expires at %s, want the expiry of the refresh token", source.credential.expiresAt)
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	if _, ok := jwtExpiry("not-a-jwt"); ok {
+		t.Error("expiry of an invalid token")
+	}
+	exp, ok := jwtExpiry("e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1700000000}`)) + ".sig")
+	if !ok || exp.Unix() != 1700000000 {
+		t.Errorf("got %s, %t", exp, ok)
+	}
+}
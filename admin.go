@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runtimeSettings is the subset of the configuration which is safe to change
+// at runtime through the admin API
+type runtimeSettings struct {
+	LogLevel           string `json:"logLevel"`
+	LoopDuration       string `json:"loopDuration"`
+	Paused             bool   `json:"paused"`
+	ExcludedNamespaces string `json:"excludedNamespaces"`
+}
+
+// runtimeSettingsPatch holds the settings to change, nil fields are kept
+type runtimeSettingsPatch struct {
+	LogLevel           *string `json:"logLevel,omitempty"`
+	LoopDuration       *string `json:"loopDuration,omitempty"`
+	Paused             *bool   `json:"paused,omitempty"`
+	ExcludedNamespaces *string `json:"excludedNamespaces,omitempty"`
+}
+
+var (
+	runtimeSettingsMu sync.Mutex
+	currentSettings   runtimeSettings
+)
+
+// initRuntimeSettings seeds the runtime settings from the flags, then
+// overrides them with the ones persisted in the config ConfigMap
+func initRuntimeSettings(ctx context.Context, k8s *k8sClient) error {
+	settings := runtimeSettings{
+		LogLevel:           log.GetLevel().String(),
+		LoopDuration:       configLoopDuration.String(),
+		Paused:             configPaused,
+		ExcludedNamespaces: configExcludedNamespaces,
+	}
+	if configConfigMap != "" {
+		namespace, name, err := splitNamespacedName(configConfigMap)
+		if err != nil {
+			return err
+		}
+		cm, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to GET config ConfigMap: %v", err)
+		}
+		if err == nil {
+			settings, err = settingsFromConfigMap(settings, cm)
+			if err != nil {
+				return fmt.Errorf("invalid config ConfigMap: %v", err)
+			}
+			log.Infof("Loaded runtime settings from ConfigMap [%s]", configConfigMap)
+		}
+	}
+	runtimeSettingsMu.Lock()
+	currentSettings = settings
+	runtimeSettingsMu.Unlock()
+	return nil
+}
+
+func splitNamespacedName(s string) (string, string, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q is not in the form namespace/name", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+func settingsFromConfigMap(settings runtimeSettings, cm *corev1.ConfigMap) (runtimeSettings, error) {
+	patch := runtimeSettingsPatch{}
+	if v, ok := cm.Data["logLevel"]; ok {
+		patch.LogLevel = &v
+	}
+	if v, ok := cm.Data["loopDuration"]; ok {
+		patch.LoopDuration = &v
+	}
+	if v, ok := cm.Data["paused"]; ok {
+		paused, err := strconv.ParseBool(v)
+		if err != nil {
+			return settings, fmt.Errorf("paused: %v", err)
+		}
+		patch.Paused = &paused
+	}
+	if v, ok := cm.Data["excludedNamespaces"]; ok {
+		patch.ExcludedNamespaces = &v
+	}
+	return patch.apply(settings)
+}
+
+// apply validates the patch and returns settings with it applied
+func (p runtimeSettingsPatch) apply(settings runtimeSettings) (runtimeSettings, error) {
+	if p.LogLevel != nil {
+		if _, err := log.ParseLevel(*p.LogLevel); err != nil {
+			return settings, fmt.Errorf("logLevel: %v", err)
+		}
+		settings.LogLevel = *p.LogLevel
+	}
+	if p.LoopDuration != nil {
+		d, err := time.ParseDuration(*p.LoopDuration)
+		if err != nil {
+			return settings, fmt.Errorf("loopDuration: %v", err)
+		}
+		if d <= 0 {
+			return settings, fmt.Errorf("loopDuration: must be positive")
+		}
+		settings.LoopDuration = *p.LoopDuration
+	}
+	if p.Paused != nil {
+		settings.Paused = *p.Paused
+	}
+	if p.ExcludedNamespaces != nil {
+		if err := validateNamespacePatterns(*p.ExcludedNamespaces); err != nil {
+			return settings, fmt.Errorf("excludedNamespaces: %v", err)
+		}
+		settings.ExcludedNamespaces = *p.ExcludedNamespaces
+	}
+	return settings, nil
+}
+
+// persistRuntimeSettings writes settings to the config ConfigMap
+func persistRuntimeSettings(ctx context.Context, k8s *k8sClient, settings runtimeSettings) error {
+	if configConfigMap == "" {
+		return nil
+	}
+	namespace, name, err := splitNamespacedName(configConfigMap)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{
+		"logLevel":           settings.LogLevel,
+		"loopDuration":       settings.LoopDuration,
+		"paused":             strconv.FormatBool(settings.Paused),
+		"excludedNamespaces": settings.ExcludedNamespaces,
+	}
+	client := k8s.clientset.CoreV1().ConfigMaps(namespace)
+	cm, err := client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// applyRuntimeSettings copies the runtime settings into the configuration,
+// called by the main loop between sweeps so a sweep sees consistent values
+func applyRuntimeSettings() {
+	runtimeSettingsMu.Lock()
+	settings := currentSettings
+	runtimeSettingsMu.Unlock()
+
+	if level, err := log.ParseLevel(settings.LogLevel); err == nil {
+		log.SetLevel(level)
+	}
+	if d, err := time.ParseDuration(settings.LoopDuration); err == nil {
+		configLoopDuration = d
+	}
+	configPaused = settings.Paused
+	configExcludedNamespaces = settings.ExcludedNamespaces
+}
+
+func authorizedAdmin(r *http.Request) bool {
+	if configAdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(configAdminToken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminHandler serves the admin API, every request must carry the admin token
+// as a bearer token
+func adminHandler(k8s *k8sClient) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			runtimeSettingsMu.Lock()
+			settings := currentSettings
+			runtimeSettingsMu.Unlock()
+			writeJSON(w, http.StatusOK, settings)
+		case http.MethodPatch:
+			patch := runtimeSettingsPatch{}
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			runtimeSettingsMu.Lock()
+			defer runtimeSettingsMu.Unlock()
+			settings, err := patch.apply(currentSettings)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := persistRuntimeSettings(r.Context(), k8s, settings); err != nil {
+				log.Errorf("Failed to persist runtime settings: %v", err)
+				http.Error(w, fmt.Sprintf("failed to persist settings: %v", err), http.StatusInternalServerError)
+				return
+			}
+			currentSettings = settings
+			log.Infof("Runtime settings changed through admin API: %+v", settings)
+			writeJSON(w, http.StatusOK, settings)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if triggerLoop() {
+			log.Info("Loop triggered through admin API")
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorizedAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveAdmin exposes the admin API on addr in the background, until ctx is
+// done
+func serveAdmin(ctx context.Context, addr string, k8s *k8sClient) {
+	server := newHTTPServer(ctx, addr, adminHandler(k8s))
+	go func() {
+		log.Infof("Serving admin API on %s", addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("Admin server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAdminHandlerConfig(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	k8s := &k8sClient{
+		clientset: fake.NewSimpleClientset(),
+	}
+	configAdminToken = "secret-token"
+	configConfigMap = "imagepullsecret-patcher/config"
+	configLoopDuration = 10 * time.Second
+	configExcludedNamespaces = ""
+	defer func() { configConfigMap = "" }()
+	if err := initRuntimeSettings(context.TODO(), k8s); err != nil {
+		t.Fatalf("initRuntimeSettings has error %v", err)
+	}
+	handler := adminHandler(k8s)
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		method   string
+		body     string
+		expected int
+	}{
+		{
+			name:     "no token",
+			method:   http.MethodGet,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong token",
+			token:    "other-token",
+			method:   http.MethodGet,
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "get",
+			token:    "secret-token",
+			method:   http.MethodGet,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "invalid log level",
+			token:    "secret-token",
+			method:   http.MethodPatch,
+			body:     `{"logLevel":"loud"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid loop duration",
+			token:    "secret-token",
+			method:   http.MethodPatch,
+			body:     `{"loopDuration":"-1s"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "patch",
+			token:    "secret-token",
+			method:   http.MethodPatch,
+			body:     `{"loopDuration":"1m","paused":true,"excludedNamespaces":"kube-system"}`,
+			expected: http.StatusOK,
+		},
+	} {
+		req := httptest.NewRequest(tc.method, "/config", strings.NewReader(tc.body))
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.expected {
+			t.Errorf("adminHandler(%s) gives status %d, expects %d", tc.name, rec.Code, tc.expected)
+		}
+	}
+
+	cm, err := k8s.clientset.CoreV1().ConfigMaps("imagepullsecret-patcher").Get(context.TODO(), "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("adminHandler did not persist settings: %v", err)
+	}
+	if cm.Data["loopDuration"] != "1m" || cm.Data["paused"] != "true" || cm.Data["excludedNamespaces"] != "kube-system" {
+		t.Errorf("adminHandler persisted %v", cm.Data)
+	}
+
+	applyRuntimeSettings()
+	if configLoopDuration != time.Minute || !configPaused || configExcludedNamespaces != "kube-system" {
+		t.Errorf("applyRuntimeSettings gives loop duration %v, paused %v, excluded namespaces %q", configLoopDuration, configPaused, configExcludedNamespaces)
+	}
+	configPaused = false
+	configExcludedNamespaces = ""
+
+	// settings persisted earlier win over the flags on startup
+	configLoopDuration = 10 * time.Second
+	if err := initRuntimeSettings(context.TODO(), k8s); err != nil {
+		t.Fatalf("initRuntimeSettings has error %v", err)
+	}
+	if currentSettings.LoopDuration != "1m" {
+		t.Errorf("initRuntimeSettings gives loop duration %s, expects 1m", currentSettings.LoopDuration)
+	}
+}
+
+func TestAdminHandlerLoop(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configAdminToken = "secret-token"
+	handler := adminHandler(&k8sClient{clientset: fake.NewSimpleClientset()})
+	// drain a trigger left by other tests
+	select {
+	case <-loopTrigger:
+	default:
+	}
+
+	for _, tc := range []struct {
+		method   string
+		expected int
+	}{
+		{method: http.MethodGet, expected: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, expected: http.StatusAccepted},
+		// a second trigger while one is pending is merged into it
+		{method: http.MethodPost, expected: http.StatusAccepted},
+	} {
+		req := httptest.NewRequest(tc.method, "/loop", nil)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.expected {
+			t.Errorf("adminHandler(%s /loop) gives status %d, expects %d", tc.method, rec.Code, tc.expected)
+		}
+	}
+
+	select {
+	case <-loopTrigger:
+	default:
+		t.Fatal("POST /loop did not trigger a loop")
+	}
+	select {
+	case <-loopTrigger:
+		t.Error("POST /loop triggered more than one pending loop")
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultAnnotationPrefix is the domain of the annotation keys of the
+// patcher unless `annotation-prefix` is set
+const defaultAnnotationPrefix = "k8s.titansoft.com"
+
+// the annotation keys of the patcher, under the configured prefix
+var (
+	annotationImagepullsecretPatcherExclude          = patcherAnnotationKey(defaultAnnotationPrefix, "exclude")
+	annotationImagepullsecretPatcherExcludeConfigMap = patcherAnnotationKey(defaultAnnotationPrefix, "exclude-configmap")
+	annotationImagepullsecretPatcherSourceSecret     = patcherAnnotationKey(defaultAnnotationPrefix, "source-secret")
+
+	// annotationManagedSecrets records on a service account the names of the
+	// secrets the patcher added to its imagePullSecrets
+	annotationManagedSecrets = patcherAnnotationKey(defaultAnnotationPrefix, "managed-secrets")
+
+	// annotationContentHash records the sha256 of the plaintext a managed
+	// object was generated from, for objects whose content can't be compared
+	annotationContentHash = patcherAnnotationKey(defaultAnnotationPrefix, "content-sha256")
+
+	// annotationContentHMAC records the HMAC-SHA256 of the plaintext a
+	// SealedSecret was sealed from, which unlike a plain hash doesn't let the
+	// readers of the SealedSecret guess the plaintext
+	annotationContentHMAC = patcherAnnotationKey(defaultAnnotationPrefix, "content-hmac-sha256")
+
+	// annotationLastSyncedAt records when the patcher last wrote the content
+	// of a managed object, and annotationControllerVersion its version then
+	annotationLastSyncedAt      = patcherAnnotationKey(defaultAnnotationPrefix, "last-synced-at")
+	annotationControllerVersion = patcherAnnotationKey(defaultAnnotationPrefix, "controller-version")
+
+	// annotationOwnership asks the patcher to adopt, release or orphan a
+	// secret or ConfigMap
+	annotationOwnership = patcherAnnotationKey(defaultAnnotationPrefix, "ownership")
+)
+
+func patcherAnnotationKey(prefix, name string) string {
+	return prefix + "/imagepullsecret-patcher-" + name
+}
+
+// setAnnotationPrefix moves the annotation keys of the patcher to prefix
+func setAnnotationPrefix(prefix string) {
+	annotationImagepullsecretPatcherExclude = patcherAnnotationKey(prefix, "exclude")
+	annotationImagepullsecretPatcherExcludeConfigMap = patcherAnnotationKey(prefix, "exclude-configmap")
+	annotationImagepullsecretPatcherSourceSecret = patcherAnnotationKey(prefix, "source-secret")
+	annotationManagedSecrets = patcherAnnotationKey(prefix, "managed-secrets")
+	annotationContentHash = patcherAnnotationKey(prefix, "content-sha256")
+	annotationContentHMAC = patcherAnnotationKey(prefix, "content-hmac-sha256")
+	annotationLastSyncedAt = patcherAnnotationKey(prefix, "last-synced-at")
+	annotationControllerVersion = patcherAnnotationKey(prefix, "controller-version")
+	annotationOwnership = patcherAnnotationKey(prefix, "ownership")
+}
+
+// patcherAnnotation reads the annotation key from annotations, falling back
+// to the same key under the default prefix, so objects annotated before the
+// prefix was changed keep working
+func patcherAnnotation(annotations map[string]string, key string) (string, bool) {
+	if v, ok := annotations[key]; ok {
+		return v, true
+	}
+	name := key[strings.Index(key, "/")+1:]
+	v, ok := annotations[defaultAnnotationPrefix+"/"+name]
+	return v, ok
+}
+
+// secretContentHash returns the content hash recorded on a managed secret
+func secretContentHash(secret *corev1.Secret) string {
+	hash, _ := patcherAnnotation(secret.Annotations, annotationContentHash)
+	return hash
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetAnnotationPrefix(t *testing.T) {
+	defer setAnnotationPrefix(defaultAnnotationPrefix)
+	setAnnotationPrefix("patcher.example.com")
+	if annotationImagepullsecretPatcherExclude != "patcher.example.com/imagepullsecret-patcher-exclude" {
+		t.Errorf("exclude annotation = %s, want it under the new prefix", annotationImagepullsecretPatcherExclude)
+	}
+
+	for name, annotations := range map[string]map[string]string{
+		"new prefix":     {"patcher.example.com/imagepullsecret-patcher-exclude": "true"},
+		"default prefix": {"k8s.titansoft.com/imagepullsecret-patcher-exclude": "true"},
+	} {
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: annotations}}
+		if !namespaceIsExcluded(ns) {
+			t.Errorf("namespace annotated under the %s should be excluded", name)
+		}
+	}
+
+	// the key under the configured prefix wins
+	v, ok := patcherAnnotation(map[string]string{
+		"patcher.example.com/imagepullsecret-patcher-exclude": "false",
+		"k8s.titansoft.com/imagepullsecret-patcher-exclude":   "true",
+	}, annotationImagepullsecretPatcherExclude)
+	if !ok || v != "false" {
+		t.Errorf("patcherAnnotation = %q, %v, want the value under the configured prefix", v, ok)
+	}
+
+	secret := dockerconfigSecret("team-a")
+	if _, ok := secret.Annotations["patcher.example.com/imagepullsecret-patcher-content-sha256"]; !ok {
+		t.Errorf("content hash of new secrets should be written under the new prefix, got %v", secret.Annotations)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// liveClusterState reads the state the plan depends on from the cluster
+func liveClusterState(ctx context.Context, k8s *k8sClient) (*clusterState, error) {
+	state := newClusterState()
+	namespaces, err := k8s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	state.namespaces = namespaces.Items
+
+	sas, err := k8s.clientset.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %v", err)
+	}
+	for _, sa := range sas.Items {
+		state.serviceAccounts[sa.Namespace] = append(state.serviceAccounts[sa.Namespace], sa)
+	}
+
+	configMaps, err := k8s.clientset.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ConfigMaps: %v", err)
+	}
+	for i := range configMaps.Items {
+		if cm := &configMaps.Items[i]; isSyncedConfigMapName(cm.Name) {
+			state.addConfigMap(cm)
+		}
+	}
+
+	switch configSecretMode {
+	case secretModeExternalSecret, secretModeSealedSecret, secretModeSecretProviderClass:
+		gvr := externalSecretGVR
+		switch configSecretMode {
+		case secretModeSealedSecret:
+			gvr = sealedSecretGVR
+		case secretModeSecretProviderClass:
+			gvr = secretProviderClassGVR
+		}
+		objs, err := k8s.dynamic.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %ss: %v", managedSecretKind(), err)
+		}
+		for i := range objs.Items {
+			if obj := &objs.Items[i]; obj.GetName() == configSecretName {
+				state.secrets[obj.GetNamespace()] = obj
+			}
+		}
+	default:
+		secrets, err := k8s.clientset.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %v", err)
+		}
+		for i := range secrets.Items {
+			secret := &secrets.Items[i]
+			if secret.Name != configSecretName {
+				continue
+			}
+			obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+			if err != nil {
+				return nil, err
+			}
+			state.secrets[secret.Namespace] = &unstructured.Unstructured{Object: obj}
+		}
+	}
+	return state, nil
+}
+
+func readPlan(path string) (plan, error) {
+	p := plan{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return p, fmt.Errorf("invalid plan %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// planDrift describes how current differs from the reviewed plan, empty when
+// they match
+func planDrift(reviewed, current plan) []string {
+	drift := []string{}
+	if reviewed.CredentialHash != current.CredentialHash {
+		drift = append(drift, "credential changed")
+	}
+	planned := map[planChange]bool{}
+	for _, change := range reviewed.Changes {
+		planned[change] = true
+	}
+	for _, change := range current.Changes {
+		if !planned[change] {
+			drift = append(drift, fmt.Sprintf("[%s] unplanned %s of %s [%s]", change.Namespace, change.Action, change.Kind, change.Name))
+		}
+		delete(planned, change)
+	}
+	for _, change := range reviewed.Changes {
+		if planned[change] {
+			drift = append(drift, fmt.Sprintf("[%s] planned %s of %s [%s] no longer matches the cluster", change.Namespace, change.Action, change.Kind, change.Name))
+		}
+	}
+	if !reflect.DeepEqual(reviewed.Errors, current.Errors) {
+		drift = append(drift, "errors changed")
+	}
+	return drift
+}
+
+// runApply executes the reviewed plan of the plan file, failing when the
+// cluster state or the credential drifted since
+func runApply(ctx context.Context, k8s *k8sClient) error {
+	if configPlanFile == "" {
+		return fmt.Errorf("`plan-file` is required to apply")
+	}
+	reviewed, err := readPlan(configPlanFile)
+	if err != nil {
+		return err
+	}
+	dockerConfigJSON, err = getDockerConfigJSON(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load dockerconfigjson: %v", err)
+	}
+	if configSecretMode == secretModeSealedSecret {
+		sealingKey, err = getSealingKey(ctx, k8s)
+		if err != nil {
+			return err
+		}
+	}
+	if err := reloadExcludedNamespacesFile(); err != nil {
+		return err
+	}
+	state, err := liveClusterState(ctx, k8s)
+	if err != nil {
+		return err
+	}
+	if drift := planDrift(reviewed, buildPlan(ctx, state)); len(drift) > 0 {
+		for _, d := range drift {
+			log.Error(d)
+		}
+		return fmt.Errorf("cluster state drifted since the plan was made, plan again")
+	}
+
+	namespaces := map[string]corev1.Namespace{}
+	for _, ns := range state.namespaces {
+		namespaces[ns.Name] = ns
+	}
+	applied := map[string]bool{}
+	failed := 0
+	for _, change := range reviewed.Changes {
+		if applied[change.Namespace] {
+			continue
+		}
+		applied[change.Namespace] = true
+		if err := processNamespace(ctx, k8s, namespaces[change.Namespace]); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d namespaces failed to apply", failed, len(applied))
+	}
+	log.Infof("Applied %d changes in %d namespaces", len(reviewed.Changes), len(applied))
+	return nil
+}
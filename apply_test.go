@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newApplyTestClient() *k8sClient {
+	objs := testStateObjects()
+	return &k8sClient{clientset: fake.NewSimpleClientset(objs...)}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	setupPlanTest(t)
+	configDockerconfigjson = testDockerconfig
+	configPlanFile = filepath.Join(t.TempDir(), "plan.json")
+	defer func() { configPlanFile = "" }()
+
+	k8s := newApplyTestClient()
+	if err := runPlan(context.TODO(), k8s, ioutil.Discard); err != nil {
+		t.Fatalf("runPlan failed: %v", err)
+	}
+	p, err := readPlan(configPlanFile)
+	if err != nil {
+		t.Fatalf("runPlan wrote an unreadable plan: %v", err)
+	}
+	if len(p.Changes) != len(expectedTestPlanChanges) {
+		t.Fatalf("runPlan() = %+v, want %+v", p.Changes, expectedTestPlanChanges)
+	}
+
+	if err := runApply(context.TODO(), k8s); err != nil {
+		t.Fatalf("runApply failed: %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().Secrets("missing").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("runApply did not create the planned secret: %v", err)
+	}
+	sa, err := k8s.clientset.CoreV1().ServiceAccounts("missing").Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("runApply did not patch the planned service account")
+	}
+
+	// the plan is done now, applying it again finds the cluster drifted
+	if err := runApply(context.TODO(), k8s); err == nil {
+		t.Errorf("runApply of an applied plan should fail")
+	}
+}
+
+func TestApplyDrift(t *testing.T) {
+	setupPlanTest(t)
+	configDockerconfigjson = testDockerconfig
+	configPlanFile = filepath.Join(t.TempDir(), "plan.json")
+	defer func() { configPlanFile = "" }()
+
+	k8s := newApplyTestClient()
+	if err := runPlan(context.TODO(), k8s, ioutil.Discard); err != nil {
+		t.Fatalf("runPlan failed: %v", err)
+	}
+
+	// a new namespace appears after the review
+	_, err := k8s.clientset.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runApply(context.TODO(), k8s); err == nil {
+		t.Fatalf("runApply should fail when the cluster drifted")
+	}
+	if _, err := k8s.clientset.CoreV1().Secrets("missing").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err == nil {
+		t.Errorf("runApply should not change anything when the cluster drifted")
+	}
+}
+
+func TestPlanDrift(t *testing.T) {
+	reviewed := plan{
+		CredentialHash: "a",
+		Changes:        []planChange{{mutationPatch, "ns", "ServiceAccount", "default", "ImagePullSecretMissing", "1"}},
+	}
+	if drift := planDrift(reviewed, reviewed); len(drift) != 0 {
+		t.Errorf("planDrift() of the same plan = %v, want none", drift)
+	}
+	current := plan{
+		CredentialHash: "b",
+		Changes:        []planChange{{mutationPatch, "ns", "ServiceAccount", "default", "ImagePullSecretMissing", "2"}},
+	}
+	if drift := planDrift(reviewed, current); len(drift) != 3 {
+		t.Errorf("planDrift() = %v, want the credential, the unplanned and the stale change", drift)
+	}
+}
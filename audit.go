@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditStdout is the audit log destination writing to stdout, which the logs
+// stay out of as they go to stderr
+const auditStdout = "-"
+
+// auditRecord is the audit log line of a mutation
+type auditRecord struct {
+	Time      string `json:"time"`
+	Actor     string `json:"actor"`
+	Instance  string `json:"instance"`
+	Version   string `json:"version"`
+	Namespace string `json:"namespace"`
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	// OldHash is the sha256 of the credential the object held before the
+	// mutation, when known, and NewHash the one it holds after it
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+}
+
+// credentialKinds are the kinds of objects holding the credential, the only
+// ones an audit record gives a new hash for
+var credentialKinds = map[string]bool{
+	"Secret":       true,
+	"SealedSecret": true,
+}
+
+var (
+	auditMu  sync.Mutex
+	auditLog io.Writer
+)
+
+// openAuditLog opens the audit log destination, stdout for auditStdout and
+// otherwise a file only ever appended to
+func openAuditLog(path string) error {
+	if path == auditStdout {
+		auditLog = os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	auditLog = f
+	return nil
+}
+
+// newAuditRecord builds the audit record of an action on the object kind/name
+// in namespace, which held the credential hashed as oldHash
+func newAuditRecord(namespace, action, kind, name, reason, oldHash string, now time.Time) auditRecord {
+	instance, _ := os.Hostname()
+	record := auditRecord{
+		Time:      now.UTC().Format(time.RFC3339Nano),
+		Actor:     fieldManager,
+		Instance:  instance,
+		Version:   version,
+		Namespace: namespace,
+		Action:    action,
+		Kind:      kind,
+		Name:      name,
+		Reason:    reason,
+		OldHash:   oldHash,
+	}
+	if credentialKinds[kind] && action != mutationDelete {
+		record.NewHash = credentialHash(credentialFor(namespace))
+	}
+	return record
+}
+
+// auditMutation appends the audit record of a mutation to the audit log when
+// enabled, a failure is only logged as the mutation itself already happened
+func auditMutation(namespace, action, kind, name, reason, oldHash string) {
+	if auditLog == nil {
+		return
+	}
+	b, err := json.Marshal(newAuditRecord(namespace, action, kind, name, reason, oldHash, time.Now()))
+	if err != nil {
+		nsLog(namespace).Warnf("Failed to audit %s of %s [%s]: %v", action, kind, name, err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if _, err := auditLog.Write(append(b, '\n')); err != nil {
+		nsLog(namespace).Warnf("Failed to audit %s of %s [%s]: %v", action, kind, name, err)
+	}
+}
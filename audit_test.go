@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAuditMutation(t *testing.T) {
+	dockerConfigJSON = testDockerconfig
+	buf := &bytes.Buffer{}
+	auditLog = buf
+	defer func() { auditLog = nil }()
+
+	old := `{"auths":{"old.example.com":{"auth":"b2xkOm9sZA=="}}}`
+	secret := namedDockerconfigSecret("team-a", configSecretName, old)
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset(secret)}
+	if err := updateSecret(context.TODO(), k8s, secret, testDockerconfig, string(secretDataNotMatch)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupSecret(context.TODO(), k8s, "team-a", configSecretName, "Uninstall"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected an audit record per mutation, got %q", buf.String())
+	}
+	records := make([]auditRecord, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update, cleanup := records[0], records[1]
+	if update.Action != mutationPatch || update.Namespace != "team-a" || update.Name != configSecretName || update.Actor != fieldManager || update.Time == "" {
+		t.Errorf("unexpected update record %+v", update)
+	}
+	if update.OldHash != credentialHash(old) || update.NewHash != credentialHash(testDockerconfig) {
+		t.Errorf("update record should go from the old to the new credential, got %+v", update)
+	}
+	if cleanup.Action != mutationDelete || cleanup.OldHash != credentialHash(testDockerconfig) || cleanup.NewHash != "" {
+		t.Errorf("unexpected cleanup record %+v", cleanup)
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	defer func() { auditLog = nil }()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	auditMutation("team-a", mutationPatch, "ServiceAccount", "default", "ImagePullSecretMissing", "")
+	auditLog.(*os.File).Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || lines[0] != "{}" {
+		t.Fatalf("the audit log should be appended to, got %q", b)
+	}
+	record := auditRecord{}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Kind != "ServiceAccount" || record.NewHash != "" {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
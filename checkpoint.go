@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const checkpointKey = "lastNamespace"
+
+// checkpointEvery is the number of namespaces processed between two writes of
+// the checkpoint
+var checkpointEvery = 50
+
+// loadCheckpoint returns the namespace the previous loop was interrupted
+// after, empty when it completed or no checkpoint ConfigMap is configured
+func loadCheckpoint(ctx context.Context, k8s *k8sClient) (string, error) {
+	if configCheckpointConfigMap == "" {
+		return "", nil
+	}
+	namespace, name, err := splitNamespacedName(configCheckpointConfigMap)
+	if err != nil {
+		return "", err
+	}
+	cm, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to GET checkpoint ConfigMap: %v", err)
+	}
+	return cm.Data[checkpointKey], nil
+}
+
+// saveCheckpoint writes the namespace a loop got to into the checkpoint
+// ConfigMap, empty once the loop completed
+func saveCheckpoint(ctx context.Context, k8s *k8sClient, last string) error {
+	if configCheckpointConfigMap == "" {
+		return nil
+	}
+	namespace, name, err := splitNamespacedName(configCheckpointConfigMap)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{checkpointKey: last}
+	client := k8s.clientset.CoreV1().ConfigMaps(namespace)
+	cm, err := client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		cm.Data = data
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint ConfigMap: %v", err)
+	}
+	return nil
+}
+
+// resumeNamespaces orders the namespaces by name, starting after last so the
+// namespaces an interrupted loop did not get to come first
+func resumeNamespaces(namespaces []corev1.Namespace, last string) []corev1.Namespace {
+	sorted := make([]corev1.Namespace, len(namespaces))
+	copy(sorted, namespaces)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	if last == "" {
+		return sorted
+	}
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Name > last })
+	return append(sorted[i:], sorted[:i]...)
+}
+
+// loopProgress checkpoints every checkpointEvery namespaces a loop processed,
+// so a restart resumes about where it stopped
+type loopProgress struct {
+	k8s       *k8sClient
+	mu        sync.Mutex
+	processed int
+	last      string
+}
+
+// done records that the loop processed namespace
+func (p *loopProgress) done(ctx context.Context, namespace string) {
+	if configCheckpointConfigMap == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed++
+	p.last = namespace
+	if p.processed%checkpointEvery == 0 {
+		if err := saveCheckpoint(ctx, p.k8s, namespace); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// finish checkpoints the end of the loop, clearing the checkpoint when the
+// loop completed and otherwise keeping the last namespace it processed
+func (p *loopProgress) finish(ctx context.Context, completed bool, resumed string) {
+	if configCheckpointConfigMap == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last := ""
+	switch {
+	case !completed && p.last != "":
+		last = p.last
+	case !completed:
+		return
+	case resumed == "" && p.processed < checkpointEvery:
+		// nothing was checkpointed
+		return
+	}
+	if err := saveCheckpoint(ctx, p.k8s, last); err != nil {
+		log.Warn(err)
+	}
+}
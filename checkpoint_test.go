@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResumeNamespaces(t *testing.T) {
+	namespaces := []corev1.Namespace{}
+	for _, name := range []string{"c", "a", "d", "b"} {
+		namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	for _, tc := range []struct {
+		last     string
+		expected []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{"b", []string{"c", "d", "a", "b"}},
+		{"bb", []string{"c", "d", "a", "b"}},
+		{"d", []string{"a", "b", "c", "d"}},
+	} {
+		names := []string{}
+		for _, ns := range resumeNamespaces(namespaces, tc.last) {
+			names = append(names, ns.Name)
+		}
+		if !reflect.DeepEqual(names, tc.expected) {
+			t.Errorf("resuming after %q got %v, want %v", tc.last, names, tc.expected)
+		}
+	}
+}
+
+func TestLoopProgressCheckpoint(t *testing.T) {
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	configCheckpointConfigMap = "imagepullsecret-patcher/checkpoint"
+	checkpointEvery = 2
+	defer func() { configCheckpointConfigMap, checkpointEvery = "", 50 }()
+
+	assertCheckpoint := func(expected string) {
+		t.Helper()
+		last, err := loadCheckpoint(context.TODO(), k8s)
+		if err != nil {
+			t.Fatalf("loadCheckpoint has error %v", err)
+		}
+		if last != expected {
+			t.Errorf("checkpoint %q, want %q", last, expected)
+		}
+	}
+
+	assertCheckpoint("")
+	progress := &loopProgress{k8s: k8s}
+	progress.done(context.TODO(), "a")
+	assertCheckpoint("")
+	progress.done(context.TODO(), "b")
+	assertCheckpoint("b")
+	progress.done(context.TODO(), "c")
+	progress.finish(context.TODO(), false, "")
+	assertCheckpoint("c")
+
+	progress = &loopProgress{k8s: k8s}
+	progress.done(context.TODO(), "d")
+	progress.finish(context.TODO(), true, "c")
+	assertCheckpoint("")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	cleanedUpNamespacesMu sync.Mutex
+	// cleanedUpNamespaces records the excluded namespaces cleaned up since
+	// they were last processed, so they aren't listed again every loop
+	cleanedUpNamespaces = map[string]bool{}
+
+	orphansCollectedMu sync.Mutex
+	// orphansCollected records the namespaces rid of the secrets of previous
+	// names since the patcher started
+	orphansCollected = map[string]bool{}
+)
+
+// forgetNamespaceCleanup lets namespace be cleaned up again once it becomes
+// excluded again
+func forgetNamespaceCleanup(namespace string) {
+	cleanedUpNamespacesMu.Lock()
+	defer cleanedUpNamespacesMu.Unlock()
+	delete(cleanedUpNamespaces, namespace)
+}
+
+// cleanupExcludedNamespace removes the managed secrets of an excluded
+// namespace, and their references from the service accounts, once until the
+// namespace is processed again
+func cleanupExcludedNamespace(ctx context.Context, k8s *k8sClient, namespace string) error {
+	cleanedUpNamespacesMu.Lock()
+	done := cleanedUpNamespaces[namespace]
+	cleanedUpNamespacesMu.Unlock()
+	if done {
+		return nil
+	}
+	for _, name := range managedSecretNames() {
+		if name == configSecretName && isSourceSecret(namespace) {
+			continue
+		}
+		if err := cleanupSecret(ctx, k8s, namespace, name, "NamespaceExcluded"); err != nil {
+			return err
+		}
+	}
+	cleanedUpNamespacesMu.Lock()
+	cleanedUpNamespaces[namespace] = true
+	cleanedUpNamespacesMu.Unlock()
+	return nil
+}
+
+// collectOrphanedSecrets deletes the managed secrets of namespace named after
+// a previous `secretname`, along with their references, once since the start
+func collectOrphanedSecrets(ctx context.Context, k8s *k8sClient, namespace string) error {
+	orphansCollectedMu.Lock()
+	done := orphansCollected[namespace]
+	orphansCollectedMu.Unlock()
+	if done {
+		return nil
+	}
+	for _, name := range configPreviousSecretNames.items {
+		if sourceSecret != nil && sourceSecret.namespace == namespace && sourceSecret.name == name {
+			continue
+		}
+		if err := cleanupSecret(ctx, k8s, namespace, name, "SecretRenamed"); err != nil {
+			return err
+		}
+	}
+	orphansCollectedMu.Lock()
+	orphansCollected[namespace] = true
+	orphansCollectedMu.Unlock()
+	return nil
+}
+
+// cleanupSecret deletes the managed secret name of namespace along with its
+// references, unless the secret is unmanaged
+func cleanupSecret(ctx context.Context, k8s *k8sClient, namespace, name, reason string) error {
+	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	found := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to GET secret: %v", err)
+	}
+	if found && !isManagedSecret(secret) {
+		nsLog(namespace).WithField(logFieldSecret, name).Debug("Secret is unmanaged, not cleaning up")
+		return nil
+	}
+	if err := stripServiceAccountReferences(ctx, k8s, namespace, name, reason); err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	err = k8s.clientset.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to delete secret [%s]: %v", name, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationDelete}).Info("Cleaned up secret")
+	recordContentMutation(ctx, k8s, namespace, mutationDelete, "Secret", name, reason, secretContentHash(secret))
+	return nil
+}
+
+// stripServiceAccountReferences removes the secret name from the
+// imagePullSecrets and secrets of every service account of namespace
+func stripServiceAccountReferences(ctx context.Context, k8s *k8sClient, namespace, name, reason string) error {
+	sas, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list service accounts: %v", err)
+	}
+	for _, sa := range sas.Items {
+		patch, ok := stripPatch(&sa, name)
+		if !ok {
+			continue
+		}
+		b, err := json.Marshal(patch)
+		if err != nil {
+			return fmt.Errorf("Failed to get patch string: %v", err)
+		}
+		err = retryOnTransientError(func() error {
+			_, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, sa.Name, types.StrategicMergePatchType, b, metav1.PatchOptions{FieldManager: fieldManager})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to remove imagePullSecrets from service account [%s]: %v", sa.Name, err)
+		}
+		nsLog(namespace).WithFields(log.Fields{logFieldServiceAccount: sa.Name, logFieldAction: mutationPatch}).Info("Removed imagePullSecrets from service account")
+		recordMutation(ctx, k8s, namespace, mutationPatch, "ServiceAccount", sa.Name, reason)
+	}
+	return nil
+}
+
+// stripPatch returns the strategic merge patch removing secretName from the
+// service account, and whether it references it at all
+func stripPatch(sa *corev1.ServiceAccount, secretName string) (map[string]interface{}, bool) {
+	patch := map[string]interface{}{}
+	if includeImagePullSecret(sa, secretName) {
+		// imagePullSecrets is replaced as a whole
+		refs := []corev1.LocalObjectReference{}
+		for _, ref := range sa.ImagePullSecrets {
+			if ref.Name != secretName {
+				refs = append(refs, ref)
+			}
+		}
+		patch["imagePullSecrets"] = refs
+	}
+	if includeSecret(sa, secretName) {
+		// secrets is merged by name
+		patch["secrets"] = []map[string]string{{"$patch": "delete", "name": secretName}}
+	}
+	if _, ok := sa.Annotations[annotationManagedSecrets]; ok {
+		patch["metadata"] = map[string]interface{}{
+			"annotations": map[string]interface{}{annotationManagedSecrets: nil},
+		}
+	}
+	return patch, len(patch) > 0
+}
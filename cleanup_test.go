@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCleanupExcludedNamespace(t *testing.T) {
+	defer forgetNamespaceCleanup("team-a")
+	defer forgetNamespaceCleanup("team-b")
+	managed := map[string]string{annotationManagedBy: annotationAppName}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-a", Annotations: managed}},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "team-a"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "other"}, {Name: configSecretName}},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-b"}},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "team-b"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}},
+		},
+	)
+	k8s := &k8sClient{clientset: clientset}
+
+	if err := cleanupExcludedNamespace(context.TODO(), k8s, "team-a"); err != nil {
+		t.Fatalf("cleanupExcludedNamespace failed: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("team-a").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err == nil {
+		t.Error("managed secret of excluded namespace not deleted")
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts("team-a").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if includeImagePullSecret(sa, configSecretName) || !includeImagePullSecret(sa, "other") {
+		t.Errorf("imagePullSecrets should only lose the managed secret, got %v", sa.ImagePullSecrets)
+	}
+
+	if err := cleanupExcludedNamespace(context.TODO(), k8s, "team-b"); err != nil {
+		t.Fatalf("cleanupExcludedNamespace failed: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("team-b").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("unmanaged secret deleted: %v", err)
+	}
+	sa, err = clientset.CoreV1().ServiceAccounts("team-b").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		t.Error("reference to an unmanaged secret removed")
+	}
+}
+
+func TestStripPatch(t *testing.T) {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta:       metav1.ObjectMeta{Annotations: map[string]string{annotationManagedSecrets: "secret-a"}},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "secret-a"}},
+		Secrets:          []corev1.ObjectReference{{Name: "secret-a"}},
+	}
+	patch, ok := stripPatch(sa, "secret-a")
+	if !ok {
+		t.Fatal("stripPatch found nothing to remove")
+	}
+	if refs := patch["imagePullSecrets"].([]corev1.LocalObjectReference); len(refs) != 0 {
+		t.Errorf("imagePullSecrets should be emptied, got %v", refs)
+	}
+	if _, ok := patch["secrets"]; !ok {
+		t.Error("secrets should be patched")
+	}
+	if _, ok := patch["metadata"]; !ok {
+		t.Error("managed secrets annotation should be removed")
+	}
+	if _, ok := stripPatch(&corev1.ServiceAccount{}, "secret-a"); ok {
+		t.Error("stripPatch should skip service accounts without the secret")
+	}
+}
+
+func TestCollectOrphanedSecrets(t *testing.T) {
+	configPreviousSecretNames = stringListFlag{items: []string{"old-registry"}}
+	defer func() {
+		configPreviousSecretNames = stringListFlag{}
+		orphansCollectedMu.Lock()
+		delete(orphansCollected, "team-a")
+		orphansCollectedMu.Unlock()
+	}()
+	managed := map[string]string{annotationManagedBy: annotationAppName}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "old-registry", Namespace: "team-a", Annotations: managed}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-a", Annotations: managed}},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "team-a"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "old-registry"}, {Name: configSecretName}},
+		},
+	)
+	k8s := &k8sClient{clientset: clientset}
+
+	if err := collectOrphanedSecrets(context.TODO(), k8s, "team-a"); err != nil {
+		t.Fatalf("collectOrphanedSecrets failed: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("team-a").Get(context.TODO(), "old-registry", metav1.GetOptions{}); err == nil {
+		t.Error("secret of the previous name not deleted")
+	}
+	if _, err := clientset.CoreV1().Secrets("team-a").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("secret of the current name deleted: %v", err)
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts("team-a").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if includeImagePullSecret(sa, "old-registry") || !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("imagePullSecrets should only lose the previous name, got %v", sa.ImagePullSecrets)
+	}
+
+	// a namespace is only collected once
+	clientset.ClearActions()
+	if err := collectOrphanedSecrets(context.TODO(), k8s, "team-a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(clientset.Actions()) > 0 {
+		t.Errorf("namespace collected twice: %v", clientset.Actions())
+	}
+}
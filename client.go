@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// transportRefreshInterval is the minimum time between two transport rebuilds
+const transportRefreshInterval = 10 * time.Second
+
+// apiServerPollInterval is the wait between two checks of the API server on
+// startup, replaced in tests
+var apiServerPollInterval = 2 * time.Second
+
+// refreshingTransport rebuilds its transport, re-reading the token and CA,
+// when the API server rejects our credentials or certificate, so an expired
+// bound token or a rotated CA doesn't require a pod restart
+type refreshingTransport struct {
+	mu          sync.RWMutex
+	rt          http.RoundTripper
+	newRT       func() (http.RoundTripper, error)
+	lastRefresh time.Time
+}
+
+func newRefreshingTransport(newRT func() (http.RoundTripper, error)) (*refreshingTransport, error) {
+	rt, err := newRT()
+	if err != nil {
+		return nil, err
+	}
+	return &refreshingTransport{rt: rt, newRT: newRT, lastRefresh: time.Now()}, nil
+}
+
+func (t *refreshingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	rt := t.rt
+	t.mu.RUnlock()
+	resp, err := rt.RoundTrip(req)
+	if err != nil && isCertificateError(err) {
+		t.refresh("API server certificate is not trusted")
+	} else if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.refresh("API server answered 401 Unauthorized")
+	}
+	return resp, err
+}
+
+// refresh rebuilds the transport for subsequent requests
+func (t *refreshingTransport) refresh(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastRefresh) < transportRefreshInterval {
+		return
+	}
+	t.lastRefresh = time.Now()
+	log.Warnf("%s, rebuilding the Kubernetes client transport", reason)
+	rt, err := t.newRT()
+	if err != nil {
+		log.Errorf("Failed to rebuild the Kubernetes client transport: %v", err)
+		return
+	}
+	t.rt = rt
+	metricClientRefreshes.Inc()
+}
+
+// timeoutTransport gives every request but watches a deadline of timeout, so
+// a hung API server doesn't stall the patcher
+type timeoutTransport struct {
+	rt      http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 || req.URL.Query().Get("watch") == "true" {
+		return t.rt.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the deadline also covers reading the body, so it is released on close
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the context of a response once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var verification *tls.CertificateVerificationError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &verification)
+}
+
+// loadRESTConfig loads the kubeconfig when given, the in-cluster config otherwise
+func loadRESTConfig() (*rest.Config, error) {
+	if configKubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: configKubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: configKubeContext},
+	).ClientConfig()
+}
+
+// restTransport builds a transport from a freshly loaded config, running the
+// exec credential plugin of the kubeconfig if any
+func restTransport() (http.RoundTripper, error) {
+	config, err := loadRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return rest.TransportFor(config)
+}
+
+// execPluginError tells whether err comes from running an exec credential
+// plugin, which the client-go authenticator only reports as text
+func execPluginError(err error) bool {
+	var execErr *exec.Error
+	var exitErr *exec.ExitError
+	return errors.As(err, &execErr) || errors.As(err, &exitErr) || strings.Contains(err.Error(), "getting credentials: ")
+}
+
+// describeClientError points at the exec credential plugin of the config when
+// err comes from it, as its failures otherwise read like generic API errors
+func describeClientError(config *rest.Config, err error) error {
+	if config.ExecProvider == nil || !execPluginError(err) {
+		return err
+	}
+	return fmt.Errorf("kubeconfig exec credential plugin [%s] failed, check it is installed, on the PATH and logged in: %v", config.ExecProvider.Command, err)
+}
+
+// apiServerUnavailable tells whether err means the API server is not up yet,
+// rather than the client being misconfigured
+func apiServerUnavailable(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return isTransientAPIError(err) || errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// waitForAPIServer checks the API server answers its version, retrying for up
+// to timeout while it is unavailable
+func waitForAPIServer(clientset kubernetes.Interface, timeout time.Duration) error {
+	var lastErr error
+	check := func() (bool, error) {
+		_, lastErr = clientset.Discovery().ServerVersion()
+		if lastErr == nil {
+			return true, nil
+		}
+		if timeout <= 0 || !apiServerUnavailable(lastErr) {
+			return false, lastErr
+		}
+		log.Warnf("API server is not available yet: %v", lastErr)
+		return false, nil
+	}
+	if timeout <= 0 {
+		_, err := check()
+		return err
+	}
+	err := wait.PollImmediate(apiServerPollInterval, timeout, check)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("API server not available after %s: %v", timeout, lastErr)
+	}
+	return err
+}
+
+// clientConfigFor copies config, keeping its QPS, burst, user agent and
+// proxy, on top of rt, which already carries its TLS settings and credentials
+func clientConfigFor(config *rest.Config, rt http.RoundTripper) *rest.Config {
+	clientConfig := rest.CopyConfig(config)
+	clientConfig.Transport = rt
+	// client-go rejects TLS settings along with a transport, and would add the
+	// credentials and impersonation on top of it a second time
+	clientConfig.TLSClientConfig = rest.TLSClientConfig{}
+	clientConfig.BearerToken = ""
+	clientConfig.BearerTokenFile = ""
+	clientConfig.Username = ""
+	clientConfig.Password = ""
+	clientConfig.ExecProvider = nil
+	clientConfig.AuthProvider = nil
+	clientConfig.Impersonate = rest.ImpersonationConfig{}
+	clientConfig.WrapTransport = nil
+	clientConfig.Dial = nil
+	return clientConfig
+}
+
+// newK8sClient creates the clients from the kubeconfig or the in-cluster
+// config on top of a refreshing transport
+func newK8sClient() (*k8sClient, error) {
+	config, err := loadRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := newRefreshingTransport(restTransport)
+	if err != nil {
+		return nil, describeClientError(config, err)
+	}
+	var transport http.RoundTripper = rt
+	if configTracing {
+		transport = &tracingTransport{rt: transport}
+	}
+	transport = &timeoutTransport{rt: transport, timeout: configRequestTimeout}
+	clientConfig := clientConfigFor(config, transport)
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	// fail early when the credentials can't be obtained, after giving an API
+	// server starting along with the patcher some time
+	if err := waitForAPIServer(clientset, configAPIServerWaitTimeout); err != nil {
+		return nil, describeClientError(config, err)
+	}
+	return &k8sClient{
+		clientset:  clientset,
+		dynamic:    dynamicClient,
+		restConfig: clientConfig,
+	}, nil
+}
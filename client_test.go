@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRefreshingTransport(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	built := 0
+	newRT := func() (http.RoundTripper, error) {
+		built++
+		// the first transport carries an expired token
+		status := http.StatusUnauthorized
+		if built > 1 {
+			status = http.StatusOK
+		}
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(status)
+			return rec.Result(), nil
+		}), nil
+	}
+	rt, err := newRefreshingTransport(newRT)
+	if err != nil {
+		t.Fatalf("newRefreshingTransport has error %v", err)
+	}
+	rt.lastRefresh = time.Time{}
+
+	req := httptest.NewRequest(http.MethodGet, "https://kubernetes.default/api", nil)
+	for _, expected := range []int{http.StatusUnauthorized, http.StatusOK, http.StatusOK} {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip has error %v", err)
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("RoundTrip gives status %d, expects %d", resp.StatusCode, expected)
+		}
+	}
+	if built != 2 {
+		t.Errorf("refreshingTransport built %d transports, expects 2", built)
+	}
+}
+
+func TestTimeoutTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	rt := &timeoutTransport{rt: http.DefaultTransport, timeout: 50 * time.Millisecond}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/namespaces", nil)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip of a hung request has error %v, expects the deadline to be exceeded", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/v1/namespaces?watch=true", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip of a watch has error %v, watches are exempt", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientConfigFor(t *testing.T) {
+	config := &rest.Config{
+		Host:            "https://kubernetes.default",
+		APIPath:         "/api",
+		QPS:             50,
+		Burst:           100,
+		UserAgent:       "patcher",
+		BearerToken:     "token",
+		TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")},
+	}
+	rt := roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, nil })
+
+	clientConfig := clientConfigFor(config, rt)
+	if clientConfig.QPS != 50 || clientConfig.Burst != 100 || clientConfig.UserAgent != "patcher" {
+		t.Errorf("clientConfigFor dropped the QPS, burst or user agent: %+v", clientConfig)
+	}
+	if clientConfig.BearerToken != "" || len(clientConfig.TLSClientConfig.CAData) != 0 {
+		t.Errorf("clientConfigFor kept the credentials or TLS settings the transport carries: %+v", clientConfig)
+	}
+	if config.BearerToken != "token" {
+		t.Errorf("clientConfigFor modified the loaded config")
+	}
+	if _, err := kubernetes.NewForConfig(clientConfig); err != nil {
+		t.Errorf("kubernetes.NewForConfig rejects the config: %v", err)
+	}
+}
+
+const testExecKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: imagepullsecret-patcher-missing-plugin
+      args: ["token"]
+`
+
+func TestNewK8sClientExecPluginError(t *testing.T) {
+	configKubeconfig = filepath.Join(t.TempDir(), "kubeconfig")
+	defer func() { configKubeconfig = "" }()
+	if err := os.WriteFile(configKubeconfig, []byte(testExecKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadRESTConfig()
+	if err != nil {
+		t.Fatalf("loadRESTConfig failed: %v", err)
+	}
+	if config.ExecProvider == nil || config.ExecProvider.Command != "imagepullsecret-patcher-missing-plugin" {
+		t.Fatalf("loadRESTConfig did not load the exec provider: %+v", config.ExecProvider)
+	}
+
+	_, err = newK8sClient()
+	if err == nil || !strings.Contains(err.Error(), "exec credential plugin [imagepullsecret-patcher-missing-plugin] failed") {
+		t.Errorf("newK8sClient should name the failing exec plugin, got %v", err)
+	}
+}
+
+func TestDescribeClientError(t *testing.T) {
+	config := &rest.Config{ExecProvider: &clientcmdapi.ExecConfig{Command: "aws"}}
+	for _, tc := range []struct {
+		err       error
+		described bool
+	}{
+		{fmt.Errorf(`Get "https://10.0.0.1/version": getting credentials: exec: executable aws failed with exit code 255`), true},
+		{&exec.Error{Name: "aws", Err: exec.ErrNotFound}, true},
+		{fmt.Errorf(`Get "https://10.0.0.1/version": dial tcp 10.0.0.1:443: connect: connection refused`), false},
+		{fmt.Errorf("the server has asked for the client to provide credentials"), false},
+	} {
+		err := describeClientError(config, tc.err)
+		if described := err != tc.err; described != tc.described {
+			t.Errorf("describeClientError(%v) gives %v, expects it described: %v", tc.err, err, tc.described)
+		}
+	}
+	if err := fmt.Errorf("getting credentials: exec: executable aws not found"); describeClientError(&rest.Config{}, err) != err {
+		t.Errorf("describeClientError should leave errors of a config without exec plugin as is")
+	}
+}
+
+func TestWaitForAPIServer(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	apiServerPollInterval = 10 * time.Millisecond
+	defer func() { apiServerPollInterval = 2 * time.Second }()
+	unavailable := 3
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unavailable > 0 {
+			unavailable--
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"26","gitVersion":"v1.26.2"}`))
+	}))
+	defer srv.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitForAPIServer(clientset, 0); err == nil {
+		t.Error("waitForAPIServer without timeout should check once and fail")
+	}
+	if err := waitForAPIServer(clientset, 5*time.Second); err != nil {
+		t.Errorf("waitForAPIServer should succeed once the API server is available, got %v", err)
+	}
+	unavailable = 1000
+	if err := waitForAPIServer(clientset, 50*time.Millisecond); err == nil {
+		t.Error("waitForAPIServer should give up after the timeout")
+	}
+
+	// misconfigured clients fail right away
+	attempts := 0
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	clientset, err = kubernetes.NewForConfig(&rest.Config{Host: forbidden.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForAPIServer(clientset, 5*time.Second); err == nil || attempts != 1 {
+		t.Errorf("waitForAPIServer should fail on the first forbidden answer, got %v after %d attempts", err, attempts)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -61,3 +64,127 @@ func LookupEnvOrDuration(key string, defaultVal time.Duration) time.Duration {
 
 	return val
 }
+
+// LookupEnvOrFloat64 lookup ENV string with given key and convert to float64,
+// or returns default value if not exists or conversion failed
+func LookupEnvOrFloat64(key string, defaultVal float64) float64 {
+	str, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultVal
+	}
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// stringListFlag is a repeatable flag, each occurrence adding an item. The
+// items of the first occurrence replace the default ones.
+type stringListFlag struct {
+	items []string
+	set   bool
+}
+
+func (f *stringListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.items, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	if !f.set {
+		f.items = nil
+		f.set = true
+	}
+	f.items = append(f.items, value)
+	return nil
+}
+
+// LookupEnvOrStringList lookup ENV string with given key and split it on
+// commas, or returns an empty list if not exists
+func LookupEnvOrStringList(key string) stringListFlag {
+	return LookupEnvOrStringListSeparated(key, ",")
+}
+
+// LookupEnvOrStringListSeparated splits the environment variable on sep, for
+// items which hold commas themselves
+func LookupEnvOrStringListSeparated(key, sep string) stringListFlag {
+	str, ok := os.LookupEnv(key)
+	if !ok || str == "" {
+		return stringListFlag{}
+	}
+	return stringListFlag{items: strings.Split(str, sep)}
+}
+
+// printableFlags are safe to log. Every other flag, e.g. one holding a
+// credential or the path to one, has its value left out of the logged
+// configuration, so a new credential flag is redacted without being listed.
+var printableFlags = []string{
+	"force", "debug", "version", "log-format", "log-dedup-interval",
+	"managedonly", "runonce", "allserviceaccount", "dockerconfigjson-stdin",
+	"secret-labels", "secret-annotations", "previous-secret-names",
+	"registry-url", "registry-username", "credential-template",
+	"validate-credential", "verify-registry-auth", "credential-helpers",
+	"vault-addr", "dockerconfigjson-url", "keep-last-known-good",
+	"last-known-good-secret", "source-secret-annotation-namespaces",
+	"source-secret-namespace", "source-secret-name", "refresh-before", "provider",
+	"ecr-region", "ecr-registry-ids", "ecr-registries", "ecr-refresh-before",
+	"gcr-registries", "gcr-refresh-before", "acr-registries", "acr-tenant-id",
+	"acr-client-id", "acr-refresh-before", "ghcr-api-url", "ghcr-registry",
+	"ghcr-app-id", "ghcr-installation-id", "ghcr-refresh-before", "gitlab-url",
+	"gitlab-registry", "gitlab-project", "gitlab-group", "gitlab-token-lifetime",
+	"gitlab-refresh-before", "secretname", "secret-sync-interval",
+	"sa-patch-interval", "digest-short-circuit", "priority-namespaces",
+	"excluded-namespaces", "included-namespaces", "included-namespace-selector",
+	"annotation-prefix", "include-system-namespaces",
+	"exclude-namespaces-by-label", "selection-configmap",
+	"excluded-namespaces-file", "serviceaccounts",
+	"serviceaccount-label-selector", "loop-duration", "loop-jitter",
+	"max-failed-loops", "loop-timeout", "request-timeout", "kube-context",
+	"api-server-wait-timeout", "http-proxy", "https-proxy", "no-proxy",
+	"ca-bundle", "metrics-addr", "pprof-addr", "credential-rotation-sla",
+	"backoff-foreign-managers", "sa-patch-min-interval",
+	"default-sa-wait-timeout", "events", "mutation-records",
+	"mutation-record-ttl", "audit-log", "registry-health-interval",
+	"enable-secret-sync", "enable-sa-patch", "prune-stale-references",
+	"adopt-existing", "detect-only", "immutable-secrets", "server-side-apply",
+	"merge-existing", "patch-sa-secrets", "enable-configmap-sync", "reverify-age",
+	"secret-mode", "concurrency", "namespace-timeout",
+	"namespace-failure-max-backoff", "namespace-max-retries",
+	"namespace-retry-initial-backoff", "namespace-retry-max-backoff",
+	"namespace-retry-multiplier", "externalsecret-store-name",
+	"externalsecret-store-kind", "externalsecret-remote-key",
+	"externalsecret-remote-property", "externalsecret-refresh-interval",
+	"secretproviderclass-provider", "secretproviderclass-parameters",
+	"secretproviderclass-object-name", "secretproviderclass-sync-secret",
+	"admin-addr", "config-configmap", "checkpoint-configmap", "status-configmap",
+	"tracing", "sentry-error-threshold", "export-dir", "export-git",
+	"export-git-push", "sealedsecret-cert", "sealedsecret-controller-namespace",
+	"sealedsecret-controller-name", "selftest-canary-image", "selftest-timeout",
+	"aws-configmap-name", "aws-config-file", "sync-configmap",
+	"cleanup-excluded-namespaces", "prune-configmaps", "informers",
+	"watch-credential-file", "watch-configmap-deletions",
+}
+
+// effectiveConfig returns the value of every flag of fs, whether set on the
+// command line, by its environment variable or by default, with the
+// credentials redacted
+func effectiveConfig(fs *flag.FlagSet) map[string]interface{} {
+	printable := map[string]bool{}
+	for _, name := range printableFlags {
+		printable[name] = true
+	}
+	config := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if !printable[f.Name] && value != "" {
+			value = redactedValue
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		config[f.Name] = value
+	})
+	return config
+}
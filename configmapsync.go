@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// syncFormatEnv turns the key=value lines of the file into keys
+	syncFormatEnv = "env"
+	// syncFormatRaw keeps the file as a whole under its base name
+	syncFormatRaw = "raw"
+	// syncFormatJSON turns the members of the JSON object of the file into keys
+	syncFormatJSON = "json"
+)
+
+// configMapSync is a file distributed to every namespace as a managed
+// ConfigMap
+type configMapSync struct {
+	name   string
+	path   string
+	format string
+}
+
+// configMapSyncs are parsed from `sync-configmap`
+var configMapSyncs []configMapSync
+
+// parseConfigMapSync parses a `sync-configmap` entry, given as
+// `name=foo,path=/config/foo,format=env`, the format defaulting to env
+func parseConfigMapSync(spec string) (configMapSync, error) {
+	s := configMapSync{format: syncFormatEnv}
+	for _, option := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return s, fmt.Errorf("%q is not in the form key=value", option)
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			s.name = strings.TrimSpace(value)
+		case "path":
+			s.path = strings.TrimSpace(value)
+		case "format":
+			s.format = strings.TrimSpace(value)
+		default:
+			return s, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(s.name); len(errs) > 0 {
+		return s, fmt.Errorf("invalid name %q: %s", s.name, strings.Join(errs, ", "))
+	}
+	if s.path == "" {
+		return s, fmt.Errorf("ConfigMap %s lacks a path", s.name)
+	}
+	switch s.format {
+	case syncFormatEnv, syncFormatRaw, syncFormatJSON:
+	default:
+		return s, fmt.Errorf("ConfigMap %s has unknown format %q, must be %s, %s or %s", s.name, s.format, syncFormatEnv, syncFormatRaw, syncFormatJSON)
+	}
+	return s, nil
+}
+
+// parseConfigMapSyncs parses the `sync-configmap` entries, refusing a name
+// given twice, including the AWS ConfigMap one
+func parseConfigMapSyncs(specs []string) ([]configMapSync, error) {
+	names := map[string]bool{}
+	if s, ok := awsConfigMapSync(); ok {
+		names[s.name] = true
+	}
+	syncs := []configMapSync{}
+	for _, spec := range specs {
+		s, err := parseConfigMapSync(spec)
+		if err != nil {
+			return nil, err
+		}
+		if names[s.name] {
+			return nil, fmt.Errorf("ConfigMap %s is given twice", s.name)
+		}
+		names[s.name] = true
+		syncs = append(syncs, s)
+	}
+	return syncs, nil
+}
+
+// awsConfigMapSync returns the AWS ConfigMap of `aws-configmap-name` and
+// `aws-config-file`, unless the file is set empty
+func awsConfigMapSync() (configMapSync, bool) {
+	s := configMapSync{name: configAWSConfigMapName, path: configAWSConfigFilePath, format: syncFormatEnv}
+	return s, configAWSConfigFilePath != ""
+}
+
+// syncedConfigMaps returns the ConfigMaps distributed to every namespace, the
+// AWS one first
+func syncedConfigMaps() []configMapSync {
+	syncs := []configMapSync{}
+	if s, ok := awsConfigMapSync(); ok {
+		syncs = append(syncs, s)
+	}
+	return append(syncs, configMapSyncs...)
+}
+
+// syncedConfigMapNamed returns the synced ConfigMap called name
+func syncedConfigMapNamed(name string) (configMapSync, bool) {
+	for _, s := range syncedConfigMaps() {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return configMapSync{}, false
+}
+
+// isSyncedConfigMapName tells whether name is the name of a synced ConfigMap
+func isSyncedConfigMapName(name string) bool {
+	_, ok := syncedConfigMapNamed(name)
+	return ok
+}
+
+// awsConfigMap creates the AWS ConfigMap with values parsed from an
+// environment file
+func awsConfigMap(namespace string) (*corev1.ConfigMap, error) {
+	s, _ := awsConfigMapSync()
+	return s.configMap(namespace)
+}
+
+// configMap creates the ConfigMap of namespace with the data of the file
+func (s configMapSync) configMap(namespace string) (*corev1.ConfigMap, error) {
+	// Check if the file exists
+	fileInfo, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access file of ConfigMap %s: %v", s.name, err)
+	}
+
+	// If it's a directory, throw an error
+	if fileInfo.IsDir() {
+		return nil, fmt.Errorf("path of ConfigMap %s is a directory, expected a file: %s", s.name, s.path)
+	}
+
+	// Read the content of the file
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file of ConfigMap %s: %v", s.name, err)
+	}
+
+	var data map[string]string
+	switch s.format {
+	case syncFormatRaw:
+		data = map[string]string{filepath.Base(s.path): string(content)}
+	case syncFormatJSON:
+		data, err = parseJSONFile(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON file %s: %v", s.path, err)
+		}
+	default:
+		data = parseEnvFile(content)
+	}
+
+	// Return error if no valid data was found
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no valid entries found in %s file %s", s.format, s.path)
+	}
+
+	annotations := provenanceAnnotations(string(content))
+	annotations[annotationManagedBy] = annotationAppName
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        s.name,
+			Namespace:   namespace,
+			Labels:      map[string]string{annotationManagedBy: annotationAppName},
+			Annotations: annotations,
+		},
+		Data: data,
+	}, nil
+}
+
+// parseEnvFile parses the key=value lines of an environment file
+func parseEnvFile(content []byte) map[string]string {
+	data := make(map[string]string)
+	lines := strings.Split(string(content), "\n")
+
+	for _, line := range lines {
+		// Skip empty lines or comment lines
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split by first equals sign
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			log.Warnf("Ignoring invalid line in env file: %s", line)
+			continue
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		// Remove quotes if present
+		if len(value) > 1 && (strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) ||
+			(strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) {
+			value = value[1 : len(value)-1]
+		}
+
+		data[key] = value
+	}
+	return data
+}
+
+// parseJSONFile parses a JSON object, keeping its string members as they are
+// and the others as JSON
+func parseJSONFile(content []byte) (map[string]string, error) {
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &members); err != nil {
+		return nil, err
+	}
+	data := map[string]string{}
+	for key, raw := range members {
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			value = string(raw)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+// reconcileConfigMaps makes sure the synced ConfigMaps exist, or are pruned
+// where they are skipped
+func reconcileConfigMaps(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error {
+	sync := configEnableConfigMapSync && !configMapIsExcluded(ns)
+	if !sync {
+		nsLog(ns.Name).Debug("ConfigMaps skipped")
+		if !configPruneConfigMaps {
+			return nil
+		}
+	}
+	var firstErr error
+	for _, s := range syncedConfigMaps() {
+		var err error
+		if sync {
+			err = processSyncedConfigMap(ctx, k8s, ns.Name, s)
+		} else {
+			err = pruneSyncedConfigMap(ctx, k8s, ns.Name, s)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// processSyncedConfigMap ensures the ConfigMap of s exists in the given
+// namespace
+func processSyncedConfigMap(ctx context.Context, k8s *k8sClient, namespace string, s configMapSync) error {
+	logger := nsLog(namespace).WithField(logFieldConfigMap, s.name)
+	configMap, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// Create the ConfigMap from the file
+		desired, err := s.configMap(namespace)
+		if err != nil {
+			// If the file doesn't exist or is inaccessible, log it and return without error
+			logger.Debugf("Skipping ConfigMap creation: %v", err)
+			return nil
+		}
+		if configServerSideApply {
+			return applyConfigMap(ctx, k8s, desired, mutationCreate, "ConfigMapNotFound")
+		}
+
+		_, err = k8s.clientset.CoreV1().ConfigMaps(namespace).Create(ctx, desired, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create ConfigMap [%s]: %v", s.name, err)
+		}
+		logger.WithField(logFieldAction, mutationCreate).Info("Created ConfigMap")
+		recordMutation(ctx, k8s, namespace, mutationCreate, "ConfigMap", s.name, "ConfigMapNotFound")
+	} else if err != nil {
+		return fmt.Errorf("Failed to GET ConfigMap [%s]: %v", s.name, err)
+	} else {
+		if orphaned, err := reconcileOwnership(ctx, k8s, configMap); err != nil {
+			return err
+		} else if orphaned {
+			logger.Debug("ConfigMap is orphaned, left alone")
+			return nil
+		}
+		// Read the current file
+		desired, err := s.configMap(namespace)
+		if !isManagedConfigMap(configMap) {
+			return leaveUnowned(namespace, "ConfigMap", s.name, err == nil && mapsEqual(configMap.Data, desired.Data))
+		}
+		if err != nil {
+			// If the file doesn't exist anymore, consider removing the ConfigMap
+			logger.Warnf("ConfigMap file is no longer accessible: %v", err)
+			if configForce || configPruneConfigMaps {
+				logger.Warn("Deleting ConfigMap since its file is gone")
+				err = k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, s.name, metav1.DeleteOptions{})
+				if err != nil {
+					return fmt.Errorf("Failed to delete ConfigMap [%s]: %v", s.name, err)
+				}
+				logger.WithField(logFieldAction, mutationDelete).Info("Deleted ConfigMap")
+				recordMutation(ctx, k8s, namespace, mutationDelete, "ConfigMap", s.name, "ConfigFileGone")
+			}
+			return nil
+		}
+
+		// Check if the ConfigMap data matches what we read from the file
+		if !mapsEqual(configMap.Data, desired.Data) && configServerSideApply {
+			return applyConfigMap(ctx, k8s, desired, mutationPatch, "ConfigMapDataNotMatch")
+		} else if !mapsEqual(configMap.Data, desired.Data) {
+			if configForce {
+				logger.Warn("ConfigMap is not valid, overwriting now")
+				err = k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, s.name, metav1.DeleteOptions{})
+				if err != nil {
+					return fmt.Errorf("Failed to delete ConfigMap [%s]: %v", s.name, err)
+				}
+				logger.WithField(logFieldAction, mutationDelete).Warn("Deleted ConfigMap")
+				recordMutation(ctx, k8s, namespace, mutationDelete, "ConfigMap", s.name, "ConfigMapDataNotMatch")
+				_, err = k8s.clientset.CoreV1().ConfigMaps(namespace).Create(ctx, desired, metav1.CreateOptions{})
+				if err != nil {
+					return fmt.Errorf("Failed to create ConfigMap [%s]: %v", s.name, err)
+				}
+				logger.WithField(logFieldAction, mutationCreate).Info("Created ConfigMap")
+				recordMutation(ctx, k8s, namespace, mutationCreate, "ConfigMap", s.name, "ConfigMapDataNotMatch")
+			} else {
+				return fmt.Errorf("ConfigMap [%s] is not valid, set --force to true to overwrite", s.name)
+			}
+		} else {
+			logger.Debug("ConfigMap is valid")
+		}
+	}
+	return nil
+}
+
+// pruneSyncedConfigMap deletes the ConfigMap of s in namespace if we manage it
+func pruneSyncedConfigMap(ctx context.Context, k8s *k8sClient, namespace string, s configMapSync) error {
+	configMap, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to GET ConfigMap [%s]: %v", s.name, err)
+	}
+	if !isManagedConfigMap(configMap) {
+		nsLog(namespace).WithField(logFieldConfigMap, s.name).Debug("ConfigMap is unmanaged, not pruning it")
+		return nil
+	}
+	err = k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, s.name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to delete ConfigMap [%s]: %v", s.name, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldConfigMap: s.name, logFieldAction: mutationDelete}).Info("Pruned ConfigMap")
+	recordMutation(ctx, k8s, namespace, mutationDelete, "ConfigMap", s.name, "ConfigMapSyncDisabled")
+	return nil
+}
+
+// syncedFilesContent concatenates the files of the synced ConfigMaps, so a
+// changed file makes every namespace due again
+func syncedFilesContent() string {
+	contents := []string{}
+	for _, s := range syncedConfigMaps() {
+		content, _ := os.ReadFile(s.path)
+		contents = append(contents, s.name+"="+string(content))
+	}
+	return strings.Join(contents, "\x00")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseConfigMapSyncs(t *testing.T) {
+	defer func(path string) { configAWSConfigFilePath = path }(configAWSConfigFilePath)
+	configAWSConfigFilePath = "/config/aws-configs"
+
+	syncs, err := parseConfigMapSyncs([]string{
+		"name=app-env,path=/config/app.env",
+		" name = ca-bundle , path = /config/ca.pem , format = raw ",
+		"name=flags,path=/config/flags.json,format=json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []configMapSync{
+		{"app-env", "/config/app.env", syncFormatEnv},
+		{"ca-bundle", "/config/ca.pem", syncFormatRaw},
+		{"flags", "/config/flags.json", syncFormatJSON},
+	}
+	if !reflect.DeepEqual(syncs, expected) {
+		t.Errorf("parseConfigMapSyncs gives %+v, expects %+v", syncs, expected)
+	}
+
+	for _, specs := range [][]string{
+		{"name=app-env"},
+		{"path=/config/app.env"},
+		{"name=App_Env,path=/config/app.env"},
+		{"name=app-env,path=/config/app.env,format=yaml"},
+		{"name=app-env,path=/config/app.env,mode=0644"},
+		{"name=app-env,path=/config/app.env", "name=app-env,path=/config/other.env"},
+		{"name=" + configAWSConfigMapName + ",path=/config/other.env"},
+	} {
+		if _, err := parseConfigMapSyncs(specs); err == nil {
+			t.Errorf("parseConfigMapSyncs(%q) should fail", specs)
+		}
+	}
+
+	configAWSConfigFilePath = ""
+	if _, err := parseConfigMapSyncs([]string{"name=" + configAWSConfigMapName + ",path=/config/other.env"}); err != nil {
+		t.Errorf("the AWS ConfigMap name should be free with `aws-config-file` empty, got %v", err)
+	}
+}
+
+func TestConfigMapSyncFormats(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		sync     configMapSync
+		expected map[string]string
+	}{
+		{configMapSync{"env", write("app.env", "# comment\nLOG_LEVEL=info\nREGION = 'eu-west-1'\n"), syncFormatEnv}, map[string]string{"LOG_LEVEL": "info", "REGION": "eu-west-1"}},
+		{configMapSync{"raw", write("ca.pem", "-----BEGIN CERTIFICATE-----\n"), syncFormatRaw}, map[string]string{"ca.pem": "-----BEGIN CERTIFICATE-----\n"}},
+		{configMapSync{"json", write("flags.json", `{"endpoint":"https://api","retries":3,"features":{"beta":true}}`), syncFormatJSON}, map[string]string{"endpoint": "https://api", "retries": "3", "features": `{"beta":true}`}},
+	}
+	for _, test := range tests {
+		configMap, err := test.sync.configMap("team-a")
+		if err != nil {
+			t.Errorf("[%s] configMap failed: %v", test.sync.format, err)
+			continue
+		}
+		if configMap.Name != test.sync.name || configMap.Namespace != "team-a" || !isManagedConfigMap(configMap) {
+			t.Errorf("[%s] unexpected ConfigMap %s/%s", test.sync.format, configMap.Namespace, configMap.Name)
+		}
+		if !reflect.DeepEqual(configMap.Data, test.expected) {
+			t.Errorf("[%s] ConfigMap data is %v, expects %v", test.sync.format, configMap.Data, test.expected)
+		}
+	}
+
+	invalid := configMapSync{"json", write("invalid.json", `["not", "an", "object"]`), syncFormatJSON}
+	if _, err := invalid.configMap("team-a"); err == nil {
+		t.Errorf("a JSON file which is not an object should be refused")
+	}
+}
+
+func TestReconcileConfigMaps(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string, syncs []configMapSync) {
+		configAWSConfigFilePath, configMapSyncs = path, syncs
+	}(configAWSConfigFilePath, configMapSyncs)
+	configAWSConfigFilePath = filepath.Join(dir, "aws-configs")
+	if err := os.WriteFile(configAWSConfigFilePath, []byte("AWS_REGION=us-west-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, []byte("certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configMapSyncs = []configMapSync{
+		{"ca-bundle", caPath, syncFormatRaw},
+		{"missing", filepath.Join(dir, "missing.env"), syncFormatEnv},
+	}
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+
+	if err := reconcileConfigMaps(context.TODO(), k8s, ns); err != nil {
+		t.Fatalf("reconcileConfigMaps failed: %v", err)
+	}
+	for name, key := range map[string]string{configAWSConfigMapName: "AWS_REGION", "ca-bundle": "ca.pem"} {
+		configMap, err := k8s.clientset.CoreV1().ConfigMaps(ns.Name).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("reconcileConfigMaps did not create ConfigMap [%s]: %v", name, err)
+		} else if _, ok := configMap.Data[key]; !ok {
+			t.Errorf("ConfigMap [%s] lacks key %s, got %v", name, key, configMap.Data)
+		}
+	}
+	if _, err := k8s.clientset.CoreV1().ConfigMaps(ns.Name).Get(context.TODO(), "missing", metav1.GetOptions{}); err == nil {
+		t.Errorf("reconcileConfigMaps should skip the ConfigMap of a missing file")
+	}
+
+	// the ConfigMap is brought back in line with its changed file
+	if err := os.WriteFile(caPath, []byte("rotated certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileConfigMaps(context.TODO(), k8s, ns); err != nil {
+		t.Fatalf("reconcileConfigMaps failed: %v", err)
+	}
+	configMap, err := k8s.clientset.CoreV1().ConfigMaps(ns.Name).Get(context.TODO(), "ca-bundle", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["ca.pem"] != "rotated certificate" {
+		t.Errorf("ConfigMap [ca-bundle] was not updated, got %v", configMap.Data)
+	}
+}
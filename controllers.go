@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// namespaceObjectReconciler reconciles the namespace of a request with
+// reconcile, or with the whole processNamespace when reconcile is nil. The
+// requests are keyed by namespace, so the events of several objects of a
+// namespace are reconciled once.
+type namespaceObjectReconciler struct {
+	k8s       *k8sClient
+	reconcile namespaceReconciler
+}
+
+// Reconcile re-syncs the namespace of req, which the controller retries with
+// an exponential backoff on error
+func (r *namespaceObjectReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	return reconcile.Result{}, resyncNamespaceWith(ctx, r.k8s, req.Name, r.reconcile)
+}
+
+// namespaceRequest maps an object to the request of its namespace
+func namespaceRequest(obj client.Object) []reconcile.Request {
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = obj.GetName()
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: namespace}}}
+}
+
+// namespacePredicate passes namespaces created since started, the initial
+// list being covered by the loop, and namespaces whose labels or annotations,
+// which decide whether and how they are processed, changed
+func namespacePredicate(started time.Time) predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			if !createdSince(e.Object, started) {
+				return false
+			}
+			nsLog(e.Object.GetName()).Debug("Namespace was created")
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) || !reflect.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations())
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// secretPredicate passes the managed secrets which are deleted, or whose
+// data or type is modified
+func secretPredicate() predicate.Funcs {
+	managed := func(obj client.Object) bool {
+		for _, name := range managedSecretNames() {
+			if obj.GetName() == name {
+				return true
+			}
+		}
+		return false
+	}
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, ok := e.ObjectOld.(*corev1.Secret)
+			secret, ok2 := e.ObjectNew.(*corev1.Secret)
+			if !ok || !ok2 || !managed(secret) {
+				return false
+			}
+			if old.Type == secret.Type && reflect.DeepEqual(old.Data, secret.Data) {
+				return false
+			}
+			nsLog(secret.Namespace).Debug("Secret was modified")
+			return true
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			if !managed(e.Object) {
+				return false
+			}
+			nsLog(e.Object.GetNamespace()).Debug("Secret was deleted")
+			return true
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// serviceAccountPredicate passes the targeted service accounts which are
+// created since started, e.g. recreated by a CI tool, or modified, and miss
+// the image pull secret
+func serviceAccountPredicate(started time.Time) predicate.Funcs {
+	unpatched := func(obj client.Object) bool {
+		sa, ok := obj.(*corev1.ServiceAccount)
+		return ok && serviceAccountTargeted(sa) && !serviceAccountPatched(sa, managedSecretNames()...)
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			if !createdSince(e.Object, started) || !unpatched(e.Object) {
+				return false
+			}
+			nsLog(e.Object.GetNamespace()).WithField(logFieldServiceAccount, e.Object.GetName()).Debug("Service account was created")
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !unpatched(e.ObjectNew) {
+				return false
+			}
+			nsLog(e.ObjectNew.GetNamespace()).WithField(logFieldServiceAccount, e.ObjectNew.GetName()).Debug("Service account lost its imagePullSecret")
+			return true
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// configMapPredicate passes the deletions of managed synced ConfigMaps
+func configMapPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(event.UpdateEvent) bool { return false },
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			cm, ok := e.Object.(*corev1.ConfigMap)
+			if !ok || !isManagedConfigMap(cm) {
+				return false
+			}
+			if _, synced := syncedConfigMapNamed(cm.Name); !synced {
+				return false
+			}
+			nsLog(cm.Namespace).WithField(logFieldConfigMap, cm.Name).Info("ConfigMap was deleted, re-syncing it")
+			return true
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// createdSince tells whether obj was created at or after started, so the
+// initial list of a cache, which the loop covers, is not re-synced
+func createdSince(obj metav1.Object, started time.Time) bool {
+	return !obj.GetCreationTimestamp().Time.Before(started)
+}
+
+// managedObjectSelector restricts the cache to the objects named name, or to
+// those carrying the managed-by label when there are several names, as field
+// selectors can't match either of them
+func managedObjectSelector(names []string) cache.ObjectSelector {
+	if len(names) == 1 {
+		return cache.ObjectSelector{Field: fields.OneTermEqualSelector("metadata.name", names[0])}
+	}
+	return cache.ObjectSelector{Label: labels.SelectorFromSet(labels.Set{annotationManagedBy: annotationAppName})}
+}
+
+// labelSelectedNames returns names when managedObjectSelector selects their
+// objects by the managed-by label
+func labelSelectedNames(names []string) []string {
+	if len(names) == 1 {
+		return nil
+	}
+	return names
+}
+
+// syncedConfigMapNames returns the names of the synced ConfigMaps
+func syncedConfigMapNames() []string {
+	names := []string{}
+	for _, s := range syncedConfigMaps() {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// controllerSelectors restricts the cache to the secrets and ConfigMaps the
+// patcher manages, so it doesn't hold every secret of the cluster
+func controllerSelectors() cache.SelectorsByObject {
+	return cache.SelectorsByObject{
+		&corev1.Secret{}:    managedObjectSelector(managedSecretNames()),
+		&corev1.ConfigMap{}: managedObjectSelector(syncedConfigMapNames()),
+	}
+}
+
+// controllersEnabled tells whether any controller would be registered
+func controllersEnabled() bool {
+	return configInformers || configMapDeletionsWatched()
+}
+
+// configMapDeletionsWatched tells whether deleted synced ConfigMaps are
+// re-synced as soon as they are deleted
+func configMapDeletionsWatched() bool {
+	return configWatchConfigMapDeletions && configEnableConfigMapSync && len(syncedConfigMaps()) > 0
+}
+
+// addControllers registers a controller per kind of object whose events
+// re-sync a namespace with the reconciler of that kind, or all of them for
+// the namespace controller
+func addControllers(mgr manager.Manager, k8s *k8sClient, started time.Time) error {
+	options := controller.Options{MaxConcurrentReconciles: configConcurrency}
+	type namedController struct {
+		name      string
+		object    client.Object
+		predicate predicate.Predicate
+		reconcile namespaceReconciler
+	}
+	controllers := []namedController{}
+	if configInformers {
+		controllers = append(controllers, namedController{"namespace", &corev1.Namespace{}, namespacePredicate(started), nil})
+		// the secret mode writes the managed secret, other modes leave it to
+		// an operator or driver
+		if configEnableSecretSync && configSecretMode == secretModeSecret {
+			controllers = append(controllers, namedController{"secret", &corev1.Secret{}, secretPredicate(), reconcileSecret})
+		}
+		if configEnableSAPatch {
+			controllers = append(controllers, namedController{"serviceaccount", &corev1.ServiceAccount{}, serviceAccountPredicate(started), reconcileServiceAccounts})
+		}
+	}
+	if configMapDeletionsWatched() {
+		controllers = append(controllers, namedController{"configmap", &corev1.ConfigMap{}, configMapPredicate(), reconcileConfigMaps})
+	}
+	for _, c := range controllers {
+		err := builder.ControllerManagedBy(mgr).
+			Named(c.name).
+			WithOptions(options).
+			Watches(&source.Kind{Type: c.object}, handler.EnqueueRequestsFromMapFunc(namespaceRequest), builder.WithPredicates(c.predicate)).
+			Complete(&namespaceObjectReconciler{k8s: k8s, reconcile: c.reconcile})
+		if err != nil {
+			return fmt.Errorf("failed to set up the %s controller: %v", c.name, err)
+		}
+	}
+	return nil
+}
+
+// runControllers re-syncs namespaces as soon as they are created, and their
+// managed objects as soon as they are deleted or modified, on a
+// controller-runtime manager, until ctx is done and the reconciles in
+// progress are finished
+func runControllers(ctx context.Context, k8s *k8sClient) error {
+	logger := logr.New(logrusSink{entry: log.NewEntry(log.StandardLogger())})
+	crlog.SetLogger(logger)
+	// objects of earlier versions only carry the managed-by annotation, which
+	// the label selector of the cache would miss
+	if err := labelMarkedObjects(ctx, k8s, labelSelectedNames(managedSecretNames()), labelSelectedNames(syncedConfigMapNames())); err != nil {
+		return err
+	}
+	mgr, err := manager.New(k8s.restConfig, manager.Options{
+		Logger: logger,
+		// the metrics of the manager are served along ours by serveMetrics
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+		NewCache:               cache.BuilderWithOptions(cache.Options{SelectorsByObject: controllerSelectors()}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the controller manager: %v", err)
+	}
+	if err := addControllers(mgr, k8s, time.Now().Truncate(time.Second)); err != nil {
+		return err
+	}
+	log.Debug("Starting controllers")
+	return mgr.Start(ctx)
+}
+
+// logrusSink writes the logs of controller-runtime through logrus, so they
+// carry our format and redaction. Its info logs are debug level here, as they
+// only tell about the controllers starting and stopping.
+type logrusSink struct {
+	entry *log.Entry
+}
+
+func (s logrusSink) Init(logr.RuntimeInfo) {}
+
+func (s logrusSink) Enabled(level int) bool {
+	return s.entry.Logger.IsLevelEnabled(log.DebugLevel)
+}
+
+func (s logrusSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.withValues(keysAndValues).Debug(msg)
+}
+
+func (s logrusSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.withValues(keysAndValues).WithError(err).Error(msg)
+}
+
+func (s logrusSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return logrusSink{entry: s.withValues(keysAndValues)}
+}
+
+func (s logrusSink) WithName(name string) logr.LogSink {
+	if prefix, ok := s.entry.Data["logger"].(string); ok {
+		name = prefix + "." + name
+	}
+	return logrusSink{entry: s.entry.WithField("logger", name)}
+}
+
+func (s logrusSink) withValues(keysAndValues []interface{}) *log.Entry {
+	fields := log.Fields{}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	return s.entry.WithFields(fields)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNamespaceObjectReconciler(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configPaused = false
+	configExcludedNamespaces = ""
+	configEnableSecretSync = true
+	configEnableSAPatch = true
+	configEnableConfigMapSync = true
+	configAllServiceAccount = false
+	configServiceAccounts = defaultServiceAccountName
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "aws-configs")
+	if err := os.WriteFile(configAWSConfigFilePath, []byte("AWS_REGION=us-west-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { configEnableConfigMapSync = false }()
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault}},
+	)
+	k8s := &k8sClient{clientset: clientset}
+	if err := processNamespace(context.TODO(), k8s, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}); err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace := func(reconcile namespaceReconciler, namespace string) {
+		t.Helper()
+		r := &namespaceObjectReconciler{k8s: k8s, reconcile: reconcile}
+		if _, err := r.Reconcile(context.TODO(), namespaceRequest(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Delete(context.TODO(), configSecretName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace(reconcileSecret, corev1.NamespaceDefault)
+	secret, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("deleted secret was not recreated: %v", err)
+	}
+
+	secret.Data[corev1.DockerConfigJsonKey] = []byte("{}")
+	if _, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace(reconcileSecret, corev1.NamespaceDefault)
+	secret, err = clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil || verifySecret(secret) != secretOk {
+		t.Errorf("modified secret was not restored: %v", err)
+	}
+
+	_, err = clientset.CoreV1().ServiceAccounts(corev1.NamespaceDefault).Update(context.TODO(), &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault}}, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace(reconcileServiceAccounts, corev1.NamespaceDefault)
+	sa, err := clientset.CoreV1().ServiceAccounts(corev1.NamespaceDefault).Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil || !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("service account was not re-patched: %v", err)
+	}
+
+	err = clientset.CoreV1().ConfigMaps(corev1.NamespaceDefault).Delete(context.TODO(), configAWSConfigMapName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace(reconcileConfigMaps, corev1.NamespaceDefault)
+	if _, err := clientset.CoreV1().ConfigMaps(corev1.NamespaceDefault).Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{}); err != nil {
+		t.Errorf("deleted AWS ConfigMap was not recreated: %v", err)
+	}
+
+	_, err = clientset.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clientset.CoreV1().ServiceAccounts("new").Create(context.TODO(), &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "new"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace(nil, "new")
+	if _, err := clientset.CoreV1().Secrets("new").Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("secret was not created in new namespace: %v", err)
+	}
+	sa, err = clientset.CoreV1().ServiceAccounts("new").Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil || !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("service account of new namespace was not patched: %v", err)
+	}
+
+	// a namespace deleted since the event is not an error
+	reconcileNamespace(nil, "deleted")
+}
+
+func TestControllerPredicates(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretName = "registry"
+	configAllServiceAccount = false
+	configServiceAccounts = defaultServiceAccountName
+	configAWSConfigFilePath = "/etc/aws/config"
+	started := time.Now().Truncate(time.Second)
+	before := metav1.NewTime(started.Add(-time.Hour))
+	after := metav1.NewTime(started.Add(time.Second))
+
+	namespaces := namespacePredicate(started)
+	if namespaces.Create(event.CreateEvent{Object: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "old", CreationTimestamp: before}}}) {
+		t.Error("namespace of the initial list should not be reconciled")
+	}
+	if !namespaces.Create(event.CreateEvent{Object: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new", CreationTimestamp: after}}}) {
+		t.Error("created namespace should be reconciled")
+	}
+	old := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}
+	relabelled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{"team": "a"}}}
+	if !namespaces.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: relabelled}) {
+		t.Error("relabelled namespace should be reconciled")
+	}
+	if namespaces.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: old.DeepCopy()}) {
+		t.Error("unchanged namespace should not be reconciled")
+	}
+
+	secrets := secretPredicate()
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "ns"}, Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(testDockerconfig)}}
+	modified := secret.DeepCopy()
+	modified.Data[corev1.DockerConfigJsonKey] = []byte("{}")
+	if !secrets.Update(event.UpdateEvent{ObjectOld: secret, ObjectNew: modified}) {
+		t.Error("modified secret should be reconciled")
+	}
+	if secrets.Update(event.UpdateEvent{ObjectOld: secret, ObjectNew: secret.DeepCopy()}) {
+		t.Error("secret with unchanged data should not be reconciled")
+	}
+	if !secrets.Delete(event.DeleteEvent{Object: secret}) {
+		t.Error("deleted secret should be reconciled")
+	}
+	if secrets.Delete(event.DeleteEvent{Object: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"}}}) {
+		t.Error("deleted unmanaged secret should not be reconciled")
+	}
+
+	serviceAccounts := serviceAccountPredicate(started)
+	if !serviceAccounts.Create(event.CreateEvent{Object: &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "ns", CreationTimestamp: after}}}) {
+		t.Error("created service account should be reconciled")
+	}
+	if serviceAccounts.Create(event.CreateEvent{Object: &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns", CreationTimestamp: after}}}) {
+		t.Error("created service account which is not targeted should not be reconciled")
+	}
+	patched := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "ns"}, ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}}}
+	if serviceAccounts.Update(event.UpdateEvent{ObjectOld: patched, ObjectNew: patched}) {
+		t.Error("patched service account should not be reconciled")
+	}
+
+	configMaps := configMapPredicate()
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configAWSConfigMapName, Namespace: "ns", Labels: map[string]string{annotationManagedBy: annotationAppName}}}
+	if !configMaps.Delete(event.DeleteEvent{Object: configMap}) {
+		t.Error("deleted synced ConfigMap should be reconciled")
+	}
+	if configMaps.Delete(event.DeleteEvent{Object: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configAWSConfigMapName, Namespace: "ns"}}}) {
+		t.Error("deleted unmanaged ConfigMap should not be reconciled")
+	}
+
+	if requests := namespaceRequest(secret); len(requests) != 1 || requests[0].Name != "ns" || requests[0].Namespace != "" {
+		t.Errorf("secret should map to the request of its namespace, got %v", requests)
+	}
+}
+
+func TestReconcilersAreIndependent(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configEnableSecretSync = true
+	configEnableSAPatch = true
+	configEnableConfigMapSync = false
+	configPruneConfigMaps = false
+	configAllServiceAccount = false
+	configServiceAccounts = defaultServiceAccountName
+	dockerConfigJSON = testDockerconfig
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}
+	clientset := fake.NewSimpleClientset(
+		&ns,
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault}},
+	)
+	k8s := &k8sClient{clientset: clientset}
+
+	if err := reconcileServiceAccounts(context.TODO(), k8s, ns); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts(corev1.NamespaceDefault).Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil || !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("service account was not patched: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{}); err == nil {
+		t.Error("service account reconciler created the secret")
+	}
+
+	if err := reconcileSecret(context.TODO(), k8s, ns); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("secret was not created: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logFieldSweepID     = "sweep_id"
+	logFieldReconcileID = "reconcile_id"
+
+	// the fields naming the objects a log line is about, and the mutation
+	// action it reports
+	logFieldNamespace      = "namespace"
+	logFieldSecret         = "secret"
+	logFieldServiceAccount = "serviceaccount"
+	logFieldConfigMap      = "configmap"
+	logFieldAction         = "action"
+)
+
+var (
+	correlationMu sync.RWMutex
+	sweepID       string
+	reconcileIDs  = map[string]string{}
+)
+
+func newCorrelationID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// startSweep assigns a new ID to the current pass over all namespaces
+func startSweep() *log.Entry {
+	correlationMu.Lock()
+	defer correlationMu.Unlock()
+	sweepID = newCorrelationID()
+	return log.WithField(logFieldSweepID, sweepID)
+}
+
+// startReconcile assigns a new reconcile ID to namespace within the current sweep
+func startReconcile(namespace string) *log.Entry {
+	correlationMu.Lock()
+	reconcileIDs[namespace] = newCorrelationID()
+	correlationMu.Unlock()
+	return nsLog(namespace)
+}
+
+// finishReconcile drops the reconcile ID of namespace
+func finishReconcile(namespace string) {
+	correlationMu.Lock()
+	defer correlationMu.Unlock()
+	delete(reconcileIDs, namespace)
+}
+
+// nsLog returns a logger carrying namespace and its sweep and reconcile IDs
+func nsLog(namespace string) *log.Entry {
+	correlationMu.RLock()
+	defer correlationMu.RUnlock()
+	fields := log.Fields{logFieldNamespace: namespace}
+	if sweepID != "" {
+		fields[logFieldSweepID] = sweepID
+	}
+	if id, ok := reconcileIDs[namespace]; ok {
+		fields[logFieldReconcileID] = id
+	}
+	return log.WithFields(fields)
+}
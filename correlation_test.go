@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNsLogCorrelationIDs(t *testing.T) {
+	sweepLog := startSweep()
+	sweep, ok := sweepLog.Data[logFieldSweepID]
+	if !ok {
+		t.Fatalf("startSweep gives no %s", logFieldSweepID)
+	}
+
+	if _, ok := nsLog("default").Data[logFieldReconcileID]; ok {
+		t.Errorf("nsLog gives %s before startReconcile", logFieldReconcileID)
+	}
+	if actual := nsLog("default").Data[logFieldNamespace]; actual != "default" {
+		t.Errorf("nsLog gives %s %v, expects default", logFieldNamespace, actual)
+	}
+
+	entry := startReconcile("default")
+	if entry.Data[logFieldSweepID] != sweep {
+		t.Errorf("startReconcile gives %s %v, expects %v", logFieldSweepID, entry.Data[logFieldSweepID], sweep)
+	}
+	reconcile, ok := entry.Data[logFieldReconcileID]
+	if !ok {
+		t.Fatalf("startReconcile gives no %s", logFieldReconcileID)
+	}
+	if actual := nsLog("default").Data[logFieldReconcileID]; actual != reconcile {
+		t.Errorf("nsLog gives %s %v, expects %v", logFieldReconcileID, actual, reconcile)
+	}
+	if _, ok := nsLog("other").Data[logFieldReconcileID]; ok {
+		t.Errorf("nsLog gives %s for a namespace not being reconciled", logFieldReconcileID)
+	}
+
+	finishReconcile("default")
+	if _, ok := nsLog("default").Data[logFieldReconcileID]; ok {
+		t.Errorf("nsLog gives %s after finishReconcile", logFieldReconcileID)
+	}
+}
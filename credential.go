@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// credentialState records when the distributed content of a credential set
+// was last seen to change, and whether the rotation SLA warning was logged
+// since
+type credentialState struct {
+	hash      string
+	changedAt time.Time
+	slaWarned bool
+}
+
+var credentialStates = map[string]*credentialState{}
+
+func credentialHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// credentialSourceModTime returns the time the credential source was last
+// written, falling back to now when the source is not a file
+func credentialSourceModTime(now time.Time) time.Time {
+	if configDockerConfigJSONPath == "" {
+		return now
+	}
+	info, err := os.Stat(configDockerConfigJSONPath)
+	if err != nil {
+		return now
+	}
+	return info.ModTime()
+}
+
+// trackCredential records the content of credential set name and returns how
+// long it has been unchanged
+func trackCredential(name, content string, now time.Time) time.Duration {
+	hash := credentialHash(content)
+	state, ok := credentialStates[name]
+	if !ok {
+		state = &credentialState{hash: hash, changedAt: credentialSourceModTime(now)}
+		credentialStates[name] = state
+	} else if state.hash != hash {
+		log.Infof("Credential [%s] changed", name)
+		state.hash = hash
+		state.changedAt = now
+		state.slaWarned = false
+	}
+	return now.Sub(state.changedAt)
+}
+
+// updateCredentialExpiry exposes when credential set name expires, dropping
+// the metric when its expiry is unknown
+func updateCredentialExpiry(name string, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		metricCredentialExpiry.DeleteLabelValues(name)
+		return
+	}
+	metricCredentialExpiry.WithLabelValues(name).Set(float64(expiresAt.Unix()))
+}
+
+// updateCredentialAge refreshes the age metrics of credential set name and
+// warns once per content when the rotation SLA is exceeded, the metrics being
+// what to alert on
+func updateCredentialAge(name, content string) {
+	age := trackCredential(name, content, time.Now())
+	metricCredentialAge.WithLabelValues(name).Set(age.Seconds())
+	if configCredentialRotationSLA <= 0 {
+		return
+	}
+	if age > configCredentialRotationSLA {
+		if state := credentialStates[name]; !state.slaWarned {
+			state.slaWarned = true
+			log.Warnf("Credential [%s] is %s old, exceeding the rotation SLA of %s", name, age.Round(time.Second), configCredentialRotationSLA)
+		}
+		metricCredentialRotationSLAExceeded.WithLabelValues(name).Set(1)
+	} else {
+		metricCredentialRotationSLAExceeded.WithLabelValues(name).Set(0)
+	}
+}
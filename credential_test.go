@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestTrackCredential(t *testing.T) {
+	credentialStates = map[string]*credentialState{}
+	configDockerConfigJSONPath = ""
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		content  string
+		now      time.Time
+		expected time.Duration
+	}{
+		{
+			name:     "first seen",
+			content:  testDockerconfig,
+			now:      start,
+			expected: 0,
+		},
+		{
+			name:     "unchanged",
+			content:  testDockerconfig,
+			now:      start.Add(time.Hour),
+			expected: time.Hour,
+		},
+		{
+			name:     "changed",
+			content:  `{"auths":{}}`,
+			now:      start.Add(2 * time.Hour),
+			expected: 0,
+		},
+		{
+			name:     "unchanged after change",
+			content:  `{"auths":{}}`,
+			now:      start.Add(3 * time.Hour),
+			expected: time.Hour,
+		},
+	} {
+		if actual := trackCredential("registry", tc.content, tc.now); actual != tc.expected {
+			t.Errorf("trackCredential(%s) gives %v, expects %v", tc.name, actual, tc.expected)
+		}
+	}
+}
+
+func TestUpdateCredentialAgeWarnsOnce(t *testing.T) {
+	credentialStates = map[string]*credentialState{}
+	configCredentialRotationSLA = time.Hour
+	defer func() { configCredentialRotationSLA = 0 }()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testDockerconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	configDockerConfigJSONPath = path
+	defer func() { configDockerConfigJSONPath = "" }()
+	hook := captureLogs(t)
+
+	for i := 0; i < 3; i++ {
+		updateCredentialAge("registry", testDockerconfig)
+	}
+	warnings := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("updateCredentialAge logged %d warnings over 3 loops, expects 1", warnings)
+	}
+
+	updateCredentialAge("registry", `{"auths":{}}`)
+	if credentialStates["registry"].slaWarned {
+		t.Errorf("updateCredentialAge kept the warning of the previous credential")
+	}
+}
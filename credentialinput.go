@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stdinMaxCredentialSize bounds the credential read from stdin
+const stdinMaxCredentialSize = 1 << 20
+
+// decodeDockerConfigJSONBase64 decodes the base64 encoded dockerconfigjson,
+// as found in the data of a secret, with or without padding
+func decodeDockerConfigJSONBase64(s string) (string, error) {
+	s = strings.Join(strings.Fields(s), "")
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return "", fmt.Errorf("`dockerconfigjson-b64` is not valid base64: %v", err)
+	}
+	return string(b), nil
+}
+
+// readDockerConfigJSON reads the whole dockerconfigjson from r, once at
+// start-up
+func readDockerConfigJSON(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, stdinMaxCredentialSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read dockerconfigjson from stdin: %v", err)
+	}
+	if len(b) > stdinMaxCredentialSize {
+		return "", fmt.Errorf("dockerconfigjson on stdin exceeds %d bytes", stdinMaxCredentialSize)
+	}
+	content := strings.TrimSpace(string(b))
+	if content == "" {
+		return "", fmt.Errorf("no dockerconfigjson on stdin")
+	}
+	return content, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDockerConfigJSONBase64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testDockerconfig))
+	for _, s := range []string{
+		encoded,
+		strings.TrimRight(encoded, "="),
+		encoded[:20] + "\n" + encoded[20:] + "\n",
+	} {
+		content, err := decodeDockerConfigJSONBase64(s)
+		if err != nil || content != testDockerconfig {
+			t.Errorf("decodeDockerConfigJSONBase64(%q) = %q, %v, want %q", s, content, err, testDockerconfig)
+		}
+	}
+	if _, err := decodeDockerConfigJSONBase64(testDockerconfig); err == nil {
+		t.Errorf("decodeDockerConfigJSONBase64 of plain json should fail")
+	}
+}
+
+func TestReadDockerConfigJSON(t *testing.T) {
+	content, err := readDockerConfigJSON(strings.NewReader(testDockerconfig + "\n"))
+	if err != nil || content != testDockerconfig {
+		t.Errorf("readDockerConfigJSON = %q, %v, want %q", content, err, testDockerconfig)
+	}
+	if _, err := readDockerConfigJSON(strings.NewReader(" \n")); err == nil {
+		t.Errorf("readDockerConfigJSON of empty input should fail")
+	}
+	if _, err := readDockerConfigJSON(strings.NewReader(strings.Repeat("x", stdinMaxCredentialSize+1))); err == nil {
+		t.Errorf("readDockerConfigJSON of oversized input should fail")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// vaultHTTPTimeout bounds a single request to Vault
+const vaultHTTPTimeout = 10 * time.Second
+
+var (
+	// credentialSources are parsed from the credential sources config
+	credentialSources []credentialSource
+
+	vaultHTTPClient = &http.Client{Timeout: vaultHTTPTimeout}
+)
+
+// credentialSource loads the dockerconfigjson from one place
+type credentialSource interface {
+	// String returns the source as configured, naming it in logs and metrics
+	String() string
+	load(ctx context.Context) (string, error)
+}
+
+// fileCredentialSource reads a mounted file, re-read on every load
+type fileCredentialSource struct {
+	path string
+}
+
+func (s fileCredentialSource) String() string { return "file:" + s.path }
+
+func (s fileCredentialSource) load(context.Context) (string, error) {
+	b, err := os.ReadFile(s.path)
+	return string(b), err
+}
+
+// envCredentialSource reads an environment variable
+type envCredentialSource struct {
+	variable string
+}
+
+func (s envCredentialSource) String() string { return "env:" + s.variable }
+
+func (s envCredentialSource) load(context.Context) (string, error) {
+	v, ok := os.LookupEnv(s.variable)
+	if !ok || v == "" {
+		return "", fmt.Errorf("environment variable %s is not set", s.variable)
+	}
+	return v, nil
+}
+
+// vaultCredentialSource reads a field of a Vault KV secret
+type vaultCredentialSource struct {
+	path  string
+	field string
+	lease *vaultLease
+}
+
+// vaultLease records when the secret last read from Vault expires, as told by
+// its lease duration
+type vaultLease struct {
+	mu        sync.Mutex
+	expiresAt time.Time
+}
+
+func (s vaultCredentialSource) String() string { return "vault:" + s.path + "#" + s.field }
+
+// vaultToken returns the token from the token file, VAULT_TOKEN otherwise
+func vaultToken() (string, error) {
+	if configVaultTokenFile == "" {
+		return os.Getenv("VAULT_TOKEN"), nil
+	}
+	b, err := os.ReadFile(configVaultTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault token: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s vaultCredentialSource) load(ctx context.Context) (string, error) {
+	token, err := vaultToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(configVaultAddr, "/")+"/v1/"+strings.TrimPrefix(s.path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := vaultHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault answered %s", resp.Status)
+	}
+	secret := struct {
+		LeaseDuration int64                  `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("invalid Vault response: %v", err)
+	}
+	if s.lease != nil {
+		s.lease.mu.Lock()
+		s.lease.expiresAt = time.Time{}
+		if secret.LeaseDuration > 0 {
+			s.lease.expiresAt = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+		}
+		s.lease.mu.Unlock()
+	}
+	// KV version 2 nests the fields in another data object
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	v, ok := data[s.field].(string)
+	if !ok || v == "" {
+		return "", fmt.Errorf("field %s not found", s.field)
+	}
+	return v, nil
+}
+
+// parseCredentialSources parses a comma-separated list of `file:<path>`,
+// `env:<variable>` and `vault:<path>#<field>` sources
+func parseCredentialSources(spec string) ([]credentialSource, error) {
+	sources := []credentialSource{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		kind, arg, _ := strings.Cut(s, ":")
+		if arg == "" {
+			return nil, fmt.Errorf("credential source %q lacks an argument", s)
+		}
+		switch kind {
+		case "file":
+			sources = append(sources, fileCredentialSource{path: arg})
+		case "env":
+			sources = append(sources, envCredentialSource{variable: arg})
+		case "vault":
+			path, field, _ := strings.Cut(arg, "#")
+			if field == "" {
+				field = "dockerconfigjson"
+			}
+			if configVaultAddr == "" {
+				return nil, fmt.Errorf("`vault-addr` is required for credential source %q", s)
+			}
+			sources = append(sources, vaultCredentialSource{path: path, field: field, lease: &vaultLease{}})
+		default:
+			return nil, fmt.Errorf("unknown credential source %q", s)
+		}
+	}
+	return sources, nil
+}
+
+// loadCredentialChain returns the credential of the first source of sources
+// which loads, recording which one is active
+func loadCredentialChain(ctx context.Context, sources []credentialSource) (string, error) {
+	errs := []string{}
+	active := -1
+	content := ""
+	for i, source := range sources {
+		var err error
+		content, err = source.load(ctx)
+		if err == nil {
+			registerCredential(source.String(), content)
+			active = i
+			break
+		}
+		log.Warnf("Credential source [%s] is unavailable: %v", source, err)
+		metricCredentialSourceFailures.WithLabelValues(source.String()).Inc()
+		errs = append(errs, fmt.Sprintf("%s: %v", source, err))
+	}
+	for i, source := range sources {
+		if i == active {
+			metricCredentialSourceActive.WithLabelValues(source.String()).Set(1)
+		} else {
+			metricCredentialSourceActive.WithLabelValues(source.String()).Set(0)
+		}
+	}
+	if active < 0 {
+		return "", fmt.Errorf("no credential source available: %s", strings.Join(errs, "; "))
+	}
+	if active > 0 {
+		log.Warnf("Using fallback credential source [%s]", sources[active])
+	}
+	return content, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseCredentialSources(t *testing.T) {
+	defer func() { configVaultAddr = "" }()
+	configVaultAddr = "https://vault.example.com"
+	sources, err := parseCredentialSources("vault:secret/data/registry, file:/secrets/.dockerconfigjson,env:REGISTRY_AUTH")
+	if err != nil {
+		t.Fatalf("parseCredentialSources failed: %v", err)
+	}
+	want := []string{"vault:secret/data/registry#dockerconfigjson", "file:/secrets/.dockerconfigjson", "env:REGISTRY_AUTH"}
+	if len(sources) != len(want) {
+		t.Fatalf("parseCredentialSources returned %d sources, want %d", len(sources), len(want))
+	}
+	for i, source := range sources {
+		if source.String() != want[i] {
+			t.Errorf("source %d = %s, want %s", i, source, want[i])
+		}
+	}
+
+	for _, spec := range []string{"s3:bucket/key", "file:", "file"} {
+		if _, err := parseCredentialSources(spec); err == nil {
+			t.Errorf("parseCredentialSources(%q) should fail", spec)
+		}
+	}
+	configVaultAddr = ""
+	if _, err := parseCredentialSources("vault:secret/data/registry"); err == nil {
+		t.Errorf("parseCredentialSources should require the Vault address")
+	}
+}
+
+func TestLoadCredentialChain(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	path := filepath.Join(t.TempDir(), ".dockerconfigjson")
+	t.Setenv("TEST_REGISTRY_AUTH", "from-env")
+	primary := fileCredentialSource{path: path}
+	fallback := envCredentialSource{variable: "TEST_REGISTRY_AUTH"}
+	sources := []credentialSource{primary, fallback}
+
+	content, err := loadCredentialChain(context.TODO(), sources)
+	if err != nil || content != "from-env" {
+		t.Fatalf("loadCredentialChain = %q, %v, want the fallback", content, err)
+	}
+	if v := testutil.ToFloat64(metricCredentialSourceActive.WithLabelValues(fallback.String())); v != 1 {
+		t.Errorf("fallback source active = %v, want 1", v)
+	}
+
+	if err := os.WriteFile(path, []byte("from-file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	content, err = loadCredentialChain(context.TODO(), sources)
+	if err != nil || content != "from-file" {
+		t.Fatalf("loadCredentialChain = %q, %v, want the primary", content, err)
+	}
+	if v := testutil.ToFloat64(metricCredentialSourceActive.WithLabelValues(fallback.String())); v != 0 {
+		t.Errorf("fallback source active = %v, want 0", v)
+	}
+
+	if _, err := loadCredentialChain(context.TODO(), []credentialSource{envCredentialSource{variable: "TEST_UNSET"}}); err == nil {
+		t.Errorf("loadCredentialChain without available source should fail")
+	}
+}
+
+func TestVaultCredentialSource(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/registry":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": map[string]interface{}{"dockerconfigjson": "from-kv2"}},
+			})
+		case "/v1/kv/registry":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_duration": 3600,
+				"data":           map[string]interface{}{"auth": "from-kv1"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defaultClient := vaultHTTPClient
+	defer func() { vaultHTTPClient, configVaultAddr = defaultClient, "" }()
+	vaultHTTPClient, configVaultAddr = server.Client(), server.URL
+	t.Setenv("VAULT_TOKEN", "token")
+
+	for source, want := range map[vaultCredentialSource]string{
+		{path: "secret/data/registry", field: "dockerconfigjson"}: "from-kv2",
+		{path: "kv/registry", field: "auth"}:                      "from-kv1",
+	} {
+		if got, err := source.load(context.TODO()); err != nil || got != want {
+			t.Errorf("%s load() = %q, %v, want %q", source, got, err, want)
+		}
+	}
+	for _, source := range []vaultCredentialSource{
+		{path: "kv/registry", field: "missing"},
+		{path: "kv/other", field: "auth"},
+	} {
+		if _, err := source.load(context.TODO()); err == nil {
+			t.Errorf("%s load() should fail", source)
+		}
+	}
+
+	source := vaultCredentialSource{path: "kv/registry", field: "auth", lease: &vaultLease{}}
+	if _, err := source.load(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	if exp := source.expiry(); exp.Before(time.Now().Add(59*time.Minute)) || exp.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiry of a secret leased for an hour is %s", exp)
+	}
+	source.path, source.field = "secret/data/registry", "dockerconfigjson"
+	if _, err := source.load(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	if exp := source.expiry(); !exp.IsZero() {
+		t.Errorf("expiry of a secret without lease is %s, want none", exp)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// credentialHelperTimeout bounds a single execution of a credential helper
+const credentialHelperTimeout = 10 * time.Second
+
+// dockerCLIConfig is the part of a docker CLI config.json naming the
+// credential helpers
+type dockerCLIConfig struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore,omitempty"`
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+}
+
+// runCredentialHelper executes `docker-credential-<helper> get` for registry,
+// following the docker credential helper protocol
+func runCredentialHelper(helper, registry string) (dockerConfigAuth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return dockerConfigAuth{}, fmt.Errorf("credential helper %s is not installed, add docker-credential-%s to the image: %v", helper, helper, err)
+	}
+	if err != nil {
+		// helpers print the reason to stdout, or stderr
+		reason := strings.TrimSpace(string(out) + stderr.String())
+		return dockerConfigAuth{}, fmt.Errorf("credential helper %s failed for %s: %v %s", helper, registry, err, reason)
+	}
+	creds := struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}{}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return dockerConfigAuth{}, fmt.Errorf("invalid output of credential helper %s: %v", helper, err)
+	}
+	if creds.Username == "<token>" {
+		return dockerConfigAuth{}, fmt.Errorf("credential helper %s returned an identity token for %s, which the kubelet cannot use", helper, registry)
+	}
+	return dockerConfigAuth{
+		Username: creds.Username,
+		Password: creds.Secret,
+		Auth:     base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Secret)),
+	}, nil
+}
+
+// resolveCredentialHelpers replaces the credential helpers of a docker CLI
+// config.json by the credentials they return, the registries of credHelpers
+// by their helper and those without credentials in auths by credsStore, and
+// returns the resulting dockerconfigjson. A config without helpers is
+// returned as is.
+func resolveCredentialHelpers(content string) (string, error) {
+	config := dockerCLIConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil || (config.CredsStore == "" && len(config.CredHelpers) == 0) {
+		return content, nil
+	}
+	helpers := map[string]string{}
+	if config.CredsStore != "" {
+		for registry, auth := range config.Auths {
+			if auth.Auth == "" && auth.Username == "" {
+				helpers[registry] = config.CredsStore
+			}
+		}
+	}
+	for registry, helper := range config.CredHelpers {
+		helpers[registry] = helper
+	}
+	registries := []string{}
+	for registry := range helpers {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	resolved := dockerConfig{Auths: map[string]dockerConfigAuth{}}
+	for registry, auth := range config.Auths {
+		if _, ok := helpers[registry]; !ok {
+			resolved.Auths[registry] = auth
+		}
+	}
+	for _, registry := range registries {
+		auth, err := runCredentialHelper(helpers[registry], registry)
+		if err != nil {
+			return "", err
+		}
+		resolved.Auths[registry] = auth
+	}
+	b, err := json.Marshal(resolved)
+	return string(b), err
+}
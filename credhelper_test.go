@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveCredentialHelpers(t *testing.T) {
+	dir := t.TempDir()
+	helper := `#!/bin/sh
+read registry
+case "$registry" in
+  *.dkr.ecr.*) echo '{"ServerURL":"'$registry'","Username":"AWS","Secret":"password"}' ;;
+  *) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	plain := `{"auths":{"ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`
+	if content, err := resolveCredentialHelpers(plain); err != nil || content != plain {
+		t.Errorf("config without helpers changed to %s, error %v", content, err)
+	}
+
+	content, err := resolveCredentialHelpers(`{"auths":{"ghcr.io":{"auth":"Ym90OnRva2Vu"},"123456789012.dkr.ecr.us-east-1.amazonaws.com":{}},"credsStore":"test","credHelpers":{"123456789012.dkr.ecr.eu-west-1.amazonaws.com":"test"}}`)
+	if err != nil {
+		t.Fatalf("resolveCredentialHelpers has error %v", err)
+	}
+	expected := `{"auths":{"123456789012.dkr.ecr.eu-west-1.amazonaws.com":{"username":"AWS","password":"password","auth":"QVdTOnBhc3N3b3Jk"},"123456789012.dkr.ecr.us-east-1.amazonaws.com":{"username":"AWS","password":"password","auth":"QVdTOnBhc3N3b3Jk"},"ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`
+	if content != expected {
+		t.Errorf("content %s, want %s", content, expected)
+	}
+
+	if _, err := resolveCredentialHelpers(`{"credHelpers":{"quay.io":"test"}}`); err == nil {
+		t.Error("no error for a registry the helper has no credentials of")
+	}
+	if _, err := resolveCredentialHelpers(`{"credHelpers":{"quay.io":"missing"}}`); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("missing helper gives error %v, expects it to tell the helper is not installed", err)
+	}
+}
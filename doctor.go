@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// imageRegistryHost returns the host serving the registry API for an image
+// reference, following the docker rules for references without a registry
+func imageRegistryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return registryHost("docker.io")
+	}
+	return registryHost(first)
+}
+
+// secretDockerConfig parses the credential a pull with secret would use,
+// checking it against the distributed credential in secret mode
+func secretDockerConfig(secret *corev1.Secret) (dockerConfig, error) {
+	config := dockerConfig{}
+	if result := verifySecret(secret); result == secretWrongType || result == secretNoKey {
+		return config, fmt.Errorf("%s", result)
+	} else if result == secretDataNotMatch && configSecretMode == secretModeSecret {
+		return config, fmt.Errorf("%s, the patcher has not synced it yet", result)
+	}
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+		return config, fmt.Errorf("invalid %s: %v", corev1.DockerConfigJsonKey, err)
+	}
+	return config, nil
+}
+
+// doctorRegistries returns the registries of the images of pod, or of every
+// credential of config without pod
+func doctorRegistries(pod *corev1.Pod, config dockerConfig) []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	if pod == nil {
+		for key := range config.Auths {
+			add(registryHost(key))
+		}
+		sort.Strings(hosts)
+		return hosts
+	}
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		add(imageRegistryHost(c.Image))
+	}
+	return hosts
+}
+
+// registryCredential returns the credential of config for host
+func registryCredential(config dockerConfig, host string) (dockerConfigAuth, bool) {
+	for key, auth := range config.Auths {
+		if registryHost(key) == host {
+			return auth, true
+		}
+	}
+	return dockerConfigAuth{}, false
+}
+
+// runDoctor walks the links an image pull in namespace depends on, for the
+// images of pod when given, printing every link to w and returning the first
+// broken one
+func runDoctor(ctx context.Context, k8s *k8sClient, w io.Writer, namespace, podName string) error {
+	ok := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, "OK    "+format+"\n", args...)
+	}
+	fail := func(format string, args ...interface{}) error {
+		err := fmt.Errorf(format, args...)
+		fmt.Fprintf(w, "FAIL  %v\n", err)
+		return err
+	}
+
+	var err error
+	dockerConfigJSON, err = getDockerConfigJSON(ctx)
+	if err != nil {
+		return fail("failed to load dockerconfigjson: %v", err)
+	}
+
+	ns, err := k8s.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return fail("namespace [%s]: %v", namespace, err)
+	}
+	if namespaceIsExcluded(*ns) {
+		return fail("namespace [%s] is excluded from processing", namespace)
+	}
+	ok("namespace [%s] is processed", namespace)
+
+	var pod *corev1.Pod
+	serviceAccount := defaultServiceAccountName
+	if podName != "" {
+		pod, err = k8s.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return fail("pod [%s]: %v", podName, err)
+		}
+		if pod.Spec.ServiceAccountName != "" {
+			serviceAccount = pod.Spec.ServiceAccountName
+		}
+	}
+
+	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, configSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fail("secret [%s] does not exist", configSecretName)
+	} else if err != nil {
+		return fail("secret [%s]: %v", configSecretName, err)
+	}
+	config, err := secretDockerConfig(secret)
+	if err != nil {
+		return fail("secret [%s] is not valid: %v", configSecretName, err)
+	}
+	ok("secret [%s] is valid", configSecretName)
+
+	sa, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		return fail("service account [%s]: %v", serviceAccount, err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		return fail("service account [%s] does not reference secret [%s]", serviceAccount, configSecretName)
+	}
+	ok("service account [%s] references secret [%s]", serviceAccount, configSecretName)
+	if pod != nil {
+		referenced := false
+		for _, ref := range pod.Spec.ImagePullSecrets {
+			referenced = referenced || ref.Name == configSecretName
+		}
+		// imagePullSecrets of the service account are copied on pod admission only
+		if !referenced {
+			return fail("pod [%s] does not reference secret [%s], it was created before service account [%s] was patched and has to be recreated", podName, configSecretName, serviceAccount)
+		}
+		ok("pod [%s] references secret [%s]", podName, configSecretName)
+	}
+
+	for _, host := range doctorRegistries(pod, config) {
+		auth, found := registryCredential(config, host)
+		if !found {
+			return fail("secret [%s] holds no credential for registry [%s]", configSecretName, host)
+		}
+		if err := checkRegistry(ctx, host, auth); err != nil {
+			return fail("credential for registry [%s] does not authenticate: %v", host, err)
+		}
+		ok("credential for registry [%s] authenticates", host)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestImageRegistryHost(t *testing.T) {
+	for image, want := range map[string]string{
+		"nginx":                                   "registry-1.docker.io",
+		"library/nginx:1.25":                      "registry-1.docker.io",
+		"gcr.io/project/app@sha256:abc":           "gcr.io",
+		"registry.local:5000/app":                 "registry.local:5000",
+		"localhost/app":                           "localhost",
+		"docker.io/library/nginx:1.25":            "registry-1.docker.io",
+		"123.dkr.ecr.eu-west-1.amazonaws.com/app": "123.dkr.ecr.eu-west-1.amazonaws.com",
+	} {
+		if got := imageRegistryHost(image); got != want {
+			t.Errorf("imageRegistryHost(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+// setupDoctorTest returns a client for namespace app holding the managed
+// secret with password for the registry at host, and a pod pulling from it
+func setupDoctorTest(host, password string, podSecrets []corev1.LocalObjectReference) *k8sClient {
+	configSecretMode = secretModeSecret
+	configDockerConfigJSONPath = ""
+	configDockerconfigjson = `{"auths":{"` + host + `":{"username":"user","password":"` + password + `"}}}`
+	dockerConfigJSON = configDockerconfigjson
+	secret := dockerconfigSecret("app")
+	return &k8sClient{clientset: fake.NewSimpleClientset([]runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+		secret,
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "app"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "app"},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: podSecrets,
+				Containers:       []corev1.Container{{Name: "web", Image: host + "/web:1.0"}},
+			},
+		},
+	}...)}
+}
+
+func TestRunDoctor(t *testing.T) {
+	defaultClient := registryHTTPClient
+	defer func() { registryHTTPClient = defaultClient }()
+	defer func() { configDockerconfigjson = "" }()
+	server := newTestRegistry(t, true)
+	registryHTTPClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+	referenced := []corev1.LocalObjectReference{{Name: configSecretName}}
+
+	for name, tc := range map[string]struct {
+		password   string
+		podSecrets []corev1.LocalObjectReference
+		pod        string
+		failure    string
+	}{
+		"healthy pod":        {"pass", referenced, "web", ""},
+		"healthy namespace":  {"pass", referenced, "", ""},
+		"pod predates patch": {"pass", nil, "web", "has to be recreated"},
+		"wrong password":     {"wrong", referenced, "web", "does not authenticate"},
+		"unknown pod":        {"pass", referenced, "api", "pod [api]"},
+	} {
+		k8s := setupDoctorTest(host, tc.password, tc.podSecrets)
+		out := &bytes.Buffer{}
+		err := runDoctor(context.TODO(), k8s, out, "app", tc.pod)
+		if tc.failure == "" && err != nil {
+			t.Errorf("%s: runDoctor failed: %v\n%s", name, err, out)
+		}
+		if tc.failure != "" && (err == nil || !strings.Contains(err.Error(), tc.failure)) {
+			t.Errorf("%s: runDoctor error = %v, want %q", name, err, tc.failure)
+		}
+	}
+}
+
+func TestRunDoctorMissingRegistryCredential(t *testing.T) {
+	defer func() { configDockerconfigjson = "" }()
+	k8s := setupDoctorTest("registry.example.com", "pass", []corev1.LocalObjectReference{{Name: configSecretName}})
+	pod, _ := k8s.clientset.CoreV1().Pods("app").Get(context.TODO(), "web", metav1.GetOptions{})
+	pod.Spec.Containers[0].Image = "nginx"
+	k8s.clientset.(*fake.Clientset).Tracker().Update(corev1.SchemeGroupVersion.WithResource("pods"), pod, "app")
+
+	err := runDoctor(context.TODO(), k8s, &bytes.Buffer{}, "app", "web")
+	if err == nil || !strings.Contains(err.Error(), "no credential for registry [registry-1.docker.io]") {
+		t.Errorf("runDoctor error = %v, want missing credential for docker hub", err)
+	}
+}
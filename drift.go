@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// secretDrift describes how a secret differs from the one the patcher would
+// write, naming the registries but never their credentials
+type secretDrift struct {
+	Reason string
+	// Type is the type of a secret of the wrong type
+	Type                 corev1.SecretType
+	MissingRegistries    []string
+	UnexpectedRegistries []string
+	ChangedRegistries    []string
+}
+
+// diffSecret compares the auths entries of secret, nil when missing, with
+// the ones of content
+func diffSecret(secret *corev1.Secret, content string, reason string) secretDrift {
+	drift := secretDrift{Reason: reason}
+	have := map[string]json.RawMessage{}
+	if secret != nil {
+		if secret.Type != corev1.SecretTypeDockerConfigJson {
+			drift.Type = secret.Type
+		}
+		if _, auths, err := parseDockerConfigAuths(string(secret.Data[corev1.DockerConfigJsonKey])); err == nil {
+			have = auths
+		}
+	}
+	_, want, _ := parseDockerConfigAuths(content)
+	for registry, entry := range want {
+		if actual, ok := have[registry]; !ok {
+			drift.MissingRegistries = append(drift.MissingRegistries, registry)
+		} else if !jsonEqual(actual, entry) {
+			drift.ChangedRegistries = append(drift.ChangedRegistries, registry)
+		}
+	}
+	for registry := range have {
+		if _, ok := want[registry]; !ok {
+			drift.UnexpectedRegistries = append(drift.UnexpectedRegistries, registry)
+		}
+	}
+	sort.Strings(drift.MissingRegistries)
+	sort.Strings(drift.UnexpectedRegistries)
+	sort.Strings(drift.ChangedRegistries)
+	return drift
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// detectSecretDrift reports whether the secret name of namespace, nil when
+// missing, drifted from content, without changing anything
+func detectSecretDrift(namespace, name string, secret *corev1.Secret, content string) {
+	reason := "SecretNotFound"
+	if secret != nil {
+		result := verifySecretContent(secret, content)
+		if result == secretOk {
+			nsLog(namespace).WithField(logFieldSecret, name).Debug("Secret is valid")
+			metricSecretDrifted.WithLabelValues(namespace, name).Set(0)
+			recordSecretDrift(namespace, name, false)
+			return
+		}
+		reason = string(result)
+	}
+	drift := diffSecret(secret, content, reason)
+	fields := log.Fields{logFieldSecret: name, "reason": drift.Reason}
+	if drift.Type != "" {
+		fields["type"] = drift.Type
+	}
+	if len(drift.MissingRegistries) > 0 {
+		fields["missingRegistries"] = drift.MissingRegistries
+	}
+	if len(drift.UnexpectedRegistries) > 0 {
+		fields["unexpectedRegistries"] = drift.UnexpectedRegistries
+	}
+	if len(drift.ChangedRegistries) > 0 {
+		fields["changedRegistries"] = drift.ChangedRegistries
+	}
+	nsLog(namespace).WithFields(fields).Warn("Secret drifted, left as it is in detect-only mode")
+	metricSecretDrifted.WithLabelValues(namespace, name).Set(1)
+	recordSecretDrift(namespace, name, true)
+	metricSecretDriftDetected.WithLabelValues(drift.Reason).Inc()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiffSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"a.example.com":{"auth":"old"},"b.example.com":{"auth":"b"},"c.example.com":{"auth":"c"}}}`)},
+	}
+	content := `{"auths":{"a.example.com":{"auth":"new"},"b.example.com": {"auth": "b"},"d.example.com":{"auth":"d"}}}`
+	drift := diffSecret(secret, content, string(secretWrongType))
+	want := secretDrift{
+		Reason:               string(secretWrongType),
+		Type:                 corev1.SecretTypeOpaque,
+		MissingRegistries:    []string{"d.example.com"},
+		UnexpectedRegistries: []string{"c.example.com"},
+		ChangedRegistries:    []string{"a.example.com"},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("diffSecret = %+v, want %+v", drift, want)
+	}
+
+	drift = diffSecret(nil, content, "SecretNotFound")
+	if len(drift.MissingRegistries) != 3 || drift.Type != "" {
+		t.Errorf("every registry of a missing secret should be missing, got %+v", drift)
+	}
+}
+
+func TestDetectOnlyChangesNothing(t *testing.T) {
+	configDetectOnly = true
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configDetectOnly = false
+		configForce = false
+		dockerConfigJSON = ""
+	}()
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-a", Annotations: map[string]string{annotationManagedBy: annotationAppName}},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	for _, namespace := range []string{"team-a", "team-b"} {
+		if err := processSecret(context.TODO(), k8s, namespace); err != nil {
+			t.Fatalf("processSecret failed: %v", err)
+		}
+		if v := testutil.ToFloat64(metricSecretDrifted.WithLabelValues(namespace, configSecretName)); v != 1 {
+			t.Errorf("[%s] drift gauge = %v, want 1", namespace, v)
+		}
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("detect-only mode should not %s", action.GetVerb())
+		}
+	}
+}
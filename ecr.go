@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	providerECR = "ecr"
+
+	// ecrHTTPTimeout bounds a single request to the ECR API
+	ecrHTTPTimeout = 10 * time.Second
+)
+
+// ecrAuthorizationAPI is the part of the ECR client used, faked in tests
+type ecrAuthorizationAPI interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+// ecrRegistryGroup is the registries whose tokens one client requests, those
+// of a region reached with the same credentials
+type ecrRegistryGroup struct {
+	region      string
+	role        string
+	registryIDs []string
+	client      ecrAuthorizationAPI
+}
+
+// ecrRegistryPattern matches the host of a private ECR registry
+var ecrRegistryPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// parseECRRegistries parses a comma-separated list of `<registry>` or
+// `<registry>=<role ARN>` entries into groups, in the order of their first
+// registry
+func parseECRRegistries(spec string) ([]*ecrRegistryGroup, error) {
+	groups := []*ecrRegistryGroup{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, role, _ := strings.Cut(entry, "=")
+		match := ecrRegistryPattern.FindStringSubmatch(host)
+		if match == nil {
+			return nil, fmt.Errorf("%q is not an ECR registry", host)
+		}
+		var group *ecrRegistryGroup
+		for _, g := range groups {
+			if g.region == match[2] && g.role == role {
+				group = g
+			}
+		}
+		if group == nil {
+			group = &ecrRegistryGroup{region: match[2], role: role}
+			groups = append(groups, group)
+		}
+		group.registryIDs = append(group.registryIDs, match[1])
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no ECR registry in %q", spec)
+	}
+	return groups, nil
+}
+
+// ecrCredentialSource builds the dockerconfigjson from ECR authorization
+// tokens, requesting new ones once the current ones are about to expire
+type ecrCredentialSource struct {
+	groups     []*ecrRegistryGroup
+	now        func() time.Time
+	credential refreshingCredential
+}
+
+// newECRCredentialSource creates the ECR clients from the default AWS
+// credential chain, which covers IRSA, the node role and the usual
+// environment variables, assuming the role of a registry when it has one
+func newECRCredentialSource(ctx context.Context, httpClient *http.Client) (*ecrCredentialSource, error) {
+	groups := []*ecrRegistryGroup{{region: configECRRegion, registryIDs: splitCommaList(configECRRegistryIDs)}}
+	if configECRRegistries != "" {
+		if configECRRegistryIDs != "" {
+			return nil, fmt.Errorf("Cannot specify both `ecr-registries` and `ecr-registry-ids`")
+		}
+		var err error
+		groups, err = parseECRRegistries(configECRRegistries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range groups {
+		opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(httpClient)}
+		if group.region != "" {
+			opts = append(opts, awsconfig.WithRegion(group.region))
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %v", err)
+		}
+		if cfg.Region == "" {
+			return nil, fmt.Errorf("`ecr-region` or AWS_REGION is required with `provider=%s`", providerECR)
+		}
+		if group.role != "" {
+			cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), group.role))
+		}
+		group.client = ecr.NewFromConfig(cfg)
+	}
+	return &ecrCredentialSource{groups: groups, now: time.Now}, nil
+}
+
+func (s *ecrCredentialSource) String() string {
+	names := []string{}
+	for _, group := range s.groups {
+		names = append(names, group.registryIDs...)
+	}
+	if len(names) == 0 {
+		return providerECR
+	}
+	return providerECR + ":" + strings.Join(names, ",")
+}
+
+// load returns the dockerconfigjson of the current tokens, refreshing them
+// `ecr-refresh-before`, or `refresh-before`, the first of them expires
+func (s *ecrCredentialSource) load(ctx context.Context) (string, error) {
+	return s.credential.get(s.now(), providerRefreshBefore(configECRRefreshBefore), func() (string, time.Time, error) {
+		return s.generate(ctx)
+	})
+}
+
+// generate requests the tokens of all registries and merges them
+func (s *ecrCredentialSource) generate(ctx context.Context) (string, time.Time, error) {
+	data := []types.AuthorizationData{}
+	for _, group := range s.groups {
+		input := &ecr.GetAuthorizationTokenInput{}
+		if len(group.registryIDs) > 0 {
+			input.RegistryIds = group.registryIDs
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, ecrHTTPTimeout)
+		output, err := group.client.GetAuthorizationToken(requestCtx, input)
+		cancel()
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to get ECR authorization token of %s: %v", strings.Join(group.registryIDs, ","), err)
+		}
+		data = append(data, output.AuthorizationData...)
+	}
+	content, expiresAt, err := ecrDockerConfigJSON(data)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Infof("Refreshed ECR authorization tokens, expiring at %s", expiresAt.Format(time.RFC3339))
+	return content, expiresAt, nil
+}
+
+// ecrDockerConfigJSON builds the dockerconfigjson merging the authorization
+// tokens, returning when the first of them expires
+func ecrDockerConfigJSON(authorizations []types.AuthorizationData) (string, time.Time, error) {
+	auths := map[string]string{}
+	var expiresAt time.Time
+	for _, data := range authorizations {
+		endpoint := aws.ToString(data.ProxyEndpoint)
+		token := aws.ToString(data.AuthorizationToken)
+		if endpoint == "" || token == "" {
+			return "", time.Time{}, fmt.Errorf("ECR returned authorization data without endpoint or token")
+		}
+		// the token is already the base64 encoded `AWS:<password>` of the
+		// auth field
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			endpoint = u.Host
+		}
+		auths[endpoint] = token
+		if data.ExpiresAt != nil && (expiresAt.IsZero() || data.ExpiresAt.Before(expiresAt)) {
+			expiresAt = *data.ExpiresAt
+		}
+	}
+	if len(auths) == 0 {
+		return "", time.Time{}, fmt.Errorf("ECR returned no authorization data")
+	}
+	content, err := renderDockerConfigJSON(auths)
+	return content, expiresAt, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+type fakeECR struct {
+	calls     int
+	expiresAt time.Time
+	endpoint  string
+}
+
+func (f *fakeECR) GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	f.calls++
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []types.AuthorizationData{{
+			AuthorizationToken: aws.String("QVdTOnBhc3N3b3Jk"),
+			ExpiresAt:          aws.Time(f.expiresAt),
+			ProxyEndpoint:      aws.String("https://" + f.endpoint),
+		}},
+	}, nil
+}
+
+func TestECRCredentialSource(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &fakeECR{expiresAt: now.Add(12 * time.Hour), endpoint: "123456789012.dkr.ecr.eu-west-1.amazonaws.com"}
+	source := &ecrCredentialSource{groups: []*ecrRegistryGroup{{client: client}}, now: func() time.Time { return now }}
+
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	expected := `{"auths":{"123456789012.dkr.ecr.eu-west-1.amazonaws.com":{"auth":"QVdTOnBhc3N3b3Jk"}}}`
+	if content != expected {
+		t.Errorf("content %s, want %s", content, expected)
+	}
+
+	now = now.Add(10 * time.Hour)
+	if _, err := source.load(context.TODO()); err != nil || client.calls != 1 {
+		t.Errorf("token refreshed %d times before its refresh time, error %v", client.calls-1, err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := source.load(context.TODO()); err != nil || client.calls != 2 {
+		t.Errorf("token not refreshed an hour before its expiry, error %v", err)
+	}
+}
+
+func TestECRCredentialSourceMergesRegistries(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := &ecrCredentialSource{
+		groups: []*ecrRegistryGroup{
+			{client: &fakeECR{expiresAt: now.Add(12 * time.Hour), endpoint: "123456789012.dkr.ecr.us-east-1.amazonaws.com"}},
+			{client: &fakeECR{expiresAt: now.Add(6 * time.Hour), endpoint: "210987654321.dkr.ecr.eu-west-1.amazonaws.com"}},
+		},
+		now: func() time.Time { return now },
+	}
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	expected := `{"auths":{"123456789012.dkr.ecr.us-east-1.amazonaws.com":{"auth":"QVdTOnBhc3N3b3Jk"},"210987654321.dkr.ecr.eu-west-1.amazonaws.com":{"auth":"QVdTOnBhc3N3b3Jk"}}}`
+	if content != expected {
+		t.Errorf("content %s, want %s", content, expected)
+	}
+	if !source.credential.expiresAt.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("expires at %s, want the first expiry", source.credential.expiresAt)
+	}
+}
+
+func TestParseECRRegistries(t *testing.T) {
+	groups, err := parseECRRegistries("1234567890.dkr.ecr.us-east-1.amazonaws.com")
+	if err == nil {
+		t.Errorf("no error for an invalid account ID, got %v", groups)
+	}
+	groups, err = parseECRRegistries("111111111111.dkr.ecr.us-east-1.amazonaws.com, 222222222222.dkr.ecr.eu-west-1.amazonaws.com=arn:aws:iam::222222222222:role/pull,333333333333.dkr.ecr.us-east-1.amazonaws.com")
+	if err != nil {
+		t.Fatalf("parseECRRegistries has error %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if groups[0].region != "us-east-1" || groups[0].role != "" || !reflect.DeepEqual(groups[0].registryIDs, []string{"111111111111", "333333333333"}) {
+		t.Errorf("first group %+v", groups[0])
+	}
+	if groups[1].region != "eu-west-1" || groups[1].role != "arn:aws:iam::222222222222:role/pull" || !reflect.DeepEqual(groups[1].registryIDs, []string{"222222222222"}) {
+		t.Errorf("second group %+v", groups[1])
+	}
+}
+
+func TestECRDockerConfigJSONWithoutData(t *testing.T) {
+	if _, _, err := ecrDockerConfigJSON(nil); err == nil {
+		t.Error("no error without authorization data")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// errorReportFlushTimeout bounds the wait for the error reports to be sent
+// before exiting
+const errorReportFlushTimeout = 5 * time.Second
+
+var (
+	// errorReporting tells whether errors are reported to Sentry
+	errorReporting bool
+
+	// namespaceErrorCounts holds the failures in a row of every namespace,
+	// reported once they reach the threshold
+	namespaceErrorCountsMu sync.Mutex
+	namespaceErrorCounts   = map[string]int{}
+)
+
+// setupErrorReporting reports panics and repeated reconcile errors to the
+// Sentry project of dsn
+func setupErrorReporting(dsn string) error {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:     dsn,
+		Release: annotationAppName + "@" + version,
+	})
+	if err != nil {
+		return err
+	}
+	errorReporting = true
+	return nil
+}
+
+// flushErrorReports waits for the error reports not sent yet
+func flushErrorReports() {
+	if errorReporting {
+		sentry.Flush(errorReportFlushTimeout)
+	}
+}
+
+// reportPanic reports the panic of the calling goroutine before resuming it,
+// to be deferred at the start of the goroutines
+func reportPanic() {
+	if r := recover(); r != nil {
+		if errorReporting {
+			sentry.CurrentHub().Recover(r)
+			sentry.Flush(errorReportFlushTimeout)
+		}
+		panic(r)
+	}
+}
+
+// reportNamespaceResult counts the failures in a row of namespace, reporting
+// err once when they reach the threshold, and forgets them on success
+func reportNamespaceResult(namespace string, err error) {
+	if !errorReporting {
+		return
+	}
+	namespaceErrorCountsMu.Lock()
+	if err == nil {
+		delete(namespaceErrorCounts, namespace)
+		namespaceErrorCountsMu.Unlock()
+		return
+	}
+	namespaceErrorCounts[namespace]++
+	count := namespaceErrorCounts[namespace]
+	namespaceErrorCountsMu.Unlock()
+	if count != configSentryErrorThreshold {
+		return
+	}
+	fields := nsLog(namespace).Data
+	sentry.WithScope(func(scope *sentry.Scope) {
+		for k, v := range fields {
+			scope.SetTag(k, fmt.Sprint(v))
+		}
+		scope.SetExtra("failures", count)
+		// one issue per namespace, rather than per error message
+		scope.SetFingerprint([]string{"reconcile", namespace})
+		sentry.CaptureException(err)
+	})
+}
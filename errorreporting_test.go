@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// fakeSentryTransport keeps the events instead of sending them
+type fakeSentryTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *fakeSentryTransport) Configure(sentry.ClientOptions) {}
+func (t *fakeSentryTransport) Flush(time.Duration) bool       { return true }
+func (t *fakeSentryTransport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func TestReportNamespaceResult(t *testing.T) {
+	transport := &fakeSentryTransport{}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: "https://key@sentry.example.com/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	errorReporting = true
+	defer func() {
+		errorReporting = false
+		namespaceErrorCounts = map[string]int{}
+	}()
+
+	err := fmt.Errorf("Secret [registry] is present but unmanaged")
+	reportNamespaceResult("team-a", err)
+	reportNamespaceResult("team-a", err)
+	reportNamespaceResult("team-b", err)
+	if len(transport.events) != 0 {
+		t.Fatalf("errors should be reported once repeated %d times, got %d events", configSentryErrorThreshold, len(transport.events))
+	}
+	reportNamespaceResult("team-a", err)
+	reportNamespaceResult("team-a", err)
+	if len(transport.events) != 1 {
+		t.Fatalf("expected a single event, got %d", len(transport.events))
+	}
+	event := transport.events[0]
+	if event.Tags[logFieldNamespace] != "team-a" || event.Extra["failures"] != configSentryErrorThreshold || len(event.Exception) == 0 || event.Exception[0].Value != err.Error() {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// a success starts counting over
+	reportNamespaceResult("team-a", nil)
+	for i := 0; i < configSentryErrorThreshold; i++ {
+		reportNamespaceResult("team-a", err)
+	}
+	if len(transport.events) != 2 {
+		t.Errorf("expected another event after the namespace recovered, got %d", len(transport.events))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventReasons are the reasons of the Events of the mutation actions
+var eventReasons = map[string]string{
+	mutationCreate:    "Created",
+	mutationPatch:     "Updated",
+	mutationDelete:    "Deleted",
+	mutationOverwrite: "Overwritten",
+}
+
+// newEvent builds an Event of the patcher about the object kind/name in
+// namespace, the Namespace itself when kind is Namespace
+func newEvent(namespace, kind, name, eventType, reason, message string, now time.Time) *corev1.Event {
+	instance, _ := os.Hostname()
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// named like those of the client-go event recorder
+			Name:      fmt.Sprintf("%s.%x", strings.ToLower(name), now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       kind,
+			Namespace:  namespace,
+			Name:       name,
+		},
+		Type:                eventType,
+		Reason:              reason,
+		Message:             message,
+		Source:              corev1.EventSource{Component: annotationAppName},
+		ReportingController: annotationAppName,
+		ReportingInstance:   instance,
+		FirstTimestamp:      metav1.NewTime(now),
+		LastTimestamp:       metav1.NewTime(now),
+		Count:               1,
+	}
+}
+
+// emitEvent publishes the Event when enabled, a failure is only logged as
+// the Event merely reports what happened
+func emitEvent(ctx context.Context, k8s *k8sClient, event *corev1.Event) {
+	if !configEvents {
+		return
+	}
+	_, err := k8s.clientset.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		nsLog(event.Namespace).Warnf("Failed to emit event %s: %v", event.Reason, err)
+	}
+}
+
+// emitMutationEvent reports an action on the object kind/name in namespace
+func emitMutationEvent(ctx context.Context, k8s *k8sClient, namespace, action, kind, name, reason string) {
+	eventType := corev1.EventTypeNormal
+	if action == mutationDelete || action == mutationOverwrite {
+		eventType = corev1.EventTypeWarning
+	}
+	message := fmt.Sprintf("%s %s [%s]: %s", eventReasons[action], kind, name, reason)
+	emitEvent(ctx, k8s, newEvent(namespace, kind, name, eventType, eventReasons[action], message, time.Now()))
+}
+
+// emitFailureEvent reports on the Namespace that it could not be reconciled
+func emitFailureEvent(ctx context.Context, k8s *k8sClient, namespace string, err error) {
+	emitEvent(ctx, k8s, newEvent(namespace, "Namespace", namespace, corev1.EventTypeWarning, "ReconcileFailed", err.Error(), time.Now()))
+}
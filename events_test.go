@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEmitEvents(t *testing.T) {
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	recordMutation(context.TODO(), k8s, "team-a", mutationCreate, "Secret", configSecretName, "SecretNotFound")
+	events, err := k8s.clientset.CoreV1().Events("team-a").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 0 {
+		t.Errorf("events should only be emitted when enabled, got %v", events.Items)
+	}
+
+	configEvents = true
+	defer func() { configEvents = false }()
+	recordMutation(context.TODO(), k8s, "team-a", mutationCreate, "Secret", configSecretName, "SecretNotFound")
+	emitFailureEvent(context.TODO(), k8s, "team-a", fmt.Errorf("Secret [registry] is present but unmanaged"))
+	events, err = k8s.clientset.CoreV1().Events("team-a").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 2 {
+		t.Fatalf("expected 2 events, got %v", events.Items)
+	}
+	for _, event := range events.Items {
+		switch event.InvolvedObject.Kind {
+		case "Secret":
+			if event.Type != corev1.EventTypeNormal || event.Reason != "Created" || event.InvolvedObject.Name != configSecretName {
+				t.Errorf("unexpected create event %+v", event)
+			}
+		case "Namespace":
+			if event.Type != corev1.EventTypeWarning || event.Reason != "ReconcileFailed" || event.InvolvedObject.Name != "team-a" {
+				t.Errorf("unexpected failure event %+v", event)
+			}
+		default:
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	excludedNamespacesFileMu       sync.RWMutex
+	excludedNamespacesFileModTime  time.Time
+	excludedNamespacesFilePatterns []string
+
+	// excludedNamespaceSelector is parsed from `exclude-namespaces-by-label`,
+	// nil when unset
+	excludedNamespaceSelector labels.Selector
+
+	// includedNamespaceSelector is parsed from `included-namespace-selector`,
+	// nil when unset
+	includedNamespaceSelector labels.Selector
+
+	// systemNamespaces are excluded unless `include-system-namespaces` is set
+	systemNamespaces = []string{metav1.NamespaceSystem, metav1.NamespacePublic, corev1.NamespaceNodeLease}
+
+	// namespaceRegexps caches the compiled regular expression patterns
+	namespaceRegexps sync.Map
+)
+
+// validateNamespacePattern checks a namespace pattern, a regular expression
+// when it starts with `^`, a glob pattern otherwise
+func validateNamespacePattern(pattern string) error {
+	if strings.HasPrefix(pattern, "^") {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		namespaceRegexps.Store(pattern, re)
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// validateNamespacePatterns checks a comma-separated list of namespace
+// patterns
+func validateNamespacePatterns(list string) error {
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			if err := validateNamespacePattern(pattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// namespacePatternMatches tells whether name matches pattern, invalid
+// patterns matching nothing
+func namespacePatternMatches(pattern, name string) bool {
+	if !strings.HasPrefix(pattern, "^") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	re, ok := namespaceRegexps.Load(pattern)
+	if !ok {
+		if validateNamespacePattern(pattern) != nil {
+			return false
+		}
+		re, _ = namespaceRegexps.Load(pattern)
+	}
+	return re.(*regexp.Regexp).MatchString(name)
+}
+
+// parseExcludedNamespaces parses one namespace name or pattern per line,
+// skipping empty lines and comments
+func parseExcludedNamespaces(content string) ([]string, error) {
+	patterns := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateNamespacePattern(line); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+// reloadExcludedNamespacesFile re-reads the excluded namespaces file when it
+// changed since the last read, keeping the previous list on errors
+func reloadExcludedNamespacesFile() error {
+	if configExcludedNamespacesFile == "" {
+		return nil
+	}
+	info, err := os.Stat(configExcludedNamespacesFile)
+	if err != nil {
+		return fmt.Errorf("failed to access excluded namespaces file: %v", err)
+	}
+	excludedNamespacesFileMu.RLock()
+	unchanged := info.ModTime().Equal(excludedNamespacesFileModTime)
+	excludedNamespacesFileMu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	content, err := os.ReadFile(configExcludedNamespacesFile)
+	if err != nil {
+		return fmt.Errorf("failed to read excluded namespaces file: %v", err)
+	}
+	patterns, err := parseExcludedNamespaces(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse excluded namespaces file: %v", err)
+	}
+	excludedNamespacesFileMu.Lock()
+	excludedNamespacesFileModTime = info.ModTime()
+	excludedNamespacesFilePatterns = patterns
+	excludedNamespacesFileMu.Unlock()
+	log.Infof("Loaded %d excluded namespaces from %s", len(patterns), configExcludedNamespacesFile)
+	return nil
+}
+
+// namespaceExcludedByFile checks name against the excluded namespaces file
+func namespaceExcludedByFile(name string) bool {
+	excludedNamespacesFileMu.RLock()
+	defer excludedNamespacesFileMu.RUnlock()
+	for _, pattern := range excludedNamespacesFilePatterns {
+		if namespacePatternMatches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceIsIncluded tells whether ns is on the allowlist, matching a
+// pattern of `included-namespaces`, `included-namespace-selector` or the
+// selection ConfigMap, which holds every namespace when none is set
+func namespaceIsIncluded(ns corev1.Namespace) bool {
+	included, selected := namespaceIncludedBySelection(ns.Name)
+	if included {
+		return true
+	}
+	if strings.TrimSpace(configIncludedNamespaces) == "" && includedNamespaceSelector == nil && !selected {
+		return true
+	}
+	for _, pattern := range strings.Split(configIncludedNamespaces, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" && namespacePatternMatches(pattern, ns.Name) {
+			return true
+		}
+	}
+	return includedNamespaceSelector != nil && includedNamespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// isSystemNamespace tells whether name is one of the namespaces of the
+// cluster itself
+func isSystemNamespace(name string) bool {
+	for _, system := range systemNamespaces {
+		if name == system {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseExcludedNamespaces(t *testing.T) {
+	patterns, err := parseExcludedNamespaces("kube-system\n\n# monitoring stack\n  monitoring \nteam-*\n")
+	if err != nil {
+		t.Fatalf("parseExcludedNamespaces failed: %v", err)
+	}
+	if len(patterns) != 3 || patterns[1] != "monitoring" || patterns[2] != "team-*" {
+		t.Errorf("parseExcludedNamespaces() = %v, want [kube-system monitoring team-*]", patterns)
+	}
+	if _, err := parseExcludedNamespaces("team-[\n"); err == nil {
+		t.Errorf("parseExcludedNamespaces should reject invalid patterns")
+	}
+}
+
+func TestReloadExcludedNamespacesFile(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configExcludedNamespaces = ""
+	configExcludedNamespacesFile = filepath.Join(t.TempDir(), "excluded")
+	defer func() {
+		configExcludedNamespacesFile = ""
+		excludedNamespacesFilePatterns = nil
+		excludedNamespacesFileModTime = time.Time{}
+	}()
+	excluded := func(name string) bool {
+		return namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	if err := os.WriteFile(configExcludedNamespacesFile, []byte("kube-system\nteam-*\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadExcludedNamespacesFile(); err != nil {
+		t.Fatalf("reloadExcludedNamespacesFile failed: %v", err)
+	}
+	if !excluded("kube-system") || !excluded("team-a") || excluded("default") {
+		t.Errorf("namespaces not excluded according to the file")
+	}
+
+	// a changed file is picked up, an invalid one keeps the previous list
+	if err := os.WriteFile(configExcludedNamespacesFile, []byte("default\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(configExcludedNamespacesFile, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadExcludedNamespacesFile(); err != nil {
+		t.Fatalf("reloadExcludedNamespacesFile failed: %v", err)
+	}
+	if !excluded("default") || excluded("team-a") {
+		t.Errorf("changed file was not reloaded")
+	}
+	if err := os.WriteFile(configExcludedNamespacesFile, []byte("team-[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(configExcludedNamespacesFile, time.Now(), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadExcludedNamespacesFile(); err == nil {
+		t.Errorf("reloadExcludedNamespacesFile should fail on an invalid file")
+	}
+	if !excluded("default") {
+		t.Errorf("invalid file should keep the previous list")
+	}
+}
+
+func TestNamespacePatternMatches(t *testing.T) {
+	for _, c := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"kube-system", "kube-system", true},
+		{"kube-system", "kube-public", false},
+		{"kube-*", "kube-public", true},
+		{"openshift-*", "kube-public", false},
+		{"^cattle-.*$", "cattle-system", true},
+		{"^cattle-.*$", "my-cattle-system", false},
+		{"^team-(a|b)$", "team-b", true},
+		{"^team-(a|b)$", "team-c", false},
+		{"^team-(", "team-(", false},
+	} {
+		if got := namespacePatternMatches(c.pattern, c.name); got != c.want {
+			t.Errorf("namespacePatternMatches(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateNamespacePatterns(t *testing.T) {
+	if err := validateNamespacePatterns("kube-*, openshift-*,^cattle-.*$,"); err != nil {
+		t.Errorf("validateNamespacePatterns failed: %v", err)
+	}
+	for _, list := range []string{"kube-[", "kube-*,^team-("} {
+		if err := validateNamespacePatterns(list); err == nil {
+			t.Errorf("validateNamespacePatterns(%q) should fail", list)
+		}
+	}
+}
+
+func TestNamespaceIsExcludedByPattern(t *testing.T) {
+	defer func() { configExcludedNamespaces = "" }()
+	configExcludedNamespaces = "kube-*,^cattle-.*$"
+	for name, want := range map[string]bool{"kube-system": true, "cattle-system": true, "default": false} {
+		if got := namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}); got != want {
+			t.Errorf("namespaceIsExcluded(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNamespaceIsIncluded(t *testing.T) {
+	defer func() {
+		configIncludedNamespaces = ""
+		includedNamespaceSelector = nil
+	}()
+	teamA := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a-web"}}
+	labeled := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"owner": "platform"}}}
+	other := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+
+	for _, ns := range []corev1.Namespace{teamA, labeled, other} {
+		if !namespaceIsIncluded(ns) {
+			t.Errorf("namespace %s should be included without an allowlist", ns.Name)
+		}
+	}
+
+	configIncludedNamespaces = "team-a-*"
+	selector, err := labels.Parse("owner=platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	includedNamespaceSelector = selector
+	for ns, want := range map[*corev1.Namespace]bool{&teamA: true, &labeled: true, &other: false} {
+		if got := namespaceIsIncluded(*ns); got != want {
+			t.Errorf("namespaceIsIncluded(%s) = %v, want %v", ns.Name, got, want)
+		}
+		if got := namespaceIsExcluded(*ns); got == want {
+			t.Errorf("namespaceIsExcluded(%s) = %v, want %v", ns.Name, got, !want)
+		}
+	}
+}
+
+func TestSystemNamespacesExcluded(t *testing.T) {
+	defer func() { configIncludeSystemNamespaces = false }()
+	for _, name := range []string{"kube-system", "kube-public", "kube-node-lease"} {
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		configIncludeSystemNamespaces = false
+		if !namespaceIsExcluded(ns) {
+			t.Errorf("system namespace %s should be excluded by default", name)
+		}
+		configIncludeSystemNamespaces = true
+		if namespaceIsExcluded(ns) {
+			t.Errorf("system namespace %s should be processed with include-system-namespaces", name)
+		}
+	}
+	configIncludeSystemNamespaces = false
+	if namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}) {
+		t.Errorf("default should not be excluded")
+	}
+}
+
+func TestNamespaceExcludedByLabel(t *testing.T) {
+	defer func() { excludedNamespaceSelector = nil }()
+	selector, err := labels.Parse("field.cattle.io/projectId in (p-system,p-monitoring)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	excludedNamespaceSelector = selector
+	for project, want := range map[string]bool{"p-system": true, "p-monitoring": true, "p-team-a": false} {
+		ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "cattle-" + project,
+			Labels: map[string]string{"field.cattle.io/projectId": project},
+		}}
+		if got := namespaceIsExcluded(ns); got != want {
+			t.Errorf("namespaceIsExcluded of project %s = %v, want %v", project, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// credentialExpiresAt is when the distributed credential expires, zero when
+// unknown
+var credentialExpiresAt time.Time
+
+// expiringCredentialSource is a credential source knowing when the
+// credential it last loaded expires
+type expiringCredentialSource interface {
+	expiry() time.Time
+}
+
+func (c *refreshingCredential) expiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiresAt
+}
+
+func (s *ecrCredentialSource) expiry() time.Time    { return s.credential.expiry() }
+func (s *gcrCredentialSource) expiry() time.Time    { return s.credential.expiry() }
+func (s *acrCredentialSource) expiry() time.Time    { return s.credential.expiry() }
+func (s *ghcrCredentialSource) expiry() time.Time   { return s.credential.expiry() }
+func (s *gitlabCredentialSource) expiry() time.Time { return s.credential.expiry() }
+
+func (s vaultCredentialSource) expiry() time.Time {
+	if s.lease == nil {
+		return time.Time{}
+	}
+	s.lease.mu.Lock()
+	defer s.lease.mu.Unlock()
+	return s.lease.expiresAt
+}
+
+// providerRefreshBefore returns `refresh-before` when set, the refresh window
+// of the provider otherwise
+func providerRefreshBefore(provider time.Duration) time.Duration {
+	if configRefreshBefore > 0 {
+		return configRefreshBefore
+	}
+	return provider
+}
+
+// credentialExpiry returns when content expires, as told by the provider
+// generating it, or else by the first `exp` claim of the passwords which are
+// JWTs, zero when unknown
+func credentialExpiry(content string) time.Time {
+	if len(credentialSources) == 1 {
+		if source, ok := credentialSources[0].(expiringCredentialSource); ok {
+			return source.expiry()
+		}
+	}
+	config := dockerConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return time.Time{}
+	}
+	var expiresAt time.Time
+	for _, auth := range config.Auths {
+		_, password := auth.credentials()
+		if exp, ok := jwtExpiry(password); ok && (expiresAt.IsZero() || exp.Before(expiresAt)) {
+			expiresAt = exp
+		}
+	}
+	return expiresAt
+}
+
+// jwtExpiry reads the expiry of a JWT without verifying it
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// refreshWait returns how long until the credential expiring at expiresAt is
+// to be refreshed, false when its expiry is unknown, refreshing is disabled
+// or its refresh time already passed without it being refreshed
+func refreshWait(expiresAt time.Time, now time.Time) (time.Duration, bool) {
+	if configRefreshBefore <= 0 || expiresAt.IsZero() {
+		return 0, false
+	}
+	wait := expiresAt.Add(-configRefreshBefore).Sub(now)
+	return wait, wait > 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCredentialExpiry(t *testing.T) {
+	defer func() { credentialSources = nil }()
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	jwt := "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".sig"
+
+	if got := credentialExpiry(`{"auths":{"ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`); !got.IsZero() {
+		t.Errorf("expiry of a static password is %s, want none", got)
+	}
+	content := `{"auths":{"ghcr.io":{"auth":"Ym90OnRva2Vu"},"registry.example.com":{"username":"bot","password":"` + jwt + `"}}}`
+	if got := credentialExpiry(content); !got.Equal(exp) {
+		t.Errorf("expiry of a JWT password is %s, want %s", got, exp)
+	}
+
+	source := &ecrCredentialSource{}
+	source.credential.expiresAt = exp.Add(-time.Hour)
+	credentialSources = []credentialSource{source}
+	if got := credentialExpiry(content); !got.Equal(exp.Add(-time.Hour)) {
+		t.Errorf("expiry with a provider is %s, want the one of the provider", got)
+	}
+}
+
+func TestUpdateCredentialExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	updateCredentialExpiry("registry", exp)
+	if v := testutil.ToFloat64(metricCredentialExpiry.WithLabelValues("registry")); v != float64(exp.Unix()) {
+		t.Errorf("expiry timestamp = %v, want %d", v, exp.Unix())
+	}
+	updateCredentialExpiry("registry", time.Time{})
+	if n := testutil.CollectAndCount(metricCredentialExpiry); n != 0 {
+		t.Errorf("unknown expiry should drop the metric, got %d series", n)
+	}
+}
+
+func TestRefreshWait(t *testing.T) {
+	defer func() { configRefreshBefore = 0 }()
+	now := time.Now()
+	expiresAt := now.Add(12 * time.Hour)
+
+	if _, ok := refreshWait(expiresAt, now); ok {
+		t.Error("refresh scheduled with `refresh-before` unset")
+	}
+	configRefreshBefore = 2 * time.Hour
+	if wait, ok := refreshWait(expiresAt, now); !ok || wait != 10*time.Hour {
+		t.Errorf("refresh in %s, %t, want in 10h", wait, ok)
+	}
+	if _, ok := refreshWait(time.Time{}, now); ok {
+		t.Error("refresh scheduled for an unknown expiry")
+	}
+	if _, ok := refreshWait(now.Add(time.Hour), now); ok {
+		t.Error("refresh scheduled after its time passed")
+	}
+	if got := providerRefreshBefore(time.Minute); got != 2*time.Hour {
+		t.Errorf("provider refresh window %s, want the one of `refresh-before`", got)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// exportManifest writes obj as YAML to file in the export directory of
+// namespace
+func exportManifest(namespace, file string, obj interface{}) error {
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return exportFile(namespace, file, b)
+}
+
+// exportFile writes b to file in the export directory of namespace, readable
+// by the owner only as it may hold the credential
+func exportFile(namespace, file string, b []byte) error {
+	dir := filepath.Join(configExportDir, namespace)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, file), b, 0600)
+}
+
+// sealedSecretUpToDate tells whether the exported manifest b is the
+// SealedSecret of namespace, sealed from its current credential
+func sealedSecretUpToDate(namespace string, b []byte) bool {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(b, &obj.Object); err != nil {
+		return false
+	}
+	return obj.GetKind() == "SealedSecret" && obj.GetNamespace() == namespace && obj.GetName() == configSecretName &&
+		verifySealedSecret(obj) == secretOk
+}
+
+// exportSecret renders the managed secret in the form of the configured secret
+// mode, keeping the previous manifest of a SealedSecret which is up to date
+func exportSecret(namespace string, previous []byte) error {
+	var obj runtime.Object
+	var err error
+	switch configSecretMode {
+	case secretModeExternalSecret:
+		obj = externalSecret(namespace)
+	case secretModeSecretProviderClass:
+		obj = secretProviderClass(namespace)
+	case secretModeSealedSecret:
+		// sealing again would change the manifest on every export
+		if sealedSecretUpToDate(namespace, previous) {
+			return exportFile(namespace, "secret.yaml", previous)
+		}
+		obj, err = sealedSecret(namespace)
+		if err != nil {
+			return err
+		}
+	default:
+		secret := dockerconfigSecret(namespace)
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		// the sync time would change the manifest on every export
+		delete(secret.Annotations, annotationLastSyncedAt)
+		obj = secret
+	}
+	return exportManifest(namespace, "secret.yaml", obj)
+}
+
+// exportServiceAccounts renders a strategic merge patch adding the managed
+// secret to every targeted service account
+func exportServiceAccounts(ctx context.Context, k8s *k8sClient, namespace string) error {
+	sas, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list service accounts: %v", err)
+	}
+	for _, sa := range sas.Items {
+		if !serviceAccountTargeted(&sa) {
+			continue
+		}
+		b, err := getPatchString(&sa, managedSecretNames()...)
+		if err != nil {
+			return err
+		}
+		saPatch := map[string]interface{}{}
+		if err := json.Unmarshal(b, &saPatch); err != nil {
+			return err
+		}
+		saPatch["apiVersion"] = "v1"
+		saPatch["kind"] = "ServiceAccount"
+		metadata, _ := saPatch["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+		}
+		metadata["name"] = sa.Name
+		metadata["namespace"] = namespace
+		saPatch["metadata"] = metadata
+		if err := exportManifest(namespace, "serviceaccount-"+sa.Name+".yaml", saPatch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportNamespace writes the desired state of ns to the export directory
+// instead of applying it to the cluster
+func exportNamespace(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error {
+	namespace := ns.Name
+	previous, _ := os.ReadFile(filepath.Join(configExportDir, namespace, "secret.yaml"))
+	// start from scratch so objects no longer desired disappear from the export
+	if err := os.RemoveAll(filepath.Join(configExportDir, namespace)); err != nil {
+		return fmt.Errorf("Failed to clean export directory: %v", err)
+	}
+	if configEnableSecretSync {
+		if err := exportSecret(namespace, previous); err != nil {
+			return fmt.Errorf("Failed to export secret: %v", err)
+		}
+	}
+	if configEnableConfigMapSync && !configMapIsExcluded(ns) {
+		for _, s := range syncedConfigMaps() {
+			configMap, err := s.configMap(namespace)
+			if err != nil {
+				continue
+			}
+			configMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+			delete(configMap.Annotations, annotationLastSyncedAt)
+			if err := exportManifest(namespace, "configmap-"+s.name+".yaml", configMap); err != nil {
+				return fmt.Errorf("Failed to export ConfigMap [%s]: %v", s.name, err)
+			}
+		}
+	}
+	if configEnableSAPatch {
+		if err := exportServiceAccounts(ctx, k8s, namespace); err != nil {
+			return fmt.Errorf("Failed to export service accounts: %v", err)
+		}
+	}
+	nsLog(namespace).Debug("Exported manifests")
+	return nil
+}
+
+// pruneExport removes the export directories of namespaces which were not
+// exported in the last sweep
+func pruneExport(exported map[string]bool) error {
+	entries, err := os.ReadDir(configExportDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || exported[entry.Name()] {
+			continue
+		}
+		nsLog(entry.Name()).Info("Removing export of namespace")
+		if err := os.RemoveAll(filepath.Join(configExportDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", configExportDir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return string(out), nil
+}
+
+// commitExport commits the export directory to its git repository when it
+// changed, and pushes it if configured
+func commitExport() error {
+	status, err := git("status", "--porcelain", "--", ".")
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		log.Debug("Export unchanged, nothing to commit")
+		return nil
+	}
+	if _, err := git("add", "--all", "--", "."); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("imagepullsecret-patcher: sync at %s", time.Now().UTC().Format(time.RFC3339))
+	if _, err := git("commit", "-m", msg); err != nil {
+		return err
+	}
+	log.Info("Committed export")
+	if configExportGitPush {
+		if _, err := git("push"); err != nil {
+			return err
+		}
+		log.Info("Pushed export")
+	}
+	return nil
+}
+
+// exportSweep renders all given namespaces to the export directory
+func exportSweep(ctx context.Context, k8s *k8sClient, namespaces []corev1.Namespace) {
+	exported := map[string]bool{}
+	for _, ns := range namespaces {
+		if namespaceIsExcluded(ns) {
+			continue
+		}
+		startReconcile(ns.Name)
+		if err := assignNamespaceCredential(ctx, ns); err != nil {
+			nsLog(ns.Name).Error(err)
+		} else if err := exportNamespace(ctx, k8s, ns); err != nil {
+			nsLog(ns.Name).Error(err)
+		}
+		finishReconcile(ns.Name)
+		exported[ns.Name] = true
+	}
+	if err := pruneExport(exported); err != nil {
+		log.Errorf("Failed to prune export directory: %v", err)
+		return
+	}
+	if configExportGit {
+		if err := commitExport(); err != nil {
+			log.Errorf("Failed to commit export: %v", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func TestExportSweep(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configExportDir = t.TempDir()
+	defer func() { configExportDir = "" }()
+	configSecretMode = secretModeSecret
+	configAllServiceAccount = true
+	configExcludedNamespaces = "excluded"
+	configAWSConfigFilePath = filepath.Join(configExportDir, "missing")
+	dockerConfigJSON = testDockerconfig
+
+	// a namespace exported before but gone since should be pruned
+	if err := os.MkdirAll(filepath.Join(configExportDir, "deleted"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	k8s := &k8sClient{
+		clientset: fake.NewSimpleClientset(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      defaultServiceAccountName,
+				Namespace: corev1.NamespaceDefault,
+			},
+		}),
+	}
+	exportSweep(context.TODO(), k8s, []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "excluded"}},
+	})
+
+	b, err := os.ReadFile(filepath.Join(configExportDir, corev1.NamespaceDefault, "secret.yaml"))
+	if err != nil {
+		t.Fatalf("exportSweep did not export secret: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(configExportDir, corev1.NamespaceDefault, "secret.yaml")); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("exported secret has mode %v, want readable by the owner only", fi.Mode().Perm())
+	}
+	secret := &corev1.Secret{}
+	if err := yaml.Unmarshal(b, secret); err != nil {
+		t.Fatalf("exported secret is not valid: %v", err)
+	}
+	if result := verifySecret(secret); result != secretOk {
+		t.Errorf("exported secret is invalid: %v", result)
+	}
+
+	b, err = os.ReadFile(filepath.Join(configExportDir, corev1.NamespaceDefault, "serviceaccount-default.yaml"))
+	if err != nil {
+		t.Fatalf("exportSweep did not export service account patch: %v", err)
+	}
+	sa := &corev1.ServiceAccount{}
+	if err := yaml.Unmarshal(b, sa); err != nil {
+		t.Fatalf("exported service account patch is not valid: %v", err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("exported service account patch does not include image pull secret [%s]", configSecretName)
+	}
+
+	if _, err := os.Stat(filepath.Join(configExportDir, corev1.NamespaceDefault, "configmap-"+configAWSConfigMapName+".yaml")); !os.IsNotExist(err) {
+		t.Errorf("exportSweep exported AWS ConfigMap without a config file")
+	}
+	for _, ns := range []string{"excluded", "deleted"} {
+		if _, err := os.Stat(filepath.Join(configExportDir, ns)); !os.IsNotExist(err) {
+			t.Errorf("exportSweep left export of namespace [%s]", ns)
+		}
+	}
+}
+
+func TestExportSweepSealedSecretUnchanged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	logrus.SetOutput(ioutil.Discard)
+	configExportDir = t.TempDir()
+	configExportGit = true
+	configSecretMode = secretModeSealedSecret
+	defer func() {
+		configExportDir = ""
+		configExportGit = false
+		configSecretMode = secretModeSecret
+	}()
+	configAWSConfigFilePath = filepath.Join(configExportDir, "missing")
+	helperSealingKey(nil)
+	for _, args := range [][]string{{"init"}, {"config", "user.name", "test"}, {"config", "user.email", "test@example.com"}} {
+		if _, err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	namespaces := []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}}
+	exportSweep(context.TODO(), k8s, namespaces)
+	exportSweep(context.TODO(), k8s, namespaces)
+	if out, err := git("rev-list", "--count", "HEAD"); err != nil || strings.TrimSpace(out) != "1" {
+		t.Errorf("two sweeps of an unchanged credential made %s commits, %v, expects 1", strings.TrimSpace(out), err)
+	}
+
+	dockerConfigJSON = `{"auths":{}}`
+	defer func() { dockerConfigJSON = testDockerconfig }()
+	exportSweep(context.TODO(), k8s, namespaces)
+	if out, err := git("rev-list", "--count", "HEAD"); err != nil || strings.TrimSpace(out) != "2" {
+		t.Errorf("a changed credential made %s commits in total, %v, expects 2", strings.TrimSpace(out), err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// modes for distributing the managed secret
+	secretModeSecret         = "secret"
+	secretModeExternalSecret = "externalsecret"
+)
+
+var externalSecretGVR = schema.GroupVersionResource{
+	Group:    "external-secrets.io",
+	Version:  "v1beta1",
+	Resource: "externalsecrets",
+}
+
+// externalSecret builds an ExternalSecret which makes the external-secrets
+// operator materialize the managed dockerconfigjson secret from the central
+// store
+func externalSecret(namespace string) *unstructured.Unstructured {
+	remoteRef := map[string]interface{}{
+		"key": configExternalSecretRemoteKey,
+	}
+	if configExternalSecretRemoteProperty != "" {
+		remoteRef["property"] = configExternalSecretRemoteProperty
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": externalSecretGVR.GroupVersion().String(),
+			"kind":       "ExternalSecret",
+			"metadata": map[string]interface{}{
+				"name":      configSecretName,
+				"namespace": namespace,
+				"annotations": map[string]interface{}{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			"spec": map[string]interface{}{
+				"refreshInterval": configExternalSecretRefreshInterval,
+				"secretStoreRef": map[string]interface{}{
+					"name": configExternalSecretStoreName,
+					"kind": configExternalSecretStoreKind,
+				},
+				"target": map[string]interface{}{
+					"name":           configSecretName,
+					"creationPolicy": "Owner",
+					"template": map[string]interface{}{
+						"type": string(corev1.SecretTypeDockerConfigJson),
+						"metadata": map[string]interface{}{
+							"annotations": map[string]interface{}{
+								annotationManagedBy: annotationAppName,
+							},
+						},
+					},
+				},
+				"data": []interface{}{
+					map[string]interface{}{
+						"secretKey": corev1.DockerConfigJsonKey,
+						"remoteRef": remoteRef,
+					},
+				},
+			},
+		},
+	}
+}
+
+// verifyExternalSecret compares the fields we own, ignoring anything the
+// operator defaults on admission
+func verifyExternalSecret(actual *unstructured.Unstructured) bool {
+	expected := externalSecret(actual.GetNamespace())
+	for _, fields := range [][]string{
+		{"spec", "refreshInterval"},
+		{"spec", "secretStoreRef", "name"},
+		{"spec", "secretStoreRef", "kind"},
+		{"spec", "target", "name"},
+		{"spec", "target", "template", "type"},
+	} {
+		a, _, _ := unstructured.NestedString(actual.Object, fields...)
+		e, _, _ := unstructured.NestedString(expected.Object, fields...)
+		if a != e {
+			return false
+		}
+	}
+	actualData, _, _ := unstructured.NestedSlice(actual.Object, "spec", "data")
+	expectedData, _, _ := unstructured.NestedSlice(expected.Object, "spec", "data")
+	if len(actualData) != len(expectedData) {
+		return false
+	}
+	for i := range expectedData {
+		a, ok := actualData[i].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		e := expectedData[i].(map[string]interface{})
+		for _, fields := range [][]string{
+			{"secretKey"},
+			{"remoteRef", "key"},
+			{"remoteRef", "property"},
+		} {
+			av, _, _ := unstructured.NestedString(a, fields...)
+			ev, _, _ := unstructured.NestedString(e, fields...)
+			if av != ev {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isManagedExternalSecret(obj *unstructured.Unstructured) bool {
+	return isOwned(obj)
+}
+
+// processExternalSecret makes sure the ExternalSecret for the managed secret
+// exists in namespace, leaving the secret itself to the external-secrets operator
+func processExternalSecret(ctx context.Context, k8s *k8sClient, namespace string) error {
+	client := k8s.dynamic.Resource(externalSecretGVR).Namespace(namespace)
+	es, err := client.Get(ctx, configSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err := client.Create(ctx, externalSecret(namespace), metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create ExternalSecret: %v", err)
+		}
+		nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created ExternalSecret")
+		recordMutation(ctx, k8s, namespace, mutationCreate, "ExternalSecret", configSecretName, "SecretNotFound")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to GET ExternalSecret: %v", err)
+	}
+	if !isManagedExternalSecret(es) {
+		return leaveUnowned(namespace, "ExternalSecret", configSecretName, verifyExternalSecret(es))
+	}
+	if verifyExternalSecret(es) {
+		nsLog(namespace).Debug("ExternalSecret is valid")
+		return nil
+	}
+	if !configForce {
+		return fmt.Errorf("ExternalSecret is not valid, set --force to true to overwrite")
+	}
+	nsLog(namespace).Warn("ExternalSecret is not valid, overwriting now")
+	err = client.Delete(ctx, configSecretName, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to delete ExternalSecret [%s]: %v", configSecretName, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: configSecretName, logFieldAction: mutationDelete}).Warn("Deleted ExternalSecret")
+	recordMutation(ctx, k8s, namespace, mutationDelete, "ExternalSecret", configSecretName, "SpecNotMatch")
+	_, err = client.Create(ctx, externalSecret(namespace), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to create ExternalSecret: %v", err)
+	}
+	nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created ExternalSecret")
+	recordMutation(ctx, k8s, namespace, mutationCreate, "ExternalSecret", configSecretName, "SpecNotMatch")
+	return nil
+}
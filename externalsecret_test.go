@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var testCasesProcessExternalSecret = []testCase{
+	{
+		name: "no external secret",
+		prepSteps: []step{
+			helperExternalSecretConfig,
+		},
+		testSteps: []step{
+			processExternalSecretDefault,
+			assertExternalSecretIsValid,
+		},
+	},
+	{
+		name: "has valid external secret",
+		prepSteps: []step{
+			helperExternalSecretConfig,
+			helperCreateExternalSecret("central-store"),
+			assertExternalSecretIsValid,
+		},
+		testSteps: []step{
+			processExternalSecretDefault,
+			assertExternalSecretIsValid,
+		},
+	},
+	{
+		name: "has invalid external secret - force on",
+		prepSteps: []step{
+			helperExternalSecretConfig,
+			helperForceOn,
+			helperCreateExternalSecret("other-store"),
+			assertHasError(assertExternalSecretIsValid),
+		},
+		testSteps: []step{
+			processExternalSecretDefault,
+			assertExternalSecretIsValid,
+		},
+	},
+	{
+		name: "has invalid external secret - force off",
+		prepSteps: []step{
+			helperExternalSecretConfig,
+			helperForceOff,
+			helperCreateExternalSecret("other-store"),
+		},
+		testSteps: []step{
+			assertHasError(processExternalSecretDefault),
+			assertHasError(assertExternalSecretIsValid),
+		},
+	},
+}
+
+func TestProcessExternalSecret(t *testing.T) {
+	for _, tc := range testCasesProcessExternalSecret {
+		runTestCase(t, "ProcessExternalSecret", tc)
+	}
+}
+
+func processExternalSecretDefault(k8s *k8sClient) error {
+	return processExternalSecret(context.TODO(), k8s, v1.NamespaceDefault)
+}
+
+func helperExternalSecretConfig(_ *k8sClient) error {
+	configExternalSecretStoreName = "central-store"
+	configExternalSecretRemoteKey = "registry/dockerconfigjson"
+	return nil
+}
+
+func helperCreateExternalSecret(storeName string) step {
+	return func(k8s *k8sClient) error {
+		es := externalSecret(v1.NamespaceDefault)
+		if err := unstructured.SetNestedField(es.Object, storeName, "spec", "secretStoreRef", "name"); err != nil {
+			return err
+		}
+		_, err := k8s.dynamic.Resource(externalSecretGVR).Namespace(v1.NamespaceDefault).Create(context.TODO(), es, metav1.CreateOptions{})
+		return err
+	}
+}
+
+func assertExternalSecretIsValid(k8s *k8sClient) error {
+	es, err := k8s.dynamic.Resource(externalSecretGVR).Namespace(v1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("assert external secret valid but not found")
+	}
+	if !verifyExternalSecret(es) {
+		return fmt.Errorf("assert external secret valid but invalid")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// credentialFilePaths returns the files the credential is read from
+func credentialFilePaths() []string {
+	paths := []string{}
+	if configDockerConfigJSONPath != "" {
+		paths = append(paths, configDockerConfigJSONPath)
+	}
+	for _, source := range credentialSources {
+		if file, ok := source.(fileCredentialSource); ok {
+			paths = append(paths, file.path)
+		}
+	}
+	return paths
+}
+
+// fileContentHash returns the hash of the content of the file at path, empty
+// when it cannot be read, e.g. in the middle of an update
+func fileContentHash(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return credentialHash(string(b))
+}
+
+// watchCredentialFiles triggers a loop as soon as the content of one of the
+// files at paths changes, in the background until ctx is done. The
+// directories are watched rather than the files, as Kubernetes updates a
+// mounted secret by swapping the symlink of its data directory, which
+// replaces the file without writing it.
+func watchCredentialFiles(ctx context.Context, paths []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	hashes := map[string]string{}
+	for _, path := range paths {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+			return err
+		}
+		hashes[path] = fileContentHash(path)
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Debugf("Credential file event %s", event)
+				for _, path := range paths {
+					hash := fileContentHash(path)
+					if hash == "" || hash == hashes[path] {
+						continue
+					}
+					hashes[path] = hash
+					log.Infof("Credential file [%s] changed, triggering loop", path)
+					triggerLoop()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("Failed to watch credential files: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchCredentialFilesFollowsSymlinkSwap(t *testing.T) {
+	// lay the file out as Kubernetes mounts secrets, through the symlink of a
+	// data directory
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "..v1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "..v1", ".dockerconfigjson"), []byte(`{"auths":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", ".dockerconfigjson"), filepath.Join(dir, ".dockerconfigjson")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".dockerconfigjson")
+
+	select {
+	case <-loopTrigger:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watchCredentialFiles(ctx, []string{path}); err != nil {
+		t.Fatalf("watchCredentialFiles has error %v", err)
+	}
+
+	// an unrelated file does not trigger a loop
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loopTrigger:
+		t.Fatal("loop triggered by an unrelated file")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// swap the data directory atomically
+	if err := os.Mkdir(filepath.Join(dir, "..v2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "..v2", ".dockerconfigjson"), []byte(`{"auths":{"ghcr.io":{}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-loopTrigger:
+	case <-time.After(5 * time.Second):
+		t.Error("loop not triggered by the swap of the data directory")
+	}
+}
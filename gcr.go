@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	providerGCR = "gcr"
+
+	// gcrHTTPTimeout bounds a single request for an access token
+	gcrHTTPTimeout = 10 * time.Second
+
+	gcrScope       = "https://www.googleapis.com/auth/cloud-platform"
+	gcrTokenURL    = "https://oauth2.googleapis.com/token"
+	gcrMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// metadataTokenSource gets the access token of the Workload Identity, or the
+// node service account, from the GKE metadata server
+type metadataTokenSource struct {
+	client *http.Client
+	url    string
+}
+
+func (s metadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server answered %s", resp.Status)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid metadata server response: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// serviceAccountKeyTokenSource exchanges a service account key for access
+// tokens
+func serviceAccountKeyTokenSource(path string, httpClient *http.Client) (oauth2.TokenSource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %v", err)
+	}
+	key := struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}{}
+	if err := json.Unmarshal(b, &key); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("%s is not a service account key", path)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = gcrTokenURL
+	}
+	config := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{gcrScope},
+		TokenURL:     key.TokenURI,
+	}
+	return config.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)), nil
+}
+
+// gcrCredentialSource renders short-lived access tokens as the
+// `oauth2accesstoken` user of GCR and Artifact Registry
+type gcrCredentialSource struct {
+	registries  []string
+	tokenSource oauth2.TokenSource
+	now         func() time.Time
+	credential  refreshingCredential
+}
+
+// newGCRCredentialSource gets the access tokens with the service account key
+// file, or from the metadata server without one
+func newGCRCredentialSource(httpClient *http.Client) (*gcrCredentialSource, error) {
+	source := &gcrCredentialSource{
+		registries:  splitCommaList(configGCRRegistries),
+		tokenSource: metadataTokenSource{client: httpClient, url: gcrMetadataURL},
+		now:         time.Now,
+	}
+	if len(source.registries) == 0 {
+		return nil, fmt.Errorf("`gcr-registries` is required with `provider=%s`", providerGCR)
+	}
+	if configGCRKeyFile != "" {
+		var err error
+		source.tokenSource, err = serviceAccountKeyTokenSource(configGCRKeyFile, httpClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+func (s *gcrCredentialSource) String() string {
+	return providerGCR + ":" + strings.Join(s.registries, ",")
+}
+
+// load returns the dockerconfigjson of the current access token, refreshing
+// it `gcr-refresh-before`, or `refresh-before`, before its expiry
+func (s *gcrCredentialSource) load(context.Context) (string, error) {
+	return s.credential.get(s.now(), providerRefreshBefore(configGCRRefreshBefore), s.generate)
+}
+
+func (s *gcrCredentialSource) generate() (string, time.Time, error) {
+	token, err := s.tokenSource.Token()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get GCP access token: %v", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("GCP returned an empty access token")
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("oauth2accesstoken:" + token.AccessToken))
+	auths := map[string]string{}
+	for _, registry := range s.registries {
+		auths[registry] = auth
+	}
+	content, err := renderDockerConfigJSON(auths)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Infof("Refreshed GCP access token, expiring at %s", token.Expiry.Format(time.RFC3339))
+	return content, token.Expiry, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGCRCredentialSource(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor header", http.StatusForbidden)
+			return
+		}
+		calls++
+		w.Write([]byte(`{"access_token":"ya29.token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	source := &gcrCredentialSource{
+		registries:  []string{"gcr.io", "europe-docker.pkg.dev"},
+		tokenSource: metadataTokenSource{client: server.Client(), url: server.URL},
+		now:         func() time.Time { return now },
+	}
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	config := struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("invalid dockerconfigjson %s: %v", content, err)
+	}
+	expected := base64.StdEncoding.EncodeToString([]byte("oauth2accesstoken:ya29.token"))
+	for _, registry := range source.registries {
+		if config.Auths[registry].Auth != expected {
+			t.Errorf("auth of %s is %q, want %q", registry, config.Auths[registry].Auth, expected)
+		}
+	}
+
+	now = now.Add(45 * time.Minute)
+	if _, err := source.load(context.TODO()); err != nil || calls != 1 {
+		t.Errorf("token refreshed before its refresh time, %d calls, error %v", calls, err)
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := source.load(context.TODO()); err != nil || calls != 2 {
+		t.Errorf("token not refreshed 10 minutes before its expiry, %d calls, error %v", calls, err)
+	}
+}
+
+func TestServiceAccountKeyTokenSourceRejectsOtherKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, []byte(`{"type":"authorized_user","client_id":"id"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serviceAccountKeyTokenSource(path, http.DefaultClient); err == nil {
+		t.Error("no error for a key which is not of a service account")
+	}
+}
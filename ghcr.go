@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/jws"
+)
+
+const (
+	providerGHCR = "ghcr"
+
+	// ghcrHTTPTimeout bounds a single request to the GitHub API
+	ghcrHTTPTimeout = 10 * time.Second
+
+	// ghcrTokenUser is the user name of installation tokens, which GitHub
+	// does not check
+	ghcrTokenUser = "x-access-token"
+)
+
+// parseRSAPrivateKey parses a PEM private key in PKCS #1, as GitHub issues
+// them, or PKCS #8
+func parseRSAPrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// ghcrCredentialSource renders the installation tokens of a GitHub App as
+// credentials of GitHub Container Registry
+type ghcrCredentialSource struct {
+	apiURL         string
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+	registry       string
+	client         *http.Client
+	now            func() time.Time
+	credential     refreshingCredential
+}
+
+func newGHCRCredentialSource(httpClient *http.Client) (*ghcrCredentialSource, error) {
+	if configGHCRAppID == "" || configGHCRInstallationID == "" || configGHCRPrivateKeyFile == "" {
+		return nil, fmt.Errorf("`ghcr-app-id`, `ghcr-installation-id` and `ghcr-private-key-file` are required with `provider=%s`", providerGHCR)
+	}
+	b, err := os.ReadFile(configGHCRPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %v", err)
+	}
+	key, err := parseRSAPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %v", err)
+	}
+	return &ghcrCredentialSource{
+		apiURL:         strings.TrimSuffix(configGHCRAPIURL, "/"),
+		appID:          configGHCRAppID,
+		installationID: configGHCRInstallationID,
+		key:            key,
+		registry:       configGHCRRegistry,
+		client:         httpClient,
+		now:            time.Now,
+	}, nil
+}
+
+func (s *ghcrCredentialSource) String() string {
+	return providerGHCR + ":" + s.registry
+}
+
+// load returns the dockerconfigjson of the current installation token,
+// requesting a new one `ghcr-refresh-before`, or `refresh-before`, before it
+// expires
+func (s *ghcrCredentialSource) load(context.Context) (string, error) {
+	return s.credential.get(s.now(), providerRefreshBefore(configGHCRRefreshBefore), s.generate)
+}
+
+// appJWT signs the short-lived JWT authenticating as the GitHub App, issued
+// a minute early against clock drift
+func (s *ghcrCredentialSource) appJWT() (string, error) {
+	now := s.now()
+	return jws.Encode(&jws.Header{Algorithm: "RS256", Typ: "JWT"}, &jws.ClaimSet{
+		Iss: s.appID,
+		Iat: now.Add(-time.Minute).Unix(),
+		Exp: now.Add(9 * time.Minute).Unix(),
+	}, s.key)
+}
+
+func (s *ghcrCredentialSource) generate() (string, time.Time, error) {
+	assertion, err := s.appJWT()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign GitHub App JWT: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.apiURL+"/app/installations/"+s.installationID+"/access_tokens", nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+assertion)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get GitHub installation token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("failed to get GitHub installation token: GitHub answered %s", resp.Status)
+	}
+	token := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid GitHub installation token response: %v", err)
+	}
+	if token.Token == "" {
+		return "", time.Time{}, fmt.Errorf("GitHub returned an empty installation token")
+	}
+	content, err := renderDockerConfigJSON(map[string]string{
+		s.registry: base64.StdEncoding.EncodeToString([]byte(ghcrTokenUser + ":" + token.Token)),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Infof("Refreshed GitHub installation token, expiring at %s", token.ExpiresAt.Format(time.RFC3339))
+	return content, token.ExpiresAt, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2/jws"
+)
+
+func TestGHCRCredentialSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assertion := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := jws.Verify(assertion, &key.PublicKey); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if claims, err := jws.Decode(assertion); err != nil || claims.Iss != "1234" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"token": "ghs_token", "expires_at": now.Add(time.Hour).Format(time.RFC3339)})
+	}))
+	defer server.Close()
+
+	source := &ghcrCredentialSource{
+		apiURL:         server.URL,
+		appID:          "1234",
+		installationID: "42",
+		key:            key,
+		registry:       "ghcr.io",
+		client:         server.Client(),
+		now:            func() time.Time { return now },
+	}
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	config := dockerConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("invalid dockerconfigjson %s: %v", content, err)
+	}
+	if want := base64.StdEncoding.EncodeToString([]byte("x-access-token:ghs_token")); config.Auths["ghcr.io"].Auth != want {
+		t.Errorf("auth of ghcr.io is %q, want %q", config.Auths["ghcr.io"].Auth, want)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if _, err := source.load(context.TODO()); err != nil || calls != 1 {
+		t.Errorf("token refreshed before its refresh time, %d calls, error %v", calls, err)
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := source.load(context.TODO()); err != nil || calls != 2 {
+		t.Errorf("token not refreshed 10 minutes before its expiry, %d calls, error %v", calls, err)
+	}
+}
+
+func TestParseRSAPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, block := range map[string]*pem.Block{
+		"PKCS #1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"PKCS #8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		if _, err := parseRSAPrivateKey(pem.EncodeToMemory(block)); err != nil {
+			t.Errorf("parseRSAPrivateKey of %s key failed: %v", name, err)
+		}
+	}
+	if _, err := parseRSAPrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("parseRSAPrivateKey should fail without a PEM block")
+	}
+}
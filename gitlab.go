@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	providerGitLab = "gitlab"
+
+	// gitlabHTTPTimeout bounds a single request to the GitLab API
+	gitlabHTTPTimeout = 10 * time.Second
+)
+
+// gitlabCredentialSource creates short-lived deploy tokens of a GitLab
+// project or group, read-only on its container registry, replacing them
+// before they expire. Replaced tokens are left to expire, as namespaces may
+// still use them until the next loop.
+type gitlabCredentialSource struct {
+	// deployTokensURL is the deploy tokens endpoint of the project or group
+	deployTokensURL string
+	// tokenFile holds the access token creating deploy tokens, re-read on
+	// every request
+	tokenFile  string
+	registry   string
+	lifetime   time.Duration
+	client     *http.Client
+	now        func() time.Time
+	credential refreshingCredential
+}
+
+func newGitLabCredentialSource(httpClient *http.Client) (*gitlabCredentialSource, error) {
+	if configGitLabTokenFile == "" {
+		return nil, fmt.Errorf("`gitlab-token-file` is required with `provider=%s`", providerGitLab)
+	}
+	if (configGitLabProject == "") == (configGitLabGroup == "") {
+		return nil, fmt.Errorf("exactly one of `gitlab-project` and `gitlab-group` is required with `provider=%s`", providerGitLab)
+	}
+	if configGitLabTokenLifetime <= providerRefreshBefore(configGitLabRefreshBefore) {
+		return nil, fmt.Errorf("`gitlab-token-lifetime` must be longer than the refresh window")
+	}
+	endpoint := "/projects/" + url.PathEscape(configGitLabProject)
+	if configGitLabGroup != "" {
+		endpoint = "/groups/" + url.PathEscape(configGitLabGroup)
+	}
+	return &gitlabCredentialSource{
+		deployTokensURL: strings.TrimSuffix(configGitLabURL, "/") + "/api/v4" + endpoint + "/deploy_tokens",
+		tokenFile:       configGitLabTokenFile,
+		registry:        configGitLabRegistry,
+		lifetime:        configGitLabTokenLifetime,
+		client:          httpClient,
+		now:             time.Now,
+	}, nil
+}
+
+func (s *gitlabCredentialSource) String() string {
+	return providerGitLab + ":" + s.registry
+}
+
+// load returns the dockerconfigjson of the current deploy token, creating a
+// new one `gitlab-refresh-before`, or `refresh-before`, before it expires
+func (s *gitlabCredentialSource) load(context.Context) (string, error) {
+	return s.credential.get(s.now(), providerRefreshBefore(configGitLabRefreshBefore), s.generate)
+}
+
+func (s *gitlabCredentialSource) generate() (string, time.Time, error) {
+	b, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read GitLab access token: %v", err)
+	}
+	now := s.now()
+	body, err := json.Marshal(map[string]interface{}{
+		"name":       fmt.Sprintf("%s-%d", annotationAppName, now.Unix()),
+		"scopes":     []string{"read_registry"},
+		"expires_at": now.Add(s.lifetime).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.deployTokensURL, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", strings.TrimSpace(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create GitLab deploy token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("failed to create GitLab deploy token: GitLab answered %s", resp.Status)
+	}
+	token := struct {
+		Username  string    `json:"username"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid GitLab deploy token response: %v", err)
+	}
+	if token.Username == "" || token.Token == "" {
+		return "", time.Time{}, fmt.Errorf("GitLab returned a deploy token without username or token")
+	}
+	content, err := renderDockerConfigJSON(map[string]string{
+		s.registry: base64.StdEncoding.EncodeToString([]byte(token.Username + ":" + token.Token)),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	log.Infof("Created GitLab deploy token %s, expiring at %s", token.Username, token.ExpiresAt.Format(time.RFC3339))
+	return content, token.ExpiresAt, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitLabCredentialSource(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.EscapedPath() != "/api/v4/projects/team-a%2Fapp/deploy_tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		request := struct {
+			Scopes    []string  `json:"scopes"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Scopes) != 1 || request.Scopes[0] != "read_registry" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"username":   "gitlab+deploy-token-1",
+			"token":      "gldt-token",
+			"expires_at": request.ExpiresAt,
+		})
+	}))
+	defer server.Close()
+	defer func() {
+		configGitLabURL, configGitLabProject, configGitLabTokenFile = "https://gitlab.com", "", ""
+	}()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("glpat-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configGitLabURL = server.URL
+	configGitLabProject = "team-a/app"
+	configGitLabTokenFile = tokenFile
+	source, err := newGitLabCredentialSource(server.Client())
+	if err != nil {
+		t.Fatalf("newGitLabCredentialSource failed: %v", err)
+	}
+	source.now = func() time.Time { return now }
+
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	config := dockerConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("invalid dockerconfigjson %s: %v", content, err)
+	}
+	if want := base64.StdEncoding.EncodeToString([]byte("gitlab+deploy-token-1:gldt-token")); config.Auths["registry.gitlab.com"].Auth != want {
+		t.Errorf("auth of registry.gitlab.com is %q, want %q", config.Auths["registry.gitlab.com"].Auth, want)
+	}
+	if exp := source.expiry(); !exp.Equal(now.Add(24 * time.Hour).Truncate(time.Second)) {
+		t.Errorf("expiry is %s, want the token lifetime", exp)
+	}
+
+	now = now.Add(22 * time.Hour)
+	if _, err := source.load(context.TODO()); err != nil || calls != 1 {
+		t.Errorf("deploy token replaced before its refresh time, %d calls, error %v", calls, err)
+	}
+	now = now.Add(90 * time.Minute)
+	if _, err := source.load(context.TODO()); err != nil || calls != 2 {
+		t.Errorf("deploy token not replaced an hour before its expiry, %d calls, error %v", calls, err)
+	}
+}
+
+func TestNewGitLabCredentialSourceValidation(t *testing.T) {
+	defer func() { configGitLabProject, configGitLabGroup, configGitLabTokenFile = "", "", "" }()
+	configGitLabTokenFile = "/secrets/gitlab-token"
+	for _, target := range [][2]string{{"", ""}, {"team-a/app", "team-a"}} {
+		configGitLabProject, configGitLabGroup = target[0], target[1]
+		if _, err := newGitLabCredentialSource(http.DefaultClient); err == nil {
+			t.Errorf("newGitLabCredentialSource with project %q and group %q should fail", target[0], target[1])
+		}
+	}
+}
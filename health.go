@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// credentialRequired tells whether the patcher distributes the credential
+// itself, rather than leaving it to an operator or driver
+func credentialRequired() bool {
+	return configEnableSecretSync && (configSecretMode == secretModeSecret || configSecretMode == secretModeSealedSecret)
+}
+
+// loadCredential loads the dockerconfigjson and records whether its source is
+// available
+func loadCredential(ctx context.Context) (string, error) {
+	content, err := getDockerConfigJSON(ctx)
+	if err == nil && configCredentialHelpers {
+		content, err = resolveCredentialHelpers(content)
+		registerCredential("credential-helpers", content)
+	}
+	if err == nil && strings.TrimSpace(content) == "" && credentialRequired() {
+		err = fmt.Errorf("credential is empty")
+	}
+	if err != nil {
+		metricCredentialSourceAvailable.Set(0)
+		return "", fmt.Errorf("failed to load dockerconfigjson: %v", err)
+	}
+	metricCredentialSourceAvailable.Set(1)
+	// a template is checked once expanded for each namespace
+	if configCredentialTemplate {
+		if _, err := parseCredentialTemplate(content); err != nil {
+			metricCredentialInvalid.Set(1)
+			return "", fmt.Errorf("refusing to distribute malformed credential template: %v", err)
+		}
+		metricCredentialInvalid.Set(0)
+		return content, nil
+	}
+	if strings.TrimSpace(content) != "" && configValidateCredential {
+		if err := validateDockerConfigJSON(content); err != nil {
+			metricCredentialInvalid.Set(1)
+			return "", fmt.Errorf("refusing to distribute malformed dockerconfigjson: %v", err)
+		}
+		metricCredentialInvalid.Set(0)
+	}
+	if strings.TrimSpace(content) != "" && configVerifyRegistryAuth {
+		if err := verifyRegistryAuth(ctx, content); err != nil {
+			return "", fmt.Errorf("refusing to distribute dockerconfigjson: %v", err)
+		}
+	}
+	return content, nil
+}
+
+// healthzHandler reports the process is alive
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports the patcher unready while the credential source is
+// unavailable, checking it on every probe
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := loadCredential(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
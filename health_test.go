@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	configSecretMode = secretModeSecret
+	configDockerConfigJSONPath = filepath.Join(t.TempDir(), ".dockerconfigjson")
+	defer func() { configDockerConfigJSONPath = "" }()
+
+	for name, tc := range map[string]struct {
+		exists  bool
+		content string
+		status  int
+	}{
+		"missing file": {false, "", http.StatusServiceUnavailable},
+		"empty file":   {true, "", http.StatusServiceUnavailable},
+		"credential":   {true, testDockerconfig, http.StatusOK},
+	} {
+		os.Remove(configDockerConfigJSONPath)
+		if tc.exists {
+			if err := os.WriteFile(configDockerConfigJSONPath, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != tc.status {
+			t.Errorf("%s: readyz answered %d, want %d", name, w.Code, tc.status)
+		}
+		available := 0.0
+		if tc.status == http.StatusOK {
+			available = 1
+		}
+		if v := testutil.ToFloat64(metricCredentialSourceAvailable); v != available {
+			t.Errorf("%s: credential_source_available = %v, want %v", name, v, available)
+		}
+	}
+}
+
+func TestLoadCredentialOptional(t *testing.T) {
+	configSecretMode = secretModeExternalSecret
+	defer func() { configSecretMode = secretModeSecret }()
+	if _, err := loadCredential(context.TODO()); err != nil {
+		t.Errorf("loadCredential should accept an empty credential in externalsecret mode: %v", err)
+	}
+}
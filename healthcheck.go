@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dockerConfig is the content of a kubernetes.io/dockerconfigjson secret
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// credentials returns the username and password, decoding them from auth if
+// they are not given separately
+func (a dockerConfigAuth) credentials() (string, string) {
+	if a.Username != "" || a.Auth == "" {
+		return a.Username, a.Password
+	}
+	b, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", ""
+	}
+	username, password, _ := strings.Cut(string(b), ":")
+	return username, password
+}
+
+// registryHTTPTimeout bounds a single request to a registry
+const registryHTTPTimeout = 10 * time.Second
+
+var (
+	registryHTTPClient = &http.Client{Timeout: registryHTTPTimeout}
+
+	registryHealthMu sync.RWMutex
+	registryHealth   = map[string]error{}
+
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+	// errRegistryAuthentication is returned when a registry rejects the
+	// credentials, as opposed to failing to answer
+	errRegistryAuthentication = errors.New("authentication failed")
+)
+
+// registryHost returns the host serving the registry API for a dockerconfigjson key
+func registryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "index.docker.io":
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(header, " ")
+	params := map[string]string{}
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(rest, -1) {
+		params[m[1]] = m[2]
+	}
+	return strings.ToLower(scheme), params
+}
+
+// checkRegistry pings the registry API of host and authenticates with the
+// given credentials, fetching a token when the registry asks for one
+func checkRegistry(ctx context.Context, host string, auth dockerConfigAuth) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := registryHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+	default:
+		return fmt.Errorf("registry answered %s", resp.Status)
+	}
+
+	username, password := auth.credentials()
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	var target string
+	switch scheme {
+	case "basic":
+		target = "https://" + host + "/v2/"
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return fmt.Errorf("invalid token realm %q", params["realm"])
+		}
+		if service, ok := params["service"]; ok {
+			q := realm.Query()
+			q.Set("service", service)
+			realm.RawQuery = q.Encode()
+		}
+		target = realm.String()
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+	resp, err = registryHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", errRegistryAuthentication, resp.Status)
+	default:
+		return fmt.Errorf("authentication answered %s", resp.Status)
+	}
+	if scheme == "bearer" {
+		token := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("invalid token response: %v", err)
+		}
+		if token.Token == "" && token.AccessToken == "" {
+			return fmt.Errorf("registry issued an empty token")
+		}
+	}
+	return nil
+}
+
+// checkRegistries checks every registry of the credential the loop last
+// loaded and records the results in the health state and metrics
+func checkRegistries(ctx context.Context) {
+	reconcileMu.RLock()
+	content := dockerConfigJSON
+	reconcileMu.RUnlock()
+	if content == "" {
+		log.Debug("Registry health check skipped, no credential loaded yet")
+		return
+	}
+	config := dockerConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		log.Errorf("Registry health check failed to parse dockerconfigjson: %v", err)
+		return
+	}
+	for key, auth := range config.Auths {
+		host := registryHost(key)
+		err := checkRegistry(ctx, host, auth)
+		if err == nil {
+			log.Debugf("Registry [%s] is healthy", host)
+			metricRegistryHealthy.WithLabelValues(host).Set(1)
+		} else {
+			log.Warnf("Registry [%s] is unhealthy: %v", host, err)
+			metricRegistryHealthy.WithLabelValues(host).Set(0)
+			metricRegistryHealthCheckFailures.WithLabelValues(host).Inc()
+		}
+		registryHealthMu.Lock()
+		registryHealth[host] = err
+		registryHealthMu.Unlock()
+	}
+}
+
+// registryUnreachable tells whether the last health check of host failed to
+// reach it, as opposed to it rejecting the credential, an unchecked registry
+// counting as reachable
+func registryUnreachable(host string) bool {
+	registryHealthMu.RLock()
+	defer registryHealthMu.RUnlock()
+	err := registryHealth[host]
+	return err != nil && !errors.Is(err, errRegistryAuthentication)
+}
+
+// runRegistryHealthChecks checks the registries every interval in the
+// background, independently from the sync loop, until ctx is done
+func runRegistryHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		log.Infof("Checking registry health every %s", interval)
+		for {
+			checkRegistries(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
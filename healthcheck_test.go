@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRegistryHost(t *testing.T) {
+	for key, want := range map[string]string{
+		"gcr.io":                      "gcr.io",
+		"https://index.docker.io/v1/": "registry-1.docker.io",
+		"docker.io":                   "registry-1.docker.io",
+		"http://registry.local:5000":  "registry.local:5000",
+	} {
+		if got := registryHost(key); got != want {
+			t.Errorf("registryHost(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDockerConfigAuthCredentials(t *testing.T) {
+	username, password := dockerConfigAuth{Auth: "dXNlcjpwYXNz"}.credentials()
+	if username != "user" || password != "pass" {
+		t.Errorf("credentials() from auth = %q, %q, want user, pass", username, password)
+	}
+	username, password = dockerConfigAuth{Username: "u", Password: "p", Auth: "dXNlcjpwYXNz"}.credentials()
+	if username != "u" || password != "p" {
+		t.Errorf("credentials() = %q, %q, want u, p", username, password)
+	}
+}
+
+// newTestRegistry serves a registry API requiring user/pass, through a token
+// realm when bearer is set
+func newTestRegistry(t *testing.T, bearer bool) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		authorized := ok && username == "user" && password == "pass"
+		switch {
+		case r.URL.Path == "/token" && bearer:
+			if !authorized || r.URL.Query().Get("service") != "test" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "t"})
+		case r.URL.Path == "/v2/" && bearer:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/" && !authorized:
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckRegistry(t *testing.T) {
+	defaultClient := registryHTTPClient
+	defer func() { registryHTTPClient = defaultClient }()
+
+	for _, bearer := range []bool{false, true} {
+		server := newTestRegistry(t, bearer)
+		registryHTTPClient = server.Client()
+		host := strings.TrimPrefix(server.URL, "https://")
+
+		if err := checkRegistry(context.TODO(), host, dockerConfigAuth{Username: "user", Password: "pass"}); err != nil {
+			t.Errorf("checkRegistry with valid credentials (bearer: %v) failed: %v", bearer, err)
+		}
+		if err := checkRegistry(context.TODO(), host, dockerConfigAuth{Username: "user", Password: "wrong"}); err == nil {
+			t.Errorf("checkRegistry with invalid credentials (bearer: %v) should fail", bearer)
+		}
+	}
+}
+
+func TestCheckRegistries(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defaultClient := registryHTTPClient
+	defer func() { registryHTTPClient = defaultClient }()
+	server := newTestRegistry(t, false)
+	registryHTTPClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	defer func() { dockerConfigJSON = testDockerconfig }()
+	dockerConfigJSON = `{"auths":{"` + host + `":{"username":"user","password":"wrong"}}}`
+	checkRegistries(context.TODO())
+	if err := registryHealth[host]; !errors.Is(err, errRegistryAuthentication) {
+		t.Errorf("registry should be unhealthy with invalid credentials, got %v", err)
+	}
+	if registryUnreachable(host) {
+		t.Errorf("registry rejecting the credential should count as reachable")
+	}
+	dockerConfigJSON = `{"auths":{"` + host + `":{"auth":"dXNlcjpwYXNz"}}}`
+	checkRegistries(context.TODO())
+	if err := registryHealth[host]; err != nil {
+		t.Errorf("registry should be healthy with valid credentials, got %v", err)
+	}
+	server.Close()
+	checkRegistries(context.TODO())
+	if !registryUnreachable(host) {
+		t.Errorf("registry should be unreachable once closed")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// outboundProxy returns the proxy selection of outbound HTTP clients, from the
+// standard environment variables overridden by the proxy configs
+func outboundProxy() func(*http.Request) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	if configHTTPProxy != "" {
+		config.HTTPProxy = configHTTPProxy
+	}
+	if configHTTPSProxy != "" {
+		config.HTTPSProxy = configHTTPSProxy
+	}
+	if configNoProxy != "" {
+		config.NoProxy = configNoProxy
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// outboundRootCAs returns the system roots extended by the CA bundle, nil to
+// use the system roots only
+func outboundRootCAs() (*x509.CertPool, error) {
+	if configCABundle == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(configCABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", configCABundle)
+	}
+	return pool, nil
+}
+
+// newOutboundHTTPClient returns a client for calls to registries and cloud
+// endpoints, i.e. everything but the Kubernetes API, honoring the proxy
+// configs and the CA bundle
+func newOutboundHTTPClient(timeout time.Duration) (*http.Client, error) {
+	rootCAs, err := outboundRootCAs()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = outboundProxy()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
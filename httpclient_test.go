@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutboundProxy(t *testing.T) {
+	configHTTPSProxy, configNoProxy = "http://proxy.example.com:3128", "internal.example.com"
+	defer func() { configHTTPSProxy, configNoProxy = "", "" }()
+	proxy := outboundProxy()
+
+	for target, want := range map[string]string{
+		"https://registry.example.com/v2/": "http://proxy.example.com:3128",
+		"https://internal.example.com/v2/": "",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatalf("proxy(%s) failed: %v", target, err)
+		}
+		if (got == nil && want != "") || (got != nil && got.String() != want) {
+			t.Errorf("proxy(%s) = %v, want %q", target, got, want)
+		}
+	}
+}
+
+func TestNewOutboundHTTPClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	defer func() { configCABundle = "" }()
+
+	client, err := newOutboundHTTPClient(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatalf("expected the certificate of the server to be untrusted without CA bundle")
+	}
+
+	configCABundle = filepath.Join(t.TempDir(), "ca.pem")
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(configCABundle, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err = newOutboundHTTPClient(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with CA bundle failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := os.WriteFile(configCABundle, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newOutboundHTTPClient(time.Second); err == nil {
+		t.Errorf("expected error for a CA bundle without certificates")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the timeouts of the servers of the patcher, leaving out the write
+	// timeout as profiles and traces are streamed for as long as requested
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 30 * time.Second
+	serverIdleTimeout       = 2 * time.Minute
+	serverShutdownTimeout   = 5 * time.Second
+)
+
+// newHTTPServer returns the server of handler on addr, shut down once ctx is
+// done, after which its ListenAndServe returns http.ErrServerClosed
+func newHTTPServer(ctx context.Context, addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		IdleTimeout:       serverIdleTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("Failed to shut down server on %s: %v", addr, err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := newHTTPServer(ctx, "127.0.0.1:0", http.NotFoundHandler())
+	if server.ReadHeaderTimeout == 0 || server.ReadTimeout == 0 || server.IdleTimeout == 0 {
+		t.Errorf("newHTTPServer gives a server without timeouts: %+v", server)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- server.ListenAndServe()
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Errorf("ListenAndServe returned %v once ctx is done, expects %v", err, http.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("newHTTPServer was not shut down once ctx is done")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// namespacesInSync holds whether every namespace reconciled is in sync,
+	// mirrored by imagepullsecret_namespace_in_sync
+	namespacesInSyncMu sync.Mutex
+	namespacesInSync   = map[string]bool{}
+
+	// driftedSecrets holds the secrets of every namespace found drifted with
+	// `detect-only`, which leave it out of sync though its reconcile succeeded
+	driftedSecretsMu sync.Mutex
+	driftedSecrets   = map[string]map[string]bool{}
+)
+
+// recordSecretDrift records whether the secret name of namespace drifted
+func recordSecretDrift(namespace, name string, drifted bool) {
+	driftedSecretsMu.Lock()
+	defer driftedSecretsMu.Unlock()
+	if !drifted {
+		delete(driftedSecrets[namespace], name)
+		if len(driftedSecrets[namespace]) == 0 {
+			delete(driftedSecrets, namespace)
+		}
+		return
+	}
+	if driftedSecrets[namespace] == nil {
+		driftedSecrets[namespace] = map[string]bool{}
+	}
+	driftedSecrets[namespace][name] = true
+}
+
+// recordNamespaceInSync records the result of reconciling namespace, which is
+// in sync when it succeeded and none of its secrets drifted
+func recordNamespaceInSync(namespace string, err error) {
+	driftedSecretsMu.Lock()
+	drifted := len(driftedSecrets[namespace]) > 0
+	driftedSecretsMu.Unlock()
+	inSync := err == nil && !drifted
+
+	namespacesInSyncMu.Lock()
+	defer namespacesInSyncMu.Unlock()
+	namespacesInSync[namespace] = inSync
+	if inSync {
+		metricNamespaceInSync.WithLabelValues(namespace).Set(1)
+	} else {
+		metricNamespaceInSync.WithLabelValues(namespace).Set(0)
+	}
+	updateNamespacesOutOfSync()
+}
+
+// pruneNamespacesInSync forgets the namespaces which are gone or excluded, so
+// they neither count as out of sync nor leave a stale series behind
+func pruneNamespacesInSync(namespaces []corev1.Namespace) {
+	managed := map[string]bool{}
+	for _, ns := range namespaces {
+		if !namespaceIsExcluded(ns) {
+			managed[ns.Name] = true
+		}
+	}
+	driftedSecretsMu.Lock()
+	for namespace := range driftedSecrets {
+		if !managed[namespace] {
+			delete(driftedSecrets, namespace)
+		}
+	}
+	driftedSecretsMu.Unlock()
+
+	namespacesInSyncMu.Lock()
+	defer namespacesInSyncMu.Unlock()
+	for namespace := range namespacesInSync {
+		if !managed[namespace] {
+			delete(namespacesInSync, namespace)
+			metricNamespaceInSync.DeleteLabelValues(namespace)
+		}
+	}
+	updateNamespacesOutOfSync()
+}
+
+// updateNamespacesOutOfSync counts the namespaces out of sync, to be called
+// with namespacesInSyncMu held
+func updateNamespacesOutOfSync() {
+	outOfSync := 0
+	for _, inSync := range namespacesInSync {
+		if !inSync {
+			outOfSync++
+		}
+	}
+	metricNamespacesOutOfSync.Set(float64(outOfSync))
+}
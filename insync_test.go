@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceInSync(t *testing.T) {
+	defer func() {
+		namespacesInSync = map[string]bool{}
+		driftedSecrets = map[string]map[string]bool{}
+		metricNamespaceInSync.Reset()
+		metricNamespacesOutOfSync.Set(0)
+	}()
+	namespacesInSync = map[string]bool{}
+	driftedSecrets = map[string]map[string]bool{}
+
+	recordNamespaceInSync("team-a", nil)
+	recordNamespaceInSync("team-b", fmt.Errorf("failed to create secret"))
+	recordSecretDrift("team-c", configSecretName, true)
+	recordNamespaceInSync("team-c", nil)
+	for namespace, want := range map[string]float64{"team-a": 1, "team-b": 0, "team-c": 0} {
+		if v := testutil.ToFloat64(metricNamespaceInSync.WithLabelValues(namespace)); v != want {
+			t.Errorf("[%s] in sync gauge = %v, want %v", namespace, v, want)
+		}
+	}
+	if v := testutil.ToFloat64(metricNamespacesOutOfSync); v != 2 {
+		t.Errorf("out of sync gauge = %v, want 2", v)
+	}
+
+	recordSecretDrift("team-c", configSecretName, false)
+	recordNamespaceInSync("team-c", nil)
+	if v := testutil.ToFloat64(metricNamespaceInSync.WithLabelValues("team-c")); v != 1 {
+		t.Errorf("a namespace whose secret no longer drifts should be in sync, got %v", v)
+	}
+
+	// team-b is gone, its series must not linger as out of sync
+	pruneNamespacesInSync([]corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-c"}},
+	})
+	if v := testutil.ToFloat64(metricNamespacesOutOfSync); v != 0 {
+		t.Errorf("out of sync gauge = %v after pruning, want 0", v)
+	}
+	if n := testutil.CollectAndCount(metricNamespaceInSync); n != 2 {
+		t.Errorf("expected the series of the 2 remaining namespaces, got %d", n)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// lastKnownGood is the last credential which loaded, guarded by reconcileMu
+var lastKnownGood string
+
+// keepLastKnownGood returns content when it loaded, recording it as the last
+// known good credential, and the last known good one otherwise, read from
+// the last known good secret after a restart. The credential is flagged
+// stale while the last known good one is used.
+func keepLastKnownGood(ctx context.Context, k8s *k8sClient, content string, err error) (string, error) {
+	if err == nil {
+		if content != lastKnownGood {
+			if err := saveLastKnownGood(ctx, k8s, content); err != nil {
+				log.Warn(err)
+			}
+			lastKnownGood = content
+		}
+		metricCredentialStale.Set(0)
+		return content, nil
+	}
+	if lastKnownGood == "" {
+		stored, loadErr := loadLastKnownGood(ctx, k8s)
+		if loadErr != nil {
+			log.Warn(loadErr)
+		}
+		lastKnownGood = stored
+	}
+	if lastKnownGood == "" {
+		return "", err
+	}
+	log.Warnf("Keeping the last known good credential: %v", err)
+	metricCredentialStale.Set(1)
+	return lastKnownGood, nil
+}
+
+// loadLastKnownGood reads the credential from the last known good secret,
+// empty when it does not exist or none is configured
+func loadLastKnownGood(ctx context.Context, k8s *k8sClient) (string, error) {
+	if configLastKnownGoodSecret == "" {
+		return "", nil
+	}
+	namespace, name, err := splitNamespacedName(configLastKnownGoodSecret)
+	if err != nil {
+		return "", err
+	}
+	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to GET last known good secret: %v", err)
+	}
+	return string(secret.Data[corev1.DockerConfigJsonKey]), nil
+}
+
+// saveLastKnownGood writes content into the last known good secret, so it
+// survives a restart during an outage of the source
+func saveLastKnownGood(ctx context.Context, k8s *k8sClient, content string) error {
+	if configLastKnownGoodSecret == "" {
+		return nil
+	}
+	namespace, name, err := splitNamespacedName(configLastKnownGoodSecret)
+	if err != nil {
+		return err
+	}
+	data := map[string][]byte{corev1.DockerConfigJsonKey: []byte(content)}
+	client := k8s.clientset.CoreV1().Secrets(namespace)
+	secret, err := client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		secret.Data = data
+		_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write last known good secret: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKeepLastKnownGood(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer func() {
+		lastKnownGood = ""
+		configLastKnownGoodSecret = ""
+		metricCredentialStale.Set(0)
+	}()
+	configLastKnownGoodSecret = "kube-system/imagepullsecret-patcher-last-known-good"
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	unavailable := fmt.Errorf("no credential source available")
+
+	if _, err := keepLastKnownGood(context.TODO(), k8s, "", unavailable); err == nil {
+		t.Errorf("keepLastKnownGood should fail before any credential loaded")
+	}
+	content, err := keepLastKnownGood(context.TODO(), k8s, testDockerconfig, nil)
+	if err != nil || content != testDockerconfig {
+		t.Fatalf("keepLastKnownGood = %q, %v, want the loaded credential", content, err)
+	}
+	secret, err := k8s.clientset.CoreV1().Secrets("kube-system").Get(context.TODO(), "imagepullsecret-patcher-last-known-good", metav1.GetOptions{})
+	if err != nil || string(secret.Data[corev1.DockerConfigJsonKey]) != testDockerconfig {
+		t.Fatalf("last known good secret = %+v, %v, want the loaded credential", secret, err)
+	}
+
+	content, err = keepLastKnownGood(context.TODO(), k8s, "", unavailable)
+	if err != nil || content != testDockerconfig {
+		t.Errorf("keepLastKnownGood = %q, %v, want the last known good credential", content, err)
+	}
+	if v := testutil.ToFloat64(metricCredentialStale); v != 1 {
+		t.Errorf("credential stale = %v, want 1", v)
+	}
+
+	// after a restart, the last known good credential comes from the secret
+	lastKnownGood = ""
+	content, err = keepLastKnownGood(context.TODO(), k8s, "", unavailable)
+	if err != nil || content != testDockerconfig {
+		t.Errorf("keepLastKnownGood after a restart = %q, %v, want the stored credential", content, err)
+	}
+
+	if _, err := keepLastKnownGood(context.TODO(), k8s, testDockerconfig, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(metricCredentialStale); v != 0 {
+		t.Errorf("credential stale = %v once the source recovered, want 0", v)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// errorClassDigits matches the numbers of an error message, such as resource
+// versions and durations, which differ between occurrences of the same error
+var errorClassDigits = regexp.MustCompile(`[0-9]+`)
+
+// namespaceErrorLog records the occurrences of an error class of a namespace
+// since it was last logged
+type namespaceErrorLog struct {
+	loggedAt   time.Time
+	lastSeen   time.Time
+	suppressed int
+}
+
+var (
+	namespaceErrorLogsMu sync.Mutex
+	namespaceErrorLogs   = map[string]map[string]*namespaceErrorLog{}
+)
+
+// errorClass returns the message of err without its numbers
+func errorClass(err error) string {
+	return errorClassDigits.ReplaceAllString(err.Error(), "#")
+}
+
+// logNamespaceError logs the error namespace failed with, unless the same
+// class of error was logged for it less than `log-dedup-interval` ago, in
+// which case it is counted and rolled up into the next line logged for it
+func logNamespaceError(namespace string, err error, now time.Time) {
+	if configLogDedupInterval <= 0 {
+		nsLog(namespace).Error(err)
+		return
+	}
+	class := errorClass(err)
+	namespaceErrorLogsMu.Lock()
+	logs, ok := namespaceErrorLogs[namespace]
+	if !ok {
+		logs = map[string]*namespaceErrorLog{}
+		namespaceErrorLogs[namespace] = logs
+	}
+	l, seen := logs[class]
+	if !seen {
+		l = &namespaceErrorLog{}
+		logs[class] = l
+	}
+	l.lastSeen = now
+	if seen && now.Sub(l.loggedAt) < configLogDedupInterval {
+		l.suppressed++
+		namespaceErrorLogsMu.Unlock()
+		nsLog(namespace).Debug(err)
+		return
+	}
+	suppressed := l.suppressed
+	l.loggedAt, l.suppressed = now, 0
+	namespaceErrorLogsMu.Unlock()
+	if !seen {
+		nsLog(namespace).Error(err)
+	} else {
+		nsLog(namespace).WithField("repeated", suppressed+1).Errorf("Still failing (x%d): %v", suppressed+1, err)
+	}
+}
+
+// logNamespaceRecovered forgets the errors logged for namespace, logging
+// that it recovered when it failed before
+func logNamespaceRecovered(namespace string) {
+	namespaceErrorLogsMu.Lock()
+	_, failed := namespaceErrorLogs[namespace]
+	delete(namespaceErrorLogs, namespace)
+	namespaceErrorLogsMu.Unlock()
+	if failed {
+		nsLog(namespace).Info("Namespace recovered")
+	}
+}
+
+// pruneNamespaceErrorLogs forgets the errors not seen for two
+// `log-dedup-interval`, so deleted namespaces don't pile up
+func pruneNamespaceErrorLogs(now time.Time) {
+	namespaceErrorLogsMu.Lock()
+	defer namespaceErrorLogsMu.Unlock()
+	for namespace, logs := range namespaceErrorLogs {
+		for class, l := range logs {
+			if now.Sub(l.lastSeen) >= 2*configLogDedupInterval {
+				delete(logs, class)
+			}
+		}
+		if len(logs) == 0 {
+			delete(namespaceErrorLogs, namespace)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogNamespaceError(t *testing.T) {
+	hook := captureLogs(t)
+	configLogDedupInterval = time.Minute
+	defer func() {
+		configLogDedupInterval = 0
+		namespaceErrorLogs = map[string]map[string]*namespaceErrorLog{}
+	}()
+	errors := func() []string {
+		messages := []string{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Level <= log.ErrorLevel {
+				messages = append(messages, entry.Message)
+			}
+		}
+		hook.Reset()
+		return messages
+	}
+
+	now := time.Now()
+	unmanaged := fmt.Errorf("Secret [registry] is present but unmanaged")
+	logNamespaceError("team-a", unmanaged, now)
+	logNamespaceError("team-a", unmanaged, now.Add(10*time.Second))
+	logNamespaceError("team-a", unmanaged, now.Add(20*time.Second))
+	logNamespaceError("team-b", fmt.Errorf("Secret [registry] is present but unmanaged"), now)
+	if messages := errors(); len(messages) != 2 {
+		t.Errorf("repeated errors should be logged once per namespace, got %q", messages)
+	}
+
+	// the numbers of an error don't make it another error
+	logNamespaceError("team-a", fmt.Errorf("Failed to list service accounts: timeout after 10s"), now.Add(30*time.Second))
+	logNamespaceError("team-a", fmt.Errorf("Failed to list service accounts: timeout after 12s"), now.Add(40*time.Second))
+	if messages := errors(); len(messages) != 1 {
+		t.Errorf("another error class should be logged at once, got %q", messages)
+	}
+
+	logNamespaceError("team-a", unmanaged, now.Add(time.Minute))
+	messages := errors()
+	if len(messages) != 1 || messages[0] != "Still failing (x3): "+unmanaged.Error() {
+		t.Errorf("expected a rollup of the repeats, got %q", messages)
+	}
+
+	logNamespaceRecovered("team-a")
+	logNamespaceError("team-a", unmanaged, now.Add(70*time.Second))
+	if messages := errors(); len(messages) != 1 || messages[0] != unmanaged.Error() {
+		t.Errorf("an error after recovering should be logged at once, got %q", messages)
+	}
+
+	pruneNamespaceErrorLogs(now.Add(4 * time.Minute))
+	if len(namespaceErrorLogs) != 0 {
+		t.Errorf("errors not seen for long should be forgotten, got %v", namespaceErrorLogs)
+	}
+}
@@ -4,375 +4,1403 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
 var (
 	// Config
-	configForce                bool          = true
-	configDebug                bool          = false
-	configManagedOnly          bool          = false
-	configRunOnce              bool          = false
-	configAllServiceAccount    bool          = true
-	configDockerconfigjson     string        = ""
-	configDockerConfigJSONPath string        = ""
-	configSecretName           string        = "registry" // default to image-pull-secret
-	configExcludedNamespaces   string        = ""
-	configServiceAccounts      string        = defaultServiceAccountName
-	configLoopDuration         time.Duration = 10 * time.Second
+	configForce                  bool          = true
+	configDebug                  bool          = false
+	configPrintVersion           bool          = false
+	configLogFormat              string        = "text"
+	configLogDedupInterval       time.Duration = 0
+	configManagedOnly            bool          = false
+	configRunOnce                bool          = false
+	configAllServiceAccount      bool          = true
+	configDockerconfigjson       string        = ""
+	configDockerConfigJSONPath   string        = ""
+	configCredentialSources      string        = ""
+	configSecretName             string        = "registry" // default to image-pull-secret
+	configSecretSources          string        = ""
+	configExcludedNamespaces     string        = ""
+	configPriorityNamespaces     string        = ""
+	configDigestShortCircuit     bool          = false
+	configSecretSyncInterval     time.Duration = 0
+	configSAPatchInterval        time.Duration = 0
+	configExcludedNamespacesFile string        = ""
+	configIncludedNamespaces     string        = ""
+	configIncludedNSSelector     string        = ""
+	configSASelector             string        = ""
+	configExcludedNSSelector     string        = ""
+	configSelectionConfigMap     string        = ""
+	configServiceAccounts        string        = defaultServiceAccountName
+	configLoopDuration           time.Duration = 10 * time.Second
+	configLoopTimeout            time.Duration = 0
+	configLoopJitter             float64       = 0
+	configMaxFailedLoops         int           = 10
+	configRequestTimeout         time.Duration = 30 * time.Second
+	configMetricsAddr            string        = ":8080"
+	configPprofAddr              string        = ""
+	configCredentialRotationSLA  time.Duration = 0
+	configSecretMode             string        = secretModeSecret
+	configPaused                 bool          = false
+	configBackOffForeignManagers bool          = false
+	configSAPatchMinInterval     time.Duration = 0
+	configDefaultSAWaitTimeout   time.Duration = 10 * time.Second
+	configMutationRecords        bool          = false
+	configEvents                 bool          = false
+	configMutationRecordTTL      time.Duration = 7 * 24 * time.Hour
+	configAuditLog               string        = ""
+	configRegistryHealthInterval time.Duration = 0
+	configReverifyAge            time.Duration = 0
+	// Annotation configs
+	configAnnotationPrefix string = defaultAnnotationPrefix
+	// System namespaces configs
+	configIncludeSystemNamespaces bool = false
+	// Feature gates
+	configEnableSecretSync    bool = true
+	configEnableSAPatch       bool = true
+	configPatchSASecrets      bool = false
+	configPruneStaleRefs      bool = false
+	configMergeExisting       bool = false
+	configServerSideApply     bool = false
+	configImmutableSecrets    bool = false
+	configDetectOnly          bool = false
+	configAdoptExisting       bool = false
+	configEnableConfigMapSync bool = true
+	// Retry configs
+	configNamespaceMaxRetries          int           = 0
+	configNamespaceRetryInitialBackoff time.Duration = time.Second
+	configNamespaceRetryMaxBackoff     time.Duration = 30 * time.Second
+	configNamespaceRetryMultiplier     float64       = 2
+	configConcurrency                  int           = 1
+	configNamespaceTimeout             time.Duration = 0
+	configNamespaceFailureMaxBackoff   time.Duration = 0
+	// Vault configs
+	configVaultAddr      string = os.Getenv("VAULT_ADDR")
+	configVaultTokenFile string = ""
+	// Credential validation configs
+	configValidateCredential bool = true
+	configVerifyRegistryAuth bool = false
+	configCredentialTemplate bool = false
+	// Credential helper configs
+	configCredentialHelpers bool = false
+	// Credential input configs
+	configDockerconfigjsonB64   string = ""
+	configDockerconfigjsonStdin bool   = false
+	// Secret metadata configs
+	configSecretLabels      stringListFlag
+	configSecretAnnotations stringListFlag
+	// Secret garbage collection configs
+	configPreviousSecretNames stringListFlag
+	// Registry flags configs
+	configRegistryURLs      stringListFlag
+	configRegistryUsernames stringListFlag
+	configRegistryPasswords stringListFlag
+	// Credential URL configs
+	configDockerConfigJSONURL           string = ""
+	configDockerConfigJSONURLTokenFile  string = ""
+	configDockerConfigJSONURLClientCert string = ""
+	configDockerConfigJSONURLClientKey  string = ""
+	// Last known good configs
+	configKeepLastKnownGood   bool   = false
+	configLastKnownGoodSecret string = ""
+	// Credential mapping configs
+	configCredentialMappingFile        string = ""
+	configSourceSecretAnnotationAllows string = ""
+	// Source secret configs
+	configSourceSecretNamespace string = ""
+	configSourceSecretName      string = ""
+	// Provider configs
+	configRefreshBefore       time.Duration = 0
+	configProvider            string        = ""
+	configECRRegion           string        = ""
+	configECRRegistryIDs      string        = ""
+	configECRRegistries       string        = ""
+	configECRRefreshBefore    time.Duration = time.Hour
+	configGCRRegistries       string        = "gcr.io"
+	configGCRKeyFile          string        = ""
+	configGCRRefreshBefore    time.Duration = 10 * time.Minute
+	configACRRegistries       string        = ""
+	configACRTenantID         string        = ""
+	configACRClientID         string        = ""
+	configACRClientSecretFile string        = ""
+	configACRRefreshBefore    time.Duration = 30 * time.Minute
+	// GHCR and GitLab provider configs
+	configGHCRAPIURL          string        = "https://api.github.com"
+	configGHCRRegistry        string        = "ghcr.io"
+	configGHCRAppID           string        = ""
+	configGHCRInstallationID  string        = ""
+	configGHCRPrivateKeyFile  string        = ""
+	configGHCRRefreshBefore   time.Duration = 10 * time.Minute
+	configGitLabURL           string        = "https://gitlab.com"
+	configGitLabRegistry      string        = "registry.gitlab.com"
+	configGitLabProject       string        = ""
+	configGitLabGroup         string        = ""
+	configGitLabTokenFile     string        = ""
+	configGitLabTokenLifetime time.Duration = 24 * time.Hour
+	configGitLabRefreshBefore time.Duration = time.Hour
+	// Outbound HTTP configs
+	configHTTPProxy  string = ""
+	configHTTPSProxy string = ""
+	configNoProxy    string = ""
+	configCABundle   string = ""
+	// Kubernetes client configs
+	configKubeconfig           string        = ""
+	configKubeContext          string        = ""
+	configAPIServerWaitTimeout time.Duration = 2 * time.Minute
+	// Plan configs
+	configStateDump string = ""
+	configPlanFile  string = ""
+	// Selftest configs
+	configSelftestCanaryImage string        = ""
+	configSelftestTimeout     time.Duration = 2 * time.Minute
+	// Admin API configs
+	configAdminAddr  string = ""
+	configAdminToken string = ""
+	configConfigMap  string = ""
+	// Checkpoint configs
+	configCheckpointConfigMap string = ""
+	// Status configs
+	configStatusConfigMap string = ""
+	// Tracing configs
+	configTracing bool = false
+	// Error reporting configs
+	configSentryDSN            string = ""
+	configSentryErrorThreshold int    = 3
+	// ExternalSecret configs
+	configExternalSecretStoreName       string = ""
+	configExternalSecretStoreKind       string = "ClusterSecretStore"
+	configExternalSecretRemoteKey       string = ""
+	configExternalSecretRemoteProperty  string = ""
+	configExternalSecretRefreshInterval string = "1h"
+	// SecretProviderClass configs
+	configSecretProviderClassProvider   string = ""
+	configSecretProviderClassParameters string = ""
+	configSecretProviderClassObjectName string = ""
+	configSecretProviderClassSyncSecret bool   = true
+	// Export configs
+	configExportDir     string = ""
+	configExportGit     bool   = false
+	configExportGitPush bool   = false
+	// SealedSecret configs
+	configSealedSecretCertPath            string = ""
+	configSealedSecretControllerNamespace string = "kube-system"
+	configSealedSecretControllerName      string = "sealed-secrets-controller"
+	configSealedSecretHashKeyPath         string = ""
 	// AWS ConfigMap configs
-	configAWSConfigMapName      string = "aws-configs"
-	configAWSConfigFilePath     string = "/config/aws-configs"
+	configAWSConfigMapName        string = "aws-configs"
+	configAWSConfigFilePath       string = "/config/aws-configs"
+	configWatchConfigMapDeletions bool   = true
+	configInformers               bool   = true
+	configWatchCredentialFile     bool   = true
+	configPruneConfigMaps         bool   = false
+	configCleanupExcluded         bool   = false
+	// ConfigMap sync configs
+	configSyncConfigMaps stringListFlag
 
 	dockerConfigJSON string
 )
 
-const (
-	annotationImagepullsecretPatcherExclude = "k8s.titansoft.com/imagepullsecret-patcher-exclude"
-)
-
 type k8sClient struct {
 	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	// restConfig is the config of clientset, from which the controller
+	// manager builds its clients
+	restConfig *rest.Config
 }
 
 func main() {
+	defer reportPanic()
 	// parse flags
-	flag.BoolVar(&configForce, "force", LookUpEnvOrBool("CONFIG_FORCE", configForce), "force to overwrite secrets when not match")
+	flag.BoolVar(&configForce, "force", LookUpEnvOrBool("CONFIG_FORCE", configForce), "force to overwrite managed secrets when not match")
 	flag.BoolVar(&configDebug, "debug", LookUpEnvOrBool("CONFIG_DEBUG", configDebug), "show DEBUG logs")
-	flag.BoolVar(&configManagedOnly, "managedonly", LookUpEnvOrBool("CONFIG_MANAGEDONLY", configManagedOnly), "only modify secrets which are annotated as managed by imagepullsecret")
+	flag.BoolVar(&configPrintVersion, "version", configPrintVersion, "print the version and exit")
+	flag.StringVar(&configLogFormat, "log-format", LookupEnvOrString("CONFIG_LOG_FORMAT", configLogFormat), "format of the logs, `text` or `json`")
+	flag.DurationVar(&configLogDedupInterval, "log-dedup-interval", LookupEnvOrDuration("CONFIG_LOG_DEDUP_INTERVAL", configLogDedupInterval), "log the same error of a namespace at most once per this duration, rolling up the repeats, 0 to disable")
+	flag.BoolVar(&configManagedOnly, "managedonly", LookUpEnvOrBool("CONFIG_MANAGEDONLY", configManagedOnly), "fail the namespace of an unmanaged secret which is not valid, rather than only warning about it")
 	flag.BoolVar(&configRunOnce, "runonce", LookUpEnvOrBool("CONFIG_RUNONCE", configRunOnce), "run a single update and exit instead of looping")
 	flag.BoolVar(&configAllServiceAccount, "allserviceaccount", LookUpEnvOrBool("CONFIG_ALLSERVICEACCOUNT", configAllServiceAccount), "if false, patch just default service account; if true, list and patch all service accounts")
 	flag.StringVar(&configDockerconfigjson, "dockerconfigjson", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON", configDockerconfigjson), "json credential for authenicating container registry, exclusive with `dockerconfigjsonpath`")
 	flag.StringVar(&configDockerConfigJSONPath, "dockerconfigjsonpath", LookupEnvOrString("CONFIG_DOCKERCONFIGJSONPATH", configDockerConfigJSONPath), "path to json file containing credentials for the registry to be distributed, exclusive with `dockerconfigjson`")
-	flag.StringVar(&configSecretName, "secretname", LookupEnvOrString("CONFIG_SECRETNAME", configSecretName), "set name of managed secrets")
-	flag.StringVar(&configExcludedNamespaces, "excluded-namespaces", LookupEnvOrString("CONFIG_EXCLUDED_NAMESPACES", configExcludedNamespaces), "comma-separated namespaces excluded from processing")
+	flag.StringVar(&configDockerconfigjsonB64, "dockerconfigjson-b64", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON_B64", configDockerconfigjsonB64), "base64 encoded json credential for authenticating container registry, exclusive with the other credential flags")
+	flag.BoolVar(&configDockerconfigjsonStdin, "dockerconfigjson-stdin", LookUpEnvOrBool("CONFIG_DOCKERCONFIGJSON_STDIN", configDockerconfigjsonStdin), "read the json credential from stdin once at start-up, exclusive with the other credential flags")
+	configSecretLabels = LookupEnvOrStringList("CONFIG_SECRET_LABELS")
+	configSecretAnnotations = LookupEnvOrStringList("CONFIG_SECRET_ANNOTATIONS")
+	flag.Var(&configSecretLabels, "secret-labels", "label `key=value` set on every managed secret, repeatable")
+	flag.Var(&configSecretAnnotations, "secret-annotations", "annotation `key=value` set on every managed secret, repeatable")
+	configPreviousSecretNames = LookupEnvOrStringList("CONFIG_PREVIOUS_SECRET_NAMES")
+	flag.Var(&configPreviousSecretNames, "previous-secret-names", "former `secretname` whose managed secrets are deleted and removed from the service accounts, repeatable")
+	configRegistryURLs = LookupEnvOrStringList("CONFIG_REGISTRY_URL")
+	configRegistryUsernames = LookupEnvOrStringList("CONFIG_REGISTRY_USERNAME")
+	configRegistryPasswords = LookupEnvOrStringList("CONFIG_REGISTRY_PASSWORD")
+	flag.Var(&configRegistryURLs, "registry-url", "URL of a registry to build the dockerconfigjson for, repeatable, exclusive with the other credential flags")
+	flag.Var(&configRegistryUsernames, "registry-username", "username of the registry of the same position, repeatable")
+	flag.Var(&configRegistryPasswords, "registry-password", "password of the registry of the same position, repeatable")
+	flag.BoolVar(&configCredentialTemplate, "credential-template", LookUpEnvOrBool("CONFIG_CREDENTIAL_TEMPLATE", configCredentialTemplate), "expand the credential as a Go template for every namespace, e.g. with {{ .Namespace }}")
+	flag.BoolVar(&configValidateCredential, "validate-credential", LookUpEnvOrBool("CONFIG_VALIDATE_CREDENTIAL", configValidateCredential), "refuse to distribute a credential which is not a valid dockerconfigjson, keeping the last valid one in the namespaces")
+	flag.BoolVar(&configVerifyRegistryAuth, "verify-registry-auth", LookUpEnvOrBool("CONFIG_VERIFY_REGISTRY_AUTH", configVerifyRegistryAuth), "log in to every registry of a new credential before distributing it, refusing it when a registry rejects it")
+	flag.BoolVar(&configCredentialHelpers, "credential-helpers", LookUpEnvOrBool("CONFIG_CREDENTIAL_HELPERS", configCredentialHelpers), "execute the docker credential helpers of `credHelpers` and `credsStore` of the credential, a docker CLI config.json, to resolve the credentials to distribute")
+	flag.StringVar(&configCredentialSources, "credential-sources", LookupEnvOrString("CONFIG_CREDENTIAL_SOURCES", configCredentialSources), "comma-separated credential sources tried in order until one loads: `file:<path>`, `env:<variable>` or `vault:<path>#<field>`, exclusive with `dockerconfigjson` and `dockerconfigjsonpath`")
+	flag.StringVar(&configVaultAddr, "vault-addr", LookupEnvOrString("CONFIG_VAULT_ADDR", configVaultAddr), "address of the Vault server of `vault:` credential sources, VAULT_ADDR when empty")
+	flag.StringVar(&configDockerConfigJSONURL, "dockerconfigjson-url", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON_URL", configDockerConfigJSONURL), "https:// URL of an internal credential service to fetch the dockerconfigjson from every loop, exclusive with the other credential flags")
+	flag.StringVar(&configDockerConfigJSONURLTokenFile, "dockerconfigjson-url-token-file", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON_URL_TOKEN_FILE", configDockerConfigJSONURLTokenFile), "file holding the bearer token sent to `dockerconfigjson-url`")
+	flag.StringVar(&configDockerConfigJSONURLClientCert, "dockerconfigjson-url-client-cert", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON_URL_CLIENT_CERT", configDockerConfigJSONURLClientCert), "PEM client certificate presented to `dockerconfigjson-url`")
+	flag.StringVar(&configDockerConfigJSONURLClientKey, "dockerconfigjson-url-client-key", LookupEnvOrString("CONFIG_DOCKERCONFIGJSON_URL_CLIENT_KEY", configDockerConfigJSONURLClientKey), "PEM private key of `dockerconfigjson-url-client-cert`")
+	flag.BoolVar(&configKeepLastKnownGood, "keep-last-known-good", LookUpEnvOrBool("CONFIG_KEEP_LAST_KNOWN_GOOD", configKeepLastKnownGood), "keep reconciling with the last credential which loaded while the source is unavailable or the credential is refused")
+	flag.StringVar(&configLastKnownGoodSecret, "last-known-good-secret", LookupEnvOrString("CONFIG_LAST_KNOWN_GOOD_SECRET", configLastKnownGoodSecret), "`namespace/name` of a secret keeping the last known good credential across restarts")
+	flag.StringVar(&configCredentialMappingFile, "credential-mapping-file", LookupEnvOrString("CONFIG_CREDENTIAL_MAPPING_FILE", configCredentialMappingFile), "YAML file of rules giving the namespaces matching their name patterns and label selector the credential of their own sources")
+	flag.StringVar(&configSourceSecretAnnotationAllows, "source-secret-annotation-namespaces", LookupEnvOrString("CONFIG_SOURCE_SECRET_ANNOTATION_NAMESPACES", configSourceSecretAnnotationAllows), "comma-separated namespaces whose secrets namespaces may point at with the source secret annotation to receive another credential")
+	flag.StringVar(&configSourceSecretNamespace, "source-secret-namespace", LookupEnvOrString("CONFIG_SOURCE_SECRET_NAMESPACE", configSourceSecretNamespace), "namespace of the secret to mirror into every namespace")
+	flag.StringVar(&configSourceSecretName, "source-secret-name", LookupEnvOrString("CONFIG_SOURCE_SECRET_NAME", configSourceSecretName), "name of a dockerconfigjson secret to mirror into every namespace, exclusive with the other credential flags")
+	flag.DurationVar(&configRefreshBefore, "refresh-before", LookupEnvOrDuration("CONFIG_REFRESH_BEFORE", configRefreshBefore), "run a loop this long before the credential expires, as told by its provider or the `exp` claim of JWT passwords, overriding the refresh windows of the providers; 0 to only follow the loop duration")
+	flag.StringVar(&configProvider, "provider", LookupEnvOrString("CONFIG_PROVIDER", configProvider), "generate the credential in-process instead of loading it: `ecr`, `gcr`, `acr`, `ghcr` or `gitlab`, exclusive with the other credential flags")
+	flag.StringVar(&configECRRegion, "ecr-region", LookupEnvOrString("CONFIG_ECR_REGION", configECRRegion), "AWS region of the ECR registries, AWS_REGION when empty")
+	flag.StringVar(&configECRRegistryIDs, "ecr-registry-ids", LookupEnvOrString("CONFIG_ECR_REGISTRY_IDS", configECRRegistryIDs), "comma-separated AWS account IDs of the ECR registries, the account of the credentials when empty")
+	flag.StringVar(&configECRRegistries, "ecr-registries", LookupEnvOrString("CONFIG_ECR_REGISTRIES", configECRRegistries), "comma-separated ECR registries of any account and region, as `<registry>` or `<registry>=<role ARN>` to assume a role for it, exclusive with `ecr-registry-ids`")
+	flag.DurationVar(&configECRRefreshBefore, "ecr-refresh-before", LookupEnvOrDuration("CONFIG_ECR_REFRESH_BEFORE", configECRRefreshBefore), "refresh the ECR authorization token this long before it expires")
+	flag.StringVar(&configGCRRegistries, "gcr-registries", LookupEnvOrString("CONFIG_GCR_REGISTRIES", configGCRRegistries), "comma-separated GCR and Artifact Registry hosts to authenticate to with the GCP access token")
+	flag.StringVar(&configGCRKeyFile, "gcr-key-file", LookupEnvOrString("CONFIG_GCR_KEY_FILE", configGCRKeyFile), "GCP service account key to get access tokens with, the Workload Identity of the metadata server when empty")
+	flag.DurationVar(&configGCRRefreshBefore, "gcr-refresh-before", LookupEnvOrDuration("CONFIG_GCR_REFRESH_BEFORE", configGCRRefreshBefore), "refresh the GCP access token this long before it expires")
+	flag.StringVar(&configACRRegistries, "acr-registries", LookupEnvOrString("CONFIG_ACR_REGISTRIES", configACRRegistries), "comma-separated ACR login servers to get refresh tokens of")
+	flag.StringVar(&configACRTenantID, "acr-tenant-id", LookupEnvOrString("CONFIG_ACR_TENANT_ID", configACRTenantID), "Azure AD tenant of the service principal or managed identity")
+	flag.StringVar(&configACRClientID, "acr-client-id", LookupEnvOrString("CONFIG_ACR_CLIENT_ID", configACRClientID), "client ID of the service principal, or of the user-assigned managed identity")
+	flag.StringVar(&configACRClientSecretFile, "acr-client-secret-file", LookupEnvOrString("CONFIG_ACR_CLIENT_SECRET_FILE", configACRClientSecretFile), "file holding the client secret of the service principal, the managed identity is used when empty")
+	flag.DurationVar(&configACRRefreshBefore, "acr-refresh-before", LookupEnvOrDuration("CONFIG_ACR_REFRESH_BEFORE", configACRRefreshBefore), "refresh the ACR refresh tokens this long before they expire")
+	flag.StringVar(&configGHCRAPIURL, "ghcr-api-url", LookupEnvOrString("CONFIG_GHCR_API_URL", configGHCRAPIURL), "GitHub API URL, to be changed for GitHub Enterprise Server")
+	flag.StringVar(&configGHCRRegistry, "ghcr-registry", LookupEnvOrString("CONFIG_GHCR_REGISTRY", configGHCRRegistry), "container registry host to authenticate to with the installation token")
+	flag.StringVar(&configGHCRAppID, "ghcr-app-id", LookupEnvOrString("CONFIG_GHCR_APP_ID", configGHCRAppID), "ID of the GitHub App")
+	flag.StringVar(&configGHCRInstallationID, "ghcr-installation-id", LookupEnvOrString("CONFIG_GHCR_INSTALLATION_ID", configGHCRInstallationID), "ID of the installation of the GitHub App to get tokens of")
+	flag.StringVar(&configGHCRPrivateKeyFile, "ghcr-private-key-file", LookupEnvOrString("CONFIG_GHCR_PRIVATE_KEY_FILE", configGHCRPrivateKeyFile), "PEM private key of the GitHub App")
+	flag.DurationVar(&configGHCRRefreshBefore, "ghcr-refresh-before", LookupEnvOrDuration("CONFIG_GHCR_REFRESH_BEFORE", configGHCRRefreshBefore), "refresh the installation token this long before it expires")
+	flag.StringVar(&configGitLabURL, "gitlab-url", LookupEnvOrString("CONFIG_GITLAB_URL", configGitLabURL), "URL of the GitLab instance")
+	flag.StringVar(&configGitLabRegistry, "gitlab-registry", LookupEnvOrString("CONFIG_GITLAB_REGISTRY", configGitLabRegistry), "container registry host to authenticate to with the deploy token")
+	flag.StringVar(&configGitLabProject, "gitlab-project", LookupEnvOrString("CONFIG_GITLAB_PROJECT", configGitLabProject), "ID or path of the GitLab project to create deploy tokens of, exclusive with `gitlab-group`")
+	flag.StringVar(&configGitLabGroup, "gitlab-group", LookupEnvOrString("CONFIG_GITLAB_GROUP", configGitLabGroup), "ID or path of the GitLab group to create deploy tokens of, exclusive with `gitlab-project`")
+	flag.StringVar(&configGitLabTokenFile, "gitlab-token-file", LookupEnvOrString("CONFIG_GITLAB_TOKEN_FILE", configGitLabTokenFile), "file holding a GitLab access token allowed to create deploy tokens")
+	flag.DurationVar(&configGitLabTokenLifetime, "gitlab-token-lifetime", LookupEnvOrDuration("CONFIG_GITLAB_TOKEN_LIFETIME", configGitLabTokenLifetime), "lifetime of the created deploy tokens")
+	flag.DurationVar(&configGitLabRefreshBefore, "gitlab-refresh-before", LookupEnvOrDuration("CONFIG_GITLAB_REFRESH_BEFORE", configGitLabRefreshBefore), "create a new deploy token this long before the current one expires")
+	flag.StringVar(&configVaultTokenFile, "vault-token-file", LookupEnvOrString("CONFIG_VAULT_TOKEN_FILE", configVaultTokenFile), "file holding the Vault token of `vault:` credential sources, VAULT_TOKEN when empty")
+	flag.StringVar(&configSecretName, "secretname", LookupEnvOrString("CONFIG_SECRETNAME", configSecretName), "set name of managed secrets, comma-separated to manage several, the first holding the usual credential and the others the one of `secret-credential-sources`")
+	flag.StringVar(&configSecretSources, "secret-credential-sources", LookupEnvOrString("CONFIG_SECRET_CREDENTIAL_SOURCES", configSecretSources), "credential sources of the secrets following the first of `secretname`, as `name=source,source;name=source` in the syntax of `credential-sources`")
+	flag.DurationVar(&configSecretSyncInterval, "secret-sync-interval", LookupEnvOrDuration("CONFIG_SECRET_SYNC_INTERVAL", configSecretSyncInterval), "minimum time between two loops verifying the secrets and synced ConfigMaps, 0 for every loop")
+	flag.DurationVar(&configSAPatchInterval, "sa-patch-interval", LookupEnvOrDuration("CONFIG_SA_PATCH_INTERVAL", configSAPatchInterval), "minimum time between two loops patching the service accounts, 0 for every loop")
+	flag.BoolVar(&configDigestShortCircuit, "digest-short-circuit", LookUpEnvOrBool("CONFIG_DIGEST_SHORT_CIRCUIT", configDigestShortCircuit), "skip comparing the secret data and listing the service accounts of namespaces whose managed secret records the digest of the current credential")
+	flag.StringVar(&configPriorityNamespaces, "priority-namespaces", LookupEnvOrString("CONFIG_PRIORITY_NAMESPACES", configPriorityNamespaces), "comma-separated namespaces reconciled first, in this order, and verified in every loop")
+	flag.StringVar(&configExcludedNamespaces, "excluded-namespaces", LookupEnvOrString("CONFIG_EXCLUDED_NAMESPACES", configExcludedNamespaces), "comma-separated namespaces excluded from processing, as names, glob patterns such as `kube-*` or regular expressions starting with `^`")
+	flag.StringVar(&configIncludedNamespaces, "included-namespaces", LookupEnvOrString("CONFIG_INCLUDED_NAMESPACES", configIncludedNamespaces), "comma-separated namespaces, as names, glob patterns or regular expressions starting with `^`, to process exclusively, ignoring all others")
+	flag.StringVar(&configIncludedNSSelector, "included-namespace-selector", LookupEnvOrString("CONFIG_INCLUDED_NAMESPACE_SELECTOR", configIncludedNSSelector), "label selector of namespaces to process exclusively, along with those of `included-namespaces`")
+	flag.StringVar(&configAnnotationPrefix, "annotation-prefix", LookupEnvOrString("CONFIG_ANNOTATION_PREFIX", configAnnotationPrefix), "domain of the annotation keys of the patcher, those of the default domain are still read")
+	flag.BoolVar(&configIncludeSystemNamespaces, "include-system-namespaces", LookUpEnvOrBool("CONFIG_INCLUDE_SYSTEM_NAMESPACES", configIncludeSystemNamespaces), "process the system namespaces kube-system, kube-public and kube-node-lease too, which are excluded by default")
+	flag.StringVar(&configExcludedNSSelector, "exclude-namespaces-by-label", LookupEnvOrString("CONFIG_EXCLUDE_NAMESPACES_BY_LABEL", configExcludedNSSelector), "label selector of namespaces excluded from processing, e.g. `field.cattle.io/projectId=p-system`")
+	flag.StringVar(&configSelectionConfigMap, "selection-configmap", LookupEnvOrString("CONFIG_SELECTION_CONFIGMAP", configSelectionConfigMap), "namespace/name of a ConfigMap whose `excludedNamespaces` and `includedNamespaces` add to the namespace lists, reloaded when changed")
+	flag.StringVar(&configExcludedNamespacesFile, "excluded-namespaces-file", LookupEnvOrString("CONFIG_EXCLUDED_NAMESPACES_FILE", configExcludedNamespacesFile), "file listing one excluded namespace name, glob pattern or regular expression starting with `^` per line, reloaded when changed")
 	flag.StringVar(&configServiceAccounts, "serviceaccounts", LookupEnvOrString("CONFIG_SERVICEACCOUNTS", configServiceAccounts), "comma-separated list of serviceaccounts to patch")
+	flag.StringVar(&configSASelector, "serviceaccount-label-selector", LookupEnvOrString("CONFIG_SERVICEACCOUNT_LABEL_SELECTOR", configSASelector), "label selector of the service accounts to patch, narrowing down `allserviceaccount` and `serviceaccounts`")
 	flag.DurationVar(&configLoopDuration, "loop-duration", LookupEnvOrDuration("CONFIG_LOOP_DURATION", configLoopDuration), "String defining the loop duration")
-	
+	flag.Float64Var(&configLoopJitter, "loop-jitter", LookupEnvOrFloat64("CONFIG_LOOP_JITTER", configLoopJitter), "percentage of the loop duration each wait between loops is randomly lengthened by, and the first loop delayed by at most")
+	flag.IntVar(&configMaxFailedLoops, "max-failed-loops", LookupEnvOrInt("CONFIG_MAX_FAILED_LOOPS", configMaxFailedLoops), "exit after this many loops failed in a row, 0 to never exit")
+	flag.DurationVar(&configLoopTimeout, "loop-timeout", LookupEnvOrDuration("CONFIG_LOOP_TIMEOUT", configLoopTimeout), "stop starting namespaces once a loop ran for this long, leaving the rest to the next loop, 0 to disable")
+	flag.DurationVar(&configRequestTimeout, "request-timeout", LookupEnvOrDuration("CONFIG_REQUEST_TIMEOUT", configRequestTimeout), "timeout of a single Kubernetes API request, watches excepted, 0 to disable")
+	flag.StringVar(&configKubeconfig, "kubeconfig", LookupEnvOrString("CONFIG_KUBECONFIG", configKubeconfig), "path to a kubeconfig for running out of cluster, the in-cluster config is used when empty")
+	flag.StringVar(&configKubeContext, "kube-context", LookupEnvOrString("CONFIG_KUBE_CONTEXT", configKubeContext), "context of the kubeconfig to use, the current context when empty")
+	flag.DurationVar(&configAPIServerWaitTimeout, "api-server-wait-timeout", LookupEnvOrDuration("CONFIG_API_SERVER_WAIT_TIMEOUT", configAPIServerWaitTimeout), "how long to wait on startup for the API server to become available, 0 to check once")
+	flag.StringVar(&configHTTPProxy, "http-proxy", LookupEnvOrString("CONFIG_HTTP_PROXY", configHTTPProxy), "proxy for outbound HTTP requests to registries and cloud endpoints, HTTP_PROXY when empty")
+	flag.StringVar(&configHTTPSProxy, "https-proxy", LookupEnvOrString("CONFIG_HTTPS_PROXY", configHTTPSProxy), "proxy for outbound HTTPS requests to registries and cloud endpoints, HTTPS_PROXY when empty")
+	flag.StringVar(&configNoProxy, "no-proxy", LookupEnvOrString("CONFIG_NO_PROXY", configNoProxy), "comma-separated hosts, domains and CIDRs reached without proxy, NO_PROXY when empty")
+	flag.StringVar(&configCABundle, "ca-bundle", LookupEnvOrString("CONFIG_CA_BUNDLE", configCABundle), "PEM file of CA certificates trusted for registries and cloud endpoints in addition to the system roots")
+	flag.StringVar(&configMetricsAddr, "metrics-addr", LookupEnvOrString("CONFIG_METRICS_ADDR", configMetricsAddr), "address to serve prometheus metrics on, empty to disable")
+	flag.StringVar(&configPprofAddr, "pprof-addr", LookupEnvOrString("CONFIG_PPROF_ADDR", configPprofAddr), "address to serve the net/http/pprof profiles and expvar runtime variables on, behind the admin token, empty to disable")
+	flag.DurationVar(&configCredentialRotationSLA, "credential-rotation-sla", LookupEnvOrDuration("CONFIG_CREDENTIAL_ROTATION_SLA", configCredentialRotationSLA), "warn when the distributed credential is older than this duration, 0 to disable")
+
+	flag.BoolVar(&configBackOffForeignManagers, "backoff-foreign-managers", LookUpEnvOrBool("CONFIG_BACKOFF_FOREIGN_MANAGERS", configBackOffForeignManagers), "stop patching service accounts whose imagePullSecrets keep being rewritten by another field manager")
+	flag.DurationVar(&configSAPatchMinInterval, "sa-patch-min-interval", LookupEnvOrDuration("CONFIG_SA_PATCH_MIN_INTERVAL", configSAPatchMinInterval), "minimum duration between two patches of the same service account, 0 to disable")
+	flag.DurationVar(&configDefaultSAWaitTimeout, "default-sa-wait-timeout", LookupEnvOrDuration("CONFIG_DEFAULT_SA_WAIT_TIMEOUT", configDefaultSAWaitTimeout), "how long to wait for the default service account of a namespace created less than a minute ago, 0 to disable")
+	flag.BoolVar(&configEvents, "events", LookUpEnvOrBool("CONFIG_EVENTS", configEvents), "emit an Event for every change, and on the Namespace when it fails to be reconciled")
+	flag.BoolVar(&configMutationRecords, "mutation-records", LookUpEnvOrBool("CONFIG_MUTATION_RECORDS", configMutationRecords), "record every change as a MutationRecord in the changed namespace")
+	flag.DurationVar(&configMutationRecordTTL, "mutation-record-ttl", LookupEnvOrDuration("CONFIG_MUTATION_RECORD_TTL", configMutationRecordTTL), "how long MutationRecords are kept")
+	flag.StringVar(&configAuditLog, "audit-log", LookupEnvOrString("CONFIG_AUDIT_LOG", configAuditLog), "file to append a JSON record of every change to, `-` for stdout, empty to disable")
+	flag.DurationVar(&configRegistryHealthInterval, "registry-health-interval", LookupEnvOrDuration("CONFIG_REGISTRY_HEALTH_INTERVAL", configRegistryHealthInterval), "how often the registries of the credential are checked for authentication, independently from the loop, 0 to disable")
+	flag.BoolVar(&configEnableSecretSync, "enable-secret-sync", LookUpEnvOrBool("CONFIG_ENABLE_SECRET_SYNC", configEnableSecretSync), "create and update the managed secret in every namespace")
+	flag.BoolVar(&configEnableSAPatch, "enable-sa-patch", LookUpEnvOrBool("CONFIG_ENABLE_SA_PATCH", configEnableSAPatch), "patch the managed secret into the imagePullSecrets of service accounts")
+	flag.BoolVar(&configPruneStaleRefs, "prune-stale-references", LookUpEnvOrBool("CONFIG_PRUNE_STALE_REFERENCES", configPruneStaleRefs), "remove duplicate imagePullSecrets of service accounts, and the ones of secrets the patcher managed under a previous `secretname`")
+	flag.BoolVar(&configAdoptExisting, "adopt-existing", LookUpEnvOrBool("CONFIG_ADOPT_EXISTING", configAdoptExisting), "mark existing unmanaged secrets and synced ConfigMaps of the managed names as managed, instead of leaving them alone")
+	flag.BoolVar(&configDetectOnly, "detect-only", LookUpEnvOrBool("CONFIG_DETECT_ONLY", configDetectOnly), "report managed secrets that are missing or differ from the credential in the logs and metrics instead of changing them")
+	flag.BoolVar(&configImmutableSecrets, "immutable-secrets", LookUpEnvOrBool("CONFIG_IMMUTABLE_SECRETS", configImmutableSecrets), "mark managed secrets immutable, so the kubelets don't watch them, recreating them when the credential changes")
+	flag.BoolVar(&configServerSideApply, "server-side-apply", LookUpEnvOrBool("CONFIG_SERVER_SIDE_APPLY", configServerSideApply), "write secrets, synced ConfigMaps and the imagePullSecrets of service accounts with server-side apply, taking over fields owned by other field managers only with `force`")
+	flag.BoolVar(&configMergeExisting, "merge-existing", LookUpEnvOrBool("CONFIG_MERGE_EXISTING", configMergeExisting), "merge the auths entries of the credential into existing secrets, keeping their other registries, instead of replacing them")
+	flag.BoolVar(&configPatchSASecrets, "patch-sa-secrets", LookUpEnvOrBool("CONFIG_PATCH_SA_SECRETS", configPatchSASecrets), "also patch the managed secret into the secrets of service accounts, which OpenShift builds pull with")
+	flag.BoolVar(&configEnableConfigMapSync, "enable-configmap-sync", LookUpEnvOrBool("CONFIG_ENABLE_CONFIGMAP_SYNC", configEnableConfigMapSync), "create and update the synced ConfigMaps in every namespace")
+	flag.DurationVar(&configReverifyAge, "reverify-age", LookupEnvOrDuration("CONFIG_REVERIFY_AGE", configReverifyAge), "skip namespaces verified in sync less than this duration ago, unless the credential, a synced ConfigMap file or the namespace changed, 0 to verify every loop")
+	flag.StringVar(&configSecretMode, "secret-mode", LookupEnvOrString("CONFIG_SECRET_MODE", configSecretMode), "how the managed secret is distributed: `secret` writes it directly, `externalsecret` creates an ExternalSecret per namespace, `sealedsecret` creates a SealedSecret per namespace, `secretproviderclass` creates a SecretProviderClass per namespace")
+
+	// Retry flags
+	flag.IntVar(&configConcurrency, "concurrency", LookupEnvOrInt("CONFIG_CONCURRENCY", configConcurrency), "how many namespaces are reconciled in parallel")
+	flag.DurationVar(&configNamespaceTimeout, "namespace-timeout", LookupEnvOrDuration("CONFIG_NAMESPACE_TIMEOUT", configNamespaceTimeout), "budget of a namespace within a loop, no retry of a failed namespace starts past it, 0 to disable")
+	flag.DurationVar(&configNamespaceFailureMaxBackoff, "namespace-failure-max-backoff", LookupEnvOrDuration("CONFIG_NAMESPACE_FAILURE_MAX_BACKOFF", configNamespaceFailureMaxBackoff), "leave a failing namespace out of the loops for a loop duration, doubling with every failure in a row up to this backoff, 0 to disable")
+	flag.IntVar(&configNamespaceMaxRetries, "namespace-max-retries", LookupEnvOrInt("CONFIG_NAMESPACE_MAX_RETRIES", configNamespaceMaxRetries), "how often a failed namespace is retried within a loop, 0 to wait for the next loop")
+	flag.DurationVar(&configNamespaceRetryInitialBackoff, "namespace-retry-initial-backoff", LookupEnvOrDuration("CONFIG_NAMESPACE_RETRY_INITIAL_BACKOFF", configNamespaceRetryInitialBackoff), "wait before the first retry of a failed namespace")
+	flag.DurationVar(&configNamespaceRetryMaxBackoff, "namespace-retry-max-backoff", LookupEnvOrDuration("CONFIG_NAMESPACE_RETRY_MAX_BACKOFF", configNamespaceRetryMaxBackoff), "maximum wait between two retries of a failed namespace")
+	flag.Float64Var(&configNamespaceRetryMultiplier, "namespace-retry-multiplier", LookupEnvOrFloat64("CONFIG_NAMESPACE_RETRY_MULTIPLIER", configNamespaceRetryMultiplier), "factor the wait grows by with every retry of a failed namespace")
+
+	// ExternalSecret flags
+	flag.StringVar(&configExternalSecretStoreName, "externalsecret-store-name", LookupEnvOrString("CONFIG_EXTERNALSECRET_STORE_NAME", configExternalSecretStoreName), "name of the SecretStore referenced by generated ExternalSecrets")
+	flag.StringVar(&configExternalSecretStoreKind, "externalsecret-store-kind", LookupEnvOrString("CONFIG_EXTERNALSECRET_STORE_KIND", configExternalSecretStoreKind), "kind of the store referenced by generated ExternalSecrets, SecretStore or ClusterSecretStore")
+	flag.StringVar(&configExternalSecretRemoteKey, "externalsecret-remote-key", LookupEnvOrString("CONFIG_EXTERNALSECRET_REMOTE_KEY", configExternalSecretRemoteKey), "key of the dockerconfigjson in the external store")
+	flag.StringVar(&configExternalSecretRemoteProperty, "externalsecret-remote-property", LookupEnvOrString("CONFIG_EXTERNALSECRET_REMOTE_PROPERTY", configExternalSecretRemoteProperty), "optional property of the remote key holding the dockerconfigjson")
+	flag.StringVar(&configExternalSecretRefreshInterval, "externalsecret-refresh-interval", LookupEnvOrString("CONFIG_EXTERNALSECRET_REFRESH_INTERVAL", configExternalSecretRefreshInterval), "refresh interval of generated ExternalSecrets")
+
+	// SecretProviderClass flags
+	flag.StringVar(&configSecretProviderClassProvider, "secretproviderclass-provider", LookupEnvOrString("CONFIG_SECRETPROVIDERCLASS_PROVIDER", configSecretProviderClassProvider), "provider of generated SecretProviderClasses, e.g. aws, azure, gcp or vault")
+	flag.StringVar(&configSecretProviderClassParameters, "secretproviderclass-parameters", LookupEnvOrString("CONFIG_SECRETPROVIDERCLASS_PARAMETERS", configSecretProviderClassParameters), "JSON object of the provider specific parameters of generated SecretProviderClasses")
+	flag.StringVar(&configSecretProviderClassObjectName, "secretproviderclass-object-name", LookupEnvOrString("CONFIG_SECRETPROVIDERCLASS_OBJECT_NAME", configSecretProviderClassObjectName), "name of the mounted object holding the dockerconfigjson, synced to the managed secret")
+	flag.BoolVar(&configSecretProviderClassSyncSecret, "secretproviderclass-sync-secret", LookUpEnvOrBool("CONFIG_SECRETPROVIDERCLASS_SYNC_SECRET", configSecretProviderClassSyncSecret), "make the CSI driver sync the mounted dockerconfigjson to the managed secret")
+
+	// Admin API flags
+	flag.StringVar(&configAdminAddr, "admin-addr", LookupEnvOrString("CONFIG_ADMIN_ADDR", configAdminAddr), "address to serve the admin API on, empty to disable")
+	flag.StringVar(&configAdminToken, "admin-token", LookupEnvOrString("CONFIG_ADMIN_TOKEN", configAdminToken), "bearer token required by the admin API")
+	flag.StringVar(&configConfigMap, "config-configmap", LookupEnvOrString("CONFIG_CONFIGMAP", configConfigMap), "namespace/name of the ConfigMap persisting settings changed through the admin API")
+
+	// Checkpoint flags
+	flag.StringVar(&configCheckpointConfigMap, "checkpoint-configmap", LookupEnvOrString("CONFIG_CHECKPOINT_CONFIGMAP", configCheckpointConfigMap), "namespace/name of the ConfigMap checkpointing the progress of loops, so a restarted loop resumes where it stopped")
+
+	// Status flags
+	flag.StringVar(&configStatusConfigMap, "status-configmap", LookupEnvOrString("CONFIG_STATUS_CONFIGMAP", configStatusConfigMap), "namespace/name of the ConfigMap recording the last successful sync and last error of every namespace")
+
+	// Tracing flags
+	flag.BoolVar(&configTracing, "tracing", LookUpEnvOrBool("CONFIG_TRACING", configTracing), "export OpenTelemetry spans of the loops, namespaces and API calls over OTLP/HTTP, to the endpoint set by the OTEL_EXPORTER_OTLP_* environment variables")
+
+	// Error reporting flags
+	flag.StringVar(&configSentryDSN, "sentry-dsn", LookupEnvOrString("CONFIG_SENTRY_DSN", configSentryDSN), "DSN of the Sentry project to report panics and repeated reconcile errors to, empty to disable")
+	flag.IntVar(&configSentryErrorThreshold, "sentry-error-threshold", LookupEnvOrInt("CONFIG_SENTRY_ERROR_THRESHOLD", configSentryErrorThreshold), "failures in a row of a namespace after which its error is reported to Sentry")
+
+	// Export flags
+	flag.StringVar(&configExportDir, "export-dir", LookupEnvOrString("CONFIG_EXPORT_DIR", configExportDir), "write the desired manifests to this directory instead of applying them to the cluster")
+	flag.BoolVar(&configExportGit, "export-git", LookUpEnvOrBool("CONFIG_EXPORT_GIT", configExportGit), "commit changes of the export directory to its git repository")
+	flag.BoolVar(&configExportGitPush, "export-git-push", LookUpEnvOrBool("CONFIG_EXPORT_GIT_PUSH", configExportGitPush), "push the export commits to the upstream of the git repository")
+
+	// SealedSecret flags
+	flag.StringVar(&configSealedSecretCertPath, "sealedsecret-cert", LookupEnvOrString("CONFIG_SEALEDSECRET_CERT", configSealedSecretCertPath), "path to the sealed-secrets controller certificate, fetched from the controller when empty")
+	flag.StringVar(&configSealedSecretControllerNamespace, "sealedsecret-controller-namespace", LookupEnvOrString("CONFIG_SEALEDSECRET_CONTROLLER_NAMESPACE", configSealedSecretControllerNamespace), "namespace of the sealed-secrets controller")
+	flag.StringVar(&configSealedSecretControllerName, "sealedsecret-controller-name", LookupEnvOrString("CONFIG_SEALEDSECRET_CONTROLLER_NAME", configSealedSecretControllerName), "service name of the sealed-secrets controller")
+	flag.StringVar(&configSealedSecretHashKeyPath, "sealedsecret-hash-key", LookupEnvOrString("CONFIG_SEALEDSECRET_HASH_KEY", configSealedSecretHashKeyPath), "path to the key of the credential HMAC recorded on SealedSecrets, generated on start-up when empty")
+
+	// Plan flags
+	flag.StringVar(&configStateDump, "state-dump", LookupEnvOrString("CONFIG_STATE_DUMP", configStateDump), "comma-separated YAML or JSON dumps of the cluster state `plan` reads instead of connecting to a cluster")
+
+	flag.StringVar(&configPlanFile, "plan-file", LookupEnvOrString("CONFIG_PLAN_FILE", configPlanFile), "file `plan` writes the changeset to, and `apply` executes it from")
+
+	// Selftest flags
+	flag.StringVar(&configSelftestCanaryImage, "selftest-canary-image", LookupEnvOrString("CONFIG_SELFTEST_CANARY_IMAGE", configSelftestCanaryImage), "image pulled by a canary pod during `selftest`, empty to skip the pull")
+	flag.DurationVar(&configSelftestTimeout, "selftest-timeout", LookupEnvOrDuration("CONFIG_SELFTEST_TIMEOUT", configSelftestTimeout), "how long `selftest` waits for the default service account and the canary pod")
+
 	// AWS ConfigMap flags
 	flag.StringVar(&configAWSConfigMapName, "aws-configmap-name", LookupEnvOrString("CONFIG_AWS_CONFIGMAP_NAME", configAWSConfigMapName), "name of the AWS ConfigMap to be created")
-	flag.StringVar(&configAWSConfigFilePath, "aws-config-file", LookupEnvOrString("CONFIG_AWS_CONFIG_FILE", configAWSConfigFilePath), "path to AWS config file to be included in the ConfigMap")
-	
+	flag.StringVar(&configAWSConfigFilePath, "aws-config-file", LookupEnvOrString("CONFIG_AWS_CONFIG_FILE", configAWSConfigFilePath), "path to AWS config file to be included in the ConfigMap, empty to only sync the ConfigMaps of `sync-configmap`")
+	configSyncConfigMaps = LookupEnvOrStringListSeparated("CONFIG_SYNC_CONFIGMAP", ";")
+	flag.Var(&configSyncConfigMaps, "sync-configmap", "file distributed to every namespace as a managed ConfigMap, as `name=foo,path=/config/foo,format=env|raw|json`, repeatable")
+	flag.BoolVar(&configCleanupExcluded, "cleanup-excluded-namespaces", LookUpEnvOrBool("CONFIG_CLEANUP_EXCLUDED_NAMESPACES", configCleanupExcluded), "delete the managed secret of excluded namespaces and remove it from their service accounts")
+	flag.BoolVar(&configPruneConfigMaps, "prune-configmaps", LookUpEnvOrBool("CONFIG_PRUNE_CONFIGMAPS", configPruneConfigMaps), "delete the managed synced ConfigMaps when the ConfigMap sync is disabled, the namespace opted out or their file is gone")
+	flag.BoolVar(&configInformers, "informers", LookUpEnvOrBool("CONFIG_INFORMERS", configInformers), "re-sync namespaces as soon as they are created, or their managed secret or service accounts are deleted or modified, instead of waiting for the next loop")
+	flag.BoolVar(&configWatchCredentialFile, "watch-credential-file", LookUpEnvOrBool("CONFIG_WATCH_CREDENTIAL_FILE", configWatchCredentialFile), "watch the credential file and run a loop as soon as it changes, instead of waiting for the next loop")
+	flag.BoolVar(&configWatchConfigMapDeletions, "watch-configmap-deletions", LookUpEnvOrBool("CONFIG_WATCH_CONFIGMAP_DELETIONS", configWatchConfigMapDeletions), "watch deletions of the synced ConfigMaps and re-sync the namespace immediately")
+
 	flag.Parse()
+	if configPrintVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
 
 	// setup logrus
 	if configDebug {
 		log.SetLevel(log.DebugLevel)
 	}
-	log.Info("Application started")
+	switch configLogFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Panic(fmt.Errorf("Invalid `log-format` %q, must be `text` or `json`", configLogFormat))
+	}
+	registerCredential("dockerconfigjson", configDockerconfigjson)
+	registerSecrets("dockerconfigjson-b64", configDockerconfigjsonB64)
+	registerSecrets("registry-password", configRegistryPasswords.items...)
+	registerSecrets("admin-token", configAdminToken)
+	registerSecrets("sentry-dsn", configSentryDSN)
+	if configSentryDSN != "" {
+		if err := setupErrorReporting(configSentryDSN); err != nil {
+			log.Panic(fmt.Errorf("Invalid `sentry-dsn`: %v", err))
+		}
+	}
+	log.WithFields(log.Fields{"version": version, "commit": buildCommit(), "goversion": runtime.Version()}).Info("Application started")
+	log.WithFields(effectiveConfig(flag.CommandLine)).Info("Effective configuration")
+	if configAuditLog != "" {
+		if err := openAuditLog(configAuditLog); err != nil {
+			log.Panic(fmt.Errorf("Failed to open `audit-log`: %v", err))
+		}
+	}
 
 	// Validate input, as both of these being configured would have undefined behavior.
 	if configDockerconfigjson != "" && configDockerConfigJSONPath != "" {
 		log.Panic(fmt.Errorf("Cannot specify both `configdockerjson` and `configdockerjsonpath`"))
 	}
-
-	// create k8s clientset from in-cluster config
-	config, err := rest.InClusterConfig()
+	if configCredentialSources != "" {
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" {
+			log.Panic(fmt.Errorf("Cannot specify `credential-sources` along with `configdockerjson` or `configdockerjsonpath`"))
+		}
+		var err error
+		credentialSources, err = parseCredentialSources(configCredentialSources)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	switch configSecretMode {
+	case secretModeSecret:
+	case secretModeExternalSecret:
+		if configExternalSecretStoreName == "" || configExternalSecretRemoteKey == "" {
+			log.Panic(fmt.Errorf("`externalsecret-store-name` and `externalsecret-remote-key` are required with `secret-mode=%s`", secretModeExternalSecret))
+		}
+	case secretModeSealedSecret:
+		var err error
+		sealedSecretHashKey, err = loadSealedSecretHashKey(configSealedSecretHashKeyPath)
+		if err != nil {
+			log.Panic(err)
+		}
+		if configSealedSecretHashKeyPath == "" {
+			log.Warn("`sealedsecret-hash-key` is not set, SealedSecrets are re-sealed once after every restart")
+		}
+	case secretModeSecretProviderClass:
+		if configSecretProviderClassProvider == "" || (configSecretProviderClassSyncSecret && configSecretProviderClassObjectName == "") {
+			log.Panic(fmt.Errorf("`secretproviderclass-provider` and `secretproviderclass-object-name` are required with `secret-mode=%s`", secretModeSecretProviderClass))
+		}
+		var err error
+		secretProviderClassParameters, err = parseSecretProviderClassParameters(configSecretProviderClassParameters)
+		if err != nil {
+			log.Panic(err)
+		}
+	default:
+		log.Panic(fmt.Errorf("Unknown `secret-mode` %q", configSecretMode))
+	}
+	// a plain Secret would put the credential in the git history of every namespace
+	if (configExportGit || configExportGitPush) && configSecretMode == secretModeSecret {
+		log.Panic(fmt.Errorf("`export-git` and `export-git-push` require `secret-mode=%s`, `secret-mode=%s` or `secret-mode=%s`", secretModeSealedSecret, secretModeExternalSecret, secretModeSecretProviderClass))
+	}
+	if configExportGit || configExportGitPush {
+		if _, err := exec.LookPath("git"); err != nil {
+			log.Panic(fmt.Errorf("`export-git` and `export-git-push` require a `git` binary in the image: %v", err))
+		}
+	}
+	names := splitCommaList(configSecretName)
+	if len(names) == 0 {
+		log.Panic(fmt.Errorf("`secretname` is required"))
+	}
+	configSecretName = names[0]
+	if len(names) > 1 || configSecretSources != "" {
+		if configSecretMode != secretModeSecret {
+			log.Panic(fmt.Errorf("Several `secretname` require `secret-mode=%s`", secretModeSecret))
+		}
+		var err error
+		additionalSecrets, err = parseAdditionalSecrets(names[1:], configSecretSources)
+		if err != nil {
+			log.Panic(fmt.Errorf("Invalid `secret-credential-sources`: %v", err))
+		}
+	}
+	if m, err := parseSecretMetadata(configSecretLabels.items, true); err != nil {
+		log.Panic(fmt.Errorf("Invalid `secret-labels`: %v", err))
+	} else {
+		secretLabels = m
+	}
+	if m, err := parseSecretMetadata(configSecretAnnotations.items, false); err != nil {
+		log.Panic(fmt.Errorf("Invalid `secret-annotations`: %v", err))
+	} else {
+		secretAnnotations = m
+	}
+	for _, name := range configPreviousSecretNames.items {
+		if isManagedSecretName(name) {
+			log.Panic(fmt.Errorf("Invalid `previous-secret-names`: %s is still a managed secret", name))
+		}
+	}
+	if syncs, err := parseConfigMapSyncs(configSyncConfigMaps.items); err != nil {
+		log.Panic(fmt.Errorf("Invalid `sync-configmap`: %v", err))
+	} else {
+		configMapSyncs = syncs
+	}
+	if !configEnableSecretSync && !configEnableSAPatch && !configEnableConfigMapSync {
+		log.Panic(fmt.Errorf("At least one of `enable-secret-sync`, `enable-sa-patch` and `enable-configmap-sync` must be set"))
+	}
+	if errs := validation.IsDNS1123Subdomain(configAnnotationPrefix); len(errs) > 0 {
+		log.Panic(fmt.Errorf("Invalid `annotation-prefix`: %s", strings.Join(errs, ", ")))
+	}
+	setAnnotationPrefix(configAnnotationPrefix)
+	if err := validateNamespacePatterns(configExcludedNamespaces); err != nil {
+		log.Panic(fmt.Errorf("Invalid `excluded-namespaces`: %v", err))
+	}
+	if err := validateNamespacePatterns(configIncludedNamespaces); err != nil {
+		log.Panic(fmt.Errorf("Invalid `included-namespaces`: %v", err))
+	}
+	if configSASelector != "" {
+		selector, err := labels.Parse(configSASelector)
+		if err != nil {
+			log.Panic(fmt.Errorf("Invalid `serviceaccount-label-selector`: %v", err))
+		}
+		serviceAccountSelector = selector
+	}
+	if configExcludedNSSelector != "" {
+		selector, err := labels.Parse(configExcludedNSSelector)
+		if err != nil {
+			log.Panic(fmt.Errorf("Invalid `exclude-namespaces-by-label`: %v", err))
+		}
+		excludedNamespaceSelector = selector
+	}
+	if configIncludedNSSelector != "" {
+		selector, err := labels.Parse(configIncludedNSSelector)
+		if err != nil {
+			log.Panic(fmt.Errorf("Invalid `included-namespace-selector`: %v", err))
+		}
+		includedNamespaceSelector = selector
+	}
+	if configLoopJitter < 0 || configLoopJitter > 100 {
+		log.Panic(fmt.Errorf("`loop-jitter` must be a percentage between 0 and 100"))
+	}
+	if configConcurrency < 1 {
+		log.Panic(fmt.Errorf("`concurrency` must be at least 1"))
+	}
+	if err := namespaceRetryPolicy().validate(); err != nil {
+		log.Panic(fmt.Errorf("Invalid namespace retry policy: %v", err))
+	}
+	var err error
+	registryHTTPClient, err = newOutboundHTTPClient(registryHTTPTimeout)
 	if err != nil {
 		log.Panic(err)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	vaultHTTPClient, err = newOutboundHTTPClient(vaultHTTPTimeout)
 	if err != nil {
 		log.Panic(err)
 	}
-	k8s := &k8sClient{
-		clientset: clientset,
+	if configDockerconfigjsonB64 != "" || configDockerconfigjsonStdin {
+		if configDockerconfigjsonB64 != "" && configDockerconfigjsonStdin {
+			log.Panic(fmt.Errorf("Cannot specify both `dockerconfigjson-b64` and `dockerconfigjson-stdin`"))
+		}
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" || configCredentialSources != "" || len(configRegistryURLs.items) > 0 || configDockerConfigJSONURL != "" || configSourceSecretName != "" || configProvider != "" {
+			log.Panic(fmt.Errorf("Cannot specify `dockerconfigjson-b64` or `dockerconfigjson-stdin` along with the other credential flags"))
+		}
+		content, err := decodeDockerConfigJSONBase64(configDockerconfigjsonB64)
+		if configDockerconfigjsonStdin {
+			content, err = readDockerConfigJSON(os.Stdin)
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		registerCredential("dockerconfigjson", content)
+		configDockerconfigjson = content
+	}
+	if len(configRegistryURLs.items) > 0 {
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" || configCredentialSources != "" || configSourceSecretName != "" || configProvider != "" {
+			log.Panic(fmt.Errorf("Cannot specify `registry-url` along with `configdockerjson`, `configdockerjsonpath`, `credential-sources`, `source-secret-name` or `provider`"))
+		}
+		content, err := registryFlagsDockerConfigJSON(configRegistryURLs.items, configRegistryUsernames.items, configRegistryPasswords.items)
+		if err != nil {
+			log.Panic(err)
+		}
+		configDockerconfigjson = content
+	}
+	if configDockerConfigJSONURL != "" {
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" || configCredentialSources != "" || configSourceSecretName != "" || configProvider != "" {
+			log.Panic(fmt.Errorf("Cannot specify `dockerconfigjson-url` along with `configdockerjson`, `configdockerjsonpath`, `credential-sources`, `source-secret-name` or `provider`"))
+		}
+		httpClient, err := newOutboundHTTPClient(urlHTTPTimeout)
+		if err != nil {
+			log.Panic(err)
+		}
+		source, err := newURLCredentialSource(httpClient)
+		if err != nil {
+			log.Panic(err)
+		}
+		credentialSources = []credentialSource{source}
+	}
+	if configSourceSecretName != "" {
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" || configCredentialSources != "" || configProvider != "" {
+			log.Panic(fmt.Errorf("Cannot specify `source-secret-name` along with `configdockerjson`, `configdockerjsonpath`, `credential-sources` or `provider`"))
+		}
+		if configSourceSecretNamespace == "" {
+			log.Panic(fmt.Errorf("`source-secret-namespace` is required with `source-secret-name`"))
+		}
+		sourceSecret = &secretCredentialSource{namespace: configSourceSecretNamespace, name: configSourceSecretName}
+		credentialSources = []credentialSource{sourceSecret}
+	}
+
+	// ctx is cancelled on SIGTERM and SIGINT, which cancels the requests in
+	// flight, after which the loop saves its progress before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if configProvider != "" {
+		if configDockerconfigjson != "" || configDockerConfigJSONPath != "" || configCredentialSources != "" {
+			log.Panic(fmt.Errorf("Cannot specify `provider` along with `configdockerjson`, `configdockerjsonpath` or `credential-sources`"))
+		}
+		var source credentialSource
+		switch configProvider {
+		case providerECR:
+			httpClient, err := newOutboundHTTPClient(ecrHTTPTimeout)
+			if err != nil {
+				log.Panic(err)
+			}
+			source, err = newECRCredentialSource(ctx, httpClient)
+			if err != nil {
+				log.Panic(err)
+			}
+		case providerGCR:
+			httpClient, err := newOutboundHTTPClient(gcrHTTPTimeout)
+			if err != nil {
+				log.Panic(err)
+			}
+			source, err = newGCRCredentialSource(httpClient)
+			if err != nil {
+				log.Panic(err)
+			}
+		case providerACR:
+			httpClient, err := newOutboundHTTPClient(acrHTTPTimeout)
+			if err != nil {
+				log.Panic(err)
+			}
+			source, err = newACRCredentialSource(httpClient)
+			if err != nil {
+				log.Panic(err)
+			}
+		case providerGHCR:
+			httpClient, err := newOutboundHTTPClient(ghcrHTTPTimeout)
+			if err != nil {
+				log.Panic(err)
+			}
+			source, err = newGHCRCredentialSource(httpClient)
+			if err != nil {
+				log.Panic(err)
+			}
+		case providerGitLab:
+			httpClient, err := newOutboundHTTPClient(gitlabHTTPTimeout)
+			if err != nil {
+				log.Panic(err)
+			}
+			source, err = newGitLabCredentialSource(httpClient)
+			if err != nil {
+				log.Panic(err)
+			}
+		default:
+			log.Panic(fmt.Errorf("Unknown `provider` %q", configProvider))
+		}
+		credentialSources = []credentialSource{source}
+	}
+	if configLastKnownGoodSecret != "" {
+		if !configKeepLastKnownGood {
+			log.Panic(fmt.Errorf("`last-known-good-secret` requires `keep-last-known-good`"))
+		}
+		namespace, name, err := splitNamespacedName(configLastKnownGoodSecret)
+		if err != nil {
+			log.Panic(err)
+		}
+		if isManagedSecretName(name) || (sourceSecret != nil && sourceSecret.namespace == namespace && sourceSecret.name == name) {
+			log.Panic(fmt.Errorf("`last-known-good-secret` must neither be the managed secret nor the source secret"))
+		}
+	}
+	if (configCredentialMappingFile != "" || configSourceSecretAnnotationAllows != "") && configSecretMode != secretModeSecret && configSecretMode != secretModeSealedSecret {
+		log.Panic(fmt.Errorf("`credential-mapping-file` and `source-secret-annotation-namespaces` require `secret-mode=%s` or `secret-mode=%s`", secretModeSecret, secretModeSealedSecret))
+	}
+	if configCredentialTemplate && configSecretMode != secretModeSecret && configSecretMode != secretModeSealedSecret {
+		log.Panic(fmt.Errorf("`credential-template` requires `secret-mode=%s` or `secret-mode=%s`", secretModeSecret, secretModeSealedSecret))
+	}
+	if configCredentialTemplate && configVerifyRegistryAuth {
+		log.Panic(fmt.Errorf("`credential-template` and `verify-registry-auth` are exclusive"))
+	}
+	if configCredentialMappingFile != "" {
+		credentialMapping, err = loadCredentialMappingFile(configCredentialMappingFile)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if flag.Arg(0) == "plan" && configStateDump != "" {
+		if err := runPlan(ctx, nil, os.Stdout); err != nil {
+			log.Errorf("Plan failed: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// create k8s clientset from the kubeconfig or in-cluster config
+	k8s, err := newK8sClient()
+	if err != nil {
+		log.Panic(err)
+	}
+	if sourceSecret != nil {
+		sourceSecret.clientset = k8s.clientset
+	}
+	annotatedSecretClientset = k8s.clientset
+	if configSelectionConfigMap != "" {
+		if err := loadSelectionConfigMap(ctx, k8s); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "plan":
+		if err := runPlan(ctx, k8s, os.Stdout); err != nil {
+			log.Errorf("Plan failed: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "apply":
+		if err := runApply(ctx, k8s); err != nil {
+			log.Errorf("Apply failed: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "doctor":
+		if flag.Arg(1) == "" {
+			log.Error("Usage: doctor <namespace> [pod]")
+			os.Exit(2)
+		}
+		if err := runDoctor(ctx, k8s, os.Stdout, flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Errorf("Doctor found a problem: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "cleanup":
+		if err := runCleanup(ctx, k8s); err != nil {
+			log.Errorf("Cleanup failed: %v", err)
+			os.Exit(1)
+		}
+		log.Info("Cleanup done")
+		os.Exit(0)
+	case "selftest":
+		if err := runSelftest(ctx, k8s); err != nil {
+			log.Errorf("Selftest failed: %v", err)
+			os.Exit(1)
+		}
+		log.Info("Selftest passed")
+		os.Exit(0)
 	}
 
-	for {
-		log.Debug("Loop started")
-		loop(k8s)
+	if configMetricsAddr != "" {
+		serveMetrics(ctx, configMetricsAddr)
+	}
+	if configPprofAddr != "" {
+		if configAdminToken == "" {
+			log.Panic(fmt.Errorf("`admin-token` is required to serve pprof"))
+		}
+		servePprof(ctx, configPprofAddr)
+	}
+	if configTracing {
+		if err := setupTracing(context.Background()); err != nil {
+			log.Panic(fmt.Errorf("Failed to set up tracing: %v", err))
+		}
+	}
+	if configRegistryHealthInterval > 0 {
+		runRegistryHealthChecks(ctx, configRegistryHealthInterval)
+	}
+	if err := initRuntimeSettings(ctx, k8s); err != nil {
+		log.Panic(err)
+	}
+	if configAdminAddr != "" {
+		if configAdminToken == "" {
+			log.Panic(fmt.Errorf("`admin-token` is required to serve the admin API"))
+		}
+		serveAdmin(ctx, configAdminAddr, k8s)
+	}
+
+	if paths := credentialFilePaths(); configWatchCredentialFile && len(paths) > 0 && !configRunOnce {
+		if err := watchCredentialFiles(ctx, paths); err != nil {
+			log.Warnf("Failed to watch credential files, changes are picked up by the next loop: %v", err)
+		}
+	}
+	if sourceSecret != nil && configExportDir == "" && !configRunOnce {
+		watchSourceSecret(ctx, k8s)
+	}
+	if configSelectionConfigMap != "" && configExportDir == "" && !configRunOnce {
+		watchSelectionConfigMap(ctx, k8s)
+	}
+	controllersDone := make(chan struct{})
+	if controllersEnabled() && configExportDir == "" && !configRunOnce {
+		go func() {
+			defer close(controllersDone)
+			if err := runControllers(ctx, k8s); err != nil {
+				log.Errorf("Controllers stopped, changes are picked up by the next loop: %v", err)
+			}
+		}()
+	} else {
+		close(controllersDone)
+	}
+
+	if splay := loopSplay(configLoopDuration, configLoopJitter); splay > 0 && !configRunOnce {
+		log.Infof("Delaying the first loop by %s", splay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(splay):
+		}
+	}
+	triggerLoopOnSignal()
+	failedLoops := 0
+	for ctx.Err() == nil {
+		reconcileMu.Lock()
+		applyRuntimeSettings()
+		reconcileMu.Unlock()
+		if configPaused {
+			log.Info("Paused, skipping loop")
+		} else {
+			log.Debug("Loop started")
+			loopCtx, cancel := ctx, context.CancelFunc(func() {})
+			if configLoopTimeout > 0 {
+				loopCtx, cancel = context.WithTimeout(ctx, configLoopTimeout)
+			}
+			loopCtx, span := tracer.Start(loopCtx, "loop")
+			err := loop(loopCtx, k8s)
+			endSpan(span, err)
+			cancel()
+			if err != nil {
+				failedLoops++
+				log.Errorf("Loop failed, %d in a row: %v", failedLoops, err)
+			} else {
+				failedLoops = 0
+			}
+			metricConsecutiveFailedLoops.Set(float64(failedLoops))
+		}
 		if configRunOnce {
 			log.Info("Exiting after single loop per `CONFIG_RUNONCE`")
+			shutdownTracing()
+			flushErrorReports()
+			if failedLoops > 0 {
+				os.Exit(1)
+			}
 			os.Exit(0)
 		}
-		time.Sleep(configLoopDuration)
+		if configMaxFailedLoops > 0 && failedLoops >= configMaxFailedLoops {
+			log.Errorf("Exiting after %d failed loops in a row per `CONFIG_MAX_FAILED_LOOPS`", failedLoops)
+			shutdownTracing()
+			flushErrorReports()
+			os.Exit(1)
+		}
+		wait := jitteredLoopDuration(configLoopDuration, configLoopJitter)
+		if failedLoops > 0 {
+			wait = loopRetryPolicy().backoff(failedLoops)
+			log.Infof("Retrying loop in %s", wait)
+		}
+		if refresh, ok := refreshWait(credentialExpiresAt, time.Now()); ok && refresh < wait {
+			log.Infof("Credential expires at %s, refreshing it in %s", credentialExpiresAt.Format(time.RFC3339), refresh.Round(time.Second))
+			wait = refresh
+		} else if !ok && configRefreshBefore > 0 && !credentialExpiresAt.IsZero() {
+			log.Warnf("Credential expires at %s and was not renewed", credentialExpiresAt.Format(time.RFC3339))
+		}
+		select {
+		case <-ctx.Done():
+		case <-loopTrigger:
+			log.Info("Loop triggered")
+		case <-time.After(wait):
+		}
+	}
+	log.Info("Shutting down")
+	<-controllersDone
+	shutdownTracing()
+	flushErrorReports()
+	log.Info("Shut down")
+}
+
+// loopSplay returns a random delay of the first loop of up to jitter percent
+// of the loop duration d, so patchers restarted together don't loop together
+func loopSplay(d time.Duration, jitter float64) time.Duration {
+	return time.Duration(rand.Float64() * jitter / 100 * float64(d))
+}
+
+// jitteredLoopDuration randomly lengthens the loop duration d by up to jitter
+// percent
+func jitteredLoopDuration(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
 	}
+	return wait.Jitter(d, jitter/100)
 }
 
-func loop(k8s *k8sClient) {
+// loop reconciles every namespace once, stopping to start new namespaces and
+// cancelling the requests in flight once ctx is done, and returns the error
+// which kept it from reconciling any
+func loop(ctx context.Context, k8s *k8sClient) error {
 	var err error
+	sweepLog := startSweep()
+	if err := reloadExcludedNamespacesFile(); err != nil {
+		sweepLog.Error(err)
+	}
 
-	// Populate secret value to set
-	dockerConfigJSON, err = getDockerConfigJSON()
+	// Populate secret value to set, keeping the previous one for the watches
+	// while the source is unavailable
+	reconcileMu.Lock()
+	content, err := loadCredential(ctx)
+	if configKeepLastKnownGood {
+		content, err = keepLastKnownGood(ctx, k8s, content, err)
+	}
 	if err != nil {
-		log.Panic(err)
+		reconcileMu.Unlock()
+		return err
 	}
+	dockerConfigJSON = content
+	updateCredentialAge(configSecretName, dockerConfigJSON)
+	credentialExpiresAt = credentialExpiry(content)
+	updateCredentialExpiry(configSecretName, credentialExpiresAt)
+	loadMappedCredentials(ctx)
+	loadAdditionalSecrets(ctx)
+	if configEnableSecretSync && configSecretMode == secretModeSealedSecret {
+		key, err := getSealingKey(ctx, k8s)
+		if err != nil {
+			reconcileMu.Unlock()
+			return err
+		}
+		sealingKey = key
+	}
+	desired := desiredStateHash()
+	reconcileMu.Unlock()
+
+	now := time.Now()
+	secretSync, saPatch := loopPasses(now)
+	if !secretSync && !saPatch {
+		sweepLog.Debug("No pass due, skipping loop")
+		return nil
+	}
+	reconcilers := passReconcilers(secretSync, saPatch)
 
 	// get all namespaces
-	namespaces, err := k8s.clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := k8s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out listing namespaces")
+	} else if ctx.Err() != nil {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	sweepLog.Debugf("Got %d namespaces", len(namespaces.Items))
+	pruneServiceAccountPatchRecords(configSAPatchMinInterval, time.Now())
+	pruneNamespaceVerifications(configReverifyAge, time.Now())
+	pruneNamespaceFailures(configNamespaceFailureMaxBackoff, time.Now())
+	pruneNamespaceErrorLogs(time.Now())
+	if configMutationRecords {
+		if err := pruneMutationRecords(ctx, k8s, configMutationRecordTTL, time.Now()); err != nil {
+			sweepLog.Error(err)
+		}
+	}
+
+	if configExportDir != "" {
+		exportSweep(ctx, k8s, namespaces.Items)
+		return nil
+	}
+
+	checkpoint, err := loadCheckpoint(ctx, k8s)
 	if err != nil {
-		log.Panic(err)
+		sweepLog.Warn(err)
+	} else if checkpoint != "" {
+		sweepLog.Infof("Resuming the interrupted loop after namespace [%s]", checkpoint)
 	}
-	log.Debugf("Got %d namespaces", len(namespaces.Items))
+	progress := &loopProgress{k8s: k8s}
 
-	for _, ns := range namespaces.Items {
-		namespace := ns.Name
+	due := []corev1.Namespace{}
+	for _, ns := range prioritizeNamespaces(resumeNamespaces(namespaces.Items, checkpoint), configPriorityNamespaces) {
 		if namespaceIsExcluded(ns) {
-			log.Infof("[%s] Namespace skipped", namespace)
+			nsLog(ns.Name).Info("Namespace skipped")
+			if configCleanupExcluded && ns.DeletionTimestamp == nil {
+				if err := cleanupExcludedNamespace(ctx, k8s, ns.Name); err != nil {
+					sweepLog.Error(err)
+				}
+			}
 			continue
 		}
-		log.Debugf("[%s] Start processing", namespace)
-		
-		// for each namespace, make sure the dockerconfig secret exists
-		err = processSecret(k8s, namespace)
-		if err != nil {
-			// if has error in processing secret, should skip processing service account
-			log.Error(err)
+		forgetNamespaceCleanup(ns.Name)
+		if ns.DeletionTimestamp == nil {
+			if err := collectOrphanedSecrets(ctx, k8s, ns.Name); err != nil {
+				sweepLog.Error(err)
+			}
+		}
+		if !isPriorityNamespace(ns.Name) && !namespaceVerificationDue(ns, desired, configReverifyAge, time.Now()) {
+			nsLog(ns.Name).Debug("Namespace verified recently, skipped")
+			metricNamespaceVerificationsSkipped.Inc()
 			continue
 		}
-
-		// for each namespace, make sure the AWS ConfigMap exists
-		err = processAWSConfigMap(k8s, namespace)
-		if err != nil {
-			log.Error(err)
+		if !isPriorityNamespace(ns.Name) && namespaceBackedOff(ns.Name, time.Now()) {
+			nsLog(ns.Name).Debug("Namespace failed recently, backing off")
+			metricNamespacesBackedOff.Inc()
 			continue
 		}
-		
-		// get default service account, and patch image pull secret if not exist
-		err = processServiceAccount(k8s, namespace)
+		due = append(due, ns)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("namespaces", len(namespaces.Items)), attribute.Int("namespaces.due", len(due)))
+	errs := processNamespacesConcurrently(ctx, due, configConcurrency, func(ns corev1.Namespace) error {
+		span := startNamespaceSpan(ctx, ns.Name)
+		err := processNamespaceWithRetry(ctx, k8s, ns, reconcilers...)
+		finishNamespaceSpan(ns.Name, span, err)
+		if err != nil && ctx.Err() != nil {
+			// cut short by the loop timeout or a shutdown, not failed
+			return err
+		}
+		recordNamespaceStatus(ns.Name, err, time.Now())
+		recordNamespaceInSync(ns.Name, err)
+		reportNamespaceResult(ns.Name, err)
+		progress.done(ctx, ns.Name)
 		if err != nil {
-			log.Error(err)
+			emitFailureEvent(ctx, k8s, ns.Name, err)
+			if backoff := recordNamespaceFailure(ns.Name, configNamespaceFailureMaxBackoff, time.Now()); backoff > 0 {
+				nsLog(ns.Name).Warnf("Namespace failed, leaving it out of the loops for %s", backoff)
+			}
+			return err
 		}
+		recordNamespaceSuccess(ns.Name)
+		if len(reconcilers) == 0 {
+			recordNamespaceVerified(ns, desired, time.Now())
+		}
+		return nil
+	})
+	if len(errs) > 0 {
+		sweepLog.Warnf("Failed to process %d of %d namespaces", len(errs), len(due))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		sweepLog.Warnf("Loop timed out after %s, leaving the remaining namespaces to the next loop", configLoopTimeout)
+	}
+	// the checkpoint and statuses are written even once ctx is done, so the
+	// next loop resumes where this one stopped
+	saveCtx := context.Background()
+	progress.finish(saveCtx, ctx.Err() == nil, checkpoint)
+	pruneNamespacesInSync(namespaces.Items)
+	if err := saveNamespaceStatuses(saveCtx, k8s, namespaces.Items); err != nil {
+		sweepLog.Warn(err)
+	}
+	if secretSync {
+		lastSecretSyncPass = now
+	}
+	if saPatch {
+		lastSAPatchPass = now
+	}
+	return nil
+}
+
+// namespaceReconciler reconciles one kind of managed object of a namespace, as
+// far as its feature gate is enabled
+type namespaceReconciler func(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error
+
+// reconcileSecret makes sure the dockerconfig secret, or the object it is
+// derived from, exists
+func reconcileSecret(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error {
+	switch {
+	case !configEnableSecretSync:
+		return nil
+	case isSourceSecret(ns.Name):
+		nsLog(ns.Name).Debug("Secret is the source secret, skipped")
+		return nil
+	case configSecretMode == secretModeExternalSecret:
+		return processExternalSecret(ctx, k8s, ns.Name)
+	case configSecretMode == secretModeSealedSecret:
+		return processSealedSecret(ctx, k8s, ns.Name)
+	case configSecretMode == secretModeSecretProviderClass:
+		return processSecretProviderClass(ctx, k8s, ns.Name)
+	default:
+		if err := processSecret(ctx, k8s, ns.Name); err != nil {
+			return err
+		}
+		return processAdditionalSecrets(ctx, k8s, ns.Name)
+	}
+}
+
+// reconcileServiceAccounts patches the image pull secret into the service
+// accounts, waiting for the default one of new namespaces
+func reconcileServiceAccounts(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error {
+	if !configEnableSAPatch {
+		return nil
+	}
+	if err := waitForDefaultServiceAccount(ctx, k8s, ns); err != nil {
+		nsLog(ns.Name).Warn(err)
 	}
+	return processServiceAccount(ctx, k8s, ns.Name)
+}
+
+// processNamespace reconciles the secret, synced ConfigMaps and service accounts
+// of a single namespace under its own reconcile ID, or only the given
+// reconcilers, logging and returning the first error
+func processNamespace(ctx context.Context, k8s *k8sClient, ns corev1.Namespace, reconcilers ...namespaceReconciler) error {
+	namespace := ns.Name
+	nsLogger := startReconcile(namespace)
+	defer finishReconcile(namespace)
+	nsLogger.Debug("Start processing")
+	if err := assignNamespaceCredential(ctx, ns); err != nil {
+		logNamespaceError(namespace, err, time.Now())
+		return err
+	}
+
+	full := len(reconcilers) == 0
+	switch {
+	case len(reconcilers) > 0:
+	case configDigestShortCircuit && configEnableSecretSync && configSecretMode == secretModeSecret && secretDigestCurrent(ctx, k8s, namespace):
+		nsLogger.Debug("Secret digest is current, skipping verification and service accounts")
+		reconcilers = []namespaceReconciler{reconcileConfigMaps}
+	default:
+		reconcilers = []namespaceReconciler{reconcileSecret, reconcileConfigMaps, reconcileServiceAccounts}
+	}
+	// if has error in processing secret, should skip processing service account
+	for _, reconcile := range reconcilers {
+		if err := reconcile(ctx, k8s, ns); err != nil {
+			logNamespaceError(namespace, err, time.Now())
+			return err
+		}
+	}
+	if full {
+		logNamespaceRecovered(namespace)
+	}
+	return nil
 }
 
 func namespaceIsExcluded(ns corev1.Namespace) bool {
-	v, ok := ns.Annotations[annotationImagepullsecretPatcherExclude]
+	if !namespaceIsIncluded(ns) || (!configIncludeSystemNamespaces && isSystemNamespace(ns.Name)) {
+		return true
+	}
+	v, ok := patcherAnnotation(ns.Annotations, annotationImagepullsecretPatcherExclude)
 	if ok && v == "true" {
 		return true
 	}
 	for _, ex := range strings.Split(configExcludedNamespaces, ",") {
-		if ex == ns.Name {
+		if ex = strings.TrimSpace(ex); ex != "" && namespacePatternMatches(ex, ns.Name) {
+			return true
+		}
+	}
+	if excludedNamespaceSelector != nil && excludedNamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		return true
+	}
+	return namespaceExcludedByFile(ns.Name) || namespaceExcludedBySelection(ns.Name)
+}
+
+// prioritizeNamespaces orders namespaces with the comma-separated priority
+// namespaces first, in the order listed, followed by the others in their order
+func prioritizeNamespaces(namespaces []corev1.Namespace, priority string) []corev1.Namespace {
+	rank := map[string]int{}
+	for i, name := range strings.Split(priority, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if _, ok := rank[name]; !ok {
+				rank[name] = i
+			}
+		}
+	}
+	if len(rank) == 0 {
+		return namespaces
+	}
+	ordered := append([]corev1.Namespace{}, namespaces...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, iok := rank[ordered[i].Name]
+		rj, jok := rank[ordered[j].Name]
+		if iok && jok {
+			return ri < rj
+		}
+		return iok && !jok
+	})
+	return ordered
+}
+
+// isPriorityNamespace checks whether namespace is listed in the priority namespaces
+func isPriorityNamespace(namespace string) bool {
+	for _, name := range strings.Split(configPriorityNamespaces, ",") {
+		if strings.TrimSpace(name) == namespace {
 			return true
 		}
 	}
 	return false
 }
 
-func processSecret(k8s *k8sClient, namespace string) error {
-	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(context.TODO(), configSecretName, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		_, err := k8s.clientset.CoreV1().Secrets(namespace).Create(context.TODO(), dockerconfigSecret(namespace), metav1.CreateOptions{})
+// configMapIsExcluded checks whether the namespace opted out of the synced ConfigMaps only
+func configMapIsExcluded(ns corev1.Namespace) bool {
+	v, _ := patcherAnnotation(ns.Annotations, annotationImagepullsecretPatcherExcludeConfigMap)
+	return v == "true"
+}
+
+func processSecret(ctx context.Context, k8s *k8sClient, namespace string) error {
+	return syncSecret(ctx, k8s, namespace, configSecretName, credentialFor(namespace))
+}
+
+// syncSecret makes sure the managed secret name of namespace holds content
+func syncSecret(ctx context.Context, k8s *k8sClient, namespace, name, content string) error {
+	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if configDetectOnly && (err == nil || errors.IsNotFound(err)) {
 		if err != nil {
-			return fmt.Errorf("[%s] Failed to create secret: %v", namespace, err)
+			secret = nil
 		}
-		log.Infof("[%s] Created secret", namespace)
+		detectSecretDrift(namespace, name, secret, content)
+		return nil
+	}
+	if errors.IsNotFound(err) && configServerSideApply {
+		return applySecret(ctx, k8s, namespace, name, content, mutationCreate, "SecretNotFound", "")
+	} else if errors.IsNotFound(err) {
+		err := retryOnTransientError(func() error {
+			_, err := k8s.clientset.CoreV1().Secrets(namespace).Create(ctx, namedDockerconfigSecret(namespace, name, content), metav1.CreateOptions{})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to create secret: %v", err)
+		}
+		nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationCreate}).Info("Created secret")
+		recordMutation(ctx, k8s, namespace, mutationCreate, "Secret", name, "SecretNotFound")
 	} else if err != nil {
-		return fmt.Errorf("[%s] Failed to GET secret: %v", namespace, err)
+		return fmt.Errorf("Failed to GET secret: %v", err)
 	} else {
-		if configManagedOnly && isManagedSecret(secret) {
-			return fmt.Errorf("[%s] Secret is present but unmanaged", namespace)
+		if orphaned, err := reconcileOwnership(ctx, k8s, secret); err != nil {
+			return err
+		} else if orphaned {
+			nsLog(namespace).WithField(logFieldSecret, name).Debug("Secret is orphaned, left alone")
+			return nil
+		}
+		if !isManagedSecret(secret) {
+			return leaveUnowned(namespace, "Secret", name, verifySecretContent(secret, content) == secretOk)
 		}
-		switch verifySecret(secret) {
+		switch result := verifySecretContent(secret, content); result {
 		case secretOk:
-			log.Debugf("[%s] Secret is valid", namespace)
-		case secretWrongType, secretNoKey, secretDataNotMatch:
-			if configForce {
-				log.Warnf("[%s] Secret is not valid, overwritting now", namespace)
-				err = k8s.clientset.CoreV1().Secrets(namespace).Delete(context.TODO(), configSecretName, metav1.DeleteOptions{})
-				if err != nil {
-					return fmt.Errorf("[%s] Failed to delete secret [%s]: %v", namespace, configSecretName, err)
-				}
-				log.Warnf("[%s] Deleted secret [%s]", namespace, configSecretName)
-				_, err = k8s.clientset.CoreV1().Secrets(namespace).Create(context.TODO(), dockerconfigSecret(namespace), metav1.CreateOptions{})
-				if err != nil {
-					return fmt.Errorf("[%s] Failed to create secret: %v", namespace, err)
+			nsLog(namespace).WithField(logFieldSecret, name).Debug("Secret is valid")
+			// secrets created before the digest was recorded
+			if name == configSecretName && configDigestShortCircuit && secretContentHash(secret) != credentialHash(content) {
+				if err := annotateSecretDigest(ctx, k8s, namespace); err != nil {
+					return err
 				}
-				log.Infof("[%s] Created secret", namespace)
+			}
+			if !secretMetadataCurrent(secret) || (configImmutableSecrets && !secretIsImmutable(secret)) {
+				return patchSecretMetadata(ctx, k8s, secret)
+			}
+		case secretAuthsMissing:
+			return mergeIntoSecret(ctx, k8s, secret, content)
+		case secretNoKey, secretDataNotMatch:
+			// the data of an immutable secret can't change either, which we
+			// expect of the secrets we made immutable
+			if secretIsImmutable(secret) {
+				nsLog(namespace).WithField(logFieldSecret, name).Info("Secret is immutable and outdated, recreating it")
+				return recreateSecret(ctx, k8s, secret, content, string(result))
+			}
+			if configServerSideApply {
+				return applySecret(ctx, k8s, namespace, name, content, mutationPatch, string(result), secretContentHash(secret))
+			}
+			if !configForce {
+				return fmt.Errorf("Secret [%s] is not valid, set --force to true to overwrite", name)
+			}
+			nsLog(namespace).WithField(logFieldSecret, name).Warn("Secret is not valid, overwritting now")
+			return updateSecret(ctx, k8s, secret, content, string(result))
+		case secretWrongType:
+			// the type of a secret is immutable, so it has to be recreated
+			if configForce {
+				nsLog(namespace).WithField(logFieldSecret, name).Warn("Secret is not valid, overwritting now")
+				return recreateSecret(ctx, k8s, secret, content, string(result))
 			} else {
-				return fmt.Errorf("[%s] Secret is not valid, set --force to true to overwrite", namespace)
+				return fmt.Errorf("Secret [%s] is not valid, set --force to true to overwrite", name)
 			}
 		}
 	}
 	return nil
 }
 
-func processServiceAccount(k8s *k8sClient, namespace string) error {
-	sas, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).List(context.TODO(), metav1.ListOptions{})
+func processServiceAccount(ctx context.Context, k8s *k8sClient, namespace string) error {
+	sas, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("[%s] Failed to list service accounts: %v", namespace, err)
+		return fmt.Errorf("Failed to list service accounts: %v", err)
 	}
 	for _, sa := range sas.Items {
-		if !configAllServiceAccount && stringNotInList(sa.Name, configServiceAccounts) {
-			log.Debugf("[%s] Skip service account [%s]", namespace, sa.Name)
+		if !serviceAccountTargeted(&sa) {
+			nsLog(namespace).WithField(logFieldServiceAccount, sa.Name).Debug("Skip service account")
 			continue
 		}
-		if includeImagePullSecret(&sa, configSecretName) {
-			log.Debugf("[%s] ImagePullSecrets found", namespace)
+		if serviceAccountPatched(&sa, managedSecretNames()...) {
+			nsLog(namespace).Debug("ImagePullSecrets found")
 			continue
 		}
-		patch, err := getPatchString(&sa, configSecretName)
-		if err != nil {
-			return fmt.Errorf("[%s] Failed to get patch string: %v", namespace, err)
+		// we patched it before, so someone else removed our secret since
+		if managers := foreignImagePullSecretsManagers(&sa); len(managers) > 0 && serviceAccountPatchCount(namespace, sa.Name) > 0 {
+			nsLog(namespace).WithField(logFieldServiceAccount, sa.Name).Warnf("imagePullSecrets of service account keep being rewritten by %s", strings.Join(managers, ", "))
+			for _, manager := range managers {
+				metricServiceAccountFieldConflicts.WithLabelValues(manager).Inc()
+			}
+			if configBackOffForeignManagers {
+				nsLog(namespace).WithField(logFieldServiceAccount, sa.Name).Warn("Backing off from service account")
+				continue
+			}
 		}
-		_, err = k8s.clientset.CoreV1().ServiceAccounts(namespace).Patch(context.TODO(), sa.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
-		if err != nil {
-			return fmt.Errorf("[%s] Failed to patch imagePullSecrets to service account [%s]: %v", namespace, sa.Name, err)
+		if !serviceAccountPatchAllowed(namespace, sa.Name, configSAPatchMinInterval, time.Now()) {
+			nsLog(namespace).WithField(logFieldServiceAccount, sa.Name).Infof("Service account was patched less than %s ago, delaying patch", configSAPatchMinInterval)
+			metricServiceAccountPatchesThrottled.Inc()
+			continue
 		}
-		log.Infof("[%s] Patched imagePullSecrets to service account [%s]", namespace, sa.Name)
-	}
-	return nil
-}
-
-func stringNotInList(a string, list string) bool {
-	for _, b := range strings.Split(list, ",") {
-		if b == a {
-			return false
+		if configServerSideApply {
+			if err := applyServiceAccount(ctx, k8s, &sa, managedSecretNames()...); err != nil {
+				return err
+			}
+		} else {
+			patch, err := getPatchString(&sa, managedSecretNames()...)
+			if err != nil {
+				return fmt.Errorf("Failed to get patch string: %v", err)
+			}
+			err = retryOnTransientError(func() error {
+				_, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, sa.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Failed to patch imagePullSecrets to service account [%s]: %v", sa.Name, err)
+			}
 		}
+		recordServiceAccountPatch(namespace, sa.Name, time.Now())
+		nsLog(namespace).WithFields(log.Fields{logFieldServiceAccount: sa.Name, logFieldAction: mutationPatch}).Info("Patched imagePullSecrets to service account")
+		recordMutation(ctx, k8s, namespace, mutationPatch, "ServiceAccount", sa.Name, "ImagePullSecretMissing")
 	}
-	return true
+	return nil
 }
 
-// awsConfigMap creates a ConfigMap with values parsed from an environment file
-func awsConfigMap(namespace string) (*corev1.ConfigMap, error) {
-	// Check if the config file exists
-	fileInfo, err := os.Stat(configAWSConfigFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to access AWS config file: %v", err)
+// waitForDefaultServiceAccount waits for the default service account of a
+// namespace created just now, which the cluster creates asynchronously, so
+// the first reconcile of a new namespace doesn't miss it
+func waitForDefaultServiceAccount(ctx context.Context, k8s *k8sClient, ns corev1.Namespace) error {
+	if configDefaultSAWaitTimeout <= 0 || time.Since(ns.CreationTimestamp.Time) > newNamespaceAge {
+		return nil
 	}
-
-	// If it's a directory, throw an error
-	if fileInfo.IsDir() {
-		return nil, fmt.Errorf("AWS config path is a directory, expected a file: %s", configAWSConfigFilePath)
+	if !configAllServiceAccount && stringNotInList(defaultServiceAccountName, configServiceAccounts) {
+		return nil
 	}
-
-	// Read the content of the file
-	content, err := os.ReadFile(configAWSConfigFilePath)
+	err := wait.PollImmediate(defaultServiceAccountPollInterval, configDefaultSAWaitTimeout, func() (bool, error) {
+		_, err := k8s.clientset.CoreV1().ServiceAccounts(ns.Name).Get(ctx, defaultServiceAccountName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read AWS config file: %v", err)
+		return fmt.Errorf("default service account did not appear within %s: %v", configDefaultSAWaitTimeout, err)
 	}
-
-	// Parse the environment file (key=value lines)
-	data := make(map[string]string)
-	lines := strings.Split(string(content), "\n")
-	
-	for _, line := range lines {
-		// Skip empty lines or comment lines
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		// Split by first equals sign
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			log.Warnf("Ignoring invalid line in env file: %s", line)
-			continue
-		}
-		
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		
-		// Remove quotes if present
-		if len(value) > 1 && (strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"")) || 
-		   (strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'")) {
-			value = value[1 : len(value)-1]
-		}
-		
-		data[key] = value
-	}
-
-	// Return error if no valid data was found
-	if len(data) == 0 {
-		return nil, fmt.Errorf("no valid entries found in environment file %s", configAWSConfigFilePath)
-	}
-
-	return &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      configAWSConfigMapName,
-			Namespace: namespace,
-			Annotations: map[string]string{
-				annotationManagedBy: annotationAppName,
-			},
-		},
-		Data: data,
-	}, nil
+	return nil
 }
 
-// processAWSConfigMap ensures the AWS ConfigMap exists in the given namespace
-func processAWSConfigMap(k8s *k8sClient, namespace string) error {
-	configMap, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		// Create the AWS ConfigMap from the file
-		awsConfigMapObj, err := awsConfigMap(namespace)
-		if err != nil {
-			// If the file doesn't exist or is inaccessible, log it and return without error
-			log.Debugf("[%s] Skipping AWS ConfigMap creation: %v", namespace, err)
-			return nil
-		}
-		
-		_, err = k8s.clientset.CoreV1().ConfigMaps(namespace).Create(context.TODO(), awsConfigMapObj, metav1.CreateOptions{})
-		if err != nil {
-			return fmt.Errorf("[%s] Failed to create AWS ConfigMap: %v", namespace, err)
-		}
-		log.Infof("[%s] Created AWS ConfigMap", namespace)
-	} else if err != nil {
-		return fmt.Errorf("[%s] Failed to GET AWS ConfigMap: %v", namespace, err)
-	} else {
-		// Check if the ConfigMap is managed by us
-		if configManagedOnly && !isManagedConfigMap(configMap) {
-			return fmt.Errorf("[%s] AWS ConfigMap is present but unmanaged", namespace)
-		}
-		
-		// Read the current AWS config file
-		awsConfigMapObj, err := awsConfigMap(namespace)
-		if err != nil {
-			// If the file doesn't exist anymore, consider removing the ConfigMap
-			log.Warnf("[%s] AWS config file is no longer accessible: %v", namespace, err)
-			if configForce {
-				log.Warnf("[%s] Deleting AWS ConfigMap since config file is gone", namespace)
-				err = k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), configAWSConfigMapName, metav1.DeleteOptions{})
-				if err != nil {
-					return fmt.Errorf("[%s] Failed to delete AWS ConfigMap [%s]: %v", namespace, configAWSConfigMapName, err)
-				}
-				log.Infof("[%s] Deleted AWS ConfigMap", namespace)
-			}
-			return nil
-		}
-		
-		// Check if the ConfigMap data matches what we read from the file
-		if !mapsEqual(configMap.Data, awsConfigMapObj.Data) {
-			if configForce {
-				log.Warnf("[%s] AWS ConfigMap is not valid, overwriting now", namespace)
-				err = k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), configAWSConfigMapName, metav1.DeleteOptions{})
-				if err != nil {
-					return fmt.Errorf("[%s] Failed to delete AWS ConfigMap [%s]: %v", namespace, configAWSConfigMapName, err)
-				}
-				log.Warnf("[%s] Deleted AWS ConfigMap [%s]", namespace, configAWSConfigMapName)
-				_, err = k8s.clientset.CoreV1().ConfigMaps(namespace).Create(context.TODO(), awsConfigMapObj, metav1.CreateOptions{})
-				if err != nil {
-					return fmt.Errorf("[%s] Failed to create AWS ConfigMap: %v", namespace, err)
-				}
-				log.Infof("[%s] Created AWS ConfigMap", namespace)
-			} else {
-				return fmt.Errorf("[%s] AWS ConfigMap is not valid, set --force to true to overwrite", namespace)
-			}
-		} else {
-			log.Debugf("[%s] AWS ConfigMap is valid", namespace)
+func stringNotInList(a string, list string) bool {
+	for _, b := range strings.Split(list, ",") {
+		if b == a {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
 // isManagedConfigMap checks if the ConfigMap is managed by this application
 func isManagedConfigMap(configMap *corev1.ConfigMap) bool {
-	if k, ok := configMap.ObjectMeta.Annotations[annotationManagedBy]; ok {
-		if k == annotationAppName {
-			return true
-		}
-	}
-	return false
+	return isOwned(configMap)
 }
 
 // mapsEqual compares two string maps for equality
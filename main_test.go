@@ -5,14 +5,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 var testCasesProcessSecret = []testCase{
@@ -61,6 +67,19 @@ var testCasesProcessSecret = []testCase{
 			assertSecretIsInvalid,
 		},
 	},
+	{
+		name: "has unmanaged secret - force on",
+		prepSteps: []step{
+			helperForceOn,
+			helperCreateUnmanagedSecret,
+			assertSecretIsInvalid,
+		},
+		testSteps: []step{
+			processSecretDefault,
+			assertSecretIsInvalid,
+			assertSecretIsUnmanaged,
+		},
+	},
 }
 
 var testCasesProcessServiceAccount = []testCase{
@@ -123,6 +142,30 @@ var testCasesProcessServiceAccount = []testCase{
 			assertHasImagePullSecret(configSecretName, "other-service-account"),
 		},
 	},
+	{
+		name: "image pull secret removed by another manager - back off",
+		prepSteps: []step{
+			helperBackOffForeignManagersOn,
+			helperCreateServiceAccountManagedBy("other-controller", "fought-service-account"),
+			helperServiceAccountPatchedBefore("fought-service-account"),
+		},
+		testSteps: []step{
+			processServiceAccountDefault,
+			assertHasError(assertHasImagePullSecret(configSecretName, "fought-service-account")),
+		},
+	},
+	{
+		name: "image pull secret removed by another manager - no back off",
+		prepSteps: []step{
+			helperBackOffForeignManagersOff,
+			helperCreateServiceAccountManagedBy("other-controller", "fought-service-account"),
+			helperServiceAccountPatchedBefore("fought-service-account"),
+		},
+		testSteps: []step{
+			processServiceAccountDefault,
+			assertHasImagePullSecret(configSecretName, "fought-service-account"),
+		},
+	},
 }
 
 func TestProcessSecret(t *testing.T) {
@@ -222,6 +265,11 @@ func runTestCase(t *testing.T, testName string, tc testCase) {
 	// create fake client
 	k8s := &k8sClient{
 		clientset: fake.NewSimpleClientset(),
+		dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			externalSecretGVR:      "ExternalSecretList",
+			sealedSecretGVR:        "SealedSecretList",
+			secretProviderClassGVR: "SecretProviderClassList",
+		}),
 	}
 
 	// run preparation steps
@@ -242,14 +290,17 @@ func runTestCase(t *testing.T, testName string, tc testCase) {
 }
 
 func processSecretDefault(k8s *k8sClient) error {
-	return processSecret(k8s, v1.NamespaceDefault)
+	return processSecret(context.TODO(), k8s, v1.NamespaceDefault)
 }
 
 func processServiceAccountDefault(k8s *k8sClient) error {
-	return processServiceAccount(k8s, v1.NamespaceDefault)
+	return processServiceAccount(context.TODO(), k8s, v1.NamespaceDefault)
 }
 
 func TestNamespaceIsExcluded(t *testing.T) {
+	// the cases are about the configured exclusions of kube-system
+	configIncludeSystemNamespaces = true
+	defer func() { configIncludeSystemNamespaces = false }()
 	for _, tc := range []struct {
 		name      string
 		config    string
@@ -314,12 +365,25 @@ func helperCreateValidSecret(k8s *k8sClient) error {
 }
 
 func helperCreateOpaqueSecret(k8s *k8sClient) error {
+	_, err := k8s.clientset.CoreV1().Secrets(v1.NamespaceDefault).Create(context.TODO(), &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        configSecretName,
+			Namespace:   v1.NamespaceDefault,
+			Annotations: map[string]string{annotationManagedBy: annotationAppName},
+		},
+		Type: corev1.SecretTypeOpaque,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func helperCreateUnmanagedSecret(k8s *k8sClient) error {
 	_, err := k8s.clientset.CoreV1().Secrets(v1.NamespaceDefault).Create(context.TODO(), &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      configSecretName,
 			Namespace: v1.NamespaceDefault,
 		},
-		Type: corev1.SecretTypeOpaque,
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
 	}, metav1.CreateOptions{})
 	return err
 }
@@ -353,6 +417,41 @@ func helperCreateServiceAccountWithImagePullSecret(secretName, serviceAccountNam
 	}
 }
 
+func helperCreateServiceAccountManagedBy(manager, serviceAccountName string) step {
+	return func(k8s *k8sClient) error {
+		_, err := k8s.clientset.CoreV1().ServiceAccounts(v1.NamespaceDefault).Create(context.TODO(), &v1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceAccountName,
+				Namespace: v1.NamespaceDefault,
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{
+						Manager:  manager,
+						FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:imagePullSecrets":{}}`)},
+					},
+				},
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+}
+
+func helperServiceAccountPatchedBefore(serviceAccountName string) step {
+	return func(_ *k8sClient) error {
+		recordServiceAccountPatch(v1.NamespaceDefault, serviceAccountName, time.Now().Add(-time.Minute))
+		return nil
+	}
+}
+
+func helperBackOffForeignManagersOn(_ *k8sClient) error {
+	configBackOffForeignManagers = true
+	return nil
+}
+
+func helperBackOffForeignManagersOff(_ *k8sClient) error {
+	configBackOffForeignManagers = false
+	return nil
+}
+
 func helperForceOn(_ *k8sClient) error {
 	configForce = true
 	return nil
@@ -407,6 +506,17 @@ func assertSecretIsInvalid(k8s *k8sClient) error {
 	return nil
 }
 
+func assertSecretIsUnmanaged(k8s *k8sClient) error {
+	secret, err := k8s.clientset.CoreV1().Secrets(v1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("assert secret unmanaged but no found")
+	}
+	if isManagedSecret(secret) {
+		return fmt.Errorf("assert secret unmanaged but managed")
+	}
+	return nil
+}
+
 func assertHasError(fn step) step {
 	return func(k8s *k8sClient) error {
 		if err := fn(k8s); err == nil {
@@ -520,3 +630,212 @@ INVALID_LINE
 		t.Errorf("Expected error when file doesn't exist, got nil")
 	}
 }
+
+func TestProcessNamespaceExcludeConfigMap(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	dockerConfigJSON = testDockerconfig
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "aws-configs")
+	if err := os.WriteFile(configAWSConfigFilePath, []byte("AWS_REGION=us-west-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	ns := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "opted-out",
+			Annotations: map[string]string{
+				annotationImagepullsecretPatcherExcludeConfigMap: "true",
+			},
+		},
+	}
+
+	if err := processNamespace(context.TODO(), k8s, ns); err != nil {
+		t.Fatalf("processNamespace failed: %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().Secrets(ns.Name).Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("processNamespace did not create the secret: %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().ConfigMaps(ns.Name).Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("processNamespace should skip the AWS ConfigMap of an opted-out namespace, got %v", err)
+	}
+}
+
+func TestProcessNamespaceFeatureGates(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	dockerConfigJSON = testDockerconfig
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "aws-configs")
+	if err := os.WriteFile(configAWSConfigFilePath, []byte("AWS_REGION=us-west-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configEnableSecretSync, configEnableConfigMapSync = false, false
+	defer func() {
+		configEnableSecretSync, configEnableConfigMapSync = true, true
+	}()
+	namespace := "sa-only"
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: namespace},
+	})}
+
+	if err := processNamespace(context.TODO(), k8s, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}); err != nil {
+		t.Fatalf("processNamespace failed: %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().Secrets(namespace).Get(context.TODO(), configSecretName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("processNamespace should not create the secret with secret sync disabled, got %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("processNamespace should not create the AWS ConfigMap with ConfigMap sync disabled, got %v", err)
+	}
+	sa, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("processNamespace did not patch the service account")
+	}
+}
+
+func TestPruneAWSConfigMap(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	dockerConfigJSON = testDockerconfig
+	configEnableConfigMapSync, configPruneConfigMaps = false, true
+	defer func() {
+		configEnableConfigMapSync, configPruneConfigMaps = true, false
+	}()
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:        configAWSConfigMapName,
+			Namespace:   "managed",
+			Annotations: map[string]string{annotationManagedBy: annotationAppName},
+		}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      configAWSConfigMapName,
+			Namespace: "unmanaged",
+		}},
+	)}
+
+	for _, namespace := range []string{"managed", "unmanaged", "empty"} {
+		if err := processNamespace(context.TODO(), k8s, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}); err != nil {
+			t.Fatalf("processNamespace(%s) failed: %v", namespace, err)
+		}
+	}
+	if _, err := k8s.clientset.CoreV1().ConfigMaps("managed").Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("processNamespace should prune the managed AWS ConfigMap, got %v", err)
+	}
+	if _, err := k8s.clientset.CoreV1().ConfigMaps("unmanaged").Get(context.TODO(), configAWSConfigMapName, metav1.GetOptions{}); err != nil {
+		t.Errorf("processNamespace should keep the unmanaged AWS ConfigMap, got %v", err)
+	}
+}
+
+func TestProcessNamespaceWaitsForDefaultServiceAccount(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	dockerConfigJSON = testDockerconfig
+	defaultPollInterval := defaultServiceAccountPollInterval
+	defer func() { defaultServiceAccountPollInterval = defaultPollInterval }()
+	defaultServiceAccountPollInterval = time.Millisecond
+	namespace := "brand-new"
+	clientset := fake.NewSimpleClientset()
+	// the default service account appears on the third lookup
+	lookups := 0
+	clientset.PrependReactor("get", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if lookups++; lookups == 3 {
+			err := clientset.Tracker().Add(&corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: namespace},
+			})
+			return false, nil, err
+		}
+		return false, nil, nil
+	})
+	k8s := &k8sClient{clientset: clientset}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, CreationTimestamp: metav1.Now()}}
+
+	if err := processNamespace(context.TODO(), k8s, ns); err != nil {
+		t.Fatalf("processNamespace failed: %v", err)
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("processNamespace did not patch the default service account appearing late")
+	}
+}
+
+func TestLoopJitter(t *testing.T) {
+	d := 10 * time.Second
+	if got := jitteredLoopDuration(d, 0); got != d {
+		t.Errorf("expected %s without jitter, got %s", d, got)
+	}
+	if got := loopSplay(d, 0); got != 0 {
+		t.Errorf("expected no splay without jitter, got %s", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := jitteredLoopDuration(d, 20); got < d || got > 12*time.Second {
+			t.Fatalf("expected between %s and 12s, got %s", d, got)
+		}
+		if got := loopSplay(d, 20); got < 0 || got > 2*time.Second {
+			t.Fatalf("expected splay between 0 and 2s, got %s", got)
+		}
+	}
+}
+
+func TestLoopReturnsTransientErrors(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configEnableSecretSync = true
+	configDockerConfigJSONPath = ""
+	credentialSources = nil
+	configExportDir = ""
+
+	configDockerconfigjson = testDockerconfig
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewServiceUnavailable("API server unavailable")
+	})
+	if err := loop(context.TODO(), &k8sClient{clientset: clientset}); err == nil {
+		t.Error("expected an error when namespaces cannot be listed")
+	}
+
+	configDockerconfigjson = ""
+	if err := loop(context.TODO(), &k8sClient{clientset: fake.NewSimpleClientset()}); err == nil {
+		t.Error("expected an error when the credential is unavailable")
+	}
+	configDockerconfigjson = testDockerconfig
+}
+
+func TestLoopRetryPolicy(t *testing.T) {
+	configLoopDuration = 10 * time.Second
+	policy := loopRetryPolicy()
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, e := range expected {
+		if got := policy.backoff(i + 1); got != e {
+			t.Errorf("failed loop %d: expected backoff %s, got %s", i+1, e, got)
+		}
+	}
+}
+
+func TestPrioritizeNamespaces(t *testing.T) {
+	namespaces := []corev1.Namespace{}
+	for _, name := range []string{"tenant-a", "monitoring", "tenant-b", "ingress", "tenant-c"} {
+		namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	for priority, expected := range map[string][]string{
+		"":                             {"tenant-a", "monitoring", "tenant-b", "ingress", "tenant-c"},
+		"ingress,monitoring":           {"ingress", "monitoring", "tenant-a", "tenant-b", "tenant-c"},
+		"monitoring, ingress, missing": {"monitoring", "ingress", "tenant-a", "tenant-b", "tenant-c"},
+	} {
+		ordered := prioritizeNamespaces(namespaces, priority)
+		names := []string{}
+		for _, ns := range ordered {
+			names = append(names, ns.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(expected) {
+			t.Errorf("priority %q: expected %v, got %v", priority, expected, names)
+		}
+	}
+	if namespaces[0].Name != "tenant-a" {
+		t.Error("prioritizeNamespaces modified its argument")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// credentialMappingRule gives the namespaces matching its name patterns and
+// label selector their own credential, loaded from its sources
+type credentialMappingRule struct {
+	// Namespaces are names or glob patterns, any namespace when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector is a label selector, any labels when empty
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Source is a `credential-sources` list
+	Source string `json:"source"`
+
+	selector labels.Selector
+	sources  []credentialSource
+	// content is the credential last loaded from sources, guarded by
+	// reconcileMu
+	content string
+}
+
+var (
+	// credentialMapping is parsed from the credential mapping file, the
+	// first matching rule applies
+	credentialMapping []*credentialMappingRule
+
+	// namespaceCredentials are the credentials assigned to namespaces by the
+	// mapping when they were last reconciled
+	namespaceCredentialsMu sync.RWMutex
+	namespaceCredentials   = map[string]string{}
+
+	// annotatedSecretClientset reads the secrets namespaces point at with the
+	// source secret annotation, set once the client is created
+	annotatedSecretClientset kubernetes.Interface
+
+	// annotatedCredentials caches the credentials of the secrets namespaces
+	// point at for the duration of a loop
+	annotatedCredentialsMu sync.Mutex
+	annotatedCredentials   = map[string]string{}
+)
+
+func (r *credentialMappingRule) String() string {
+	return fmt.Sprintf("namespaces=%s selector=%q", strings.Join(r.Namespaces, ","), r.NamespaceSelector)
+}
+
+// matches tells whether the rule applies to ns
+func (r *credentialMappingRule) matches(ns corev1.Namespace) bool {
+	if !r.selector.Matches(labels.Set(ns.Labels)) {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range r.Namespaces {
+		if ok, _ := path.Match(pattern, ns.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCredentialMapping parses a YAML list of rules
+func parseCredentialMapping(content []byte) ([]*credentialMappingRule, error) {
+	rules := []*credentialMappingRule{}
+	if err := yaml.UnmarshalStrict(content, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if len(rule.Namespaces) == 0 && rule.NamespaceSelector == "" {
+			return nil, fmt.Errorf("rule %d matches neither namespaces nor a namespaceSelector", i)
+		}
+		for _, pattern := range rule.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+		var err error
+		rule.selector, err = labels.Parse(rule.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid namespaceSelector: %v", i, err)
+		}
+		rule.sources, err = parseCredentialSources(rule.Source)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		if len(rule.sources) == 0 {
+			return nil, fmt.Errorf("rule %d has no source", i)
+		}
+	}
+	return rules, nil
+}
+
+// loadCredentialMappingFile reads and parses the credential mapping file
+func loadCredentialMappingFile(path string) ([]*credentialMappingRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential mapping file: %v", err)
+	}
+	rules, err := parseCredentialMapping(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credential mapping file: %v", err)
+	}
+	return rules, nil
+}
+
+// loadMappedCredentials loads the credential of every rule, keeping the
+// previous one of a rule whose sources are unavailable or whose credential
+// is malformed, so only its namespaces are held back, and forgets the
+// credentials of the secrets namespaces point at. It must be called holding
+// reconcileMu.
+func loadMappedCredentials(ctx context.Context) {
+	annotatedCredentialsMu.Lock()
+	annotatedCredentials = map[string]string{}
+	annotatedCredentialsMu.Unlock()
+	for _, rule := range credentialMapping {
+		content, err := loadCredentialChain(ctx, rule.sources)
+		// templates are checked once expanded
+		if err == nil && configValidateCredential && !configCredentialTemplate {
+			err = validateDockerConfigJSON(content)
+		}
+		if err != nil {
+			log.Errorf("Failed to load the credential of mapping rule [%s], keeping the previous one: %v", rule, err)
+			continue
+		}
+		rule.content = content
+	}
+}
+
+// annotatedCredential loads the credential of the secret ref, in the form
+// `namespace/name`, which has to be in one of the namespaces allowed by
+// `source-secret-annotation-namespaces`
+func annotatedCredential(ctx context.Context, ref string) (string, error) {
+	namespace, name, err := splitNamespacedName(ref)
+	if err != nil {
+		return "", err
+	}
+	allowed := false
+	for _, n := range splitCommaList(configSourceSecretAnnotationAllows) {
+		allowed = allowed || n == namespace
+	}
+	if !allowed {
+		return "", fmt.Errorf("secrets of namespace %s are not allowed by `source-secret-annotation-namespaces`", namespace)
+	}
+	if isManagedSecretName(name) {
+		return "", fmt.Errorf("%s is a managed secret", ref)
+	}
+	annotatedCredentialsMu.Lock()
+	defer annotatedCredentialsMu.Unlock()
+	if content, ok := annotatedCredentials[ref]; ok {
+		return content, nil
+	}
+	source := &secretCredentialSource{clientset: annotatedSecretClientset, namespace: namespace, name: name}
+	content, err := source.load(ctx)
+	if err == nil && configValidateCredential {
+		err = validateDockerConfigJSON(content)
+	}
+	if err != nil {
+		return "", err
+	}
+	annotatedCredentials[ref] = content
+	return content, nil
+}
+
+// assignNamespaceCredential records the credential of ns, that of the secret
+// its source secret annotation points at, else that of the first matching
+// rule, or the default one when none matches
+func assignNamespaceCredential(ctx context.Context, ns corev1.Namespace) error {
+	if ref, ok := patcherAnnotation(ns.Annotations, annotationImagepullsecretPatcherSourceSecret); ok {
+		content, err := annotatedCredential(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("Failed to load the source secret %s it points at: %v", ref, err)
+		}
+		return setNamespaceCredential(ns, content)
+	}
+	for _, rule := range credentialMapping {
+		if !rule.matches(ns) {
+			continue
+		}
+		if rule.content == "" {
+			return fmt.Errorf("Credential of mapping rule [%s] is not loaded", rule)
+		}
+		return setNamespaceCredential(ns, rule.content)
+	}
+	if configCredentialTemplate {
+		return setNamespaceCredential(ns, dockerConfigJSON)
+	}
+	namespaceCredentialsMu.Lock()
+	delete(namespaceCredentials, ns.Name)
+	namespaceCredentialsMu.Unlock()
+	registerSecrets("namespace:" + ns.Name)
+	return nil
+}
+
+// setNamespaceCredential records content as the credential of ns, expanded
+// for ns with `credential-template`
+func setNamespaceCredential(ns corev1.Namespace, content string) error {
+	if configCredentialTemplate && content != "" {
+		expanded, err := expandCredentialTemplate(content, ns)
+		if err != nil {
+			return fmt.Errorf("Failed to expand the credential template: %v", err)
+		}
+		content = expanded
+		registerCredential("namespace:"+ns.Name, content)
+	}
+	namespaceCredentialsMu.Lock()
+	namespaceCredentials[ns.Name] = content
+	namespaceCredentialsMu.Unlock()
+	return nil
+}
+
+// credentialFor returns the credential to distribute to namespace
+func credentialFor(namespace string) string {
+	namespaceCredentialsMu.RLock()
+	defer namespaceCredentialsMu.RUnlock()
+	if content, ok := namespaceCredentials[namespace]; ok {
+		return content
+	}
+	return dockerConfigJSON
+}
+
+// mappedCredentialsHash fingerprints the credentials of the mapping
+func mappedCredentialsHash() string {
+	contents := []string{}
+	for _, rule := range credentialMapping {
+		contents = append(contents, rule.content)
+	}
+	return credentialHash(strings.Join(contents, "\x00"))
+}
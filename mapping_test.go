@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseCredentialMapping(t *testing.T) {
+	rules, err := parseCredentialMapping([]byte(`
+- namespaces: ["team-a-*"]
+  source: file:/secrets/team-a/.dockerconfigjson
+- namespaceSelector: team=b
+  source: env:TEAM_B_DOCKERCONFIGJSON,file:/secrets/team-b/.dockerconfigjson
+`))
+	if err != nil {
+		t.Fatalf("parseCredentialMapping failed: %v", err)
+	}
+	if len(rules) != 2 || len(rules[1].sources) != 2 {
+		t.Fatalf("parseCredentialMapping returned %+v", rules)
+	}
+	for ns, want := range map[*corev1.Namespace][]bool{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a-web"}}:                                      {true, false},
+		{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "b"}}}: {false, true},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b-web"}}:                                      {false, false},
+	} {
+		for i, rule := range rules {
+			if got := rule.matches(*ns); got != want[i] {
+				t.Errorf("rule %d matches %s = %v, want %v", i, ns.Name, got, want[i])
+			}
+		}
+	}
+
+	for _, spec := range []string{
+		`- source: file:/secrets/.dockerconfigjson`,
+		`- namespaces: ["["]` + "\n  source: file:/secrets/.dockerconfigjson",
+		`- namespaceSelector: "team in (a"` + "\n  source: file:/secrets/.dockerconfigjson",
+		`- namespaces: ["a"]` + "\n  source: s3:bucket/key",
+		`- namespaces: ["a"]`,
+		`- namespace: ["a"]` + "\n  source: file:/secrets/.dockerconfigjson",
+	} {
+		if _, err := parseCredentialMapping([]byte(spec)); err == nil {
+			t.Errorf("parseCredentialMapping(%q) should fail", spec)
+		}
+	}
+}
+
+func TestCredentialMapping(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer func() {
+		credentialMapping = nil
+		namespaceCredentials = map[string]string{}
+		dockerConfigJSON = ""
+	}()
+	teamA := `{"auths":{"harbor.example.com":{"username":"robot$team-a","password":"a"}}}`
+	path := filepath.Join(t.TempDir(), ".dockerconfigjson")
+	if err := os.WriteFile(path, []byte(teamA), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	credentialMapping, err = parseCredentialMapping([]byte("- namespaces: [team-a-*]\n  source: file:" + path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerConfigJSON = testDockerconfig
+
+	teamANamespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a-web"}}
+	if err := assignNamespaceCredential(context.TODO(), teamANamespace); err == nil {
+		t.Errorf("assignNamespaceCredential should fail before the credential of the rule is loaded")
+	}
+	loadMappedCredentials(context.TODO())
+
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	for ns, want := range map[string]string{"team-a-web": teamA, "team-b-web": testDockerconfig} {
+		if err := processNamespace(context.TODO(), k8s, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+			t.Fatalf("processNamespace(%s) failed: %v", ns, err)
+		}
+		secret, err := k8s.clientset.CoreV1().Secrets(ns).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("secret of %s not created: %v", ns, err)
+		}
+		if got := string(secret.Data[corev1.DockerConfigJsonKey]); got != want {
+			t.Errorf("secret of %s = %s, want %s", ns, got, want)
+		}
+		if verifySecret(secret) != secretOk {
+			t.Errorf("secret of %s should verify against its own credential", ns)
+		}
+	}
+
+	// an unavailable source keeps the last credential of its rule
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	loadMappedCredentials(context.TODO())
+	if credentialMapping[0].content != teamA {
+		t.Errorf("rule credential = %q after its source became unavailable, want the previous one", credentialMapping[0].content)
+	}
+}
+
+func TestSourceSecretAnnotation(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defer func() {
+		configSourceSecretAnnotationAllows = ""
+		annotatedSecretClientset = nil
+		annotatedCredentials = map[string]string{}
+		namespaceCredentials = map[string]string{}
+		dockerConfigJSON = ""
+	}()
+	alt := `{"auths":{"alt.example.com":{"username":"alt","password":"alt"}}}`
+	annotatedSecretClientset = fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "alt-registry"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(alt)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "alt-registry"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(alt)},
+		},
+	)
+	dockerConfigJSON = testDockerconfig
+	annotated := func(ref string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-b",
+			Annotations: map[string]string{annotationImagepullsecretPatcherSourceSecret: ref},
+		}}
+	}
+
+	if err := assignNamespaceCredential(context.TODO(), annotated("kube-system/alt-registry")); err == nil {
+		t.Errorf("the annotation should be refused unless its namespace is allowed")
+	}
+	configSourceSecretAnnotationAllows = "kube-system"
+	if err := assignNamespaceCredential(context.TODO(), annotated("kube-system/alt-registry")); err != nil {
+		t.Fatalf("assignNamespaceCredential failed: %v", err)
+	}
+	if got := credentialFor("team-b"); got != alt {
+		t.Errorf("credential of team-b = %s, want that of the annotated secret", got)
+	}
+	for _, ref := range []string{"team-a/alt-registry", "kube-system/missing", "kube-system", "kube-system/" + configSecretName} {
+		if err := assignNamespaceCredential(context.TODO(), annotated(ref)); err == nil {
+			t.Errorf("assignNamespaceCredential with annotation %q should fail", ref)
+		}
+	}
+
+	if err := assignNamespaceCredential(context.TODO(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := credentialFor("team-b"); got != testDockerconfig {
+		t.Errorf("credential of team-b = %s once the annotation was removed, want the default one", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretAuthsMissing is the result of verifySecret when `merge-existing` is
+// set and the secret lacks some of our auths entries
+const secretAuthsMissing verifySecretResult = "SecretAuthsMissing"
+
+// parseDockerConfigAuths returns the top-level fields and the auths entries of
+// a dockerconfigjson, keeping fields we don't know as they are
+func parseDockerConfigAuths(content string) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	config := map[string]json.RawMessage{}
+	auths := map[string]json.RawMessage{}
+	if content == "" {
+		return config, auths, nil
+	}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return nil, nil, err
+	}
+	if raw, ok := config["auths"]; ok {
+		if err := json.Unmarshal(raw, &auths); err != nil {
+			return nil, nil, fmt.Errorf("auths: %v", err)
+		}
+	}
+	return config, auths, nil
+}
+
+// dockerConfigJSONContains tells whether existing holds every auths entry of
+// ours as it is
+func dockerConfigJSONContains(existing, ours string) bool {
+	_, have, err := parseDockerConfigAuths(existing)
+	if err != nil {
+		return false
+	}
+	_, want, err := parseDockerConfigAuths(ours)
+	if err != nil {
+		return false
+	}
+	for registry, auth := range want {
+		var a, b interface{}
+		if json.Unmarshal(have[registry], &a) != nil || json.Unmarshal(auth, &b) != nil || !reflect.DeepEqual(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDockerConfigJSON adds the auths entries of ours to existing, replacing
+// the ones of the same registries and keeping the others
+func mergeDockerConfigJSON(existing, ours string) (string, error) {
+	config, auths, err := parseDockerConfigAuths(existing)
+	if err != nil {
+		return "", fmt.Errorf("invalid existing dockerconfigjson: %v", err)
+	}
+	_, want, err := parseDockerConfigAuths(ours)
+	if err != nil {
+		return "", fmt.Errorf("invalid dockerconfigjson: %v", err)
+	}
+	for registry, auth := range want {
+		auths[registry] = auth
+	}
+	raw, err := json.Marshal(auths)
+	if err != nil {
+		return "", err
+	}
+	config["auths"] = raw
+	b, err := json.Marshal(config)
+	return string(b), err
+}
+
+// mergeIntoSecret merges content into the dockerconfigjson of the existing
+// secret in place
+func mergeIntoSecret(ctx context.Context, k8s *k8sClient, secret *corev1.Secret, content string) error {
+	merged, err := mergeDockerConfigJSON(string(secret.Data[corev1.DockerConfigJsonKey]), content)
+	if err != nil {
+		return fmt.Errorf("Failed to merge into secret [%s]: %v", secret.Name, err)
+	}
+	oldHash := secretContentHash(secret)
+	secret = secret.DeepCopy()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[corev1.DockerConfigJsonKey] = []byte(merged)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	for k, v := range provenanceAnnotations(content) {
+		secret.Annotations[k] = v
+	}
+	for k, v := range secretAnnotations {
+		secret.Annotations[k] = v
+	}
+	if secret.Labels == nil && len(secretLabels) > 0 {
+		secret.Labels = map[string]string{}
+	}
+	for k, v := range secretLabels {
+		secret.Labels[k] = v
+	}
+	err = retryOnTransientError(func() error {
+		_, err := k8s.clientset.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{FieldManager: fieldManager})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update secret [%s]: %v", secret.Name, err)
+	}
+	nsLog(secret.Namespace).WithFields(log.Fields{logFieldSecret: secret.Name, logFieldAction: mutationPatch}).Info("Merged credential into secret")
+	recordContentMutation(ctx, k8s, secret.Namespace, mutationPatch, "Secret", secret.Name, string(secretAuthsMissing), oldHash)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMergeDockerConfigJSON(t *testing.T) {
+	existing := `{"auths":{"gcr.io":{"auth":"b2xkOm9sZA=="},"harbor.team.local":{"auth":"dGVhbTp0ZWFt"}},"credsStore":"none"}`
+	merged, err := mergeDockerConfigJSON(existing, testDockerconfig)
+	if err != nil {
+		t.Fatalf("mergeDockerConfigJSON failed: %v", err)
+	}
+	expected := `{"auths":{"gcr.io":{"username":"_json_key","password":"{}"},"harbor.team.local":{"auth":"dGVhbTp0ZWFt"}},"credsStore":"none"}`
+	if merged != expected {
+		t.Errorf("mergeDockerConfigJSON gives %s, expects %s", merged, expected)
+	}
+	if dockerConfigJSONContains(existing, testDockerconfig) {
+		t.Errorf("existing with another gcr.io entry should not contain the credential")
+	}
+	if !dockerConfigJSONContains(merged, testDockerconfig) {
+		t.Errorf("merged should contain the credential")
+	}
+	if _, err := mergeDockerConfigJSON("not json", testDockerconfig); err == nil {
+		t.Errorf("mergeDockerConfigJSON should fail on an invalid existing secret")
+	}
+}
+
+func TestProcessSecretMergeExisting(t *testing.T) {
+	configMergeExisting = true
+	configAdoptExisting = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configMergeExisting = false
+		configAdoptExisting = false
+		dockerConfigJSON = ""
+	}()
+	existing := `{"auths":{"harbor.team.local":{"auth":"dGVhbTp0ZWFt"}}}`
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-a", UID: "team-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(existing)},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "team-a"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	secret, err := clientset.CoreV1().Secrets("team-a").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.UID != "team-secret" {
+		t.Errorf("secret should be updated in place, not recreated")
+	}
+	content := string(secret.Data[corev1.DockerConfigJsonKey])
+	if !dockerConfigJSONContains(content, testDockerconfig) || !dockerConfigJSONContains(content, existing) {
+		t.Errorf("secret should hold both the team's and our registries, got %s", content)
+	}
+	if verifySecret(secret) != secretOk {
+		t.Errorf("merged secret should verify")
+	}
+}
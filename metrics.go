@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "imagepullsecret"
+
+var (
+	metricBuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "patcher_build_info",
+		Help:      "Always 1, labeled with the version, git commit and Go version the patcher was built with.",
+	}, []string{"version", "commit", "goversion"})
+	metricCredentialAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_age_seconds",
+		Help:      "Seconds since the distributed credential content last changed.",
+	}, []string{"credential"})
+	metricCredentialExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_expiry_timestamp",
+		Help:      "Unix time the distributed credential expires, absent when unknown.",
+	}, []string{"credential"})
+	metricCredentialRotationSLAExceeded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_rotation_sla_exceeded",
+		Help:      "1 if the credential is older than the configured rotation SLA, 0 otherwise.",
+	}, []string{"credential"})
+	metricServiceAccountFieldConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "serviceaccount_field_conflicts_total",
+		Help:      "Times a service account we patched before had imagePullSecrets rewritten by another field manager.",
+	}, []string{"manager"})
+	metricClientRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "client_refreshes_total",
+		Help:      "Times the Kubernetes client transport was rebuilt after an authentication or certificate error.",
+	})
+	metricServiceAccountPatchesThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "serviceaccount_patches_throttled_total",
+		Help:      "Service account patches delayed because the same service account was patched too recently.",
+	})
+	metricRegistryHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "registry_healthy",
+		Help:      "1 if the last health check could authenticate against the registry, 0 otherwise.",
+	}, []string{"registry"})
+	metricRegistryHealthCheckFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registry_health_check_failures_total",
+		Help:      "Failed registry health checks.",
+	}, []string{"registry"})
+	metricCredentialSourceAvailable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_source_available",
+		Help:      "1 if the credential could be loaded on the last attempt, 0 otherwise.",
+	})
+	metricCredentialInvalid = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_invalid",
+		Help:      "1 if the last loaded credential was refused as a malformed dockerconfigjson, 0 otherwise.",
+	})
+	metricCredentialStale = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_stale",
+		Help:      "1 if the last known good credential is distributed as the source failed to load, 0 otherwise.",
+	})
+	metricCredentialSourceActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_source_active",
+		Help:      "1 for the credential source the last load used, 0 for the other configured sources.",
+	}, []string{"source"})
+	metricCredentialSourceFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_source_failures_total",
+		Help:      "Failed loads of a credential source.",
+	}, []string{"source"})
+	metricNamespacesBackedOff = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "namespaces_backed_off_total",
+		Help:      "Namespaces left out of a loop because they failed in a row recently.",
+	})
+	metricConsecutiveFailedLoops = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "consecutive_failed_loops",
+		Help:      "Loops failed in a row, e.g. because the namespaces could not be listed, reset by a successful loop.",
+	})
+	metricNamespaceVerificationsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "namespace_verifications_skipped_total",
+		Help:      "Namespaces skipped by a loop because they were verified in sync more recently than the re-verification age.",
+	})
+	metricNamespaceInSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "namespace_in_sync",
+		Help:      "1 if the last reconcile of the namespace succeeded and none of its secrets drifted, 0 otherwise.",
+	}, []string{"namespace"})
+	metricNamespacesOutOfSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "namespaces_out_of_sync",
+		Help:      "Namespaces whose last reconcile failed or found a drifted secret.",
+	})
+	metricSecretDrifted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_drifted",
+		Help:      "1 if the managed secret differs from the one the patcher would write in detect-only mode, 0 otherwise.",
+	}, []string{"namespace", "secret"})
+	metricSecretDriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_drift_detected_total",
+		Help:      "Drifted secrets found in detect-only mode.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricBuildInfo,
+		metricCredentialAge,
+		metricCredentialExpiry,
+		metricCredentialRotationSLAExceeded,
+		metricServiceAccountFieldConflicts,
+		metricServiceAccountPatchesThrottled,
+		metricClientRefreshes,
+		metricRegistryHealthy,
+		metricRegistryHealthCheckFailures,
+		metricNamespaceVerificationsSkipped,
+		metricConsecutiveFailedLoops,
+		metricNamespacesBackedOff,
+		metricCredentialSourceActive,
+		metricCredentialSourceFailures,
+		metricCredentialSourceAvailable,
+		metricCredentialInvalid,
+		metricCredentialStale,
+		metricNamespaceInSync,
+		metricNamespacesOutOfSync,
+		metricSecretDrifted,
+		metricSecretDriftDetected,
+	)
+	metricBuildInfo.WithLabelValues(version, buildCommit(), runtime.Version()).Set(1)
+}
+
+// serveMetrics exposes the prometheus metrics and the health probes on addr
+// in the background, until ctx is done
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	// the workqueue and client metrics of the controllers have their own registry
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, crmetrics.Registry}, promhttp.HandlerOpts{})))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	server := newHTTPServer(ctx, addr, mux)
+	go func() {
+		log.Infof("Serving metrics on %s", addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("Metrics server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// additionalSecret is a managed secret following the first name of
+// `secretname`, distributed along with it from its own credential sources
+type additionalSecret struct {
+	name    string
+	sources []credentialSource
+	// content is the last credential loaded, kept while the sources fail
+	content string
+}
+
+// additionalSecrets are parsed from `secretname` and
+// `secret-credential-sources`
+var additionalSecrets []*additionalSecret
+
+// parseAdditionalSecrets pairs names with their credential sources, given as
+// `name=source,source;name=source` in the syntax of `credential-sources`
+func parseAdditionalSecrets(names []string, spec string) ([]*additionalSecret, error) {
+	sources := map[string][]credentialSource{}
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not in the form name=sources", entry)
+		}
+		if _, ok := sources[name]; ok {
+			return nil, fmt.Errorf("secret %s is given twice", name)
+		}
+		parsed, err := parseCredentialSources(list)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %v", name, err)
+		}
+		if len(parsed) == 0 {
+			return nil, fmt.Errorf("secret %s lacks a credential source", name)
+		}
+		sources[name] = parsed
+	}
+	secrets := []*additionalSecret{}
+	for _, name := range names {
+		s, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("secret %s lacks credential sources", name)
+		}
+		delete(sources, name)
+		secrets = append(secrets, &additionalSecret{name: name, sources: s})
+	}
+	for name := range sources {
+		return nil, fmt.Errorf("secret %s is not listed in `secretname`", name)
+	}
+	return secrets, nil
+}
+
+// loadAdditionalSecrets loads the credential of every additional secret,
+// keeping the previous one of those whose sources fail
+func loadAdditionalSecrets(ctx context.Context) {
+	for _, s := range additionalSecrets {
+		content, err := loadCredentialChain(ctx, s.sources)
+		if err == nil && configValidateCredential {
+			err = validateDockerConfigJSON(content)
+		}
+		if err != nil {
+			log.Errorf("Failed to load the credential of secret [%s], keeping the previous one: %v", s.name, err)
+			continue
+		}
+		s.content = content
+		updateCredentialAge(s.name, content)
+	}
+}
+
+// additionalSecretsHash sums up the credentials of the additional secrets
+func additionalSecretsHash() string {
+	contents := []string{}
+	for _, s := range additionalSecrets {
+		contents = append(contents, s.name+"="+s.content)
+	}
+	return credentialHash(strings.Join(contents, "\x00"))
+}
+
+// managedSecretNames returns the names of all managed secrets, the one of
+// the main credential first
+func managedSecretNames() []string {
+	names := []string{configSecretName}
+	for _, s := range additionalSecrets {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// isManagedSecretName tells whether name is one of the managed secrets
+func isManagedSecretName(name string) bool {
+	for _, n := range managedSecretNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// processAdditionalSecrets makes sure the additional secrets of namespace
+// hold their credentials, leaving out those never loaded
+func processAdditionalSecrets(ctx context.Context, k8s *k8sClient, namespace string) error {
+	for _, s := range additionalSecrets {
+		if s.content == "" {
+			nsLog(namespace).WithField(logFieldSecret, s.name).Warn("Credential of secret is not loaded yet, skipped")
+			continue
+		}
+		if err := syncSecret(ctx, k8s, namespace, s.name, s.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseAdditionalSecrets(t *testing.T) {
+	secrets, err := parseAdditionalSecrets([]string{"dockerhub", "quay"}, "dockerhub=file:/a,env:B; quay=env:C")
+	if err != nil {
+		t.Fatalf("parseAdditionalSecrets failed: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0].name != "dockerhub" || len(secrets[0].sources) != 2 || secrets[1].name != "quay" {
+		t.Errorf("parseAdditionalSecrets gives %+v", secrets)
+	}
+	for _, spec := range []string{"dockerhub=file:/a", "dockerhub=file:/a;quay=env:C;gcr=env:D", "dockerhub=file:/a;dockerhub=env:B;quay=env:C", "dockerhub;quay=env:C"} {
+		if _, err := parseAdditionalSecrets([]string{"dockerhub", "quay"}, spec); err == nil {
+			t.Errorf("parseAdditionalSecrets(%q) should fail", spec)
+		}
+	}
+}
+
+func TestProcessAdditionalSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testDockerconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	additionalSecrets = []*additionalSecret{
+		{name: "dockerhub", sources: []credentialSource{fileCredentialSource{path: path}}},
+		{name: "quay", sources: []credentialSource{envCredentialSource{variable: "UNSET_QUAY_DOCKERCONFIGJSON"}}},
+	}
+	defer func() { additionalSecrets = nil }()
+	loadAdditionalSecrets(context.TODO())
+
+	clientset := fake.NewSimpleClientset()
+	k8s := &k8sClient{clientset: clientset}
+	if err := processAdditionalSecrets(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processAdditionalSecrets failed: %v", err)
+	}
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), "dockerhub", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("additional secret not created: %v", err)
+	}
+	if verifySecretContent(secret, testDockerconfig) != secretOk {
+		t.Errorf("additional secret does not hold its credential")
+	}
+	if _, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), "quay", metav1.GetOptions{}); err == nil {
+		t.Errorf("secret whose credential never loaded should not be created")
+	}
+
+	sa := &corev1.ServiceAccount{ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}}}
+	if serviceAccountPatched(sa, managedSecretNames()...) {
+		t.Errorf("service account without the additional secrets should not be patched already")
+	}
+	actual, err := getPatchString(sa, managedSecretNames()...)
+	if err != nil {
+		t.Fatalf("getPatchString has error %v", err)
+	}
+	expected := `{"imagePullSecrets":[{"name":"` + configSecretName + `"},{"name":"dockerhub"},{"name":"quay"}]}`
+	if string(actual) != expected {
+		t.Errorf("getPatchString gives %s, expects %s", actual, expected)
+	}
+}
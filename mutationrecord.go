@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	mutationCreate = "create"
+	mutationDelete = "delete"
+	mutationPatch  = "patch"
+)
+
+var mutationRecordGVR = schema.GroupVersionResource{
+	Group:    "k8s.titansoft.com",
+	Version:  "v1alpha1",
+	Resource: "mutationrecords",
+}
+
+// mutationRecord builds the MutationRecord of an action on the object kind/name in namespace
+func mutationRecord(namespace, action, kind, name, reason string, now time.Time) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": mutationRecordGVR.GroupVersion().String(),
+			"kind":       "MutationRecord",
+			"metadata": map[string]interface{}{
+				"name":      fmt.Sprintf("%s-%s-%s", strings.ToLower(kind), name, newCorrelationID()),
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			"spec": map[string]interface{}{
+				"action": action,
+				"object": map[string]interface{}{
+					"kind": kind,
+					"name": name,
+				},
+				"reason":         reason,
+				"credentialHash": credentialHash(credentialFor(namespace)),
+				"timestamp":      now.UTC().Format(time.RFC3339),
+			},
+		},
+	}
+}
+
+// recordMutation persists a MutationRecord, emits an Event and appends to the
+// audit log when enabled, a failure is only logged as the mutation itself
+// already happened
+func recordMutation(ctx context.Context, k8s *k8sClient, namespace, action, kind, name, reason string) {
+	recordContentMutation(ctx, k8s, namespace, action, kind, name, reason, "")
+}
+
+// recordContentMutation is recordMutation for an object which held the
+// credential hashed as oldHash before the mutation
+func recordContentMutation(ctx context.Context, k8s *k8sClient, namespace, action, kind, name, reason, oldHash string) {
+	emitMutationEvent(ctx, k8s, namespace, action, kind, name, reason)
+	auditMutation(namespace, action, kind, name, reason, oldHash)
+	if !configMutationRecords {
+		return
+	}
+	record := mutationRecord(namespace, action, kind, name, reason, time.Now())
+	_, err := k8s.dynamic.Resource(mutationRecordGVR).Namespace(namespace).Create(ctx, record, metav1.CreateOptions{})
+	if err != nil {
+		nsLog(namespace).Warnf("Failed to record %s of %s [%s]: %v", action, kind, name, err)
+	}
+}
+
+// pruneMutationRecords deletes the MutationRecords older than the TTL in all namespaces
+func pruneMutationRecords(ctx context.Context, k8s *k8sClient, ttl time.Duration, now time.Time) error {
+	client := k8s.dynamic.Resource(mutationRecordGVR)
+	records, err := client.Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: annotationManagedBy + "=" + annotationAppName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list MutationRecords: %v", err)
+	}
+	for _, record := range records.Items {
+		timestamp, _, _ := unstructured.NestedString(record.Object, "spec", "timestamp")
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err == nil && now.Sub(t) < ttl {
+			continue
+		}
+		err = client.Namespace(record.GetNamespace()).Delete(ctx, record.GetName(), metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to delete MutationRecord [%s/%s]: %v", record.GetNamespace(), record.GetName(), err)
+		}
+		nsLog(record.GetNamespace()).WithField(logFieldAction, mutationDelete).Debugf("Deleted expired MutationRecord [%s]", record.GetName())
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newMutationRecordClient(objects ...runtime.Object) *k8sClient {
+	return &k8sClient{
+		dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			mutationRecordGVR: "MutationRecordList",
+		}, objects...),
+	}
+}
+
+func TestRecordMutation(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	dockerConfigJSON = testDockerconfig
+	k8s := newMutationRecordClient()
+
+	configMutationRecords = false
+	recordMutation(context.TODO(), k8s, "default", mutationCreate, "Secret", configSecretName, "SecretNotFound")
+	records, err := k8s.dynamic.Resource(mutationRecordGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Items) != 0 {
+		t.Fatalf("recordMutation should not record when disabled, got %d records", len(records.Items))
+	}
+
+	configMutationRecords = true
+	defer func() { configMutationRecords = false }()
+	recordMutation(context.TODO(), k8s, "default", mutationCreate, "Secret", configSecretName, "SecretNotFound")
+	records, err = k8s.dynamic.Resource(mutationRecordGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Items) != 1 {
+		t.Fatalf("recordMutation should record once, got %d records", len(records.Items))
+	}
+	spec := records.Items[0].Object["spec"].(map[string]interface{})
+	if spec["action"] != mutationCreate || spec["reason"] != "SecretNotFound" || spec["credentialHash"] != credentialHash(testDockerconfig) {
+		t.Errorf("unexpected MutationRecord spec: %v", spec)
+	}
+	if name, _, _ := unstructured.NestedString(spec, "object", "name"); name != configSecretName {
+		t.Errorf("MutationRecord object name = %q, want %q", name, configSecretName)
+	}
+}
+
+func TestPruneMutationRecords(t *testing.T) {
+	now := time.Now()
+	k8s := newMutationRecordClient(
+		mutationRecord("default", mutationCreate, "Secret", "expired", "SecretNotFound", now.Add(-2*time.Hour)),
+		mutationRecord("default", mutationCreate, "Secret", "recent", "SecretNotFound", now.Add(-time.Minute)),
+	)
+	if err := pruneMutationRecords(context.TODO(), k8s, time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+	records, err := k8s.dynamic.Resource(mutationRecordGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Items) != 1 {
+		t.Fatalf("pruneMutationRecords should keep 1 record, got %d", len(records.Items))
+	}
+	if name, _, _ := unstructured.NestedString(records.Items[0].Object, "spec", "object", "name"); name != "recent" {
+		t.Errorf("pruneMutationRecords kept the record of %q, want %q", name, "recent")
+	}
+}
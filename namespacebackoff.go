@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// namespaceFailure records the failures in a row of a namespace, and until
+// when the loop leaves it out
+type namespaceFailure struct {
+	count int
+	until time.Time
+}
+
+var (
+	namespaceFailuresMu sync.Mutex
+	namespaceFailures   = map[string]namespaceFailure{}
+)
+
+// namespaceFailurePolicy backs a failing namespace off for a loop duration,
+// doubling with every failure in a row up to maxBackoff
+func namespaceFailurePolicy(maxBackoff time.Duration) retryPolicy {
+	initial := configLoopDuration
+	if initial > maxBackoff {
+		initial = maxBackoff
+	}
+	return retryPolicy{
+		initialBackoff: initial,
+		maxBackoff:     maxBackoff,
+		multiplier:     2,
+	}
+}
+
+// recordNamespaceFailure backs namespace off after its reconcile failed at
+// now, returning the backoff
+func recordNamespaceFailure(namespace string, maxBackoff time.Duration, now time.Time) time.Duration {
+	if maxBackoff <= 0 {
+		return 0
+	}
+	namespaceFailuresMu.Lock()
+	defer namespaceFailuresMu.Unlock()
+	f := namespaceFailures[namespace]
+	f.count++
+	backoff := namespaceFailurePolicy(maxBackoff).backoff(f.count)
+	f.until = now.Add(backoff)
+	namespaceFailures[namespace] = f
+	return backoff
+}
+
+// recordNamespaceSuccess forgets the failures of namespace
+func recordNamespaceSuccess(namespace string) {
+	namespaceFailuresMu.Lock()
+	defer namespaceFailuresMu.Unlock()
+	delete(namespaceFailures, namespace)
+}
+
+// namespaceBackedOff tells whether the loop leaves namespace out at now
+func namespaceBackedOff(namespace string, now time.Time) bool {
+	namespaceFailuresMu.Lock()
+	defer namespaceFailuresMu.Unlock()
+	f, ok := namespaceFailures[namespace]
+	return ok && now.Before(f.until)
+}
+
+// pruneNamespaceFailures forgets namespaces whose backoff ended more than
+// maxBackoff ago, so deleted namespaces don't pile up
+func pruneNamespaceFailures(maxBackoff time.Duration, now time.Time) {
+	namespaceFailuresMu.Lock()
+	defer namespaceFailuresMu.Unlock()
+	for namespace, f := range namespaceFailures {
+		if now.Sub(f.until) >= maxBackoff {
+			delete(namespaceFailures, namespace)
+		}
+	}
+}
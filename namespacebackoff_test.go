@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceBackoff(t *testing.T) {
+	defer func() { namespaceFailures = map[string]namespaceFailure{} }()
+	configLoopDuration = 10 * time.Second
+	now := time.Now()
+
+	if backoff := recordNamespaceFailure("disabled", 0, now); backoff != 0 || namespaceBackedOff("disabled", now) {
+		t.Error("namespace backed off with the backoff disabled")
+	}
+
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		if got := recordNamespaceFailure("noisy", time.Minute, now); got != want {
+			t.Errorf("failure %d: backoff %s, want %s", i+1, got, want)
+		}
+	}
+	if !namespaceBackedOff("noisy", now.Add(59*time.Second)) {
+		t.Error("noisy namespace not backed off")
+	}
+	if namespaceBackedOff("noisy", now.Add(time.Minute)) {
+		t.Error("noisy namespace still backed off after its backoff")
+	}
+	if namespaceBackedOff("other", now) {
+		t.Error("other namespace backed off")
+	}
+
+	recordNamespaceFailure("recovered", time.Minute, now)
+	recordNamespaceSuccess("recovered")
+	if namespaceBackedOff("recovered", now) {
+		t.Error("recovered namespace still backed off")
+	}
+
+	pruneNamespaceFailures(time.Minute, now.Add(2*time.Minute))
+	if _, ok := namespaceFailures["noisy"]; ok {
+		t.Error("pruneNamespaceFailures kept a namespace whose backoff ended long ago")
+	}
+}
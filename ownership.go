@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// the values of annotationOwnership, asking the patcher to change the
+// ownership of an object
+const (
+	// ownershipAdopt makes the patcher take over an object, as if created by it
+	ownershipAdopt = "adopt"
+	// ownershipRelease makes the patcher drop its markers, treating the object
+	// as any unmanaged one, which `adopt-existing` does not take over, for as
+	// long as the annotation stays
+	ownershipRelease = "release"
+	// ownershipOrphan makes the patcher drop its markers and leave the object
+	// alone for as long as the annotation stays
+	ownershipOrphan = "orphan"
+)
+
+// isOwned tells whether the patcher owns obj, which it marks with the
+// managed-by label and annotation, unless asked to change the ownership.
+// Objects of earlier versions only carry the annotation.
+func isOwned(obj metav1.Object) bool {
+	switch ownership(obj) {
+	case ownershipAdopt:
+		return true
+	case ownershipRelease, ownershipOrphan:
+		return false
+	}
+	return obj.GetLabels()[annotationManagedBy] == annotationAppName || obj.GetAnnotations()[annotationManagedBy] == annotationAppName
+}
+
+func ownership(obj metav1.Object) string {
+	v, _ := patcherAnnotation(obj.GetAnnotations(), annotationOwnership)
+	return v
+}
+
+// ownershipPatch returns the merge patch bringing the ownership markers of obj
+// in line with its ownership annotation, and whether any is needed
+func ownershipPatch(obj metav1.Object) (map[string]interface{}, bool) {
+	labels := map[string]interface{}{}
+	annotations := map[string]interface{}{}
+	marked := obj.GetLabels()[annotationManagedBy] == annotationAppName || obj.GetAnnotations()[annotationManagedBy] == annotationAppName
+	switch ownership(obj) {
+	case ownershipAdopt:
+		labels[annotationManagedBy] = annotationAppName
+		annotations[annotationManagedBy] = annotationAppName
+		removeOwnershipAnnotation(annotations)
+	case ownershipRelease, ownershipOrphan:
+		if !marked {
+			return nil, false
+		}
+		labels[annotationManagedBy] = nil
+		annotations[annotationManagedBy] = nil
+		for _, key := range []string{annotationContentHash, annotationLastSyncedAt, annotationControllerVersion} {
+			annotations[key] = nil
+		}
+	default:
+		if !marked && configAdoptExisting {
+			labels[annotationManagedBy] = annotationAppName
+			annotations[annotationManagedBy] = annotationAppName
+		} else if !marked || obj.GetLabels()[annotationManagedBy] == annotationAppName {
+			return nil, false
+		}
+		labels[annotationManagedBy] = annotationAppName
+	}
+	metadata := map[string]interface{}{"annotations": annotations}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	return map[string]interface{}{"metadata": metadata}, true
+}
+
+// removeOwnershipAnnotation removes the ownership annotation in a merge patch,
+// under the configured prefix as well as the default one
+func removeOwnershipAnnotation(annotations map[string]interface{}) {
+	annotations[annotationOwnership] = nil
+	annotations[patcherAnnotationKey(defaultAnnotationPrefix, "ownership")] = nil
+}
+
+// reconcileOwnership adopts, releases or orphans the secret or ConfigMap obj
+// as asked by its ownership annotation, or adopts it with `adopt-existing`,
+// and labels the objects of earlier versions, updating obj in place. It
+// returns whether obj is orphaned and must be left alone.
+func reconcileOwnership(ctx context.Context, k8s *k8sClient, obj metav1.Object) (bool, error) {
+	namespace, name := obj.GetNamespace(), obj.GetName()
+	p, ok := ownershipPatch(obj)
+	if ok {
+		reason := "OwnershipMarked"
+		switch ownership(obj) {
+		case "":
+			if !isOwned(obj) {
+				reason = "OwnershipAdopted"
+			}
+		case ownershipAdopt:
+			reason = "OwnershipAdopted"
+		case ownershipRelease:
+			reason = "OwnershipReleased"
+		case ownershipOrphan:
+			reason = "OwnershipOrphaned"
+		}
+		patch, err := json.Marshal(p)
+		if err != nil {
+			return false, err
+		}
+		var kind string
+		switch o := obj.(type) {
+		case *corev1.Secret:
+			kind = "Secret"
+			var patched *corev1.Secret
+			patched, err = k8s.clientset.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+			if err == nil {
+				*o = *patched
+			}
+		case *corev1.ConfigMap:
+			kind = "ConfigMap"
+			var patched *corev1.ConfigMap
+			patched, err = k8s.clientset.CoreV1().ConfigMaps(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+			if err == nil {
+				*o = *patched
+			}
+		default:
+			return false, fmt.Errorf("Unexpected object %s: %T", name, obj)
+		}
+		if err != nil {
+			return false, fmt.Errorf("Failed to update the ownership of %s [%s]: %v", kind, name, err)
+		}
+		nsLog(namespace).WithField(logFieldAction, mutationPatch).Infof("Updated the ownership of %s [%s]: %s", kind, name, reason)
+		recordMutation(ctx, k8s, namespace, mutationPatch, kind, name, reason)
+	}
+	return ownership(obj) == ownershipOrphan, nil
+}
+
+// leaveUnowned handles an existing kind name of namespace the patcher does not
+// own, which it never overwrites nor deletes unless adopted: a valid one is
+// left alone, an invalid one too, failing the namespace with `managedonly`
+func leaveUnowned(namespace, kind, name string, valid bool) error {
+	field := logFieldSecret
+	if kind == "ConfigMap" {
+		field = logFieldConfigMap
+	}
+	logger := nsLog(namespace).WithField(field, name)
+	switch {
+	case valid:
+		logger.Debugf("%s is unmanaged but valid", kind)
+		return nil
+	case configManagedOnly:
+		return fmt.Errorf("%s [%s] is present but unmanaged", kind, name)
+	}
+	logger.Warnf("%s is unmanaged and not valid, left alone until adopted", kind)
+	return nil
+}
+
+// markedByAnnotationOnly tells whether obj carries the managed-by annotation
+// of earlier versions but not the label
+func markedByAnnotationOnly(obj metav1.Object) bool {
+	return ownership(obj) == "" && obj.GetAnnotations()[annotationManagedBy] == annotationAppName &&
+		obj.GetLabels()[annotationManagedBy] != annotationAppName
+}
+
+// labelMarkedObjects adds the managed-by label to the secrets and ConfigMaps
+// named secretNames and configMapNames which only carry the annotation, so
+// selecting the label finds them before the loop gets to label them
+func labelMarkedObjects(ctx context.Context, k8s *k8sClient, secretNames, configMapNames []string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{annotationManagedBy: annotationAppName},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	options := func(name string) metav1.ListOptions {
+		return metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()}
+	}
+	for _, name := range secretNames {
+		secrets, err := k8s.clientset.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, options(name))
+		if err != nil {
+			return fmt.Errorf("Failed to list secrets [%s]: %v", name, err)
+		}
+		for i := range secrets.Items {
+			secret := &secrets.Items[i]
+			if secret.Name != name || !markedByAnnotationOnly(secret) {
+				continue
+			}
+			if _, err := k8s.clientset.CoreV1().Secrets(secret.Namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+				return fmt.Errorf("Failed to label secret [%s/%s]: %v", secret.Namespace, name, err)
+			}
+			nsLog(secret.Namespace).WithField(logFieldAction, mutationPatch).Infof("Labelled Secret [%s] as managed", name)
+		}
+	}
+	for _, name := range configMapNames {
+		configMaps, err := k8s.clientset.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, options(name))
+		if err != nil {
+			return fmt.Errorf("Failed to list ConfigMaps [%s]: %v", name, err)
+		}
+		for i := range configMaps.Items {
+			configMap := &configMaps.Items[i]
+			if configMap.Name != name || !markedByAnnotationOnly(configMap) {
+				continue
+			}
+			if _, err := k8s.clientset.CoreV1().ConfigMaps(configMap.Namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+				return fmt.Errorf("Failed to label ConfigMap [%s/%s]: %v", configMap.Namespace, name, err)
+			}
+			nsLog(configMap.Namespace).WithField(logFieldAction, mutationPatch).Infof("Labelled ConfigMap [%s] as managed", name)
+		}
+	}
+	return nil
+}
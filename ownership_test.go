@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileOwnership(t *testing.T) {
+	managed := func(annotations map[string]string) map[string]string {
+		annotations[annotationManagedBy] = annotationAppName
+		return annotations
+	}
+	for _, tc := range []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		owned       bool
+		orphaned    bool
+		released    bool
+		patched     bool
+	}{
+		{name: "unmanaged", annotations: map[string]string{}},
+		{name: "labelled", labels: map[string]string{annotationManagedBy: annotationAppName}, annotations: managed(map[string]string{}), owned: true},
+		{name: "earlier version", annotations: managed(map[string]string{}), owned: true, patched: true},
+		{name: "adopt", annotations: map[string]string{annotationOwnership: ownershipAdopt}, owned: true, patched: true},
+		{name: "release", annotations: managed(map[string]string{annotationOwnership: ownershipRelease}), released: true, patched: true},
+		{name: "released", annotations: map[string]string{annotationOwnership: ownershipRelease}, released: true},
+		{name: "orphan", annotations: managed(map[string]string{annotationOwnership: ownershipOrphan}), orphaned: true, patched: true},
+		{name: "orphaned", annotations: map[string]string{annotationOwnership: ownershipOrphan}, orphaned: true},
+	} {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "team-a", Labels: tc.labels, Annotations: tc.annotations}}
+		clientset := fake.NewSimpleClientset(secret.DeepCopy())
+		k8s := &k8sClient{clientset: clientset}
+		orphaned, err := reconcileOwnership(context.TODO(), k8s, secret)
+		if err != nil {
+			t.Fatalf("%s: reconcileOwnership failed: %v", tc.name, err)
+		}
+		if orphaned != tc.orphaned {
+			t.Errorf("%s: orphaned = %v, want %v", tc.name, orphaned, tc.orphaned)
+		}
+		if patched := len(clientset.Actions()) > 0; patched != tc.patched {
+			t.Errorf("%s: patched = %v, want %v", tc.name, patched, tc.patched)
+		}
+		stored, err := clientset.CoreV1().Secrets("team-a").Get(context.TODO(), "s", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []*corev1.Secret{secret, stored} {
+			if isManagedSecret(s) != tc.owned {
+				t.Errorf("%s: managed = %v, want %v", tc.name, isManagedSecret(s), tc.owned)
+			}
+			if tc.owned && s.Labels[annotationManagedBy] != annotationAppName {
+				t.Errorf("%s: owned secret should be labelled, got %v", tc.name, s.Labels)
+			}
+			if kept := tc.orphaned || tc.released; kept != (ownership(s) != "") {
+				t.Errorf("%s: ownership annotation kept = %v, want %v", tc.name, ownership(s) != "", kept)
+			}
+		}
+		// a second reconcile has nothing to do
+		clientset.ClearActions()
+		if _, err := reconcileOwnership(context.TODO(), k8s, stored); err != nil || len(clientset.Actions()) > 0 {
+			t.Errorf("%s: second reconcile patched %v, %v", tc.name, clientset.Actions(), err)
+		}
+	}
+}
+
+func TestProcessSecretLeavesOrphanAlone(t *testing.T) {
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configForce = false
+		dockerConfigJSON = ""
+	}()
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "default", Annotations: map[string]string{annotationOwnership: ownershipOrphan}},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("orphaned secret should be left alone, got %s", action.GetVerb())
+		}
+	}
+}
+
+func TestProcessSecretKeepsReleased(t *testing.T) {
+	configForce = true
+	configAdoptExisting = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configForce = false
+		configAdoptExisting = false
+		dockerConfigJSON = ""
+	}()
+	stale := []byte(`{"auths":{}}`)
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        configSecretName,
+			Namespace:   "default",
+			Labels:      map[string]string{annotationManagedBy: annotationAppName},
+			Annotations: map[string]string{annotationManagedBy: annotationAppName, annotationOwnership: ownershipRelease},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: stale},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	for loop := 1; loop <= 2; loop++ {
+		if err := processSecret(context.TODO(), k8s, "default"); err != nil {
+			t.Fatalf("loop %d: processSecret failed: %v", loop, err)
+		}
+		secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isManagedSecret(secret) || secret.Labels[annotationManagedBy] != "" || secret.Annotations[annotationManagedBy] != "" {
+			t.Errorf("loop %d: released secret should stay unmanaged, got %+v", loop, secret.ObjectMeta)
+		}
+		if string(secret.Data[corev1.DockerConfigJsonKey]) != string(stale) {
+			t.Errorf("loop %d: released secret should not be overwritten", loop)
+		}
+	}
+}
+
+func TestLabelMarkedObjects(t *testing.T) {
+	marked := map[string]string{annotationManagedBy: annotationAppName}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "team-a", Annotations: marked}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "team-b"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "team-a", Annotations: marked}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-configs", Namespace: "team-a", Annotations: marked}},
+	)
+	k8s := &k8sClient{clientset: clientset}
+	if err := labelMarkedObjects(context.TODO(), k8s, []string{"registry", "mirror"}, []string{"aws-configs", "extra"}); err != nil {
+		t.Fatalf("labelMarkedObjects failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		namespace, name string
+		labelled        bool
+	}{
+		{"team-a", "registry", true},
+		{"team-b", "registry", false},
+		{"team-a", "other", false},
+	} {
+		secret, err := clientset.CoreV1().Secrets(tc.namespace).Get(context.TODO(), tc.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if labelled := secret.Labels[annotationManagedBy] == annotationAppName; labelled != tc.labelled {
+			t.Errorf("secret [%s/%s] labelled: %v, expects %v", tc.namespace, tc.name, labelled, tc.labelled)
+		}
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("team-a").Get(context.TODO(), "aws-configs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Labels[annotationManagedBy] != annotationAppName {
+		t.Errorf("ConfigMap marked by the annotation was not labelled")
+	}
+}
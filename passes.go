@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+var (
+	// lastSecretSyncPass and lastSAPatchPass are when a loop last ran the
+	// secret sync and the service account patch pass
+	lastSecretSyncPass time.Time
+	lastSAPatchPass    time.Time
+)
+
+// passDue tells whether a pass run every interval, last at last, is due at now
+func passDue(last time.Time, interval time.Duration, now time.Time) bool {
+	return interval <= 0 || now.Sub(last) >= interval
+}
+
+// loopPasses tells which passes a loop at now runs
+func loopPasses(now time.Time) (secretSync, saPatch bool) {
+	return passDue(lastSecretSyncPass, configSecretSyncInterval, now), passDue(lastSAPatchPass, configSAPatchInterval, now)
+}
+
+// passReconcilers returns the reconcilers of the passes, none for all of
+// them. The secret sync pass covers the secret and the synced ConfigMaps.
+func passReconcilers(secretSync, saPatch bool) []namespaceReconciler {
+	switch {
+	case secretSync && saPatch:
+		return nil
+	case secretSync:
+		return []namespaceReconciler{reconcileSecret, reconcileConfigMaps}
+	default:
+		return []namespaceReconciler{reconcileServiceAccounts}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoopPasses(t *testing.T) {
+	defer func() {
+		configSecretSyncInterval, configSAPatchInterval = 0, 0
+		lastSecretSyncPass, lastSAPatchPass = time.Time{}, time.Time{}
+	}()
+	now := time.Now()
+
+	if secretSync, saPatch := loopPasses(now); !secretSync || !saPatch {
+		t.Error("passes not due without intervals")
+	}
+	if got := passReconcilers(true, true); len(got) != 0 {
+		t.Errorf("full pass got %d reconcilers, want none", len(got))
+	}
+
+	configSecretSyncInterval = 30 * time.Minute
+	configSAPatchInterval = time.Minute
+	lastSecretSyncPass, lastSAPatchPass = now, now
+	if secretSync, saPatch := loopPasses(now.Add(30 * time.Second)); secretSync || saPatch {
+		t.Error("passes due before their interval")
+	}
+	secretSync, saPatch := loopPasses(now.Add(time.Minute))
+	if secretSync || !saPatch {
+		t.Errorf("after a minute got secret sync %t, SA patch %t, want only the SA patch", secretSync, saPatch)
+	}
+	if got := passReconcilers(secretSync, saPatch); len(got) != 1 {
+		t.Errorf("SA patch pass got %d reconcilers, want 1", len(got))
+	}
+	if got := passReconcilers(true, false); len(got) != 2 {
+		t.Errorf("secret sync pass got %d reconcilers, want 2", len(got))
+	}
+	if secretSync, _ := loopPasses(now.Add(30 * time.Minute)); !secretSync {
+		t.Error("secret sync not due after its interval")
+	}
+}
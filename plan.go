@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// mutationOverwrite replaces an invalid object, in place unless its kind or
+// type can't be changed, in which case it is deleted and recreated
+const mutationOverwrite = "overwrite"
+
+// planChange is a single change the patcher would make
+type planChange struct {
+	Action    string `json:"action"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	// ResourceVersion of the changed object when planned, empty for creates
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// plan lists the changes a loop would make, and the namespaces it would fail on
+type plan struct {
+	CredentialHash string       `json:"credentialHash"`
+	Changes        []planChange `json:"changes"`
+	Errors         []string     `json:"errors,omitempty"`
+}
+
+// clusterState holds the objects of a cluster the plan depends on
+type clusterState struct {
+	namespaces []corev1.Namespace
+	// the managed secret by namespace, in the kind of the secret mode
+	secrets         map[string]*unstructured.Unstructured
+	serviceAccounts map[string][]corev1.ServiceAccount
+	// the synced ConfigMaps by namespace and name
+	configMaps map[string]map[string]*corev1.ConfigMap
+}
+
+func newClusterState() *clusterState {
+	return &clusterState{
+		secrets:         map[string]*unstructured.Unstructured{},
+		serviceAccounts: map[string][]corev1.ServiceAccount{},
+		configMaps:      map[string]map[string]*corev1.ConfigMap{},
+	}
+}
+
+// addConfigMap records the synced ConfigMap cm in the state
+func (s *clusterState) addConfigMap(cm *corev1.ConfigMap) {
+	if s.configMaps[cm.Namespace] == nil {
+		s.configMaps[cm.Namespace] = map[string]*corev1.ConfigMap{}
+	}
+	s.configMaps[cm.Namespace][cm.Name] = cm
+}
+
+// managedSecretKind returns the kind of the object distributing the credential
+func managedSecretKind() string {
+	switch configSecretMode {
+	case secretModeExternalSecret:
+		return "ExternalSecret"
+	case secretModeSealedSecret:
+		return "SealedSecret"
+	case secretModeSecretProviderClass:
+		return "SecretProviderClass"
+	}
+	return "Secret"
+}
+
+// add records obj in the state when the plan depends on it
+func (s *clusterState) add(obj *unstructured.Unstructured) error {
+	converter := runtime.DefaultUnstructuredConverter
+	switch {
+	case obj.GetKind() == "Namespace":
+		ns := corev1.Namespace{}
+		if err := converter.FromUnstructured(obj.Object, &ns); err != nil {
+			return err
+		}
+		s.namespaces = append(s.namespaces, ns)
+	case obj.GetKind() == "ServiceAccount":
+		sa := corev1.ServiceAccount{}
+		if err := converter.FromUnstructured(obj.Object, &sa); err != nil {
+			return err
+		}
+		s.serviceAccounts[sa.Namespace] = append(s.serviceAccounts[sa.Namespace], sa)
+	case obj.GetKind() == "ConfigMap" && isSyncedConfigMapName(obj.GetName()):
+		cm := &corev1.ConfigMap{}
+		if err := converter.FromUnstructured(obj.Object, cm); err != nil {
+			return err
+		}
+		s.addConfigMap(cm)
+	case obj.GetKind() == managedSecretKind() && obj.GetName() == configSecretName:
+		s.secrets[obj.GetNamespace()] = obj
+	}
+	return nil
+}
+
+// loadStateDump reads the state from YAML or JSON dumps as written by
+// `kubectl get -o yaml`, either single objects, multiple documents or Lists
+func loadStateDump(r io.Reader) (*clusterState, error) {
+	state := newClusterState()
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				return state.add(item.(*unstructured.Unstructured))
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := state.add(obj); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// loadStateDumpFiles reads the state from the comma-separated dump files
+func loadStateDumpFiles(paths string) (*clusterState, error) {
+	state := newClusterState()
+	for _, path := range strings.Split(paths, ",") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		s, err := loadStateDump(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state dump %s: %v", path, err)
+		}
+		state.namespaces = append(state.namespaces, s.namespaces...)
+		for ns, obj := range s.secrets {
+			state.secrets[ns] = obj
+		}
+		for ns, sas := range s.serviceAccounts {
+			state.serviceAccounts[ns] = append(state.serviceAccounts[ns], sas...)
+		}
+		for _, configMaps := range s.configMaps {
+			for _, cm := range configMaps {
+				state.addConfigMap(cm)
+			}
+		}
+	}
+	return state, nil
+}
+
+// verifyManagedSecret verifies obj in the form of the secret mode, returning
+// whether the process function leaves it alone as unowned, and the reason it
+// is invalid, empty when valid
+func verifyManagedSecret(obj *unstructured.Unstructured) (bool, string, error) {
+	refused := !isOwned(obj)
+	switch configSecretMode {
+	case secretModeExternalSecret:
+		if verifyExternalSecret(obj) {
+			return refused, "", nil
+		}
+		return refused, "SpecNotMatch", nil
+	case secretModeSealedSecret:
+		if result := verifySealedSecret(obj); result != secretOk {
+			return refused, string(result), nil
+		}
+		return refused, "", nil
+	case secretModeSecretProviderClass:
+		if verifySecretProviderClass(obj) {
+			return refused, "", nil
+		}
+		return refused, "SpecNotMatch", nil
+	}
+	secret := &corev1.Secret{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, secret); err != nil {
+		return false, "", err
+	}
+	// processSecret leaves unmanaged secrets alone, unless adopting them
+	refused = !isManagedSecret(secret) && !(configAdoptExisting && ownership(secret) == "")
+	if result := verifySecret(secret); result != secretOk {
+		return refused, string(result), nil
+	}
+	return false, "", nil
+}
+
+// planNamespace returns the changes processNamespace would make to namespace,
+// and the error it would stop at
+func planNamespace(ctx context.Context, state *clusterState, ns corev1.Namespace) ([]planChange, error) {
+	namespace := ns.Name
+	changes := []planChange{}
+	if err := assignNamespaceCredential(ctx, ns); err != nil {
+		return changes, err
+	}
+
+	// secret
+	if configEnableSecretSync {
+		secretChanges, err := planSecret(state, namespace)
+		changes = append(changes, secretChanges...)
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	// synced ConfigMaps
+	var configMapErr error
+	for _, s := range syncedConfigMaps() {
+		if configEnableConfigMapSync && !configMapIsExcluded(ns) {
+			configMapChanges, err := planSyncedConfigMap(state, namespace, s)
+			changes = append(changes, configMapChanges...)
+			if err != nil && configMapErr == nil {
+				configMapErr = err
+			}
+		} else if cm, ok := state.configMaps[namespace][s.name]; ok && configPruneConfigMaps && isManagedConfigMap(cm) {
+			changes = append(changes, planChange{mutationDelete, namespace, "ConfigMap", s.name, "ConfigMapSyncDisabled", cm.ResourceVersion})
+		}
+	}
+	if configMapErr != nil {
+		return changes, configMapErr
+	}
+
+	// service accounts
+	if !configEnableSAPatch {
+		return changes, nil
+	}
+	for _, sa := range state.serviceAccounts[namespace] {
+		if !serviceAccountTargeted(&sa) {
+			continue
+		}
+		if !serviceAccountPatched(&sa, managedSecretNames()...) {
+			changes = append(changes, planChange{mutationPatch, namespace, "ServiceAccount", sa.Name, "ImagePullSecretMissing", sa.ResourceVersion})
+		}
+	}
+	return changes, nil
+}
+
+// planSecret returns the changes the process function of the secret mode would
+// make to namespace, and the error it would stop at
+func planSecret(state *clusterState, namespace string) ([]planChange, error) {
+	changes := []planChange{}
+	kind := managedSecretKind()
+	obj, ok := state.secrets[namespace]
+	if !ok {
+		return append(changes, planChange{mutationCreate, namespace, kind, configSecretName, "SecretNotFound", ""}), nil
+	}
+	refused, reason, err := verifyManagedSecret(obj)
+	if err != nil {
+		return changes, fmt.Errorf("Failed to read %s: %v", kind, err)
+	}
+	if refused {
+		if reason != "" && configManagedOnly {
+			return changes, fmt.Errorf("%s [%s] is present but unmanaged", kind, configSecretName)
+		}
+		return changes, nil
+	}
+	if configSecretMode == secretModeSecret && configAdoptExisting && ownership(obj) == "" && !isOwned(obj) {
+		changes = append(changes, planChange{mutationPatch, namespace, kind, configSecretName, "OwnershipAdopted", obj.GetResourceVersion()})
+	}
+	if reason == string(secretAuthsMissing) || (configServerSideApply && configSecretMode == secretModeSecret && (reason == string(secretNoKey) || reason == string(secretDataNotMatch))) {
+		return append(changes, planChange{mutationPatch, namespace, kind, configSecretName, reason, obj.GetResourceVersion()}), nil
+	}
+	if reason != "" {
+		if !configForce {
+			return changes, fmt.Errorf("%s is not valid, set --force to true to overwrite", kind)
+		}
+		changes = append(changes, planChange{mutationOverwrite, namespace, kind, configSecretName, reason, obj.GetResourceVersion()})
+	}
+	return changes, nil
+}
+
+// planSyncedConfigMap returns the changes processSyncedConfigMap would make
+// to namespace, and the error it would stop at
+func planSyncedConfigMap(state *clusterState, namespace string, s configMapSync) ([]planChange, error) {
+	changes := []planChange{}
+	desired, desiredErr := s.configMap(namespace)
+	if cm, ok := state.configMaps[namespace][s.name]; !ok {
+		if desiredErr == nil {
+			changes = append(changes, planChange{mutationCreate, namespace, "ConfigMap", s.name, "ConfigMapNotFound", ""})
+		}
+	} else {
+		if !isManagedConfigMap(cm) {
+			if (desiredErr != nil || !mapsEqual(cm.Data, desired.Data)) && configManagedOnly {
+				return changes, fmt.Errorf("ConfigMap [%s] is present but unmanaged", s.name)
+			}
+			return changes, nil
+		}
+		if desiredErr != nil {
+			if configForce || configPruneConfigMaps {
+				changes = append(changes, planChange{mutationDelete, namespace, "ConfigMap", s.name, "ConfigFileGone", cm.ResourceVersion})
+			}
+		} else if !mapsEqual(cm.Data, desired.Data) && configServerSideApply {
+			changes = append(changes, planChange{mutationPatch, namespace, "ConfigMap", s.name, "ConfigMapDataNotMatch", cm.ResourceVersion})
+		} else if !mapsEqual(cm.Data, desired.Data) {
+			if !configForce {
+				return changes, fmt.Errorf("ConfigMap [%s] is not valid, set --force to true to overwrite", s.name)
+			}
+			changes = append(changes, planChange{mutationOverwrite, namespace, "ConfigMap", s.name, "ConfigMapDataNotMatch", cm.ResourceVersion})
+		}
+	}
+	return changes, nil
+}
+
+// buildPlan plans the changes of a loop over all namespaces of state
+func buildPlan(ctx context.Context, state *clusterState) plan {
+	p := plan{
+		CredentialHash: credentialHash(dockerConfigJSON),
+		Changes:        []planChange{},
+	}
+	namespaces := append([]corev1.Namespace{}, state.namespaces...)
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	for _, ns := range namespaces {
+		if namespaceIsExcluded(ns) {
+			continue
+		}
+		changes, err := planNamespace(ctx, state, ns)
+		p.Changes = append(p.Changes, changes...)
+		if err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("namespace [%s]: %v", ns.Name, err))
+		}
+	}
+	return p
+}
+
+// loadPlanState reads the state from the state dump when given, from the
+// cluster otherwise
+func loadPlanState(ctx context.Context, k8s *k8sClient) (*clusterState, error) {
+	if configStateDump != "" {
+		return loadStateDumpFiles(configStateDump)
+	}
+	if k8s == nil {
+		return nil, fmt.Errorf("`state-dump` is required to plan without a cluster")
+	}
+	return liveClusterState(ctx, k8s)
+}
+
+// runPlan writes the plan to the plan file, or to w when none is configured
+func runPlan(ctx context.Context, k8s *k8sClient, w io.Writer) error {
+	var err error
+	dockerConfigJSON, err = getDockerConfigJSON(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load dockerconfigjson: %v", err)
+	}
+	loadMappedCredentials(ctx)
+	loadAdditionalSecrets(ctx)
+	if err := reloadExcludedNamespacesFile(); err != nil {
+		return err
+	}
+	state, err := loadPlanState(ctx, k8s)
+	if err != nil {
+		return err
+	}
+	p := buildPlan(ctx, state)
+	for _, change := range p.Changes {
+		nsLog(change.Namespace).Infof("Would %s %s [%s]: %s", change.Action, change.Kind, change.Name, change.Reason)
+	}
+	for _, err := range p.Errors {
+		log.Warn(err)
+	}
+	log.Infof("Plan: %d changes in %d namespaces, %d errors", len(p.Changes), len(state.namespaces), len(p.Errors))
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if configPlanFile != "" {
+		return os.WriteFile(configPlanFile, append(b, '\n'), 0644)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
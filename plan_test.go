@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+func testStateObjects() []runtime.Object {
+	valid := dockerconfigSecret("valid")
+	valid.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+	return []runtime.Object{
+		&corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "missing"}},
+		&corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "valid"}},
+		&corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "excluded"}},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "missing"},
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:         metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta:       metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "valid"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}},
+		},
+		valid,
+	}
+}
+
+func setupPlanTest(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configForce = true
+	configManagedOnly = false
+	configAllServiceAccount = true
+	configExcludedNamespaces = "excluded"
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "missing")
+	dockerConfigJSON = testDockerconfig
+	t.Cleanup(func() { configExcludedNamespaces = "" })
+}
+
+var expectedTestPlanChanges = []planChange{
+	{mutationCreate, "missing", "Secret", configSecretName, "SecretNotFound", ""},
+	{mutationPatch, "missing", "ServiceAccount", defaultServiceAccountName, "ImagePullSecretMissing", ""},
+}
+
+func TestLoadStateDumpDocuments(t *testing.T) {
+	setupPlanTest(t)
+	docs := []string{}
+	for _, obj := range testStateObjects() {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, string(b))
+	}
+	state, err := loadStateDump(strings.NewReader(strings.Join(docs, "---\n")))
+	if err != nil {
+		t.Fatalf("loadStateDump failed: %v", err)
+	}
+	p := buildPlan(context.TODO(), state)
+	if !reflect.DeepEqual(p.Changes, expectedTestPlanChanges) {
+		t.Errorf("buildPlan() = %+v, want %+v", p.Changes, expectedTestPlanChanges)
+	}
+}
+
+func TestRunPlanList(t *testing.T) {
+	setupPlanTest(t)
+	configDockerconfigjson = testDockerconfig
+	list := &corev1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+	for _, obj := range testStateObjects() {
+		list.Items = append(list.Items, runtime.RawExtension{Object: obj})
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configStateDump = filepath.Join(t.TempDir(), "dump.json")
+	defer func() { configStateDump = "" }()
+	if err := os.WriteFile(configStateDump, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := runPlan(context.TODO(), nil, out); err != nil {
+		t.Fatalf("runPlan failed: %v", err)
+	}
+	p := plan{}
+	if err := json.Unmarshal(out.Bytes(), &p); err != nil {
+		t.Fatalf("runPlan wrote an invalid plan: %v", err)
+	}
+	if !reflect.DeepEqual(p.Changes, expectedTestPlanChanges) {
+		t.Errorf("runPlan() = %+v, want %+v", p.Changes, expectedTestPlanChanges)
+	}
+	if p.CredentialHash != credentialHash(testDockerconfig) {
+		t.Errorf("runPlan() credential hash = %s, want %s", p.CredentialHash, credentialHash(testDockerconfig))
+	}
+}
+
+func TestPlanNamespaceInvalidSecret(t *testing.T) {
+	setupPlanTest(t)
+	state := newClusterState()
+	secret, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "ns", Labels: map[string]string{annotationManagedBy: annotationAppName}},
+		Type:       corev1.SecretTypeOpaque,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.secrets["ns"] = &unstructured.Unstructured{Object: secret}
+	state.serviceAccounts["ns"] = []corev1.ServiceAccount{{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "ns"}}}
+
+	changes, err := planNamespace(context.TODO(), state, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}})
+	if err != nil {
+		t.Fatalf("planNamespace failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Action != mutationOverwrite || changes[0].Reason != string(secretWrongType) {
+		t.Errorf("planNamespace() = %+v, want an overwrite of the secret and a patch", changes)
+	}
+
+	// without force the service accounts are never reached
+	configForce = false
+	defer func() { configForce = true }()
+	changes, err = planNamespace(context.TODO(), state, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}})
+	if err == nil || len(changes) != 0 {
+		t.Errorf("planNamespace() without force = %+v, %v, want no changes and an error", changes, err)
+	}
+}
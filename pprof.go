@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expvarHandler serves the expvar variables like expvar.Handler, leaving out
+// the command line as it may carry credentials
+func expvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// pprofHandler serves the net/http/pprof profiles under /debug/pprof/ and the
+// expvar runtime variables, e.g. memstats, under /debug/vars. Every request
+// must carry the admin token as a bearer token, and the command line is not
+// served as it may carry credentials.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", expvarHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorizedAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// servePprof exposes the profiling endpoints on addr in the background, until
+// ctx is done
+func servePprof(ctx context.Context, addr string) {
+	server := newHTTPServer(ctx, addr, pprofHandler())
+	go func() {
+		log.Infof("Serving pprof on %s", addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Errorf("Pprof server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPprofHandler(t *testing.T) {
+	configAdminToken = "s3cret"
+	defer func() { configAdminToken = "" }()
+	handler := pprofHandler()
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine", "/debug/vars"} {
+		if rec := get(path, configAdminToken); rec.Code != http.StatusOK {
+			t.Errorf("GET %s answered %d", path, rec.Code)
+		}
+		if rec := get(path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without token answered %d, expects 401", path, rec.Code)
+		}
+		if rec := get(path, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with wrong token answered %d, expects 401", path, rec.Code)
+		}
+	}
+	if rec := get("/debug/pprof/cmdline", configAdminToken); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /debug/pprof/cmdline answered %d, expects 404", rec.Code)
+	}
+	if rec := get("/debug/vars", configAdminToken); strings.Contains(rec.Body.String(), `"cmdline"`) {
+		t.Errorf("GET /debug/vars serves the command line: %s", rec.Body.String())
+	}
+	if rec := get("/metrics", configAdminToken); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics answered %d, expects 404", rec.Code)
+	}
+}
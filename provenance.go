@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// version and commit are the version and git commit of the patcher, set at
+// build time with `-ldflags "-X main.version=<version> -X main.commit=<sha>"`
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// buildCommit returns the git commit the patcher was built from, read from the
+// VCS information of the binary when not set at build time
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// versionString describes the build of the patcher
+func versionString() string {
+	return fmt.Sprintf("%s %s (commit %s, %s)", annotationAppName, version, buildCommit(), runtime.Version())
+}
+
+// provenanceAnnotations returns the annotations recording on a managed object
+// the content it was generated from, when it was last written and by which
+// version of the patcher
+func provenanceAnnotations(content string) map[string]string {
+	return map[string]string{
+		annotationContentHash:       credentialHash(content),
+		annotationLastSyncedAt:      time.Now().UTC().Format(time.RFC3339),
+		annotationControllerVersion: version,
+	}
+}
+
+// isProvenanceAnnotation tells whether key is one of the annotations set by
+// provenanceAnnotations
+func isProvenanceAnnotation(key string) bool {
+	return key == annotationContentHash || key == annotationLastSyncedAt || key == annotationControllerVersion
+}
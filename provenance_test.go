@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvenanceAnnotations(t *testing.T) {
+	dockerConfigJSON = testDockerconfig
+	defer func() { dockerConfigJSON = "" }()
+	secret := dockerconfigSecret("team-a")
+	if secret.Annotations[annotationContentHash] != credentialHash(testDockerconfig) {
+		t.Errorf("secret should record the hash of its credential, got %v", secret.Annotations)
+	}
+	if _, err := time.Parse(time.RFC3339, secret.Annotations[annotationLastSyncedAt]); err != nil {
+		t.Errorf("secret should record its sync time: %v", err)
+	}
+	if secret.Annotations[annotationControllerVersion] != version {
+		t.Errorf("secret should record the controller version, got %v", secret.Annotations)
+	}
+
+	path := filepath.Join(t.TempDir(), "aws.env")
+	if err := os.WriteFile(path, []byte("AWS_REGION=eu-west-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := configAWSConfigFilePath
+	configAWSConfigFilePath = path
+	defer func() { configAWSConfigFilePath = oldPath }()
+	configMap, err := awsConfigMap("team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Annotations[annotationContentHash] != credentialHash("AWS_REGION=eu-west-1\n") || configMap.Annotations[annotationLastSyncedAt] == "" {
+		t.Errorf("ConfigMap should record its provenance, got %v", configMap.Annotations)
+	}
+	if !isManagedConfigMap(configMap) {
+		t.Errorf("ConfigMap should stay managed")
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	defer func(previous string) { commit = previous }(commit)
+	commit = "0123abc"
+	if actual := versionString(); !strings.HasPrefix(actual, "imagepullsecret-patcher dev (commit 0123abc, go") {
+		t.Errorf("unexpected version %q", actual)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshingCredential caches the dockerconfigjson generated by a provider
+// until it is about to expire
+type refreshingCredential struct {
+	mu        sync.Mutex
+	content   string
+	expiresAt time.Time
+}
+
+// get returns the cached dockerconfigjson, generating a new one refreshBefore
+// the cached one expires
+func (c *refreshingCredential) get(now time.Time, refreshBefore time.Duration, generate func() (string, time.Time, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.content != "" && now.Before(c.expiresAt.Add(-refreshBefore)) {
+		return c.content, nil
+	}
+	content, expiresAt, err := generate()
+	if err != nil {
+		return "", err
+	}
+	c.content = content
+	c.expiresAt = expiresAt
+	return content, nil
+}
+
+// renderDockerConfigJSON renders the base64 encoded `<user>:<password>` auth
+// of each registry as dockerconfigjson
+func renderDockerConfigJSON(auths map[string]string) (string, error) {
+	if len(auths) == 0 {
+		return "", fmt.Errorf("no registry to authenticate to")
+	}
+	config := dockerConfig{Auths: map[string]dockerConfigAuth{}}
+	for registry, auth := range auths {
+		config.Auths[registry] = dockerConfigAuth{Auth: auth}
+	}
+	b, err := json.Marshal(config)
+	return string(b), err
+}
+
+// splitCommaList returns the non-empty items of a comma-separated list
+func splitCommaList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactedValue replaces the credentials in the logs and logged configuration
+const redactedValue = "REDACTED"
+
+// redactMinLength is the length below which a registered value is not masked,
+// as it would mask unrelated words of every line
+const redactMinLength = 4
+
+// redactedFields matches the values of the dockerconfigjson fields holding
+// credentials, including within JSON quoted in an error message
+var redactedFields = regexp.MustCompile(`(?i)(\\?"(?:auth|password|identitytoken|registrytoken)\\?"\s*:\s*\\?")(?:[^"\\]|\\[^"])*(\\?")`)
+
+var (
+	redactedSecretsMu sync.RWMutex
+	// redactedSources holds the credentials currently loaded by every source,
+	// replaced when the source rotates them so the list does not grow
+	redactedSources = map[string][]string{}
+	// redactedSecrets holds the credentials of all sources, longest first so
+	// a secret is masked before the shorter ones it contains
+	redactedSecrets []string
+)
+
+func init() {
+	log.AddHook(redactionHook{})
+}
+
+// registerSecrets masks values in every line logged from now on, in place of
+// the values source registered before
+func registerSecrets(source string, values ...string) {
+	kept := []string{}
+	for _, value := range values {
+		if len(strings.TrimSpace(value)) >= redactMinLength {
+			kept = append(kept, value)
+		}
+	}
+	redactedSecretsMu.Lock()
+	defer redactedSecretsMu.Unlock()
+	if len(kept) == 0 {
+		delete(redactedSources, source)
+	} else {
+		redactedSources[source] = kept
+	}
+	seen := map[string]bool{}
+	secrets := []string{}
+	for _, values := range redactedSources {
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				secrets = append(secrets, value)
+			}
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+	redactedSecrets = secrets
+}
+
+// registerCredential masks the dockerconfigjson content of source, as the
+// secret data holds it and base64 encoded, and the secrets of its auths
+// entries, in every line logged from now on. An empty content, e.g. of a
+// failed load, keeps the credential source registered before.
+func registerCredential(source, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	values := []string{content, base64.StdEncoding.EncodeToString([]byte(content))}
+	config := dockerConfig{}
+	if json.Unmarshal([]byte(content), &config) == nil {
+		for _, auth := range config.Auths {
+			values = append(values, auth.Auth, auth.Password)
+			if username, password := auth.credentials(); password != "" {
+				values = append(values, password, base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+			}
+		}
+	}
+	registerSecrets(source, values...)
+}
+
+// redact masks the credential fields and the registered secrets of s
+func redact(s string) string {
+	s = redactedFields.ReplaceAllString(s, "${1}"+redactedValue+"${2}")
+	redactedSecretsMu.RLock()
+	defer redactedSecretsMu.RUnlock()
+	for _, secret := range redactedSecrets {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, redactedValue)
+		}
+	}
+	return s
+}
+
+// redactionHook masks the credentials of the log lines before they are
+// written, whatever their level
+type redactionHook struct{}
+
+func (redactionHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire redacts the message and the string and error fields of entry, which is
+// a copy of the logged entry so its fields can be replaced
+func (redactionHook) Fire(entry *log.Entry) error {
+	entry.Message = redact(entry.Message)
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			entry.Data[k] = redact(v)
+		case error:
+			if redacted := redact(v.Error()); redacted != v.Error() {
+				entry.Data[k] = errors.New(redacted)
+			}
+		}
+	}
+	return nil
+}
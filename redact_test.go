@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// captureLogs records the entries logged during the test, as the hooks
+// installed before it, such as the redaction one, see them
+func captureLogs(t *testing.T) *test.Hook {
+	hooks := log.LevelHooks{}
+	for level, h := range log.StandardLogger().Hooks {
+		hooks[level] = append([]log.Hook(nil), h...)
+	}
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(hooks) })
+	return test.NewGlobal()
+}
+
+// resetRedactedSecrets forgets the secrets registered during the test
+func resetRedactedSecrets(t *testing.T) {
+	secrets, sources := redactedSecrets, redactedSources
+	redactedSecrets, redactedSources = nil, map[string][]string{}
+	t.Cleanup(func() { redactedSecrets, redactedSources = secrets, sources })
+}
+
+func TestRedact(t *testing.T) {
+	resetRedactedSecrets(t)
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{`{"auths":{"r":{"auth":"dXNlcjpwYXNz"}}}`, `{"auths":{"r":{"auth":"REDACTED"}}}`},
+		{`{"username":"user", "Password" : "hunter2"}`, `{"username":"user", "Password" : "REDACTED"}`},
+		{`{"identitytoken":"eyJhbGciOi.eyJzdWIi.c2ln"}`, `{"identitytoken":"REDACTED"}`},
+		{`invalid secret data "{\"auth\":\"dXNlcjpwYXNz\"}"`, `invalid secret data "{\"auth\":\"REDACTED\"}"`},
+		{`no credential here`, `no credential here`},
+	}
+	for _, test := range tests {
+		if actual := redact(test.s); actual != test.expected {
+			t.Errorf("redact(%q) gives %q, expects %q", test.s, actual, test.expected)
+		}
+	}
+}
+
+func TestRegisterCredential(t *testing.T) {
+	resetRedactedSecrets(t)
+	registerCredential("test", `{"auths":{"r":{"username":"user","password":"hunter2"},"s":{"auth":"`+base64.StdEncoding.EncodeToString([]byte("robot:s3cr3t-token"))+`"}}}`)
+	registerSecrets("short", "abc")
+
+	for _, s := range []string{"hunter2", "s3cr3t-token", base64.StdEncoding.EncodeToString([]byte("user:hunter2"))} {
+		if actual := redact("got " + s); actual != "got "+redactedValue {
+			t.Errorf("redact should mask %q, got %q", s, actual)
+		}
+	}
+	if actual := redact("user robot abc"); actual != "user robot abc" {
+		t.Errorf("usernames and short values should not be masked, got %q", actual)
+	}
+}
+
+func TestRegisterSecretsRotation(t *testing.T) {
+	resetRedactedSecrets(t)
+	registerSecrets("token", "first-token")
+	registerSecrets("other", "other-token")
+	for i := 0; i < 10; i++ {
+		registerSecrets("token", fmt.Sprintf("rotated-token-%d", i))
+	}
+
+	if len(redactedSecrets) != 2 {
+		t.Errorf("rotating a source should replace its secret, got %v", redactedSecrets)
+	}
+	if actual := redact("first-token rotated-token-8 rotated-token-9 other-token"); actual != "first-token rotated-token-8 REDACTED REDACTED" {
+		t.Errorf("only the current secrets should be masked, got %q", actual)
+	}
+	registerCredential("other", "")
+	if actual := redact("other-token"); actual != redactedValue {
+		t.Errorf("an empty credential should keep the secrets of the source, got %q", actual)
+	}
+	registerSecrets("other")
+	if actual := redact("other-token"); actual != "other-token" {
+		t.Errorf("a source without secrets should be forgotten, got %q", actual)
+	}
+}
+
+func TestRedactionHook(t *testing.T) {
+	resetRedactedSecrets(t)
+	defer func(level log.Level) { log.SetLevel(level) }(log.GetLevel())
+	log.SetLevel(log.DebugLevel)
+	var buf bytes.Buffer
+	defer log.SetOutput(log.StandardLogger().Out)
+	log.SetOutput(&buf)
+	hook := captureLogs(t)
+
+	password := "p4ssw0rd-only-in-the-credential"
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:" + password))
+	content := `{"auths":{"registry.example.com":{"auth":"` + auth + `"}}}`
+	configDockerconfigjson = content
+	defer func() { configDockerconfigjson = "" }()
+	if _, err := getDockerConfigJSON(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Debugf("Loaded credential %s", content)
+	log.WithField("data", base64.StdEncoding.EncodeToString([]byte(content))).Debug("Secret data")
+	log.WithError(fmt.Errorf("registry rejected %s", password)).Error("Failed to verify")
+	nsLog("team-a").WithField(logFieldSecret, auth).Warn("Secret is invalid")
+
+	if len(hook.AllEntries()) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(hook.AllEntries()))
+	}
+	out := buf.String()
+	for _, secret := range []string{password, auth, content, base64.StdEncoding.EncodeToString([]byte(content))} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output holds secret %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, redactedValue) {
+		t.Errorf("log output should mark the masked credentials:\n%s", out)
+	}
+	if !strings.Contains(out, "team-a") {
+		t.Errorf("log output should keep the other fields:\n%s", out)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// registryFlagsDockerConfigJSON builds the dockerconfigjson of the registry
+// flags, pairing the n-th URL with the n-th username and password, as
+// `kubectl create secret docker-registry` does
+func registryFlagsDockerConfigJSON(urls, usernames, passwords []string) (string, error) {
+	if len(usernames) != len(urls) || len(passwords) != len(urls) {
+		return "", fmt.Errorf("got %d `registry-url`, %d `registry-username` and %d `registry-password`, one of each is required per registry", len(urls), len(usernames), len(passwords))
+	}
+	config := dockerConfig{Auths: map[string]dockerConfigAuth{}}
+	for i, url := range urls {
+		if url == "" || usernames[i] == "" {
+			return "", fmt.Errorf("registry %d lacks a URL or username", i+1)
+		}
+		if _, ok := config.Auths[url]; ok {
+			return "", fmt.Errorf("registry %s is given twice", url)
+		}
+		config.Auths[url] = dockerConfigAuth{
+			Username: usernames[i],
+			Password: passwords[i],
+			Auth:     base64.StdEncoding.EncodeToString([]byte(usernames[i] + ":" + passwords[i])),
+		}
+	}
+	b, err := json.Marshal(config)
+	return string(b), err
+}
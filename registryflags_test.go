@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRegistryFlagsDockerConfigJSON(t *testing.T) {
+	content, err := registryFlagsDockerConfigJSON([]string{"ghcr.io", "quay.io"}, []string{"bot", "robot"}, []string{"token", "pass:word"})
+	if err != nil {
+		t.Fatalf("registryFlagsDockerConfigJSON has error %v", err)
+	}
+	expected := `{"auths":{"ghcr.io":{"username":"bot","password":"token","auth":"Ym90OnRva2Vu"},"quay.io":{"username":"robot","password":"pass:word","auth":"cm9ib3Q6cGFzczp3b3Jk"}}}`
+	if content != expected {
+		t.Errorf("content %s, want %s", content, expected)
+	}
+
+	for _, tc := range []struct {
+		name                       string
+		urls, usernames, passwords []string
+	}{
+		{"missing password", []string{"ghcr.io"}, []string{"bot"}, nil},
+		{"empty username", []string{"ghcr.io"}, []string{""}, []string{"token"}},
+		{"duplicate registry", []string{"ghcr.io", "ghcr.io"}, []string{"a", "b"}, []string{"a", "b"}},
+	} {
+		if _, err := registryFlagsDockerConfigJSON(tc.urls, tc.usernames, tc.passwords); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
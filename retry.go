@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+)
+
+// retryPolicy describes how often and how fast a failed reconcile is retried
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+}
+
+// retrySleep waits between retries, and retryNow tells the time, replaced in tests
+var (
+	retrySleep = time.Sleep
+	retryNow   = time.Now
+)
+
+// loopRetryPolicy retries a failed loop sooner than the loop duration, backing
+// off up to it
+func loopRetryPolicy() retryPolicy {
+	return retryPolicy{
+		initialBackoff: time.Second,
+		maxBackoff:     configLoopDuration,
+		multiplier:     2,
+	}
+}
+
+func namespaceRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxRetries:     configNamespaceMaxRetries,
+		initialBackoff: configNamespaceRetryInitialBackoff,
+		maxBackoff:     configNamespaceRetryMaxBackoff,
+		multiplier:     configNamespaceRetryMultiplier,
+	}
+}
+
+func (p retryPolicy) validate() error {
+	if p.maxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative")
+	}
+	if p.initialBackoff <= 0 {
+		return fmt.Errorf("initial backoff must be positive")
+	}
+	if p.maxBackoff < p.initialBackoff {
+		return fmt.Errorf("max backoff must not be shorter than the initial backoff")
+	}
+	if p.multiplier < 1 {
+		return fmt.Errorf("backoff multiplier must be at least 1")
+	}
+	return nil
+}
+
+// backoff returns the wait before the given retry, starting at 1
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.initialBackoff)
+	for i := 1; i < retry && d < float64(p.maxBackoff); i++ {
+		d *= p.multiplier
+	}
+	if d > float64(p.maxBackoff) {
+		return p.maxBackoff
+	}
+	return time.Duration(d)
+}
+
+// isTransientAPIError tells whether the API server may well accept the same
+// request once retried, e.g. a conflict, throttling or an internal error
+func isTransientAPIError(err error) bool {
+	return errors.IsConflict(err) || errors.IsTooManyRequests(err) || errors.IsInternalError(err) ||
+		errors.IsServerTimeout(err) || errors.IsTimeout(err) || errors.IsServiceUnavailable(err)
+}
+
+// retryOnTransientError runs a single API operation, retrying it with the
+// client-go default backoff while it fails with a transient error, so the
+// namespace doesn't wait for the next loop
+func retryOnTransientError(operation func() error) error {
+	return retry.OnError(retry.DefaultBackoff, isTransientAPIError, operation)
+}
+
+// processNamespaceWithRetry reconciles ns, or runs only the given reconcilers,
+// retrying failures according to the namespace retry policy
+func processNamespaceWithRetry(ctx context.Context, k8s *k8sClient, ns corev1.Namespace, reconcilers ...namespaceReconciler) error {
+	namespace := ns.Name
+	process := func() error {
+		reconcileMu.RLock()
+		defer reconcileMu.RUnlock()
+		defer lockNamespace(namespace)()
+		return processNamespace(ctx, k8s, ns, reconcilers...)
+	}
+	policy := namespaceRetryPolicy()
+	deadline := retryNow().Add(configNamespaceTimeout)
+	err := process()
+	for retry := 1; err != nil && ctx.Err() == nil && retry <= policy.maxRetries; retry++ {
+		backoff := policy.backoff(retry)
+		if configNamespaceTimeout > 0 && retryNow().Add(backoff).After(deadline) {
+			nsLog(namespace).Warnf("Namespace budget of %s spent, not retrying", configNamespaceTimeout)
+			break
+		}
+		nsLog(namespace).Infof("Retrying in %s (%d/%d)", backoff, retry, policy.maxRetries)
+		retrySleep(backoff)
+		err = process()
+	}
+	return err
+}
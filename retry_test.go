@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{
+		maxRetries:     5,
+		initialBackoff: time.Second,
+		maxBackoff:     5 * time.Second,
+		multiplier:     2,
+	}
+	for retry, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		5: 5 * time.Second,
+	} {
+		if got := policy.backoff(retry); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", retry, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	valid := retryPolicy{maxRetries: 3, initialBackoff: time.Second, maxBackoff: time.Minute, multiplier: 2}
+	if err := valid.validate(); err != nil {
+		t.Errorf("validate() of a valid policy failed: %v", err)
+	}
+	for name, policy := range map[string]retryPolicy{
+		"negative retries":     {maxRetries: -1, initialBackoff: time.Second, maxBackoff: time.Minute, multiplier: 2},
+		"zero initial backoff": {maxRetries: 3, maxBackoff: time.Minute, multiplier: 2},
+		"max below initial":    {maxRetries: 3, initialBackoff: time.Minute, maxBackoff: time.Second, multiplier: 2},
+		"shrinking backoff":    {maxRetries: 3, initialBackoff: time.Second, maxBackoff: time.Minute, multiplier: 0.5},
+	} {
+		if err := policy.validate(); err == nil {
+			t.Errorf("validate() of %s should fail", name)
+		}
+	}
+}
+
+func TestProcessNamespaceWithRetry(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configAllServiceAccount = true
+	dockerConfigJSON = testDockerconfig
+	configNamespaceMaxRetries = 3
+	configNamespaceRetryInitialBackoff = time.Second
+	configNamespaceRetryMaxBackoff = time.Second
+	defer func() { configNamespaceMaxRetries = 0 }()
+	var slept []time.Duration
+	retrySleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { retrySleep = time.Sleep }()
+
+	// newClient returns a client failing the given number of secret creates
+	newClient := func(failures int) *k8sClient {
+		clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault},
+		})
+		clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if failures > 0 {
+				failures--
+				return true, nil, errors.New("create failed")
+			}
+			return false, nil, nil
+		})
+		return &k8sClient{clientset: clientset}
+	}
+
+	if err := processNamespaceWithRetry(context.TODO(), newClient(2), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}); err != nil {
+		t.Fatalf("processNamespaceWithRetry should succeed on the third attempt, got %v", err)
+	}
+	if len(slept) != 2 {
+		t.Errorf("processNamespaceWithRetry should have retried twice, retried %d times", len(slept))
+	}
+
+	slept = nil
+	if err := processNamespaceWithRetry(context.TODO(), newClient(10), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}); err == nil {
+		t.Fatalf("processNamespaceWithRetry should give up after 3 retries")
+	}
+	if len(slept) != 3 {
+		t.Errorf("processNamespaceWithRetry should have retried 3 times, retried %d times", len(slept))
+	}
+}
+
+func TestRetryOnTransientError(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configAllServiceAccount = true
+	configSAPatchMinInterval = 0
+	configBackOffForeignManagers = false
+	gr := schema.GroupResource{Resource: "serviceaccounts"}
+	for name, tc := range map[string]struct {
+		err          error
+		failures     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		"retried internal errors":    {err: apierrors.NewInternalError(errors.New("etcd leader changed")), failures: 2, wantAttempts: 3},
+		"retried throttling":         {err: apierrors.NewTooManyRequests("slow down", 1), failures: 1, wantAttempts: 2},
+		"retried conflicts":          {err: apierrors.NewConflict(gr, defaultServiceAccountName, errors.New("modified")), failures: 1, wantAttempts: 2},
+		"gives up on lasting errors": {err: apierrors.NewServiceUnavailable("unavailable"), failures: 100, wantAttempts: 4, wantErr: true},
+		"fails fast on other errors": {err: apierrors.NewForbidden(gr, defaultServiceAccountName, errors.New("denied")), failures: 100, wantAttempts: 1, wantErr: true},
+	} {
+		clientset := fake.NewSimpleClientset(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault},
+		})
+		attempts, failures := 0, tc.failures
+		clientset.PrependReactor("patch", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			attempts++
+			if failures > 0 {
+				failures--
+				return true, nil, tc.err
+			}
+			return false, nil, nil
+		})
+		err := processServiceAccount(context.TODO(), &k8sClient{clientset: clientset}, corev1.NamespaceDefault)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if attempts != tc.wantAttempts {
+			t.Errorf("%s: expected %d attempts, got %d", name, tc.wantAttempts, attempts)
+		}
+	}
+}
+
+func TestProcessNamespaceWithRetryBudget(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	dockerConfigJSON = testDockerconfig
+	configNamespaceMaxRetries = 10
+	configNamespaceRetryInitialBackoff = time.Second
+	configNamespaceRetryMaxBackoff = time.Second
+	configNamespaceTimeout = 3500 * time.Millisecond
+	defer func() {
+		configNamespaceMaxRetries = 0
+		configNamespaceTimeout = 0
+	}()
+	now := time.Now()
+	retryNow = func() time.Time { return now }
+	retries := 0
+	retrySleep = func(d time.Duration) {
+		retries++
+		now = now.Add(d)
+	}
+	defer func() {
+		retrySleep = time.Sleep
+		retryNow = time.Now
+	}()
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("create failed")
+	})
+	if err := processNamespaceWithRetry(context.TODO(), &k8sClient{clientset: clientset}, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}); err == nil {
+		t.Fatal("processNamespaceWithRetry should fail")
+	}
+	if retries != 3 {
+		t.Errorf("processNamespaceWithRetry should stop retrying once the budget is spent, retried %d times", retries)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// namespaceVerification records when a namespace was last found in sync, and
+// against which desired state
+type namespaceVerification struct {
+	fingerprint string
+	at          time.Time
+}
+
+var (
+	namespaceVerificationsMu sync.Mutex
+	namespaceVerifications   = map[string]namespaceVerification{}
+)
+
+// desiredStateHash fingerprints the inputs of a reconcile besides the
+// namespace itself, so a changed credential or synced ConfigMap file makes
+// every namespace due again
+func desiredStateHash() string {
+	return credentialHash(dockerConfigJSON + "\x00" + mappedCredentialsHash() + "\x00" + additionalSecretsHash() + "\x00" + syncedFilesContent())
+}
+
+// namespaceFingerprint includes the resourceVersion of ns, as changed labels
+// or annotations can change what a reconcile does
+func namespaceFingerprint(ns corev1.Namespace, desired string) string {
+	return desired + "/" + ns.ResourceVersion
+}
+
+// namespaceVerificationDue tells whether ns has to be reconciled, i.e. it was
+// not verified within maxAge before now against the same desired state
+func namespaceVerificationDue(ns corev1.Namespace, desired string, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return true
+	}
+	namespaceVerificationsMu.Lock()
+	defer namespaceVerificationsMu.Unlock()
+	v, ok := namespaceVerifications[ns.Name]
+	return !ok || v.fingerprint != namespaceFingerprint(ns, desired) || now.Sub(v.at) >= maxAge
+}
+
+// recordNamespaceVerified records that ns was found in sync at now
+func recordNamespaceVerified(ns corev1.Namespace, desired string, now time.Time) {
+	namespaceVerificationsMu.Lock()
+	defer namespaceVerificationsMu.Unlock()
+	namespaceVerifications[ns.Name] = namespaceVerification{
+		fingerprint: namespaceFingerprint(ns, desired),
+		at:          now,
+	}
+}
+
+// pruneNamespaceVerifications forgets verifications older than maxAge, which
+// are due anyway, so deleted namespaces don't pile up
+func pruneNamespaceVerifications(maxAge time.Duration, now time.Time) {
+	namespaceVerificationsMu.Lock()
+	defer namespaceVerificationsMu.Unlock()
+	for namespace, v := range namespaceVerifications {
+		if now.Sub(v.at) >= maxAge {
+			delete(namespaceVerifications, namespace)
+		}
+	}
+}
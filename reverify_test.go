@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceVerificationDue(t *testing.T) {
+	defer func() { namespaceVerifications = map[string]namespaceVerification{} }()
+	now := time.Now()
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "1"}}
+	recordNamespaceVerified(ns, "desired", now)
+
+	changedNs := *ns.DeepCopy()
+	changedNs.ResourceVersion = "2"
+	otherNs := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	for name, tc := range map[string]struct {
+		ns      corev1.Namespace
+		desired string
+		maxAge  time.Duration
+		at      time.Time
+		want    bool
+	}{
+		"recently verified":     {ns, "desired", 30 * time.Minute, now.Add(10 * time.Minute), false},
+		"age passed":            {ns, "desired", 30 * time.Minute, now.Add(30 * time.Minute), true},
+		"disabled":              {ns, "desired", 0, now, true},
+		"desired state changed": {ns, "rotated", 30 * time.Minute, now, true},
+		"namespace changed":     {changedNs, "desired", 30 * time.Minute, now, true},
+		"never verified":        {otherNs, "desired", 30 * time.Minute, now, true},
+	} {
+		if got := namespaceVerificationDue(tc.ns, tc.desired, tc.maxAge, tc.at); got != tc.want {
+			t.Errorf("%s: namespaceVerificationDue() = %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestPruneNamespaceVerifications(t *testing.T) {
+	defer func() { namespaceVerifications = map[string]namespaceVerification{} }()
+	now := time.Now()
+	recordNamespaceVerified(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "old"}}, "desired", now.Add(-time.Hour))
+	recordNamespaceVerified(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new"}}, "desired", now)
+
+	pruneNamespaceVerifications(30*time.Minute, now)
+	if _, ok := namespaceVerifications["old"]; ok {
+		t.Errorf("pruneNamespaceVerifications kept a verification older than the max age")
+	}
+	if _, ok := namespaceVerifications["new"]; !ok {
+		t.Errorf("pruneNamespaceVerifications removed a recent verification")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	secretModeSealedSecret = "sealedsecret"
+
+	sealedSecretSessionKeyBytes = 32
+)
+
+var sealedSecretGVR = schema.GroupVersionResource{
+	Group:    "bitnami.com",
+	Version:  "v1alpha1",
+	Resource: "sealedsecrets",
+}
+
+// sealingKey is the public key of the sealed-secrets controller, refreshed every loop
+var sealingKey *rsa.PublicKey
+
+// sealedSecretHashKey keys the HMAC of the credential recorded on the
+// SealedSecrets
+var sealedSecretHashKey []byte
+
+// loadSealedSecretHashKey reads the HMAC key from path, or generates one for
+// the lifetime of the process when path is empty
+func loadSealedSecretHashKey(path string) ([]byte, error) {
+	if path == "" {
+		key := make([]byte, sha256.Size)
+		_, err := io.ReadFull(rand.Reader, key)
+		return key, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SealedSecret hash key: %v", err)
+	}
+	key := bytes.TrimSpace(b)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("SealedSecret hash key %s is empty", path)
+	}
+	return key, nil
+}
+
+// sealedContentHash returns the HMAC of content recorded on the SealedSecrets
+// sealed from it
+func sealedContentHash(content string) string {
+	mac := hmac.New(sha256.New, sealedSecretHashKey)
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSealingKey extracts the RSA public key from the PEM certificate of the
+// sealed-secrets controller
+func parseSealingKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in sealed-secrets certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sealed-secrets certificate: %v", err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sealed-secrets certificate does not hold an RSA public key")
+	}
+	return key, nil
+}
+
+// getSealingKey reads the controller certificate from file when configured,
+// or fetches it from the controller service through the API server proxy
+func getSealingKey(ctx context.Context, k8s *k8sClient) (*rsa.PublicKey, error) {
+	var data []byte
+	var err error
+	if configSealedSecretCertPath != "" {
+		data, err = os.ReadFile(configSealedSecretCertPath)
+	} else {
+		data, err = k8s.clientset.CoreV1().Services(configSealedSecretControllerNamespace).
+			ProxyGet("http", configSealedSecretControllerName, "", "/v1/cert.pem", nil).
+			DoRaw(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sealed-secrets certificate: %v", err)
+	}
+	return parseSealingKey(data)
+}
+
+// hybridEncrypt seals plaintext the same way kubeseal does: a random AES-GCM
+// session key encrypts the data, and is itself encrypted with RSA-OAEP using
+// label to bind the result to a namespace and name
+func hybridEncrypt(rnd io.Reader, key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sealedSecretSessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, 2)
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+	// the session key is used only once, so a zero nonce is safe
+	zeroNonce := make([]byte, aead.NonceSize())
+	return aead.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
+
+// sealedSecret builds a strictly scoped SealedSecret for the managed secret
+func sealedSecret(namespace string) (*unstructured.Unstructured, error) {
+	if sealingKey == nil {
+		return nil, fmt.Errorf("sealed-secrets public key is not loaded")
+	}
+	label := []byte(namespace + "/" + configSecretName)
+	encrypted, err := hybridEncrypt(rand.Reader, sealingKey, []byte(credentialFor(namespace)), label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal secret: %v", err)
+	}
+	annotations := map[string]interface{}{
+		annotationManagedBy: annotationAppName,
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": sealedSecretGVR.GroupVersion().String(),
+			"kind":       "SealedSecret",
+			"metadata": map[string]interface{}{
+				"name":      configSecretName,
+				"namespace": namespace,
+				"annotations": map[string]interface{}{
+					annotationManagedBy:   annotationAppName,
+					annotationContentHMAC: sealedContentHash(credentialFor(namespace)),
+				},
+			},
+			"spec": map[string]interface{}{
+				"encryptedData": map[string]interface{}{
+					corev1.DockerConfigJsonKey: base64.StdEncoding.EncodeToString(encrypted),
+				},
+				"template": map[string]interface{}{
+					"type": string(corev1.SecretTypeDockerConfigJson),
+					"metadata": map[string]interface{}{
+						"name":        configSecretName,
+						"namespace":   namespace,
+						"annotations": annotations,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// verifySealedSecret checks the SealedSecret was sealed from the current
+// credential, as the ciphertext itself differs on every sealing
+func verifySealedSecret(actual *unstructured.Unstructured) verifySecretResult {
+	if t, _, _ := unstructured.NestedString(actual.Object, "spec", "template", "type"); t != string(corev1.SecretTypeDockerConfigJson) {
+		return secretWrongType
+	}
+	if _, ok, _ := unstructured.NestedString(actual.Object, "spec", "encryptedData", corev1.DockerConfigJsonKey); !ok {
+		return secretNoKey
+	}
+	if hash, _ := patcherAnnotation(actual.GetAnnotations(), annotationContentHMAC); hash != sealedContentHash(credentialFor(actual.GetNamespace())) {
+		return secretDataNotMatch
+	}
+	return secretOk
+}
+
+// processSealedSecret makes sure the SealedSecret for the managed secret
+// exists in namespace, leaving the decryption to the sealed-secrets controller
+func processSealedSecret(ctx context.Context, k8s *k8sClient, namespace string) error {
+	client := k8s.dynamic.Resource(sealedSecretGVR).Namespace(namespace)
+	ss, err := client.Get(ctx, configSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		obj, err := sealedSecret(namespace)
+		if err != nil {
+			return fmt.Errorf("Failed to build SealedSecret: %v", err)
+		}
+		_, err = client.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create SealedSecret: %v", err)
+		}
+		nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created SealedSecret")
+		recordMutation(ctx, k8s, namespace, mutationCreate, "SealedSecret", configSecretName, "SecretNotFound")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to GET SealedSecret: %v", err)
+	}
+	if !isOwned(ss) {
+		return leaveUnowned(namespace, "SealedSecret", configSecretName, verifySealedSecret(ss) == secretOk)
+	}
+	result := verifySealedSecret(ss)
+	if result == secretOk {
+		nsLog(namespace).Debug("SealedSecret is valid")
+		return nil
+	}
+	if !configForce {
+		return fmt.Errorf("SealedSecret is not valid, set --force to true to overwrite")
+	}
+	obj, err := sealedSecret(namespace)
+	if err != nil {
+		return fmt.Errorf("Failed to build SealedSecret: %v", err)
+	}
+	nsLog(namespace).Warn("SealedSecret is not valid, overwriting now")
+	// updating in place keeps the SealedSecret, and the secret unsealed from
+	// it, for the whole overwrite, and fails on a concurrent change
+	obj.SetResourceVersion(ss.GetResourceVersion())
+	_, err = client.Update(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to update SealedSecret: %v", err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: configSecretName, logFieldAction: mutationPatch}).Info("Updated SealedSecret")
+	recordMutation(ctx, k8s, namespace, mutationPatch, "SealedSecret", configSecretName, string(result))
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// hybridDecrypt mirrors the sealed-secrets controller to check our sealing
+func hybridDecrypt(key *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	rsaLen := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+rsaLen], label)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[2+rsaLen:], nil)
+}
+
+var testSealingKey *rsa.PrivateKey
+
+func init() {
+	var err error
+	testSealingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestHybridEncrypt(t *testing.T) {
+	label := []byte("default/registry")
+	ciphertext, err := hybridEncrypt(rand.Reader, &testSealingKey.PublicKey, []byte(testDockerconfig), label)
+	if err != nil {
+		t.Fatalf("hybridEncrypt has error %v", err)
+	}
+	plaintext, err := hybridDecrypt(testSealingKey, ciphertext, label)
+	if err != nil {
+		t.Fatalf("hybridDecrypt has error %v", err)
+	}
+	if string(plaintext) != testDockerconfig {
+		t.Errorf("hybridEncrypt round trip gives %s, expects %s", plaintext, testDockerconfig)
+	}
+	if _, err := hybridDecrypt(testSealingKey, ciphertext, []byte("other/registry")); err == nil {
+		t.Errorf("hybridDecrypt with another label expects error")
+	}
+}
+
+var testCasesProcessSealedSecret = []testCase{
+	{
+		name: "no sealed secret",
+		prepSteps: []step{
+			helperSealingKey,
+		},
+		testSteps: []step{
+			processSealedSecretDefault,
+			assertSealedSecretIsValid,
+		},
+	},
+	{
+		name: "has sealed secret of old credential - force on",
+		prepSteps: []step{
+			helperSealingKey,
+			helperForceOn,
+			helperCreateSealedSecret(`{"auths":{}}`),
+			assertHasError(assertSealedSecretIsValid),
+		},
+		testSteps: []step{
+			processSealedSecretDefault,
+			assertSealedSecretIsValid,
+		},
+	},
+	{
+		name: "has sealed secret of old credential - force off",
+		prepSteps: []step{
+			helperSealingKey,
+			helperForceOff,
+			helperCreateSealedSecret(`{"auths":{}}`),
+		},
+		testSteps: []step{
+			assertHasError(processSealedSecretDefault),
+			assertHasError(assertSealedSecretIsValid),
+		},
+	},
+}
+
+func TestProcessSealedSecret(t *testing.T) {
+	for _, tc := range testCasesProcessSealedSecret {
+		runTestCase(t, "ProcessSealedSecret", tc)
+	}
+}
+
+func processSealedSecretDefault(k8s *k8sClient) error {
+	return processSealedSecret(context.TODO(), k8s, v1.NamespaceDefault)
+}
+
+func helperSealingKey(_ *k8sClient) error {
+	sealingKey = &testSealingKey.PublicKey
+	dockerConfigJSON = testDockerconfig
+	return nil
+}
+
+func helperCreateSealedSecret(content string) step {
+	return func(k8s *k8sClient) error {
+		current := dockerConfigJSON
+		dockerConfigJSON = content
+		defer func() { dockerConfigJSON = current }()
+		obj, err := sealedSecret(v1.NamespaceDefault)
+		if err != nil {
+			return err
+		}
+		_, err = k8s.dynamic.Resource(sealedSecretGVR).Namespace(v1.NamespaceDefault).Create(context.TODO(), obj, metav1.CreateOptions{})
+		return err
+	}
+}
+
+func assertSealedSecretIsValid(k8s *k8sClient) error {
+	ss, err := k8s.dynamic.Resource(sealedSecretGVR).Namespace(v1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("assert sealed secret valid but not found")
+	}
+	if result := verifySealedSecret(ss); result != secretOk {
+		return fmt.Errorf("assert sealed secret valid but invalid: %v", result)
+	}
+	encoded, _, _ := unstructured.NestedString(ss.Object, "spec", "encryptedData", corev1.DockerConfigJsonKey)
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	plaintext, err := hybridDecrypt(testSealingKey, ciphertext, []byte(v1.NamespaceDefault+"/"+configSecretName))
+	if err != nil {
+		return fmt.Errorf("assert sealed secret valid but cannot be unsealed: %v", err)
+	}
+	if string(plaintext) != dockerConfigJSON {
+		return fmt.Errorf("assert sealed secret valid but unsealed to %s", plaintext)
+	}
+	return nil
+}
+
+func TestSealedContentHash(t *testing.T) {
+	key := sealedSecretHashKey
+	defer func() { sealedSecretHashKey = key }()
+	helperSealingKey(nil)
+	sealedSecretHashKey = []byte("one key")
+
+	obj, err := sealedSecret(v1.NamespaceDefault)
+	if err != nil {
+		t.Fatalf("sealedSecret has error %v", err)
+	}
+	recorded := obj.GetAnnotations()[annotationContentHMAC]
+	if recorded != sealedContentHash(testDockerconfig) {
+		t.Errorf("sealedSecret records %s, expects %s", recorded, sealedContentHash(testDockerconfig))
+	}
+	if recorded == credentialHash(testDockerconfig) {
+		t.Errorf("sealedSecret records the plain sha256 of the credential")
+	}
+	sealedSecretHashKey = []byte("another key")
+	if sealedContentHash(testDockerconfig) == recorded {
+		t.Errorf("sealedContentHash doesn't depend on its key")
+	}
+}
+
+func TestProcessSealedSecretUpdatesInPlace(t *testing.T) {
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		sealedSecretGVR: "SealedSecretList",
+	})
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset(), dynamic: dynamic}
+	helperSealingKey(k8s)
+	helperForceOn(k8s)
+	if err := helperCreateSealedSecret(`{"auths":{}}`)(k8s); err != nil {
+		t.Fatal(err)
+	}
+	dynamic.ClearActions()
+
+	if err := processSealedSecretDefault(k8s); err != nil {
+		t.Fatalf("processSealedSecret has error %v", err)
+	}
+	for _, action := range dynamic.Actions() {
+		if action.GetVerb() == "delete" || action.GetVerb() == "create" {
+			t.Errorf("processSealedSecret overwrote with %s, expects an update", action.GetVerb())
+		}
+	}
+	if err := assertSealedSecretIsValid(k8s); err != nil {
+		t.Error(err)
+	}
+}
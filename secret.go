@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 
+	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type verifySecretResult string
@@ -22,51 +28,179 @@ const (
 )
 
 // getDockerConfigJSON is a dynamic getter for our secret value. It lets us
-// dynamically fetch the value from file or return the hard coded value,
-// providing a consistent interface for access
-func getDockerConfigJSON() (string, error) {
+// dynamically fetch the value from the credential sources or file, or return
+// the hard coded value, providing a consistent interface for access
+func getDockerConfigJSON(ctx context.Context) (string, error) {
+	if len(credentialSources) > 0 {
+		return loadCredentialChain(ctx, credentialSources)
+	}
 	if configDockerConfigJSONPath != "" {
-		b, ok := ioutil.ReadFile(configDockerConfigJSONPath)
-		return string(b), ok
+		b, err := ioutil.ReadFile(configDockerConfigJSONPath)
+		registerCredential("file:"+configDockerConfigJSONPath, string(b))
+		return string(b), err
 	}
+	registerCredential("dockerconfigjson", configDockerconfigjson)
 	return configDockerconfigjson, nil
 }
 
 func dockerconfigSecret(namespace string) *corev1.Secret {
-	return &corev1.Secret{
+	return namedDockerconfigSecret(namespace, configSecretName, credentialFor(namespace))
+}
+
+// namedDockerconfigSecret returns the managed secret name holding content
+func namedDockerconfigSecret(namespace, name, content string) *corev1.Secret {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	for k, v := range secretLabels {
+		labels[k] = v
+	}
+	for k, v := range secretAnnotations {
+		annotations[k] = v
+	}
+	labels[annotationManagedBy] = annotationAppName
+	annotations[annotationManagedBy] = annotationAppName
+	for k, v := range provenanceAnnotations(content) {
+		annotations[k] = v
+	}
+	secret := &corev1.Secret{
 		ObjectMeta: v1.ObjectMeta{
-			Name:      configSecretName,
-			Namespace: namespace,
-			Annotations: map[string]string{
-				annotationManagedBy: annotationAppName,
-			},
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
-			corev1.DockerConfigJsonKey: []byte(dockerConfigJSON),
+			corev1.DockerConfigJsonKey: []byte(content),
 		},
 		Type: corev1.SecretTypeDockerConfigJson,
 	}
+	if configImmutableSecrets {
+		immutable := true
+		secret.Immutable = &immutable
+	}
+	return secret
+}
+
+func secretIsImmutable(secret *corev1.Secret) bool {
+	return secret.Immutable != nil && *secret.Immutable
+}
+
+// recreateSecret deletes the existing secret and creates it holding content,
+// for the changes an update can't make
+func recreateSecret(ctx context.Context, k8s *k8sClient, secret *corev1.Secret, content, reason string) error {
+	namespace, name := secret.Namespace, secret.Name
+	err := retryOnTransientError(func() error {
+		return k8s.clientset.CoreV1().Secrets(namespace).Delete(ctx, name, v1.DeleteOptions{})
+	})
+	// a retried delete may find the secret gone by the attempt before
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to delete secret [%s]: %v", name, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationDelete}).Warn("Deleted secret")
+	recordContentMutation(ctx, k8s, namespace, mutationDelete, "Secret", name, reason, secretContentHash(secret))
+	err = retryOnTransientError(func() error {
+		_, err := k8s.clientset.CoreV1().Secrets(namespace).Create(ctx, namedDockerconfigSecret(namespace, name, content), v1.CreateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create secret: %v", err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationCreate}).Info("Created secret")
+	recordMutation(ctx, k8s, namespace, mutationCreate, "Secret", name, reason)
+	return nil
 }
 
 func verifySecret(secret *corev1.Secret) verifySecretResult {
+	return verifySecretContent(secret, credentialFor(secret.Namespace))
+}
+
+// verifySecretContent checks the secret holds content
+func verifySecretContent(secret *corev1.Secret, content string) verifySecretResult {
 	if secret.Type != corev1.SecretTypeDockerConfigJson {
 		return secretWrongType
 	}
 	b, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if configMergeExisting {
+		if !dockerConfigJSONContains(string(b), content) {
+			return secretAuthsMissing
+		}
+		return secretOk
+	}
 	if !ok {
 		return secretNoKey
 	}
-	if string(b) != dockerConfigJSON {
+	if string(b) != content {
 		return secretDataNotMatch
 	}
 	return secretOk
 }
 
 func isManagedSecret(secret *corev1.Secret) bool {
-	if k, ok := secret.ObjectMeta.Annotations[annotationManagedBy]; ok {
-		if k == annotationAppName {
-			return true
+	return isOwned(secret)
+}
+
+// secretDigestCurrent checks whether the managed secret exists in namespace
+// and records the digest of the current credential, trusting its data to
+// match without comparing it
+func secretDigestCurrent(ctx context.Context, k8s *k8sClient, namespace string) bool {
+	secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, configSecretName, v1.GetOptions{})
+	return err == nil && secret.Type == corev1.SecretTypeDockerConfigJson &&
+		secretContentHash(secret) == credentialHash(credentialFor(namespace))
+}
+
+// updateSecret overwrites the data of the existing secret with content in
+// place, so pods never find it missing, re-reading it on conflicts
+func updateSecret(ctx context.Context, k8s *k8sClient, secret *corev1.Secret, content, reason string) error {
+	namespace, name := secret.Namespace, secret.Name
+	current := secret.DeepCopy()
+	err := retryOnTransientError(func() error {
+		if current == nil {
+			var err error
+			if current, err = k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{}); err != nil {
+				return err
+			}
+		}
+		desired := namedDockerconfigSecret(namespace, name, content)
+		current.Data = desired.Data
+		current.Immutable = desired.Immutable
+		if current.Labels == nil && len(desired.Labels) > 0 {
+			current.Labels = map[string]string{}
+		}
+		for k, v := range desired.Labels {
+			current.Labels[k] = v
 		}
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+		for k, v := range desired.Annotations {
+			current.Annotations[k] = v
+		}
+		_, err := k8s.clientset.CoreV1().Secrets(namespace).Update(ctx, current, v1.UpdateOptions{FieldManager: fieldManager})
+		current = nil
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update secret [%s]: %v", name, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationPatch}).Info("Updated secret")
+	recordContentMutation(ctx, k8s, namespace, mutationPatch, "Secret", name, reason, secretContentHash(secret))
+	return nil
+}
+
+// annotateSecretDigest records the digest of the current credential on the
+// managed secret, which was found valid
+func annotateSecretDigest(ctx context.Context, k8s *k8sClient, namespace string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{annotationContentHash: credentialHash(credentialFor(namespace))},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = k8s.clientset.CoreV1().Secrets(namespace).Patch(ctx, configSecretName, types.MergePatchType, patch, v1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("Failed to annotate secret with its digest: %v", err)
 	}
-	return false
+	return nil
 }
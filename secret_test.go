@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
 	"testing"
 
+	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const (
-	testDockerconfig = `{"auth":{"gcr.io":{"username":"_json_key","password":"{}"}}}`
+	testDockerconfig = `{"auths":{"gcr.io":{"username":"_json_key","password":"{}"}}}`
 )
 
 var testCasesVerifySecret = []struct {
@@ -120,3 +124,172 @@ func TestIsManagedSecret(t *testing.T) {
 		}
 	}
 }
+
+func TestDigestShortCircuit(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configEnableSecretSync = true
+	configEnableSAPatch = true
+	configEnableConfigMapSync = false
+	defer func() { configEnableConfigMapSync = true }()
+	configPruneConfigMaps = false
+	configAllServiceAccount = true
+	configManagedOnly = false
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	configDigestShortCircuit = true
+	defer func() { configDigestShortCircuit = false }()
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}}
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: corev1.NamespaceDefault}}
+
+	// a valid secret without digest is annotated
+	secret := dockerconfigSecret(corev1.NamespaceDefault)
+	delete(secret.Annotations, annotationContentHash)
+	clientset := fake.NewSimpleClientset(&ns, sa, secret)
+	k8s := &k8sClient{clientset: clientset}
+	if err := processNamespace(context.TODO(), k8s, ns); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil || secret.Annotations[annotationContentHash] != credentialHash(testDockerconfig) {
+		t.Fatalf("secret was not annotated with its digest: %v", err)
+	}
+
+	// a secret with the current digest short-circuits the namespace
+	clientset.ClearActions()
+	if err := processNamespace(context.TODO(), k8s, ns); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "serviceaccounts" || action.GetVerb() != "get" {
+			t.Errorf("unexpected %s %s with a current digest", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	// a changed credential makes the namespace verified again
+	dockerConfigJSON = `{"auths":{}}`
+	defer func() { dockerConfigJSON = testDockerconfig }()
+	if err := processNamespace(context.TODO(), k8s, ns); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil || verifySecret(secret) != secretOk {
+		t.Errorf("secret was not updated after the credential changed: %v", err)
+	}
+}
+
+func TestProcessSecretUpdatesInPlace(t *testing.T) {
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configForce = false
+		dockerConfigJSON = ""
+	}()
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "default", UID: "original", Labels: map[string]string{"team": "a", annotationManagedBy: annotationAppName}},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "delete" {
+			t.Errorf("secret should not be deleted to overwrite its data")
+		}
+	}
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.UID != "original" || secret.Labels["team"] != "a" {
+		t.Errorf("secret should keep its identity and metadata, got %+v", secret.ObjectMeta)
+	}
+	if verifySecret(secret) != secretOk || !isManagedSecret(secret) {
+		t.Errorf("updated secret should be valid and managed")
+	}
+}
+
+func TestProcessSecretRecreatesImmutable(t *testing.T) {
+	configImmutableSecrets = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configImmutableSecrets = false
+		dockerConfigJSON = ""
+	}()
+	immutable := true
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "default", Annotations: map[string]string{annotationManagedBy: annotationAppName}},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Immutable:  &immutable,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	deleted := false
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("immutable secret should not be updated")
+		}
+		deleted = deleted || action.GetVerb() == "delete"
+	}
+	if !deleted {
+		t.Errorf("immutable secret should be deleted to change its data")
+	}
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifySecret(secret) != secretOk || !secretIsImmutable(secret) {
+		t.Errorf("recreated secret should be valid and immutable")
+	}
+}
+
+func TestProcessSecretManagedOnly(t *testing.T) {
+	configManagedOnly = true
+	configForce = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configManagedOnly = false
+		configForce = false
+		configAdoptExisting = false
+		dockerConfigJSON = ""
+	}()
+	stale := map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)}
+	current := map[string][]byte{corev1.DockerConfigJsonKey: []byte(testDockerconfig)}
+	managed := map[string]string{annotationManagedBy: annotationAppName}
+	newClient := func() *k8sClient {
+		return &k8sClient{clientset: fake.NewSimpleClientset(
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "managed", Annotations: managed}, Type: corev1.SecretTypeDockerConfigJson, Data: stale},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "unmanaged"}, Type: corev1.SecretTypeDockerConfigJson, Data: stale},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "valid"}, Type: corev1.SecretTypeDockerConfigJson, Data: current},
+		)}
+	}
+
+	k8s := newClient()
+	if err := processSecret(context.TODO(), k8s, "managed"); err != nil {
+		t.Errorf("managed secret should be overwritten: %v", err)
+	}
+	if err := processSecret(context.TODO(), k8s, "valid"); err != nil {
+		t.Errorf("valid unmanaged secret should be left alone: %v", err)
+	}
+	if err := processSecret(context.TODO(), k8s, "unmanaged"); err == nil {
+		t.Errorf("unmanaged secret should be refused")
+	}
+
+	configAdoptExisting = true
+	k8s = newClient()
+	if err := processSecret(context.TODO(), k8s, "unmanaged"); err != nil {
+		t.Fatalf("unmanaged secret should be adopted: %v", err)
+	}
+	secret, err := k8s.clientset.CoreV1().Secrets("unmanaged").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isManagedSecret(secret) || verifySecret(secret) != secretOk {
+		t.Errorf("adopted secret should be managed and valid, got %+v", secret.ObjectMeta)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	// secretLabels and secretAnnotations are parsed from `secret-labels` and
+	// `secret-annotations`, and set on every managed secret
+	secretLabels      = map[string]string{}
+	secretAnnotations = map[string]string{}
+)
+
+// parseSecretMetadata parses key=value items, checking the keys, and the
+// values when they are label values
+func parseSecretMetadata(items []string, labels bool) (map[string]string, error) {
+	m := map[string]string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the form key=value", item)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+		// the managed-by label marks the secrets the patcher owns
+		if key == annotationManagedBy && (!labels || value != annotationAppName) {
+			return nil, fmt.Errorf("%s is set by the patcher", key)
+		}
+		if labels {
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid value of %s: %s", key, strings.Join(errs, ", "))
+			}
+		} else if isProvenanceAnnotation(key) || key == annotationOwnership {
+			return nil, fmt.Errorf("%s is set by the patcher", key)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// secretMetadataCurrent tells whether the secret carries the configured
+// labels and annotations
+func secretMetadataCurrent(secret *corev1.Secret) bool {
+	for k, v := range secretLabels {
+		if secret.Labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range secretAnnotations {
+		if secret.Annotations[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// patchSecretMetadata adds the configured labels and annotations to the
+// secret
+func patchSecretMetadata(ctx context.Context, k8s *k8sClient, secret *corev1.Secret) error {
+	p := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      secretLabels,
+			"annotations": secretAnnotations,
+		},
+	}
+	if configImmutableSecrets {
+		p["immutable"] = true
+	}
+	patch, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = k8s.clientset.CoreV1().Secrets(secret.Namespace).Patch(ctx, secret.Name, types.MergePatchType, patch, v1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("Failed to label secret [%s]: %v", secret.Name, err)
+	}
+	nsLog(secret.Namespace).WithFields(log.Fields{logFieldSecret: secret.Name, logFieldAction: mutationPatch}).Info("Labeled secret")
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseSecretMetadata(t *testing.T) {
+	m, err := parseSecretMetadata([]string{"app.kubernetes.io/managed-by=imagepullsecret-patcher", " cost-center=platform "}, true)
+	if err != nil {
+		t.Fatalf("parseSecretMetadata failed: %v", err)
+	}
+	if len(m) != 2 || m["cost-center"] != "platform" {
+		t.Errorf("parseSecretMetadata gives %v", m)
+	}
+	for _, items := range [][]string{{"cost-center"}, {"bad key=x"}, {"cost-center=not valid"}} {
+		if _, err := parseSecretMetadata(items, true); err == nil {
+			t.Errorf("parseSecretMetadata(%q) should fail", items)
+		}
+	}
+	if _, err := parseSecretMetadata([]string{annotationManagedBy + "=someone"}, false); err == nil {
+		t.Errorf("parseSecretMetadata should refuse the annotations of the patcher")
+	}
+}
+
+func TestSecretMetadataApplied(t *testing.T) {
+	secretLabels = map[string]string{"cost-center": "platform"}
+	secretAnnotations = map[string]string{"policy.example.com/owner": "platform"}
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		secretLabels = map[string]string{}
+		secretAnnotations = map[string]string{}
+		dockerConfigJSON = ""
+	}()
+	existing := dockerconfigSecret("team-a")
+	existing.Labels = nil
+	clientset := fake.NewSimpleClientset(existing)
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "team-a"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	if err := processSecret(context.TODO(), k8s, "team-b"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	for _, namespace := range []string{"team-a", "team-b"} {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !secretMetadataCurrent(secret) || !isManagedSecret(secret) {
+			t.Errorf("[%s] secret lacks the configured metadata, got %+v", namespace, secret.ObjectMeta)
+		}
+	}
+	if secretMetadataCurrent(&corev1.Secret{}) {
+		t.Errorf("secret without labels should not be current")
+	}
+}
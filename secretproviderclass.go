@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const secretModeSecretProviderClass = "secretproviderclass"
+
+var secretProviderClassGVR = schema.GroupVersionResource{
+	Group:    "secrets-store.csi.x-k8s.io",
+	Version:  "v1",
+	Resource: "secretproviderclasses",
+}
+
+// secretProviderClassParameters are the provider specific parameters parsed
+// from the SecretProviderClass parameters config
+var secretProviderClassParameters = map[string]string{}
+
+// parseSecretProviderClassParameters parses a JSON object of string values
+func parseSecretProviderClassParameters(s string) (map[string]string, error) {
+	parameters := map[string]string{}
+	if s == "" {
+		return parameters, nil
+	}
+	if err := json.Unmarshal([]byte(s), &parameters); err != nil {
+		return nil, fmt.Errorf("invalid SecretProviderClass parameters: %v", err)
+	}
+	return parameters, nil
+}
+
+// secretProviderClass builds a SecretProviderClass which makes the Secrets
+// Store CSI driver fetch the dockerconfigjson from the cloud secret store, and
+// optionally sync it to the managed secret while a pod mounts it
+func secretProviderClass(namespace string) *unstructured.Unstructured {
+	parameters := map[string]interface{}{}
+	for k, v := range secretProviderClassParameters {
+		parameters[k] = v
+	}
+	spec := map[string]interface{}{
+		"provider":   configSecretProviderClassProvider,
+		"parameters": parameters,
+	}
+	if configSecretProviderClassSyncSecret {
+		spec["secretObjects"] = []interface{}{
+			map[string]interface{}{
+				"secretName": configSecretName,
+				"type":       string(corev1.SecretTypeDockerConfigJson),
+				"annotations": map[string]interface{}{
+					annotationManagedBy: annotationAppName,
+				},
+				"data": []interface{}{
+					map[string]interface{}{
+						"objectName": configSecretProviderClassObjectName,
+						"key":        corev1.DockerConfigJsonKey,
+					},
+				},
+			},
+		}
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": secretProviderClassGVR.GroupVersion().String(),
+			"kind":       "SecretProviderClass",
+			"metadata": map[string]interface{}{
+				"name":      configSecretName,
+				"namespace": namespace,
+				"annotations": map[string]interface{}{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// verifySecretProviderClass compares the fields we own, ignoring anything the
+// driver defaults on admission
+func verifySecretProviderClass(actual *unstructured.Unstructured) bool {
+	expected := secretProviderClass(actual.GetNamespace())
+	a, _, _ := unstructured.NestedString(actual.Object, "spec", "provider")
+	e, _, _ := unstructured.NestedString(expected.Object, "spec", "provider")
+	if a != e {
+		return false
+	}
+	actualParameters, _, _ := unstructured.NestedStringMap(actual.Object, "spec", "parameters")
+	if !mapsEqual(actualParameters, secretProviderClassParameters) {
+		return false
+	}
+	actualObjects, _, _ := unstructured.NestedSlice(actual.Object, "spec", "secretObjects")
+	expectedObjects, _, _ := unstructured.NestedSlice(expected.Object, "spec", "secretObjects")
+	if len(actualObjects) != len(expectedObjects) {
+		return false
+	}
+	for i := range expectedObjects {
+		a, ok := actualObjects[i].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		e := expectedObjects[i].(map[string]interface{})
+		for _, field := range []string{"secretName", "type"} {
+			av, _, _ := unstructured.NestedString(a, field)
+			ev, _, _ := unstructured.NestedString(e, field)
+			if av != ev {
+				return false
+			}
+		}
+		actualData, _, _ := unstructured.NestedSlice(a, "data")
+		expectedData, _, _ := unstructured.NestedSlice(e, "data")
+		if len(actualData) != len(expectedData) {
+			return false
+		}
+		for j := range expectedData {
+			ad, ok := actualData[j].(map[string]interface{})
+			if !ok {
+				return false
+			}
+			ed := expectedData[j].(map[string]interface{})
+			for _, field := range []string{"objectName", "key"} {
+				av, _, _ := unstructured.NestedString(ad, field)
+				ev, _, _ := unstructured.NestedString(ed, field)
+				if av != ev {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// processSecretProviderClass makes sure the SecretProviderClass for the managed
+// secret exists in namespace, leaving the secret itself to the CSI driver
+func processSecretProviderClass(ctx context.Context, k8s *k8sClient, namespace string) error {
+	client := k8s.dynamic.Resource(secretProviderClassGVR).Namespace(namespace)
+	spc, err := client.Get(ctx, configSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err := client.Create(ctx, secretProviderClass(namespace), metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create SecretProviderClass: %v", err)
+		}
+		nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created SecretProviderClass")
+		recordMutation(ctx, k8s, namespace, mutationCreate, "SecretProviderClass", configSecretName, "SecretNotFound")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to GET SecretProviderClass: %v", err)
+	}
+	if !isOwned(spc) {
+		return leaveUnowned(namespace, "SecretProviderClass", configSecretName, verifySecretProviderClass(spc))
+	}
+	if verifySecretProviderClass(spc) {
+		nsLog(namespace).Debug("SecretProviderClass is valid")
+		return nil
+	}
+	if !configForce {
+		return fmt.Errorf("SecretProviderClass is not valid, set --force to true to overwrite")
+	}
+	nsLog(namespace).Warn("SecretProviderClass is not valid, overwriting now")
+	err = client.Delete(ctx, configSecretName, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to delete SecretProviderClass [%s]: %v", configSecretName, err)
+	}
+	nsLog(namespace).WithField(logFieldAction, mutationDelete).Warnf("Deleted SecretProviderClass [%s]", configSecretName)
+	recordMutation(ctx, k8s, namespace, mutationDelete, "SecretProviderClass", configSecretName, "SpecNotMatch")
+	_, err = client.Create(ctx, secretProviderClass(namespace), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to create SecretProviderClass: %v", err)
+	}
+	nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created SecretProviderClass")
+	recordMutation(ctx, k8s, namespace, mutationCreate, "SecretProviderClass", configSecretName, "SpecNotMatch")
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var testCasesProcessSecretProviderClass = []testCase{
+	{
+		name: "no secret provider class",
+		prepSteps: []step{
+			helperSecretProviderClassConfig,
+		},
+		testSteps: []step{
+			processSecretProviderClassDefault,
+			assertSecretProviderClassIsValid,
+		},
+	},
+	{
+		name: "has valid secret provider class",
+		prepSteps: []step{
+			helperSecretProviderClassConfig,
+			helperCreateSecretProviderClass("aws"),
+			assertSecretProviderClassIsValid,
+		},
+		testSteps: []step{
+			processSecretProviderClassDefault,
+			assertSecretProviderClassIsValid,
+		},
+	},
+	{
+		name: "has invalid secret provider class - force on",
+		prepSteps: []step{
+			helperSecretProviderClassConfig,
+			helperForceOn,
+			helperCreateSecretProviderClass("vault"),
+			assertHasError(assertSecretProviderClassIsValid),
+		},
+		testSteps: []step{
+			processSecretProviderClassDefault,
+			assertSecretProviderClassIsValid,
+		},
+	},
+	{
+		name: "has invalid secret provider class - force off",
+		prepSteps: []step{
+			helperSecretProviderClassConfig,
+			helperForceOff,
+			helperCreateSecretProviderClass("vault"),
+		},
+		testSteps: []step{
+			assertHasError(processSecretProviderClassDefault),
+			assertHasError(assertSecretProviderClassIsValid),
+		},
+	},
+}
+
+func TestProcessSecretProviderClass(t *testing.T) {
+	for _, tc := range testCasesProcessSecretProviderClass {
+		runTestCase(t, "ProcessSecretProviderClass", tc)
+	}
+}
+
+func TestParseSecretProviderClassParameters(t *testing.T) {
+	parameters, err := parseSecretProviderClassParameters(`{"region":"eu-west-1","objects":"- objectName: registry\n  objectType: secretsmanager\n"}`)
+	if err != nil {
+		t.Fatalf("parseSecretProviderClassParameters failed: %v", err)
+	}
+	if parameters["region"] != "eu-west-1" || len(parameters) != 2 {
+		t.Errorf("unexpected parameters %v", parameters)
+	}
+	if _, err := parseSecretProviderClassParameters(`{"region":1}`); err == nil {
+		t.Errorf("expected error for non-string parameter values")
+	}
+}
+
+func TestVerifySecretProviderClassParameters(t *testing.T) {
+	helperSecretProviderClassConfig(nil)
+	spc := secretProviderClass(v1.NamespaceDefault)
+	if !verifySecretProviderClass(spc) {
+		t.Fatalf("generated SecretProviderClass is not valid")
+	}
+	if err := unstructured.SetNestedField(spc.Object, "us-east-1", "spec", "parameters", "region"); err != nil {
+		t.Fatal(err)
+	}
+	if verifySecretProviderClass(spc) {
+		t.Errorf("SecretProviderClass with changed parameters is valid")
+	}
+}
+
+func processSecretProviderClassDefault(k8s *k8sClient) error {
+	return processSecretProviderClass(context.TODO(), k8s, v1.NamespaceDefault)
+}
+
+func helperSecretProviderClassConfig(_ *k8sClient) error {
+	configSecretProviderClassProvider = "aws"
+	configSecretProviderClassObjectName = "registry"
+	secretProviderClassParameters = map[string]string{"region": "eu-west-1"}
+	return nil
+}
+
+func helperCreateSecretProviderClass(provider string) step {
+	return func(k8s *k8sClient) error {
+		spc := secretProviderClass(v1.NamespaceDefault)
+		if err := unstructured.SetNestedField(spc.Object, provider, "spec", "provider"); err != nil {
+			return err
+		}
+		_, err := k8s.dynamic.Resource(secretProviderClassGVR).Namespace(v1.NamespaceDefault).Create(context.TODO(), spc, metav1.CreateOptions{})
+		return err
+	}
+}
+
+func assertSecretProviderClassIsValid(k8s *k8sClient) error {
+	spc, err := k8s.dynamic.Resource(secretProviderClassGVR).Namespace(v1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("assert secret provider class valid but not found")
+	}
+	if !verifySecretProviderClass(spc) {
+		return fmt.Errorf("assert secret provider class valid but invalid")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceSelection holds the namespace lists of the selection ConfigMap,
+// which add to the ones of the flags
+type namespaceSelection struct {
+	excluded []string
+	included []string
+}
+
+var (
+	selectionMu sync.RWMutex
+	selection   namespaceSelection
+)
+
+// parseNamespaceSelection reads the `excludedNamespaces` and
+// `includedNamespaces` keys of cm, each listing namespace names or patterns
+// separated by commas or newlines
+func parseNamespaceSelection(cm *corev1.ConfigMap) (namespaceSelection, error) {
+	s := namespaceSelection{}
+	if cm == nil {
+		return s, nil
+	}
+	var err error
+	if s.excluded, err = parseExcludedNamespaces(strings.ReplaceAll(cm.Data["excludedNamespaces"], ",", "\n")); err != nil {
+		return s, fmt.Errorf("excludedNamespaces: %v", err)
+	}
+	if s.included, err = parseExcludedNamespaces(strings.ReplaceAll(cm.Data["includedNamespaces"], ",", "\n")); err != nil {
+		return s, fmt.Errorf("includedNamespaces: %v", err)
+	}
+	return s, nil
+}
+
+// setNamespaceSelection replaces the selection with the one of cm, nil once
+// deleted, keeping the previous one when cm is invalid, and returns whether
+// it changed
+func setNamespaceSelection(cm *corev1.ConfigMap) bool {
+	s, err := parseNamespaceSelection(cm)
+	if err != nil {
+		log.Errorf("Invalid selection ConfigMap [%s], keeping the previous selection: %v", configSelectionConfigMap, err)
+		return false
+	}
+	selectionMu.Lock()
+	defer selectionMu.Unlock()
+	if reflect.DeepEqual(s, selection) {
+		return false
+	}
+	selection = s
+	log.Infof("Loaded %d excluded and %d included namespaces from ConfigMap [%s]", len(s.excluded), len(s.included), configSelectionConfigMap)
+	return true
+}
+
+// loadSelectionConfigMap reads the selection ConfigMap once, an absent one
+// selecting nothing
+func loadSelectionConfigMap(ctx context.Context, k8s *k8sClient) error {
+	namespace, name, err := splitNamespacedName(configSelectionConfigMap)
+	if err != nil {
+		return err
+	}
+	cm, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Warnf("Selection ConfigMap [%s] not found", configSelectionConfigMap)
+		cm = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to GET selection ConfigMap: %v", err)
+	}
+	if _, err := parseNamespaceSelection(cm); err != nil {
+		return fmt.Errorf("invalid selection ConfigMap: %v", err)
+	}
+	setNamespaceSelection(cm)
+	return nil
+}
+
+func selectionEventHandler() cache.ResourceEventHandler {
+	update := func(cm *corev1.ConfigMap) {
+		if setNamespaceSelection(cm) {
+			log.Infof("Selection ConfigMap [%s] changed, triggering loop", configSelectionConfigMap)
+			triggerLoop()
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if cm, ok := obj.(*corev1.ConfigMap); ok {
+				update(cm)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if cm, ok := newObj.(*corev1.ConfigMap); ok {
+				update(cm)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if _, ok := tombstoneObject(obj).(*corev1.ConfigMap); ok {
+				log.Warnf("Selection ConfigMap [%s] was deleted", configSelectionConfigMap)
+				update(nil)
+			}
+		},
+	}
+}
+
+// watchSelectionConfigMap reloads the selection whenever its ConfigMap
+// changes, in the background until ctx is done
+func watchSelectionConfigMap(ctx context.Context, k8s *k8sClient) {
+	namespace, name, _ := splitNamespacedName(configSelectionConfigMap)
+	factory := informers.NewSharedInformerFactoryWithOptions(k8s.clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(selectionEventHandler())
+	factory.Start(ctx.Done())
+}
+
+// namespaceExcludedBySelection checks name against the excluded namespaces of
+// the selection ConfigMap
+func namespaceExcludedBySelection(name string) bool {
+	selectionMu.RLock()
+	defer selectionMu.RUnlock()
+	for _, pattern := range selection.excluded {
+		if namespacePatternMatches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceIncludedBySelection checks name against the included namespaces of
+// the selection ConfigMap, returning whether it has any
+func namespaceIncludedBySelection(name string) (included bool, any bool) {
+	selectionMu.RLock()
+	defer selectionMu.RUnlock()
+	for _, pattern := range selection.included {
+		if namespacePatternMatches(pattern, name) {
+			return true, true
+		}
+	}
+	return false, len(selection.included) > 0
+}
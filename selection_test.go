@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSelectionConfigMap(t *testing.T) {
+	configSelectionConfigMap = "imagepullsecret-patcher/config"
+	configIncludeSystemNamespaces = true
+	defer func() {
+		configSelectionConfigMap = ""
+		configIncludeSystemNamespaces = false
+		selection = namespaceSelection{}
+	}()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "imagepullsecret-patcher"},
+		Data:       map[string]string{"excludedNamespaces": "legacy-*\n# comment\n^sandbox-[0-9]+$"},
+	}
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset(cm)}
+	if err := loadSelectionConfigMap(context.TODO(), k8s); err != nil {
+		t.Fatalf("loadSelectionConfigMap failed: %v", err)
+	}
+	for name, want := range map[string]bool{"legacy-app": true, "sandbox-1": true, "sandbox-x": false, "team-a": false} {
+		if got := namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}); got != want {
+			t.Errorf("namespaceIsExcluded(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	// drain a pending trigger
+	select {
+	case <-loopTrigger:
+	default:
+	}
+	handler := selectionEventHandler()
+	changed := cm.DeepCopy()
+	changed.Data = map[string]string{"includedNamespaces": "team-a, team-b"}
+	handler.OnUpdate(cm, changed)
+	select {
+	case <-loopTrigger:
+	default:
+		t.Error("loop not triggered by a change of the selection")
+	}
+	for name, want := range map[string]bool{"legacy-app": true, "team-a": false, "team-b": false, "team-c": true} {
+		if got := namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}); got != want {
+			t.Errorf("namespaceIsExcluded(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	invalid := changed.DeepCopy()
+	invalid.Data["excludedNamespaces"] = "team-["
+	handler.OnUpdate(changed, invalid)
+	if !namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c"}}) {
+		t.Error("invalid selection replaced the previous one")
+	}
+
+	handler.OnDelete(changed)
+	if namespaceIsExcluded(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c"}}) {
+		t.Error("selection kept after the ConfigMap was deleted")
+	}
+}
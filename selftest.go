@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	selftestNamespacePrefix = "imagepullsecret-patcher-selftest-"
+	selftestPollInterval    = 2 * time.Second
+)
+
+// runSelftest reconciles a scratch namespace, verifies the end state and
+// cleans up, returning the first failure
+func runSelftest(ctx context.Context, k8s *k8sClient) error {
+	var err error
+	dockerConfigJSON, err = getDockerConfigJSON(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load dockerconfigjson: %v", err)
+	}
+	if configEnableSecretSync && configSecretMode == secretModeSealedSecret {
+		sealingKey, err = getSealingKey(ctx, k8s)
+		if err != nil {
+			return err
+		}
+	}
+
+	namespace := selftestNamespacePrefix + newCorrelationID()
+	ns, err := k8s.clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+			Annotations: map[string]string{
+				annotationManagedBy: annotationAppName,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create scratch namespace: %v", err)
+	}
+	nsLog(namespace).WithField(logFieldAction, mutationCreate).Info("Created scratch namespace")
+	defer func() {
+		err := k8s.clientset.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+		if err != nil {
+			nsLog(namespace).Errorf("Failed to delete scratch namespace: %v", err)
+			return
+		}
+		nsLog(namespace).WithField(logFieldAction, mutationDelete).Info("Deleted scratch namespace")
+	}()
+
+	// the default service account is created asynchronously by the cluster
+	err = wait.PollImmediate(selftestPollInterval, configSelftestTimeout, func() (bool, error) {
+		_, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, defaultServiceAccountName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("default service account did not appear: %v", err)
+	}
+
+	if err := processNamespace(ctx, k8s, *ns); err != nil {
+		return err
+	}
+	if err := verifySelftestNamespace(ctx, k8s, namespace); err != nil {
+		return err
+	}
+	if configSelftestCanaryImage != "" {
+		if err := runCanaryPull(ctx, k8s, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySelftestNamespace checks the managed objects of namespace are in the
+// state a reconcile should leave them in
+func verifySelftestNamespace(ctx context.Context, k8s *k8sClient, namespace string) error {
+	if configEnableSecretSync {
+		if err := verifySelftestSecret(ctx, k8s, namespace); err != nil {
+			return err
+		}
+	}
+	if configEnableSAPatch {
+		if err := verifySelftestServiceAccount(ctx, k8s, namespace); err != nil {
+			return err
+		}
+	}
+	if configEnableConfigMapSync {
+		return verifySelftestConfigMap(ctx, k8s, namespace)
+	}
+	return nil
+}
+
+func verifySelftestSecret(ctx context.Context, k8s *k8sClient, namespace string) error {
+	switch configSecretMode {
+	case secretModeExternalSecret:
+		es, err := k8s.dynamic.Resource(externalSecretGVR).Namespace(namespace).Get(ctx, configSecretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("ExternalSecret not found: %v", err)
+		}
+		if !verifyExternalSecret(es) {
+			return fmt.Errorf("ExternalSecret is not valid")
+		}
+	case secretModeSealedSecret:
+		ss, err := k8s.dynamic.Resource(sealedSecretGVR).Namespace(namespace).Get(ctx, configSecretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("SealedSecret not found: %v", err)
+		}
+		if result := verifySealedSecret(ss); result != secretOk {
+			return fmt.Errorf("SealedSecret is not valid: %s", result)
+		}
+	case secretModeSecretProviderClass:
+		spc, err := k8s.dynamic.Resource(secretProviderClassGVR).Namespace(namespace).Get(ctx, configSecretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("SecretProviderClass not found: %v", err)
+		}
+		if !verifySecretProviderClass(spc) {
+			return fmt.Errorf("SecretProviderClass is not valid")
+		}
+	default:
+		secret, err := k8s.clientset.CoreV1().Secrets(namespace).Get(ctx, configSecretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Secret not found: %v", err)
+		}
+		if result := verifySecret(secret); result != secretOk {
+			return fmt.Errorf("Secret is not valid: %s", result)
+		}
+	}
+	nsLog(namespace).Info("Secret verified")
+	return nil
+}
+
+func verifySelftestServiceAccount(ctx context.Context, k8s *k8sClient, namespace string) error {
+	sa, err := k8s.clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to GET default service account: %v", err)
+	}
+	if (configAllServiceAccount || !stringNotInList(defaultServiceAccountName, configServiceAccounts)) && !includeImagePullSecret(sa, configSecretName) {
+		return fmt.Errorf("default service account does not reference secret [%s]", configSecretName)
+	}
+	nsLog(namespace).Info("Service account verified")
+	return nil
+}
+
+func verifySelftestConfigMap(ctx context.Context, k8s *k8sClient, namespace string) error {
+	for _, s := range syncedConfigMaps() {
+		logger := nsLog(namespace).WithField(logFieldConfigMap, s.name)
+		expected, err := s.configMap(namespace)
+		if err != nil {
+			logger.Infof("Skipping ConfigMap verification: %v", err)
+			continue
+		}
+		configMap, err := k8s.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("ConfigMap [%s] not found: %v", s.name, err)
+		}
+		if !mapsEqual(configMap.Data, expected.Data) {
+			return fmt.Errorf("ConfigMap [%s] is not valid", s.name)
+		}
+		logger.Info("ConfigMap verified")
+	}
+	return nil
+}
+
+// runCanaryPull starts a pod with the canary image under the default service
+// account and waits until the image was pulled
+func runCanaryPull(ctx context.Context, k8s *k8sClient, namespace string) error {
+	pod, err := k8s.clientset.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "canary",
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: defaultServiceAccountName,
+			RestartPolicy:      corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:            "canary",
+					Image:           configSelftestCanaryImage,
+					ImagePullPolicy: corev1.PullAlways,
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to create canary pod: %v", err)
+	}
+	err = wait.PollImmediate(selftestPollInterval, configSelftestTimeout, func() (bool, error) {
+		pod, err = k8s.clientset.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Running != nil || status.State.Terminated != nil {
+				return true, nil
+			}
+			if waiting := status.State.Waiting; waiting != nil {
+				switch waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+					return false, fmt.Errorf("%s: %s", waiting.Reason, waiting.Message)
+				}
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Canary image [%s] could not be pulled: %v", configSelftestCanaryImage, err)
+	}
+	nsLog(namespace).Infof("Canary image [%s] pulled", configSelftestCanaryImage)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newSelftestClient returns a fake client which creates the default service
+// account along with every namespace, like the cluster would
+func newSelftestClient() *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ns := action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace)
+		err := clientset.Tracker().Add(&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      defaultServiceAccountName,
+				Namespace: ns.Name,
+			},
+		})
+		return false, nil, err
+	})
+	return clientset
+}
+
+func TestRunSelftest(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configDockerconfigjson = testDockerconfig
+	configAllServiceAccount = true
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "missing")
+
+	clientset := newSelftestClient()
+	if err := runSelftest(context.TODO(), &k8sClient{clientset: clientset}); err != nil {
+		t.Fatalf("runSelftest failed: %v", err)
+	}
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces.Items) != 0 {
+		t.Errorf("runSelftest did not delete scratch namespace %s", namespaces.Items[0].Name)
+	}
+}
+
+func TestRunSelftestCanaryPullFails(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configDockerconfigjson = testDockerconfig
+	configAllServiceAccount = true
+	configAWSConfigFilePath = filepath.Join(t.TempDir(), "missing")
+	configSelftestCanaryImage = "registry.example.com/canary:latest"
+	defer func() { configSelftestCanaryImage = "" }()
+
+	clientset := newSelftestClient()
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      action.(k8stesting.GetAction).GetName(),
+				Namespace: action.GetNamespace(),
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "canary",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "unauthorized"},
+						},
+					},
+				},
+			},
+		}, nil
+	})
+	err := runSelftest(context.TODO(), &k8sClient{clientset: clientset})
+	if err == nil || !strings.Contains(err.Error(), "ErrImagePull") {
+		t.Errorf("runSelftest should fail on the canary pull, got %v", err)
+	}
+}
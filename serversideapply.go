@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// retryApply runs a server-side apply, retrying it on transient errors other
+// than conflicts, which are reported to the caller
+func retryApply(operation func() error) error {
+	return retry.OnError(retry.DefaultBackoff, func(err error) bool {
+		return !errors.IsConflict(err) && isTransientAPIError(err)
+	}, operation)
+}
+
+// applyError describes a failed server-side apply, pointing at `force` when
+// another field manager owns the fields
+func applyError(namespace, kind, name string, err error) error {
+	if errors.IsConflict(err) {
+		return fmt.Errorf("%s [%s] has fields owned by another field manager, set --force to true to take them over: %v", kind, name, err)
+	}
+	return fmt.Errorf("Failed to apply %s [%s]: %v", kind, name, err)
+}
+
+func applyOptions() metav1.ApplyOptions {
+	return metav1.ApplyOptions{FieldManager: fieldManager, Force: configForce}
+}
+
+// applySecret creates or updates the managed secret name of namespace with
+// server-side apply, the existing one holding the credential hashed as oldHash
+func applySecret(ctx context.Context, k8s *k8sClient, namespace, name, content, action, reason, oldHash string) error {
+	desired := namedDockerconfigSecret(namespace, name, content)
+	secret := corev1ac.Secret(name, namespace).
+		WithLabels(desired.Labels).
+		WithAnnotations(desired.Annotations).
+		WithType(desired.Type).
+		WithData(desired.Data)
+	if desired.Immutable != nil {
+		secret.WithImmutable(*desired.Immutable)
+	}
+	err := retryApply(func() error {
+		_, err := k8s.clientset.CoreV1().Secrets(namespace).Apply(ctx, secret, applyOptions())
+		return err
+	})
+	if err != nil {
+		return applyError(namespace, "Secret", name, err)
+	}
+	nsLog(namespace).WithFields(log.Fields{logFieldSecret: name, logFieldAction: mutationPatch}).Info("Applied secret")
+	recordContentMutation(ctx, k8s, namespace, action, "Secret", name, reason, oldHash)
+	return nil
+}
+
+// applyConfigMap creates or updates the ConfigMap with server-side apply
+func applyConfigMap(ctx context.Context, k8s *k8sClient, desired *corev1.ConfigMap, action, reason string) error {
+	configMap := corev1ac.ConfigMap(desired.Name, desired.Namespace).
+		WithLabels(desired.Labels).
+		WithAnnotations(desired.Annotations).
+		WithData(desired.Data)
+	err := retryApply(func() error {
+		_, err := k8s.clientset.CoreV1().ConfigMaps(desired.Namespace).Apply(ctx, configMap, applyOptions())
+		return err
+	})
+	if err != nil {
+		return applyError(desired.Namespace, "ConfigMap", desired.Name, err)
+	}
+	nsLog(desired.Namespace).WithFields(log.Fields{logFieldConfigMap: desired.Name, logFieldAction: mutationPatch}).Info("Applied ConfigMap")
+	recordMutation(ctx, k8s, desired.Namespace, action, "ConfigMap", desired.Name, reason)
+	return nil
+}
+
+// applyServiceAccount adds the secrets to the service account with
+// server-side apply, owning its imagePullSecrets, which are replaced as a
+// whole
+func applyServiceAccount(ctx context.Context, k8s *k8sClient, sa *corev1.ServiceAccount, secretNames ...string) error {
+	ac := corev1ac.ServiceAccount(sa.Name, sa.Namespace)
+	imagePullSecrets := sa.ImagePullSecrets
+	if configPruneStaleRefs {
+		imagePullSecrets = prunedImagePullSecrets(sa, secretNames...)
+		ac.WithAnnotations(map[string]string{annotationManagedSecrets: strings.Join(secretNames, ",")})
+	}
+	for _, ref := range imagePullSecrets {
+		ac.WithImagePullSecrets(corev1ac.LocalObjectReference().WithName(ref.Name))
+	}
+	for _, name := range secretNames {
+		if !includeImagePullSecret(&corev1.ServiceAccount{ImagePullSecrets: imagePullSecrets}, name) {
+			ac.WithImagePullSecrets(corev1ac.LocalObjectReference().WithName(name))
+		}
+		// secrets are merged by name, so only ours are owned
+		if configPatchSASecrets {
+			ac.WithSecrets(corev1ac.ObjectReference().WithName(name))
+		}
+	}
+	err := retryApply(func() error {
+		_, err := k8s.clientset.CoreV1().ServiceAccounts(sa.Namespace).Apply(ctx, ac, applyOptions())
+		return err
+	})
+	if err != nil {
+		return applyError(sa.Namespace, "ServiceAccount", sa.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServerSideApply(t *testing.T) {
+	configServerSideApply = true
+	dockerConfigJSON = testDockerconfig
+	defer func() {
+		configServerSideApply = false
+		dockerConfigJSON = ""
+	}()
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "default", Labels: map[string]string{annotationManagedBy: annotationAppName}},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: "default"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "other"}},
+		},
+	)
+	k8s := &k8sClient{clientset: clientset}
+	if err := processSecret(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processSecret failed: %v", err)
+	}
+	if err := processServiceAccount(context.TODO(), k8s, "default"); err != nil {
+		t.Fatalf("processServiceAccount failed: %v", err)
+	}
+	applied := map[string]bool{}
+	for _, action := range clientset.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			if patch.GetPatchType() != types.ApplyPatchType {
+				t.Errorf("%s should be applied, got a %s patch", action.GetResource().Resource, patch.GetPatchType())
+			}
+			applied[action.GetResource().Resource] = true
+		}
+	}
+	if !applied["secrets"] || !applied["serviceaccounts"] {
+		t.Errorf("secret and service account should be applied, got %v", applied)
+	}
+	secret, err := clientset.CoreV1().Secrets("default").Get(context.TODO(), configSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifySecret(secret) != secretOk {
+		t.Errorf("applied secret should be valid")
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts("default").Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !includeImagePullSecret(sa, "other") || !includeImagePullSecret(sa, configSecretName) {
+		t.Errorf("service account should keep its imagePullSecrets and gain ours, got %v", sa.ImagePullSecrets)
+	}
+}
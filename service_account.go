@@ -2,12 +2,46 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
 	defaultServiceAccountName = "default"
+
+	// fieldManager identifies our writes in managedFields
+	fieldManager = annotationAppName
+
+	// serviceAccountPatchRecordTTL is how long patches of a service account
+	// are remembered at least
+	serviceAccountPatchRecordTTL = time.Hour
+
+	// newNamespaceAge is the age up to which a namespace may still lack its
+	// default service account
+	newNamespaceAge = time.Minute
+)
+
+// defaultServiceAccountPollInterval is the interval the default service account
+// of a new namespace is looked up at, shortened in tests
+var defaultServiceAccountPollInterval = 500 * time.Millisecond
+
+type serviceAccountPatchRecord struct {
+	count int
+	last  time.Time
+}
+
+// serviceAccountSelector is parsed from `serviceaccount-label-selector`, nil
+// when unset
+var serviceAccountSelector labels.Selector
+
+var (
+	patchedServiceAccountsMu sync.Mutex
+	// patchedServiceAccounts records our patches per namespace/name
+	patchedServiceAccounts = map[string]*serviceAccountPatchRecord{}
 )
 
 func includeImagePullSecret(sa *corev1.ServiceAccount, secretName string) bool {
@@ -19,17 +53,174 @@ func includeImagePullSecret(sa *corev1.ServiceAccount, secretName string) bool {
 	return false
 }
 
+func includeSecret(sa *corev1.ServiceAccount, secretName string) bool {
+	for _, secret := range sa.Secrets {
+		if secret.Name == secretName {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceAccountPatched tells whether the service account already references
+// the secrets everywhere they are patched into
+func serviceAccountPatched(sa *corev1.ServiceAccount, secretNames ...string) bool {
+	for _, secretName := range secretNames {
+		if !includeImagePullSecret(sa, secretName) || (configPatchSASecrets && !includeSecret(sa, secretName)) {
+			return false
+		}
+	}
+	if configPruneStaleRefs {
+		recorded, _ := patcherAnnotation(sa.Annotations, annotationManagedSecrets)
+		return recorded == strings.Join(secretNames, ",") && len(prunedImagePullSecrets(sa, secretNames...)) == len(sa.ImagePullSecrets)
+	}
+	return true
+}
+
+// prunedImagePullSecrets returns the imagePullSecrets of the service account
+// without duplicates and references to secrets the patcher managed before
+// under another name, as recorded in the managed secrets annotation
+func prunedImagePullSecrets(sa *corev1.ServiceAccount, secretNames ...string) []corev1.LocalObjectReference {
+	stale := map[string]bool{}
+	recorded, _ := patcherAnnotation(sa.Annotations, annotationManagedSecrets)
+	for _, name := range strings.Split(recorded, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			stale[name] = true
+		}
+	}
+	for _, secretName := range secretNames {
+		delete(stale, secretName)
+	}
+	seen := map[string]bool{}
+	pruned := []corev1.LocalObjectReference{}
+	for _, ref := range sa.ImagePullSecrets {
+		if stale[ref.Name] || seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+		pruned = append(pruned, ref)
+	}
+	return pruned
+}
+
+// serviceAccountTargeted tells whether the service account is to be patched,
+// i.e. it is selected by name and labels and did not opt out with the
+// exclude annotation
+func serviceAccountTargeted(sa *corev1.ServiceAccount) bool {
+	if v, _ := patcherAnnotation(sa.Annotations, annotationImagepullsecretPatcherExclude); v == "true" {
+		return false
+	}
+	if serviceAccountSelector != nil && !serviceAccountSelector.Matches(labels.Set(sa.Labels)) {
+		return false
+	}
+	return configAllServiceAccount || !stringNotInList(sa.Name, configServiceAccounts)
+}
+
 type patch struct {
+	// Metadata is only patched with `prune-stale-references`
+	Metadata         *patchMetadata                `json:"metadata,omitempty"`
 	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Secrets is only patched with `patch-sa-secrets`
+	Secrets []corev1.ObjectReference `json:"secrets,omitempty"`
+}
+
+type patchMetadata struct {
+	Annotations map[string]string `json:"annotations"`
 }
 
-func getPatchString(sa *corev1.ServiceAccount, secretName string) ([]byte, error) {
+func getPatchString(sa *corev1.ServiceAccount, secretNames ...string) ([]byte, error) {
 	saPatch := patch{
 		// copy the slice
 		ImagePullSecrets: append([]corev1.LocalObjectReference(nil), sa.ImagePullSecrets...),
 	}
-	if !includeImagePullSecret(sa, secretName) {
-		saPatch.ImagePullSecrets = append(saPatch.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+	if configPruneStaleRefs {
+		saPatch.ImagePullSecrets = prunedImagePullSecrets(sa, secretNames...)
+		saPatch.Metadata = &patchMetadata{Annotations: map[string]string{annotationManagedSecrets: strings.Join(secretNames, ",")}}
+	}
+	if configPatchSASecrets {
+		saPatch.Secrets = append([]corev1.ObjectReference(nil), sa.Secrets...)
+	}
+	for _, secretName := range secretNames {
+		if !includeImagePullSecret(&corev1.ServiceAccount{ImagePullSecrets: saPatch.ImagePullSecrets}, secretName) {
+			saPatch.ImagePullSecrets = append(saPatch.ImagePullSecrets, corev1.LocalObjectReference{Name: secretName})
+		}
+		if configPatchSASecrets && !includeSecret(sa, secretName) {
+			saPatch.Secrets = append(saPatch.Secrets, corev1.ObjectReference{Name: secretName})
+		}
 	}
 	return json.Marshal(saPatch)
 }
+
+// foreignImagePullSecretsManagers returns the field managers other than us
+// which own imagePullSecrets of the service account
+func foreignImagePullSecretsManagers(sa *corev1.ServiceAccount) []string {
+	var managers []string
+	for _, mf := range sa.ManagedFields {
+		if mf.Manager == fieldManager || mf.FieldsV1 == nil {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(mf.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields["f:imagePullSecrets"]; ok {
+			managers = append(managers, mf.Manager)
+		}
+	}
+	return managers
+}
+
+// recordServiceAccountPatch records a patch of the service account at now and
+// returns how often it was patched before
+func recordServiceAccountPatch(namespace, name string, now time.Time) int {
+	patchedServiceAccountsMu.Lock()
+	defer patchedServiceAccountsMu.Unlock()
+	key := namespace + "/" + name
+	record, ok := patchedServiceAccounts[key]
+	if !ok {
+		record = &serviceAccountPatchRecord{}
+		patchedServiceAccounts[key] = record
+	}
+	count := record.count
+	record.count++
+	record.last = now
+	return count
+}
+
+func serviceAccountPatchCount(namespace, name string) int {
+	patchedServiceAccountsMu.Lock()
+	defer patchedServiceAccountsMu.Unlock()
+	if record, ok := patchedServiceAccounts[namespace+"/"+name]; ok {
+		return record.count
+	}
+	return 0
+}
+
+// serviceAccountPatchAllowed tells whether the service account was last
+// patched at least minInterval before now, so a service account recreated
+// over and over under the same name is not patched at an unbounded rate
+func serviceAccountPatchAllowed(namespace, name string, minInterval time.Duration, now time.Time) bool {
+	if minInterval <= 0 {
+		return true
+	}
+	patchedServiceAccountsMu.Lock()
+	defer patchedServiceAccountsMu.Unlock()
+	record, ok := patchedServiceAccounts[namespace+"/"+name]
+	return !ok || now.Sub(record.last) >= minInterval
+}
+
+// pruneServiceAccountPatchRecords forgets service accounts which were not
+// patched for a while, as churned names would otherwise pile up
+func pruneServiceAccountPatchRecords(minInterval time.Duration, now time.Time) {
+	ttl := serviceAccountPatchRecordTTL
+	if minInterval > ttl {
+		ttl = minInterval
+	}
+	patchedServiceAccountsMu.Lock()
+	defer patchedServiceAccountsMu.Unlock()
+	for key, record := range patchedServiceAccounts {
+		if now.Sub(record.last) > ttl {
+			delete(patchedServiceAccounts, key)
+		}
+	}
+}
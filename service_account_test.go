@@ -1,9 +1,13 @@
 package main
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var testCasesIncludeImagePullSecret = []struct {
@@ -97,3 +101,218 @@ func TestGetPatchString(t *testing.T) {
 		}
 	}
 }
+
+var testCasesForeignImagePullSecretsManagers = []struct {
+	name     string
+	sa       *corev1.ServiceAccount
+	expected []string
+}{
+	{
+		name:     "no managed fields",
+		sa:       &corev1.ServiceAccount{},
+		expected: nil,
+	},
+	{
+		name: "only us",
+		sa: &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: fieldManager, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:imagePullSecrets":{}}`)}},
+				},
+			},
+		},
+		expected: nil,
+	},
+	{
+		name: "foreign manager of other fields",
+		sa: &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: "kubectl", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{}}}`)}},
+				},
+			},
+		},
+		expected: nil,
+	},
+	{
+		name: "foreign manager of imagePullSecrets",
+		sa: &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				ManagedFields: []metav1.ManagedFieldsEntry{
+					{Manager: fieldManager, FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{}}`)}},
+					{Manager: "argocd-controller", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:imagePullSecrets":{}}`)}},
+				},
+			},
+		},
+		expected: []string{"argocd-controller"},
+	},
+}
+
+func TestForeignImagePullSecretsManagers(t *testing.T) {
+	for _, testCase := range testCasesForeignImagePullSecretsManagers {
+		actual := foreignImagePullSecretsManagers(testCase.sa)
+		if strings.Join(actual, ",") != strings.Join(testCase.expected, ",") {
+			t.Errorf("foreignImagePullSecretsManagers(%s) gives %v, expects %v", testCase.name, actual, testCase.expected)
+		}
+	}
+}
+
+func TestServiceAccountPatchAllowed(t *testing.T) {
+	patchedServiceAccounts = map[string]*serviceAccountPatchRecord{}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	recordServiceAccountPatch("default", "ci-runner", start)
+
+	for _, tc := range []struct {
+		name        string
+		sa          string
+		minInterval time.Duration
+		now         time.Time
+		expected    bool
+	}{
+		{
+			name:        "disabled",
+			sa:          "ci-runner",
+			minInterval: 0,
+			now:         start,
+			expected:    true,
+		},
+		{
+			name:        "never patched",
+			sa:          "other",
+			minInterval: time.Minute,
+			now:         start,
+			expected:    true,
+		},
+		{
+			name:        "patched too recently",
+			sa:          "ci-runner",
+			minInterval: time.Minute,
+			now:         start.Add(30 * time.Second),
+			expected:    false,
+		},
+		{
+			name:        "patched long enough ago",
+			sa:          "ci-runner",
+			minInterval: time.Minute,
+			now:         start.Add(time.Minute),
+			expected:    true,
+		},
+	} {
+		if actual := serviceAccountPatchAllowed("default", tc.sa, tc.minInterval, tc.now); actual != tc.expected {
+			t.Errorf("serviceAccountPatchAllowed(%s) gives %v, expects %v", tc.name, actual, tc.expected)
+		}
+	}
+
+	pruneServiceAccountPatchRecords(time.Minute, start.Add(2*serviceAccountPatchRecordTTL))
+	if count := serviceAccountPatchCount("default", "ci-runner"); count != 0 {
+		t.Errorf("pruneServiceAccountPatchRecords kept a stale record with count %d", count)
+	}
+}
+
+func TestServiceAccountTargeted(t *testing.T) {
+	defer func() { configAllServiceAccount, configServiceAccounts = true, defaultServiceAccountName }()
+	builder := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "builder"}}
+	excluded := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "default",
+		Annotations: map[string]string{annotationImagepullsecretPatcherExclude: "true"},
+	}}
+
+	configAllServiceAccount = true
+	if !serviceAccountTargeted(builder) {
+		t.Errorf("service account should be targeted with allserviceaccount")
+	}
+	if serviceAccountTargeted(excluded) {
+		t.Errorf("excluded service account should not be targeted with allserviceaccount")
+	}
+	configAllServiceAccount, configServiceAccounts = false, "default"
+	if serviceAccountTargeted(builder) {
+		t.Errorf("unlisted service account should not be targeted")
+	}
+	if serviceAccountTargeted(excluded) {
+		t.Errorf("excluded service account should not be targeted though listed")
+	}
+}
+
+func TestServiceAccountTargetedBySelector(t *testing.T) {
+	defer func() { serviceAccountSelector = nil }()
+	selector, err := labels.Parse("imagepullsecret-patcher/patch=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceAccountSelector = selector
+	labeled := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:   "app",
+		Labels: map[string]string{"imagepullsecret-patcher/patch": "true"},
+	}}
+	if !serviceAccountTargeted(labeled) {
+		t.Errorf("labeled service account should be targeted")
+	}
+	if serviceAccountTargeted(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "builder"}}) {
+		t.Errorf("unlabeled service account should not be targeted")
+	}
+}
+
+func TestGetPatchStringWithSecrets(t *testing.T) {
+	configPatchSASecrets = true
+	defer func() { configPatchSASecrets = false }()
+	sa := &corev1.ServiceAccount{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "builder-dockercfg-x7k2p"}},
+		Secrets:          []corev1.ObjectReference{{Name: "builder-dockercfg-x7k2p"}},
+	}
+	if serviceAccountPatched(sa, "image-pull-secret") {
+		t.Errorf("service account without the secret should not be patched already")
+	}
+	actual, err := getPatchString(sa, "image-pull-secret")
+	if err != nil {
+		t.Fatalf("getPatchString has error %v", err)
+	}
+	expected := `{"imagePullSecrets":[{"name":"builder-dockercfg-x7k2p"},{"name":"image-pull-secret"}],"secrets":[{"name":"builder-dockercfg-x7k2p"},{"name":"image-pull-secret"}]}`
+	if string(actual) != expected {
+		t.Errorf("getPatchString gives %s, expects %s", actual, expected)
+	}
+
+	// only the secrets are missing, e.g. from before `patch-sa-secrets`
+	sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: "image-pull-secret"})
+	if serviceAccountPatched(sa, "image-pull-secret") {
+		t.Errorf("service account without the secret in its secrets should not be patched already")
+	}
+	actual, _ = getPatchString(sa, "image-pull-secret")
+	if string(actual) != expected {
+		t.Errorf("getPatchString gives %s, expects %s", actual, expected)
+	}
+	sa.Secrets = append(sa.Secrets, corev1.ObjectReference{Name: "image-pull-secret"})
+	if !serviceAccountPatched(sa, "image-pull-secret") {
+		t.Errorf("service account with the secret in both lists should be patched already")
+	}
+}
+
+func TestPruneStaleReferences(t *testing.T) {
+	configPruneStaleRefs = true
+	defer func() { configPruneStaleRefs = false }()
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annotationManagedSecrets: "old-secret"}},
+		ImagePullSecrets: []corev1.LocalObjectReference{
+			{Name: "old-secret"}, {Name: "team-secret"}, {Name: "team-secret"}},
+	}
+	if serviceAccountPatched(sa, "new-secret") {
+		t.Errorf("service account with stale references should not be patched already")
+	}
+	actual, err := getPatchString(sa, "new-secret")
+	if err != nil {
+		t.Fatalf("getPatchString has error %v", err)
+	}
+	expected := `{"metadata":{"annotations":{"k8s.titansoft.com/imagepullsecret-patcher-managed-secrets":"new-secret"}},"imagePullSecrets":[{"name":"team-secret"},{"name":"new-secret"}]}`
+	if string(actual) != expected {
+		t.Errorf("getPatchString gives %s, expects %s", actual, expected)
+	}
+
+	sa.Annotations[annotationManagedSecrets] = "new-secret"
+	sa.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "old-secret"}, {Name: "team-secret"}, {Name: "new-secret"}}
+	if !serviceAccountPatched(sa, "new-secret") {
+		t.Errorf("references the patcher did not record should be kept")
+	}
+	sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: "new-secret"})
+	if serviceAccountPatched(sa, "new-secret") {
+		t.Errorf("service account with duplicate references should not be patched already")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// sourceSecret is the secret mirrored into every namespace, nil unless
+// `source-secret-name` is set
+var sourceSecret *secretCredentialSource
+
+// secretCredentialSource mirrors the dockerconfigjson of an existing secret,
+// e.g. one materialized by an external secrets operator
+type secretCredentialSource struct {
+	// clientset is set once the client is created
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (s *secretCredentialSource) String() string { return "secret:" + s.namespace + "/" + s.name }
+
+func (s *secretCredentialSource) load(ctx context.Context) (string, error) {
+	if s.clientset == nil {
+		return "", fmt.Errorf("the source secret cannot be read without access to the cluster")
+	}
+	secret, err := s.clientset.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to GET source secret: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		return "", fmt.Errorf("source secret is of type %s instead of %s", secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	return string(secret.Data[corev1.DockerConfigJsonKey]), nil
+}
+
+// isSourceSecret tells whether the managed secret of namespace would be the
+// source secret itself, which is left alone
+func isSourceSecret(namespace string) bool {
+	return sourceSecret != nil && sourceSecret.namespace == namespace && sourceSecret.name == configSecretName
+}
+
+func sourceSecretEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok := oldObj.(*corev1.Secret)
+			secret, ok2 := newObj.(*corev1.Secret)
+			if ok && ok2 && (old.Type != secret.Type || !reflect.DeepEqual(old.Data, secret.Data)) {
+				log.Infof("Source secret [%s/%s] changed, triggering loop", secret.Namespace, secret.Name)
+				triggerLoop()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if secret, ok := tombstoneObject(obj).(*corev1.Secret); ok {
+				log.Warnf("Source secret [%s/%s] was deleted", secret.Namespace, secret.Name)
+			}
+		},
+	}
+}
+
+// watchSourceSecret triggers a loop whenever the source secret changes, in
+// the background until ctx is done
+func watchSourceSecret(ctx context.Context, k8s *k8sClient) {
+	factory := informers.NewSharedInformerFactoryWithOptions(k8s.clientset, 0,
+		informers.WithNamespace(sourceSecret.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", sourceSecret.name).String()
+		}))
+	factory.Core().V1().Secrets().Informer().AddEventHandler(sourceSecretEventHandler())
+	factory.Start(ctx.Done())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCredentialSource(t *testing.T) {
+	source := &secretCredentialSource{
+		clientset: fake.NewSimpleClientset(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "master-registry", Namespace: "kube-system"},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "kube-system"},
+				Type:       corev1.SecretTypeOpaque,
+			},
+		),
+		namespace: "kube-system",
+		name:      "master-registry",
+	}
+	content, err := source.load(context.TODO())
+	if err != nil {
+		t.Fatalf("load has error %v", err)
+	}
+	if content != `{"auths":{}}` {
+		t.Errorf("content %s", content)
+	}
+
+	source.name = "opaque"
+	if _, err := source.load(context.TODO()); err == nil {
+		t.Error("no error for an opaque source secret")
+	}
+	source.name = "missing"
+	if _, err := source.load(context.TODO()); err == nil {
+		t.Error("no error for a missing source secret")
+	}
+}
+
+func TestSourceSecretChangeTriggersLoop(t *testing.T) {
+	sourceSecret = &secretCredentialSource{namespace: "kube-system", name: "registry"}
+	defer func() { sourceSecret = nil }()
+	if !isSourceSecret("kube-system") || isSourceSecret("default") {
+		t.Error("source secret not recognized as the managed secret of its namespace")
+	}
+
+	// drain a pending trigger
+	select {
+	case <-loopTrigger:
+	default:
+	}
+	old := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "kube-system", ResourceVersion: "1"}, Data: map[string][]byte{"a": []byte("1")}}
+	relisted := old.DeepCopy()
+	relisted.ResourceVersion = "2"
+	handler := sourceSecretEventHandler()
+	handler.OnUpdate(old, relisted)
+	select {
+	case <-loopTrigger:
+		t.Error("loop triggered without a change of the data")
+	default:
+	}
+	changed := relisted.DeepCopy()
+	changed.Data["a"] = []byte("2")
+	handler.OnUpdate(relisted, changed)
+	select {
+	case <-loopTrigger:
+	default:
+		t.Error("loop not triggered by a change of the data")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// statusErrorMaxLength bounds the error kept per namespace, so the status
+// ConfigMap of a large cluster stays well below the 1MiB object limit
+const statusErrorMaxLength = 256
+
+// namespaceStatus is the last sync state of a namespace, stored as JSON under
+// the namespace name in the status ConfigMap
+type namespaceStatus struct {
+	LastSyncedAt *time.Time `json:"lastSyncedAt,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+var (
+	namespaceStatusesMu sync.Mutex
+	namespaceStatuses   = map[string]namespaceStatus{}
+	// namespaceStatusesLoaded tells whether the statuses written by a
+	// previous process were read back, so a restart keeps them
+	namespaceStatusesLoaded bool
+)
+
+// recordNamespaceStatus records the result of reconciling namespace at now,
+// keeping its last successful sync when it failed
+func recordNamespaceStatus(namespace string, err error, now time.Time) {
+	if configStatusConfigMap == "" {
+		return
+	}
+	namespaceStatusesMu.Lock()
+	defer namespaceStatusesMu.Unlock()
+	status := namespaceStatuses[namespace]
+	if err != nil {
+		status.LastError = err.Error()
+		if len(status.LastError) > statusErrorMaxLength {
+			status.LastError = status.LastError[:statusErrorMaxLength]
+		}
+		status.LastErrorAt = &now
+	} else {
+		status.LastSyncedAt = &now
+		status.LastError = ""
+		status.LastErrorAt = nil
+	}
+	namespaceStatuses[namespace] = status
+}
+
+// saveNamespaceStatuses writes the status of the namespaces into the status
+// ConfigMap, dropping the namespaces which are gone
+func saveNamespaceStatuses(ctx context.Context, k8s *k8sClient, namespaces []corev1.Namespace) error {
+	if configStatusConfigMap == "" {
+		return nil
+	}
+	namespace, name, err := splitNamespacedName(configStatusConfigMap)
+	if err != nil {
+		return err
+	}
+	client := k8s.clientset.CoreV1().ConfigMaps(namespace)
+	cm, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to GET status ConfigMap: %v", err)
+	}
+
+	namespaceStatusesMu.Lock()
+	defer namespaceStatusesMu.Unlock()
+	if !namespaceStatusesLoaded && err == nil {
+		for ns, value := range cm.Data {
+			previous := namespaceStatus{}
+			if json.Unmarshal([]byte(value), &previous) != nil {
+				continue
+			}
+			status, ok := namespaceStatuses[ns]
+			if !ok {
+				status = previous
+			} else if status.LastSyncedAt == nil {
+				status.LastSyncedAt = previous.LastSyncedAt
+			}
+			namespaceStatuses[ns] = status
+		}
+	}
+	namespaceStatusesLoaded = true
+	existing := map[string]bool{}
+	for _, ns := range namespaces {
+		existing[ns.Name] = true
+	}
+	data := map[string]string{}
+	for ns, status := range namespaceStatuses {
+		if !existing[ns] {
+			delete(namespaceStatuses, ns)
+			continue
+		}
+		value, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		data[ns] = string(value)
+	}
+
+	if errors.IsNotFound(err) {
+		_, err = client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Annotations: map[string]string{
+					annotationManagedBy: annotationAppName,
+				},
+			},
+			Data: data,
+		}, metav1.CreateOptions{})
+	} else {
+		cm.Data = data
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write status ConfigMap: %v", err)
+	}
+	return nil
+}
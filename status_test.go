@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSaveNamespaceStatuses(t *testing.T) {
+	k8s := &k8sClient{clientset: fake.NewSimpleClientset()}
+	configStatusConfigMap = "imagepullsecret-patcher/status"
+	defer func() {
+		configStatusConfigMap = ""
+		namespaceStatuses = map[string]namespaceStatus{}
+		namespaceStatusesLoaded = false
+	}()
+	namespaces := func(names ...string) []corev1.Namespace {
+		items := []corev1.Namespace{}
+		for _, name := range names {
+			items = append(items, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		return items
+	}
+	readStatuses := func() map[string]namespaceStatus {
+		t.Helper()
+		cm, err := k8s.clientset.CoreV1().ConfigMaps("imagepullsecret-patcher").Get(context.TODO(), "status", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses := map[string]namespaceStatus{}
+		for ns, value := range cm.Data {
+			status := namespaceStatus{}
+			if err := json.Unmarshal([]byte(value), &status); err != nil {
+				t.Fatal(err)
+			}
+			statuses[ns] = status
+		}
+		return statuses
+	}
+
+	synced := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	recordNamespaceStatus("team-a", nil, synced)
+	recordNamespaceStatus("team-b", fmt.Errorf("Secret [registry] is present but unmanaged"), synced)
+	if err := saveNamespaceStatuses(context.TODO(), k8s, namespaces("team-a", "team-b")); err != nil {
+		t.Fatal(err)
+	}
+	statuses := readStatuses()
+	if a := statuses["team-a"]; a.LastSyncedAt == nil || !a.LastSyncedAt.Equal(synced) || a.LastError != "" {
+		t.Errorf("unexpected status of team-a %+v", a)
+	}
+	if b := statuses["team-b"]; b.LastSyncedAt != nil || b.LastError != "Secret [registry] is present but unmanaged" {
+		t.Errorf("unexpected status of team-b %+v", b)
+	}
+
+	// a restarted patcher keeps the last successful sync of a failing
+	// namespace, and drops the deleted ones
+	namespaceStatuses = map[string]namespaceStatus{}
+	namespaceStatusesLoaded = false
+	failed := synced.Add(time.Hour)
+	recordNamespaceStatus("team-a", fmt.Errorf("Failed to list service accounts: timeout"), failed)
+	if err := saveNamespaceStatuses(context.TODO(), k8s, namespaces("team-a")); err != nil {
+		t.Fatal(err)
+	}
+	statuses = readStatuses()
+	if len(statuses) != 1 {
+		t.Errorf("expected only team-a, got %v", statuses)
+	}
+	a := statuses["team-a"]
+	if a.LastSyncedAt == nil || !a.LastSyncedAt.Equal(synced) || a.LastErrorAt == nil || !a.LastErrorAt.Equal(failed) || a.LastError == "" {
+		t.Errorf("unexpected status of team-a %+v", a)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// credentialTemplateData is what a credential is expanded with when it is a
+// template
+type credentialTemplateData struct {
+	Namespace string
+	Labels    map[string]string
+}
+
+var credentialTemplateFuncs = template.FuncMap{
+	"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"lower":  strings.ToLower,
+	"upper":  strings.ToUpper,
+}
+
+func parseCredentialTemplate(content string) (*template.Template, error) {
+	return template.New("credential").Funcs(credentialTemplateFuncs).Option("missingkey=error").Parse(content)
+}
+
+// expandCredentialTemplate expands the credential template content for ns,
+// checking the result when `validate-credential` is set
+func expandCredentialTemplate(content string, ns corev1.Namespace) (string, error) {
+	t, err := parseCredentialTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	labels := ns.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, credentialTemplateData{Namespace: ns.Name, Labels: labels}); err != nil {
+		return "", err
+	}
+	if configValidateCredential {
+		if err := validateDockerConfigJSON(b.String()); err != nil {
+			return "", fmt.Errorf("malformed dockerconfigjson: %v", err)
+		}
+	}
+	return b.String(), nil
+}
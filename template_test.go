@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAssignTemplatedCredential(t *testing.T) {
+	configCredentialTemplate = true
+	dockerConfigJSON = `{"auths":{"registry.example.com":{"username":"robot-{{ .Namespace }}","password":"{{ .Labels.team }}","auth":"{{ printf "robot-%s:x" .Namespace | b64enc }}"}}}`
+	defer func() {
+		configCredentialTemplate = false
+		dockerConfigJSON = ""
+		namespaceCredentialsMu.Lock()
+		namespaceCredentials = map[string]string{}
+		namespaceCredentialsMu.Unlock()
+	}()
+
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+	if err := assignNamespaceCredential(context.TODO(), ns); err != nil {
+		t.Fatalf("assignNamespaceCredential failed: %v", err)
+	}
+	want := `{"auths":{"registry.example.com":{"username":"robot-team-a","password":"a","auth":"cm9ib3QtdGVhbS1hOng="}}}`
+	if got := credentialFor("team-a"); got != want {
+		t.Errorf("credentialFor = %s, want %s", got, want)
+	}
+
+	// a label the template refers to is missing
+	ns = corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}}
+	if err := assignNamespaceCredential(context.TODO(), ns); err == nil {
+		t.Errorf("expanding a missing label should fail")
+	}
+
+	// the expansion must be a valid dockerconfigjson
+	dockerConfigJSON = `{"auths":{"{{ .Namespace }} registry":{"auth":"x"}}}`
+	if err := assignNamespaceCredential(context.TODO(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}); err == nil {
+		t.Errorf("a malformed expansion should fail")
+	}
+}
+
+func TestLoadCredentialTemplate(t *testing.T) {
+	configCredentialTemplate = true
+	configDockerconfigjson = `{"auths":{"r.example.com":{"auth":"{{ .Namespace"}}}`
+	defer func() {
+		configCredentialTemplate = false
+		configDockerconfigjson = ""
+	}()
+	if _, err := loadCredential(context.TODO()); err == nil {
+		t.Errorf("loadCredential should refuse a malformed template")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout bounds the flush of the spans left on exit
+const tracingShutdownTimeout = 5 * time.Second
+
+var (
+	// tracer creates the spans of the loops, of the namespaces they reconcile
+	// and of the API calls made for them, doing nothing unless setupTracing
+	// installed an exporter
+	tracer trace.Tracer = otel.Tracer(annotationAppName)
+
+	tracerProvider *sdktrace.TracerProvider
+
+	// namespaceSpans holds the span of every namespace being reconciled, as
+	// the API calls made for it don't carry it in their context
+	namespaceSpansMu sync.RWMutex
+	namespaceSpans   = map[string]trace.Span{}
+)
+
+// setupTracing exports the spans over OTLP/HTTP, to the endpoint set by the
+// standard OTEL_EXPORTER_OTLP_* environment variables
+func setupTracing(ctx context.Context) error {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(annotationAppName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	tracer = tracerProvider.Tracer(annotationAppName)
+	return nil
+}
+
+// shutdownTracing flushes the spans not exported yet
+func shutdownTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Warnf("Failed to flush traces: %v", err)
+	}
+}
+
+// endSpan ends span, marking it failed with err if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startNamespaceSpan starts the span of reconciling namespace within the loop
+// of ctx, which the API calls made for namespace are attached to
+func startNamespaceSpan(ctx context.Context, namespace string) trace.Span {
+	_, span := tracer.Start(ctx, "reconcile namespace", trace.WithAttributes(semconv.K8SNamespaceName(namespace)))
+	namespaceSpansMu.Lock()
+	defer namespaceSpansMu.Unlock()
+	namespaceSpans[namespace] = span
+	return span
+}
+
+// finishNamespaceSpan ends the span of namespace with the result of reconciling it
+func finishNamespaceSpan(namespace string, span trace.Span, err error) {
+	namespaceSpansMu.Lock()
+	delete(namespaceSpans, namespace)
+	namespaceSpansMu.Unlock()
+	endSpan(span, err)
+}
+
+// apiPathResource returns the namespace and the resource an API server path
+// is about, e.g. default and secrets for /api/v1/namespaces/default/secrets/x
+func apiPathResource(path string) (namespace, resource string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if part != "namespaces" {
+			continue
+		}
+		if i+1 < len(parts) {
+			namespace = parts[i+1]
+		}
+		if i+2 < len(parts) {
+			return namespace, parts[i+2]
+		}
+		return namespace, part
+	}
+	// cluster-scoped: /api/<version>/<resource> or /apis/<group>/<version>/<resource>
+	i := 2
+	if len(parts) > 0 && parts[0] == "apis" {
+		i = 3
+	}
+	if i < len(parts) {
+		resource = parts[i]
+	}
+	return "", resource
+}
+
+// tracingTransport creates a span for every API call made within a loop or
+// the reconciliation of a namespace, leaving the others such as watches alone
+type tracingTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	namespace, resource := apiPathResource(req.URL.Path)
+	ctx := req.Context()
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		namespaceSpansMu.RLock()
+		span, ok := namespaceSpans[namespace]
+		namespaceSpansMu.RUnlock()
+		if !ok {
+			return t.rt.RoundTrip(req)
+		}
+		ctx = trace.ContextWithSpan(ctx, span)
+	}
+	attrs := []attribute.KeyValue{semconv.HTTPMethod(req.Method), semconv.HTTPURL(req.URL.String())}
+	if namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(namespace))
+	}
+	_, span := tracer.Start(ctx, req.Method+" "+resource, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil {
+		span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	endSpan(span, err)
+	return resp, err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAPIPathResource(t *testing.T) {
+	tests := []struct {
+		path      string
+		namespace string
+		resource  string
+	}{
+		{"/api/v1/namespaces", "", "namespaces"},
+		{"/api/v1/namespaces/team-a", "team-a", "namespaces"},
+		{"/api/v1/namespaces/team-a/secrets/registry", "team-a", "secrets"},
+		{"/apis/external-secrets.io/v1beta1/namespaces/team-a/externalsecrets", "team-a", "externalsecrets"},
+		{"/apis/events.k8s.io/v1/events", "", "events"},
+		{"/version", "", ""},
+	}
+	for _, test := range tests {
+		namespace, resource := apiPathResource(test.path)
+		if namespace != test.namespace || resource != test.resource {
+			t.Errorf("apiPathResource(%q) gives %q, %q, expects %q, %q", test.path, namespace, resource, test.namespace, test.resource)
+		}
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func(previous trace.Tracer) { tracer = previous }(tracer)
+	tracer = provider.Tracer(annotationAppName)
+
+	transport := &tracingTransport{rt: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	})}
+	get := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, "https://kubernetes"+path, nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get("/api/v1/namespaces/team-a/secrets/registry")
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("API calls outside a reconciliation should not be traced, got %v", spans)
+	}
+
+	span := startNamespaceSpan(context.Background(), "team-a")
+	get("/api/v1/namespaces/team-a/secrets/registry")
+	get("/api/v1/namespaces/team-b/secrets/registry")
+	finishNamespaceSpan("team-a", span, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected the API call and namespace spans, got %v", spans)
+	}
+	if spans[0].Name != "GET secrets" || spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Errorf("API call span %q should be a child of the namespace span", spans[0].Name)
+	}
+	if spans[1].Name != "reconcile namespace" {
+		t.Errorf("unexpected namespace span %q", spans[1].Name)
+	}
+	if len(namespaceSpans) != 0 {
+		t.Errorf("namespace spans should be dropped once finished, got %v", namespaceSpans)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// loopTrigger wakes the main loop up to run a loop right away, holding at
+// most one pending trigger
+var loopTrigger = make(chan struct{}, 1)
+
+// triggerLoop requests a loop right away, or right after the running one,
+// returning false when one is already pending
+func triggerLoop() bool {
+	select {
+	case loopTrigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// triggerLoopOnSignal triggers a loop on every SIGUSR1 in the background
+func triggerLoopOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			log.Info("Received SIGUSR1, triggering loop")
+			triggerLoop()
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runCleanup removes every managed secret and ConfigMap of the cluster, and
+// the managed secrets from the service accounts, to decommission the patcher
+func runCleanup(ctx context.Context, k8s *k8sClient) error {
+	namespaces, err := k8s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	failed := 0
+	for _, ns := range namespaces.Items {
+		// excluded namespaces may still hold objects of earlier configurations
+		if err := cleanupNamespace(ctx, k8s, ns.Name); err != nil {
+			nsLog(ns.Name).Error(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to clean up %d of %d namespaces", failed, len(namespaces.Items))
+	}
+	return nil
+}
+
+// cleanupNamespace deletes the managed secrets and ConfigMaps of namespace,
+// removing the secrets from its service accounts, along with references to the
+// managed secret names left without their secret
+func cleanupNamespace(ctx context.Context, k8s *k8sClient, namespace string) error {
+	secrets, err := k8s.clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list secrets: %v", err)
+	}
+	names := map[string]bool{}
+	for _, name := range managedSecretNames() {
+		names[name] = true
+	}
+	for i := range secrets.Items {
+		if isManagedSecret(&secrets.Items[i]) {
+			names[secrets.Items[i].Name] = true
+		}
+	}
+	sorted := []string{}
+	for name := range names {
+		if sourceSecret != nil && sourceSecret.namespace == namespace && sourceSecret.name == name {
+			continue
+		}
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if err := cleanupSecret(ctx, k8s, namespace, name, "Uninstall"); err != nil {
+			return err
+		}
+	}
+
+	configMaps, err := k8s.clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to list ConfigMaps: %v", err)
+	}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if !isManagedConfigMap(configMap) {
+			continue
+		}
+		err := k8s.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, configMap.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("Failed to delete ConfigMap [%s]: %v", configMap.Name, err)
+		}
+		nsLog(namespace).WithFields(log.Fields{logFieldConfigMap: configMap.Name, logFieldAction: mutationDelete}).Info("Cleaned up ConfigMap")
+		recordMutation(ctx, k8s, namespace, mutationDelete, "ConfigMap", configMap.Name, "Uninstall")
+	}
+	return nil
+}
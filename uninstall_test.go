@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunCleanup(t *testing.T) {
+	managed := map[string]string{annotationManagedBy: annotationAppName}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: configSecretName, Namespace: "team-a", Annotations: managed}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "old-registry", Namespace: "team-a", Annotations: managed}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "team-secret", Namespace: "team-a"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configAWSConfigMapName, Namespace: "team-a", Annotations: managed}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "team-config", Namespace: "team-a"}},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "team-a"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}, {Name: "old-registry"}, {Name: "team-secret"}},
+		},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "team-b"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: configSecretName}},
+		},
+	)
+	k8s := &k8sClient{clientset: clientset}
+	if err := runCleanup(context.TODO(), k8s); err != nil {
+		t.Fatalf("runCleanup failed: %v", err)
+	}
+
+	secrets, err := clientset.CoreV1().Secrets("team-a").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != "team-secret" {
+		t.Errorf("only the unmanaged secret should be left, got %v", secrets.Items)
+	}
+	configMaps, err := clientset.CoreV1().ConfigMaps("team-a").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMaps.Items) != 1 || configMaps.Items[0].Name != "team-config" {
+		t.Errorf("only the unmanaged ConfigMap should be left, got %v", configMaps.Items)
+	}
+	sa, err := clientset.CoreV1().ServiceAccounts("team-a").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sa.ImagePullSecrets) != 1 || sa.ImagePullSecrets[0].Name != "team-secret" {
+		t.Errorf("only the unmanaged reference should be left, got %v", sa.ImagePullSecrets)
+	}
+	// the reference to the managed secret name goes even without its secret
+	sa, err = clientset.CoreV1().ServiceAccounts("team-b").Get(context.TODO(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sa.ImagePullSecrets) != 0 {
+		t.Errorf("dangling reference should be removed, got %v", sa.ImagePullSecrets)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// urlHTTPTimeout bounds a single request to the credential URL
+	urlHTTPTimeout = 10 * time.Second
+
+	// urlMaxCredentialSize bounds the response of the credential URL
+	urlMaxCredentialSize = 1 << 20
+)
+
+// urlCredentialSource fetches the dockerconfigjson from an internal credential
+// service over HTTPS, on every load
+type urlCredentialSource struct {
+	url string
+	// tokenFile holds the bearer token, re-read on every load so that a
+	// rotated token is picked up
+	tokenFile string
+	client    *http.Client
+}
+
+// newURLCredentialSource authenticates with the bearer token of
+// `dockerconfigjson-url-token-file` and the client certificate of
+// `dockerconfigjson-url-client-cert`, whichever are set
+func newURLCredentialSource(httpClient *http.Client) (*urlCredentialSource, error) {
+	if !strings.HasPrefix(configDockerConfigJSONURL, "https://") {
+		return nil, fmt.Errorf("`dockerconfigjson-url` must be an https:// URL")
+	}
+	if (configDockerConfigJSONURLClientCert == "") != (configDockerConfigJSONURLClientKey == "") {
+		return nil, fmt.Errorf("`dockerconfigjson-url-client-cert` and `dockerconfigjson-url-client-key` must be set together")
+	}
+	if configDockerConfigJSONURLClientCert != "" {
+		certFile, keyFile := configDockerConfigJSONURLClientCert, configDockerConfigJSONURLClientKey
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		transport := httpClient.Transport.(*http.Transport)
+		// the pair is loaded on every handshake so that a rotated certificate
+		// is picked up
+		transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			return &cert, err
+		}
+	}
+	return &urlCredentialSource{
+		url:       configDockerConfigJSONURL,
+		tokenFile: configDockerConfigJSONURLTokenFile,
+		client:    httpClient,
+	}, nil
+}
+
+func (s *urlCredentialSource) String() string { return "url:" + s.url }
+
+func (s *urlCredentialSource) load(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", err
+	}
+	if s.tokenFile != "" {
+		b, err := os.ReadFile(s.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read bearer token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("credential URL answered %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, urlMaxCredentialSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read credential URL response: %v", err)
+	}
+	if len(b) > urlMaxCredentialSize {
+		return "", fmt.Errorf("credential URL response exceeds %d bytes", urlMaxCredentialSize)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestClientCert writes a self-signed client certificate and its key
+func writeTestClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "imagepullsecret-patcher"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestURLCredentialSourceBearer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(testDockerconfig))
+	}))
+	defer server.Close()
+	defer func() { configDockerConfigJSONURL, configDockerConfigJSONURLTokenFile = "", "" }()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("wrong\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configDockerConfigJSONURL = server.URL + "/registry.json"
+	configDockerConfigJSONURLTokenFile = tokenFile
+	source, err := newURLCredentialSource(server.Client())
+	if err != nil {
+		t.Fatalf("newURLCredentialSource failed: %v", err)
+	}
+	if _, err := source.load(context.TODO()); err == nil {
+		t.Errorf("load with a wrong token should fail")
+	}
+	// the rotated token is read on the next load
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	content, err := source.load(context.TODO())
+	if err != nil || content != testDockerconfig {
+		t.Errorf("load = %q, %v, want the served credential", content, err)
+	}
+}
+
+func TestURLCredentialSourceClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDockerconfig))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	defer func() {
+		configDockerConfigJSONURL, configDockerConfigJSONURLClientCert, configDockerConfigJSONURLClientKey = "", "", ""
+	}()
+
+	configDockerConfigJSONURL = server.URL
+	source, err := newURLCredentialSource(server.Client())
+	if err != nil {
+		t.Fatalf("newURLCredentialSource failed: %v", err)
+	}
+	if _, err := source.load(context.TODO()); err == nil {
+		t.Errorf("load without a client certificate should fail")
+	}
+
+	configDockerConfigJSONURLClientCert, configDockerConfigJSONURLClientKey = writeTestClientCert(t, t.TempDir())
+	source, err = newURLCredentialSource(server.Client())
+	if err != nil {
+		t.Fatalf("newURLCredentialSource failed: %v", err)
+	}
+	content, err := source.load(context.TODO())
+	if err != nil || content != testDockerconfig {
+		t.Errorf("load = %q, %v, want the served credential", content, err)
+	}
+}
+
+func TestNewURLCredentialSourceValidation(t *testing.T) {
+	defer func() { configDockerConfigJSONURL, configDockerConfigJSONURLClientCert = "", "" }()
+	configDockerConfigJSONURL = "http://internal-secrets.example.com/registry.json"
+	if _, err := newURLCredentialSource(http.DefaultClient); err == nil {
+		t.Errorf("newURLCredentialSource should require https")
+	}
+	configDockerConfigJSONURL = "https://internal-secrets.example.com/registry.json"
+	configDockerConfigJSONURLClientCert = "/tls/tls.crt"
+	if _, err := newURLCredentialSource(http.DefaultClient); err == nil {
+		t.Errorf("newURLCredentialSource should require the client key along with the certificate")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// validateDockerConfigJSON checks content is a dockerconfigjson the kubelet
+// can use: an `auths` object whose keys are registries, each with a username
+// and password, given separately or as the base64 `auth` field
+func validateDockerConfigJSON(content string) error {
+	config := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if len(config.Auths) == 0 {
+		return fmt.Errorf("no registry in `auths`")
+	}
+	for registry, raw := range config.Auths {
+		if err := validateRegistryKey(registry); err != nil {
+			return fmt.Errorf("registry %q: %v", registry, err)
+		}
+		auth := dockerConfigAuth{}
+		if err := json.Unmarshal(raw, &auth); err != nil {
+			return fmt.Errorf("registry %q: invalid entry: %v", registry, err)
+		}
+		if auth.Auth != "" {
+			b, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("registry %q: `auth` is not base64: %v", registry, err)
+			}
+			if !strings.Contains(string(b), ":") {
+				return fmt.Errorf("registry %q: `auth` is not of the form <username>:<password>", registry)
+			}
+		} else if auth.Username == "" || auth.Password == "" {
+			return fmt.Errorf("registry %q: neither `auth` nor `username` and `password` are set", registry)
+		}
+	}
+	return nil
+}
+
+// validateRegistryKey checks a key of `auths` is a registry host, optionally
+// with a scheme, port and path, as the kubelet matches images against it
+func validateRegistryKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t\n") {
+		return fmt.Errorf("not a registry")
+	}
+	raw := key
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("not a registry: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("not a registry")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidateDockerConfigJSON(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		valid   bool
+	}{
+		"auth":                  {`{"auths":{"ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`, true},
+		"username and password": {`{"auths":{"https://index.docker.io/v1/":{"username":"bot","password":"token"}}}`, true},
+		"registry with port":    {`{"auths":{"registry.example.com:5000":{"auth":"Ym90OnRva2Vu"}}}`, true},
+		"garbage":               {`not json`, false},
+		"no auths":              {`{"auth":{"ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`, false},
+		"empty auths":           {`{"auths":{}}`, false},
+		"auth not base64":       {`{"auths":{"ghcr.io":{"auth":"bot:token"}}}`, false},
+		"auth without colon":    {`{"auths":{"ghcr.io":{"auth":"Ym90dG9rZW4="}}}`, false},
+		"no credentials":        {`{"auths":{"ghcr.io":{}}}`, false},
+		"password only":         {`{"auths":{"ghcr.io":{"password":"token"}}}`, false},
+		"invalid registry":      {`{"auths":{"ghcr io":{"auth":"Ym90OnRva2Vu"}}}`, false},
+		"unsupported scheme":    {`{"auths":{"ftp://ghcr.io":{"auth":"Ym90OnRva2Vu"}}}`, false},
+		"entry not an object":   {`{"auths":{"ghcr.io":"Ym90OnRva2Vu"}}`, false},
+	} {
+		err := validateDockerConfigJSON(tc.content)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestLoadCredentialRefusesMalformedContent(t *testing.T) {
+	configDockerconfigjson = `{"auths":{"ghcr.io":{"auth":"bot:token"}}}`
+	defer func() { configDockerconfigjson = "" }()
+	if _, err := loadCredential(context.TODO()); err == nil {
+		t.Error("malformed credential loaded")
+	}
+	configValidateCredential = false
+	defer func() { configValidateCredential = true }()
+	if _, err := loadCredential(context.TODO()); err != nil {
+		t.Errorf("credential refused with the validation disabled: %v", err)
+	}
+}
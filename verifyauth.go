@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// registryAuthVerification is the result of verifying the credential of hash
+// against its registries, kept so each credential is verified once
+type registryAuthVerification struct {
+	hash string
+	err  error
+}
+
+var (
+	registryAuthVerificationMu   sync.Mutex
+	lastRegistryAuthVerification registryAuthVerification
+)
+
+// verifyRegistryAuth logs in to every registry of content, returning an
+// error when one of them rejects its credentials. Registries which cannot be
+// reached, or could not on their last health check, are only warned about, so
+// an outage of one does not hold back the others. The result is kept until
+// content changes.
+func verifyRegistryAuth(ctx context.Context, content string) error {
+	hash := credentialHash(content)
+	registryAuthVerificationMu.Lock()
+	defer registryAuthVerificationMu.Unlock()
+	if lastRegistryAuthVerification.hash == hash {
+		return lastRegistryAuthVerification.err
+	}
+
+	config := dockerConfig{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return fmt.Errorf("invalid dockerconfigjson: %v", err)
+	}
+	rejected := []string{}
+	for key, auth := range config.Auths {
+		host := registryHost(key)
+		if registryUnreachable(host) {
+			log.Warnf("Registry [%s] was unreachable on its last health check, distributing the credential unverified", host)
+			continue
+		}
+		err := checkRegistry(ctx, host, auth)
+		switch {
+		case err == nil:
+			log.Debugf("Registry [%s] accepted the credential", host)
+		case errors.Is(err, errRegistryAuthentication):
+			log.Errorf("Registry [%s] rejected the credential: %v", host, err)
+			rejected = append(rejected, host)
+		default:
+			log.Warnf("Could not verify the credential of registry [%s], distributing it anyway: %v", host, err)
+		}
+	}
+	var err error
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		err = fmt.Errorf("registries %s rejected the credential", strings.Join(rejected, ", "))
+	}
+	lastRegistryAuthVerification = registryAuthVerification{hash: hash, err: err}
+	return err
+}
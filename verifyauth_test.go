@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVerifyRegistryAuth(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defaultClient := registryHTTPClient
+	defer func() {
+		registryHTTPClient = defaultClient
+		lastRegistryAuthVerification = registryAuthVerification{}
+	}()
+
+	for _, bearer := range []bool{false, true} {
+		server := newTestRegistry(t, bearer)
+		registryHTTPClient = server.Client()
+		host := strings.TrimPrefix(server.URL, "https://")
+
+		valid := `{"auths":{"` + host + `":{"auth":"dXNlcjpwYXNz"}}}`
+		if err := verifyRegistryAuth(context.TODO(), valid); err != nil {
+			t.Errorf("verifyRegistryAuth with valid credentials (bearer: %v) failed: %v", bearer, err)
+		}
+		invalid := `{"auths":{"` + host + `":{"username":"user","password":"wrong"}}}`
+		if err := verifyRegistryAuth(context.TODO(), invalid); err == nil || !strings.Contains(err.Error(), host) {
+			t.Errorf("verifyRegistryAuth with invalid credentials (bearer: %v) should name %s, got %v", bearer, host, err)
+		}
+	}
+
+	// unreachable registries are not held against the credential
+	if err := verifyRegistryAuth(context.TODO(), `{"auths":{"127.0.0.1:1":{"auth":"dXNlcjpwYXNz"}}}`); err != nil {
+		t.Errorf("verifyRegistryAuth of an unreachable registry should not fail: %v", err)
+	}
+}
+
+func TestVerifyRegistryAuthCached(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defaultClient := registryHTTPClient
+	defer func() {
+		registryHTTPClient = defaultClient
+		lastRegistryAuthVerification = registryAuthVerification{}
+	}()
+	server := newTestRegistry(t, false)
+	registryHTTPClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	invalid := `{"auths":{"` + host + `":{"username":"user","password":"wrong"}}}`
+	if err := verifyRegistryAuth(context.TODO(), invalid); err == nil {
+		t.Fatalf("verifyRegistryAuth with invalid credentials should fail")
+	}
+	server.Close()
+	if err := verifyRegistryAuth(context.TODO(), invalid); err == nil {
+		t.Errorf("verifyRegistryAuth should keep rejecting the same credential without asking the registry")
+	}
+}
+
+func TestVerifyRegistryAuthSkipsUnreachable(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	defaultClient := registryHTTPClient
+	defer func() {
+		registryHTTPClient = defaultClient
+		lastRegistryAuthVerification = registryAuthVerification{}
+		registryHealth = map[string]error{}
+	}()
+	server := newTestRegistry(t, false)
+	registryHTTPClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	// the health check found the registry down, which is not asked again
+	registryHealth[host] = fmt.Errorf("connection refused")
+	invalid := `{"auths":{"` + host + `":{"username":"user","password":"wrong"}}}`
+	if err := verifyRegistryAuth(context.TODO(), invalid); err != nil {
+		t.Errorf("verifyRegistryAuth of a registry unreachable on its last health check should not fail: %v", err)
+	}
+}
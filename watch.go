@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// reconcileMu guards the credential and runtime settings the reconciles of
+// the loop and the watches read, which take it for reading
+var reconcileMu sync.RWMutex
+
+// tombstoneObject unwraps objects whose deletion the informer missed
+func tombstoneObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+// resyncNamespaceWith reconciles namespace outside of the loop with
+// reconcile, or the whole processNamespace when nil, unless it is being
+// deleted, excluded or the patcher is paused, returning the error to retry on
+func resyncNamespaceWith(ctx context.Context, k8s *k8sClient, namespace string, reconcile namespaceReconciler) error {
+	reconcileMu.RLock()
+	defer reconcileMu.RUnlock()
+	defer lockNamespace(namespace)()
+	if configPaused {
+		return nil
+	}
+	ns, err := k8s.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		nsLog(namespace).Errorf("Failed to GET namespace: %v", err)
+		return err
+	}
+	if ns.DeletionTimestamp != nil || namespaceIsExcluded(*ns) {
+		return nil
+	}
+	if reconcile != nil {
+		nsLogger := startReconcile(namespace)
+		defer finishReconcile(namespace)
+		if err := reconcile(ctx, k8s, *ns); err != nil {
+			nsLogger.Error(err)
+			return err
+		}
+		return nil
+	}
+	if err := processNamespace(ctx, k8s, *ns); err != nil {
+		return err
+	}
+	recordNamespaceVerified(*ns, desiredStateHash(), time.Now())
+	return nil
+}
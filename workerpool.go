@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// namespaceLocks holds a mutex per namespace, so a namespace is reconciled by
+// one worker at a time while different namespaces are reconciled concurrently
+var namespaceLocks sync.Map
+
+// lockNamespace locks namespace, returning the func unlocking it
+func lockNamespace(namespace string) func() {
+	v, _ := namespaceLocks.LoadOrStore(namespace, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// processNamespacesConcurrently runs process for every namespace of
+// namespaces on up to concurrency workers, returning the errors by namespace.
+// Once ctx is done no further namespace is started, while the ones being
+// processed are finished.
+func processNamespacesConcurrently(ctx context.Context, namespaces []corev1.Namespace, concurrency int, process func(corev1.Namespace) error) map[string]error {
+	errs := map[string]error{}
+	var errsMu sync.Mutex
+	var wg sync.WaitGroup
+	todo := make(chan corev1.Namespace)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reportPanic()
+			for ns := range todo {
+				if err := process(ns); err != nil {
+					errsMu.Lock()
+					errs[ns.Name] = err
+					errsMu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for _, ns := range namespaces {
+		select {
+		case todo <- ns:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(todo)
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProcessNamespacesConcurrently(t *testing.T) {
+	namespaces := []corev1.Namespace{}
+	for i := 0; i < 20; i++ {
+		namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%d", i)}})
+	}
+	var running, maxRunning int32
+	errs := processNamespacesConcurrently(context.TODO(), namespaces, 4, func(ns corev1.Namespace) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		if ns.Name == "ns-3" || ns.Name == "ns-7" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	if maxRunning > 4 {
+		t.Errorf("ran %d namespaces at once, expected at most 4", maxRunning)
+	}
+	if len(errs) != 2 || errs["ns-3"] == nil || errs["ns-7"] == nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestProcessNamespacesConcurrentlyStopsOnCancel(t *testing.T) {
+	namespaces := []corev1.Namespace{}
+	for i := 0; i < 20; i++ {
+		namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ns-%d", i)}})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	processed := 0
+	processNamespacesConcurrently(ctx, namespaces, 1, func(ns corev1.Namespace) error {
+		processed++
+		cancel()
+		return nil
+	})
+	// the namespace being handed out while cancelling may still be processed
+	if processed > 2 {
+		t.Errorf("processed %d namespaces after cancelling", processed)
+	}
+}
+
+func TestLoopConcurrency(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configPaused = false
+	configExcludedNamespaces = ""
+	configEnableSecretSync = true
+	configEnableSAPatch = true
+	configEnableConfigMapSync = false
+	configAllServiceAccount = false
+	configServiceAccounts = defaultServiceAccountName
+	configDockerconfigjson = testDockerconfig
+	configDockerConfigJSONPath = ""
+	credentialSources = nil
+	configExportDir = ""
+	configReverifyAge = 0
+	configConcurrency = 4
+	defer func() { configConcurrency = 1 }()
+	objects := []runtime.Object{}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("ns-%d", i)
+		objects = append(objects,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}},
+			&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: defaultServiceAccountName, Namespace: name}},
+		)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	k8s := &k8sClient{clientset: clientset}
+
+	loop(context.TODO(), k8s)
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("ns-%d", i)
+		if _, err := clientset.CoreV1().Secrets(name).Get(context.TODO(), configSecretName, metav1.GetOptions{}); err != nil {
+			t.Errorf("[%s] secret was not created: %v", name, err)
+		}
+		sa, err := clientset.CoreV1().ServiceAccounts(name).Get(context.TODO(), defaultServiceAccountName, metav1.GetOptions{})
+		if err != nil || !includeImagePullSecret(sa, configSecretName) {
+			t.Errorf("[%s] service account was not patched: %v", name, err)
+		}
+	}
+}
+
+func TestLoopTimeout(t *testing.T) {
+	logrus.SetOutput(ioutil.Discard)
+	configSecretMode = secretModeSecret
+	configPaused = false
+	configExcludedNamespaces = ""
+	configEnableSecretSync = true
+	configDockerconfigjson = testDockerconfig
+	configDockerConfigJSONPath = ""
+	credentialSources = nil
+	configExportDir = ""
+	configReverifyAge = 0
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: corev1.NamespaceDefault}})
+	k8s := &k8sClient{clientset: clientset}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	loop(ctx, k8s)
+
+	if _, err := clientset.CoreV1().Secrets(corev1.NamespaceDefault).Get(context.TODO(), configSecretName, metav1.GetOptions{}); err == nil {
+		t.Error("timed out loop processed a namespace")
+	}
+}